@@ -10,6 +10,17 @@ import (
 func main() {
 	ctx := context.Background()
 
+	// subcommands: uip_counter <command> [flags]
+	if len(os.Args) > 1 {
+		ok, err := internal.RunCommand(ctx, os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatalf("%s failed: %v", os.Args[1], err)
+		}
+		if ok {
+			return
+		}
+	}
+
 	app, err := internal.NewApp()
 	if err != nil {
 		log.Fatalf("init app failed: %v", err)
@@ -0,0 +1,104 @@
+// Package main builds the counting core as a C shared library:
+//
+//	go build -buildmode=c-shared -o ./bin/libuipcounter.so ./cmd/uip_counter_cshared
+//
+// All functions return the unique count or -1 on error,
+// the error text is available through UIPLastError.
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"unique-ip-counter/pkg/uipcounter"
+)
+
+var (
+	mu      sync.Mutex
+	lastErr string
+)
+
+func setLastErr(err error) C.longlong {
+	mu.Lock()
+	defer mu.Unlock()
+	lastErr = err.Error()
+
+	return -1
+}
+
+// UIPCountFile Counts unique IPs in the file at path, th <= 0 means NumCPU.
+//
+//export UIPCountFile
+func UIPCountFile(path *C.char, th C.int) C.longlong {
+	n, err := uipcounter.CountFile(context.Background(), C.GoString(path), int(th))
+	if err != nil {
+		return setLastErr(err)
+	}
+
+	return C.longlong(n)
+}
+
+// UIPCountBuffer Counts unique IPs in an in-memory newline separated buffer,
+// data may be NULL for an empty one.
+//
+//export UIPCountBuffer
+func UIPCountBuffer(data *C.char, size C.longlong) C.longlong {
+	switch {
+	case size < 0 || int64(size) > math.MaxInt:
+		return setLastErr(fmt.Errorf("bad buffer size %d", int64(size)))
+	case data == nil && size > 0:
+		return setLastErr(errors.New("NULL buffer of non-zero size"))
+	}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(data)), int(size))
+	n, err := uipcounter.CountReader(context.Background(), bytes.NewReader(buf))
+	if err != nil {
+		return setLastErr(err)
+	}
+
+	return C.longlong(n)
+}
+
+// UIPCountFD Counts unique IPs streamed from an open file descriptor(pipe, socket etc.),
+// the descriptor is not closed.
+//
+//export UIPCountFD
+func UIPCountFD(fd C.int) C.longlong {
+	// dup so closing our *os.File never closes the caller's descriptor
+	dfd, err := syscall.Dup(int(fd))
+	if err != nil {
+		return setLastErr(err)
+	}
+	f := os.NewFile(uintptr(dfd), "fd")
+	defer f.Close()
+
+	n, err := uipcounter.CountReader(context.Background(), f)
+	if err != nil {
+		return setLastErr(err)
+	}
+
+	return C.longlong(n)
+}
+
+// UIPLastError Returns the last error message, the caller must free() it.
+//
+//export UIPLastError
+func UIPLastError() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return C.CString(lastErr)
+}
+
+func main() {}
@@ -0,0 +1,32 @@
+//go:build wasip1
+
+// Package main is a WASI command module around the streaming counting core:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -o ./bin/uip_counter.wasm ./cmd/uip_counter_wasi
+//	wasmtime run --dir=. ./bin/uip_counter.wasm [path]  # stdin when path is omitted
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"unique-ip-counter/pkg/uipcounter"
+)
+
+func main() {
+	c := uipcounter.New(nil, 1)
+
+	var err error
+	if len(os.Args) > 1 {
+		err = c.CountFile(context.Background(), os.Args[1])
+	} else {
+		err = c.CountReader(context.Background(), os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "uip_counter: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(c.UniqueCount())
+}
@@ -3,8 +3,22 @@ module unique-ip-counter
 go 1.25
 
 require (
+	github.com/google/gops v0.3.28
+	github.com/klauspost/compress v1.17.2
+	github.com/nats-io/nats.go v1.37.0
+	github.com/pierrec/lz4/v4 v4.1.15
+	github.com/segmentio/kafka-go v0.4.47
 	go.uber.org/zap v1.27.0
+	golang.org/x/net v0.17.0
 	golang.org/x/sync v0.15.0
+	golang.org/x/sys v0.16.0
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require go.uber.org/multierr v1.10.0 // indirect
+require (
+	github.com/nats-io/nkeys v0.4.7 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	go.uber.org/multierr v1.10.0 // indirect
+	golang.org/x/crypto v0.18.0 // indirect
+)
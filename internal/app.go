@@ -2,26 +2,43 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"runtime"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
+	"unique-ip-counter/internal/chaos"
+	"unique-ip-counter/internal/cpu_dispatch"
+	"unique-ip-counter/internal/enrich"
 	"unique-ip-counter/internal/file_processor"
 	"unique-ip-counter/internal/ipv4_bitset"
+	"unique-ip-counter/internal/ipv6_set"
+	"unique-ip-counter/internal/remote_source"
 )
 
 type App struct {
-	logger *zap.Logger
-	fp     *file_processor.FileProcessor
-	done   chan struct{}
+	logger   *zap.Logger
+	fp       atomic.Pointer[file_processor.FileProcessor] // processor of the current input
+	cfg      config
+	results  *resultsWriter
+	matrix   *matrixWriter
+	manifest *runManifest
+	history  *history                      // -history
+	invalid  *file_processor.InvalidWriter // -invalid-out
+	invalidF *os.File
+	resolver *file_processor.Resolver // -resolve, its cache is shared by the inputs
+	flat     *ipv4_bitset.Bitset      // -backend flat, its array is recycled by the inputs
+	done     chan struct{}
 }
 
 func NewApp() (*App, error) {
@@ -33,34 +50,75 @@ func NewApp() (*App, error) {
 	defer logger.Sync()
 
 	// pars run args
-	var (
-		path string
-		th   int
-	)
-	flag.StringVar(&path, "f", "", "path to file")
-	flag.IntVar(&th, "th", runtime.NumCPU(), "count of goroutines + shards")
-	flag.Parse()
-	if path == "" {
-		log.Fatal("please provide path to file")
-	}
-
-	// file processor
-	f, err := os.Open(path)
-	if err != nil {
-		log.Fatalf("cannot open the file: %v", err)
+	cfg := parseConfig()
+
+	// fail fast on missing local files, they are opened one by one on Run
+	for _, path := range cfg.paths {
+		if isLocal(path) {
+			if _, err = os.Stat(path); err != nil {
+				log.Fatalf("cannot open the file: %v", err)
+			}
+		}
 	}
-	fp := file_processor.New(logger, f, ipv4_bitset.New(), th)
 
-	return &App{
+	a := &App{
 		logger: logger,
-		fp:     fp,
+		cfg:    cfg,
 		done:   make(chan struct{}),
-	}, nil
+	}
+
+	// per input results
+	if cfg.resultsPath != "" {
+		if a.results, err = newResultsWriter(cfg.resultsPath); err != nil {
+			log.Fatalf("cannot create results file: %v", err)
+		}
+	}
+
+	if cfg.matrixPath != "" {
+		if a.matrix, err = newMatrixWriter(cfg.matrixPath); err != nil {
+			log.Fatalf("cannot create dimension matrix file: %v", err)
+		}
+	}
+
+	if cfg.invalidOut != "" {
+		if a.invalidF, err = os.Create(cfg.invalidOut); err != nil {
+			log.Fatalf("cannot create invalid lines file: %v", err)
+		}
+		a.invalid = file_processor.NewInvalidWriter(a.invalidF)
+	}
+
+	if cfg.resolve {
+		a.resolver = file_processor.NewResolver(nil, cfg.resolveConc, cfg.resolveTimeout)
+	}
+
+	if cfg.historyPath != "" {
+		a.history = newHistory(cfg.historyPath, cfg.historyTag)
+	}
+
+	if cfg.manifest != "" {
+		a.manifest = newRunManifest(cfg.manifest, flag.CommandLine)
+	}
+
+	// runtime introspection
+	if err = a.startIntrospection(); err != nil {
+		log.Fatalf("cannot start introspection: %v", err)
+	}
+
+	return a, nil
 }
 
 func (a *App) Close() {
-	if a.fp.GetFile() != nil {
-		_ = a.fp.GetFile().Close()
+	if a.results != nil {
+		_ = a.results.Close()
+	}
+	if a.matrix != nil {
+		_ = a.matrix.Close()
+	}
+	if a.invalid != nil {
+		if err := a.invalid.Flush(); err != nil {
+			a.logger.Error("cannot write invalid lines", zap.Error(err))
+		}
+		_ = a.invalidF.Close()
 	}
 	if a.logger != nil {
 		_ = a.logger.Sync()
@@ -68,7 +126,10 @@ func (a *App) Close() {
 }
 
 func (a *App) Run(ctx context.Context) error {
-	a.logger.Info("running uIPCounter...")
+	a.logger.Info("running uIPCounter...", zap.Any("cpu_dispatch", cpu_dispatch.Selected()))
+	if chaos.Enabled {
+		a.logger.Warn("fault injection is compiled in(-tags chaos), not for production", zap.String("spec", os.Getenv(chaos.EnvVar)))
+	}
 
 	// context with os signals cancel chan
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1)
@@ -79,29 +140,50 @@ func (a *App) Run(ctx context.Context) error {
 	// - group errors from multiple gorutines into one
 	// - wg.Add(1), wg.Done() - automatically under the hood, so never catch deadlock if you forget something ;-)
 	// - allows orchestration of parallel processes through the context.Context(gracefull shut down)
-	start := time.Now()
+	if a.cfg.emitPlan != "" {
+		return a.writePlan(ctx, a.cfg.paths[0])
+	}
+	if a.cfg.watchDir != "" {
+		return a.runWatch(ctx)
+	}
+	if a.cfg.estimateOnly {
+		return a.runEstimates(ctx)
+	}
+
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
-		fi, err := a.fp.GetFile().Stat()
-		if err != nil {
-			return err
+		// inputs are counted one by one, each with its own bitset,
+		// a failed input is reported and the batch goes on
+		var errs []error
+		for _, path := range a.cfg.paths {
+			if err := a.runInput(ctx, path); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				errs = append(errs, err)
+			}
 		}
-		if err = a.fp.ProcessFile(ctx, fi); err != nil {
-			return fmt.Errorf("FileProcessor error: %w", err)
+		if err := errors.Join(errs...); err != nil {
+			return err
 		}
 		a.done <- struct{}{}
 
 		return nil
 	})
 
-	// waiting when processing file finished or sigurg signal
+	// waiting when processing files finished or sigurg signal
 	select {
 	case <-a.done:
-		fmt.Printf("unique ip's: %v, total time: %v sec\n", a.fp.UniqueCount(), time.Since(start).Seconds())
 	case <-ctx.Done():
 	}
 
-	if err := g.Wait(); err != nil {
+	err := g.Wait()
+	if a.manifest != nil {
+		if werr := a.manifest.write(err); werr != nil {
+			a.logger.Error("cannot write manifest", zap.Error(werr))
+		}
+	}
+	if err != nil {
 		a.logger.Error("uIPCounter returning an error", zap.Error(err))
 		return err
 	}
@@ -111,4 +193,289 @@ func (a *App) Run(ctx context.Context) error {
 	return nil
 }
 
+// minOctetCheck Unique count from which addresses sharing their first octets are reported as suspicious.
+const minOctetCheck = 1024
+
+// runInput Counts a single input, prints its summary and streams its result record.
+func (a *App) runInput(ctx context.Context, path string) error {
+	start := time.Now()
+
+	var stopped, saturated bool
+	fp, err := a.newFileProcessor(path)
+	if err == nil {
+		a.fp.Store(fp)
+		pctx, cancel := context.WithCancel(ctx)
+		if a.cfg.memoryWatermark > 0 {
+			go a.watchMemory(pctx, path, fp.Bitset())
+		}
+		err = a.process(pctx, fp, path)
+		if err == nil && a.resolver != nil {
+			var names, failed int
+			names, failed, err = fp.ResolveHostnames(pctx)
+			if names+failed > 0 {
+				a.logger.Info("hostnames resolved", zap.String("path", path), zap.Int("names", names), zap.Int("failed", failed))
+			}
+		}
+		cancel()
+		if errors.Is(err, file_processor.ErrUniqueLimit) {
+			a.logger.Info("stopped early, unique limit reached", zap.Uint64("limit", a.cfg.stopAfterUniques))
+			stopped, err = true, nil
+		}
+		if errors.Is(err, file_processor.ErrBinary) {
+			err = fmt.Errorf("%w, pass -force to count it anyway", err)
+		}
+		if errors.Is(err, file_processor.ErrSaturated) {
+			a.logger.Info("stopped early, unique set is saturated")
+			saturated, err = true, nil
+		}
+		if err == nil {
+			err = fp.MergeIPv6Spill()
+		}
+		if cerr := fp.CloseIPv6Spill(); cerr != nil {
+			a.logger.Warn("cannot remove ipv6 runs", zap.Error(cerr))
+		}
+		if err == nil {
+			err = fp.CheckInvalidRatio()
+		}
+		if f := fp.GetFile(); f != nil {
+			_ = f.Close()
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("FileProcessor error(%s): %w", path, err)
+	}
+
+	s := summary{
+		Path:      path,
+		Threads:   a.cfg.th,
+		Seconds:   time.Since(start).Seconds(),
+		Stopped:   stopped,
+		Saturated: saturated,
+	}
+	if fp != nil {
+		s.Unique = fp.UniqueCount()
+		s.HoleBytes = fp.HoleBytes()
+		s.Invalid, s.InvalidSamples = fp.InvalidCount(), fp.InvalidSamples()
+		s.Skipped = fp.SkippedCount()
+		s.IPv4, s.IPv6 = fp.IPv4Count(), fp.IPv6Count()
+		s.IPv6Prefixes, s.IPv6PrefixLen = fp.IPv6PrefixCount()
+		s.setApprox(fp.Bitset().ApproxCount())
+		s.Octets = fp.Bitset().DistinctOctets()
+		if n := fp.Bitset().GetUniqueCount(); n >= minOctetCheck && (s.Octets[0] == 1 || s.Octets[1] == 1) {
+			a.logger.Warn("every address shares its leading octets, is the address column right(ports, ids)?",
+				zap.String("path", path), zap.Uint64("unique", n), zap.Ints("distinct_octets", s.Octets[:]))
+		}
+		if dims := fp.Dimensions(); len(dims) > 0 {
+			s.Dimensions = dimensionsMap(dims)
+			a.logger.Info("dimensions", zap.String("path", path), zap.Any("unique", s.Dimensions))
+		}
+		if s.Invalid > 0 {
+			a.logger.Info("invalid lines", zap.String("path", path), zap.Uint64("invalid", s.Invalid), zap.Strings("samples", s.InvalidSamples))
+		}
+	}
+	if err == nil && len(a.cfg.enrichers) > 0 {
+		s.Enrich = enrich.Run(ctx, fp.Bitset(), a.cfg.enrichers)
+		a.logger.Info("enrichment", zap.String("path", path), zap.Any("enrich", s.Enrich))
+	}
+	if err == nil && !stopped && a.history != nil {
+		// a run stopped early is no count to compare with
+		var herr error
+		if s.Previous, herr = a.history.record(s); herr != nil {
+			a.logger.Error("cannot update history", zap.Error(herr))
+		}
+	}
+	if err == nil {
+		if werr := s.write(os.Stdout, a.cfg.summary); werr != nil {
+			a.logger.Error("cannot write summary", zap.Error(werr))
+		}
+	}
+	if a.results != nil {
+		if werr := a.results.write(s, err); werr != nil {
+			a.logger.Error("cannot write result", zap.Error(werr))
+		}
+	}
+	if a.invalid != nil {
+		if werr := a.invalid.Flush(); werr != nil {
+			a.logger.Error("cannot write invalid lines", zap.Error(werr))
+		}
+	}
+	if a.manifest != nil {
+		if werr := a.manifest.add(path, s, err); werr != nil {
+			a.logger.Error("cannot add input to manifest", zap.Error(werr))
+		}
+	}
+	if err == nil && a.matrix != nil {
+		if werr := a.matrix.write(path, fp.Matrix(stopped || saturated), stopped || saturated); werr != nil {
+			a.logger.Error("cannot write dimension matrix", zap.Error(werr))
+		}
+	}
+
+	return err
+}
+
+// newFileProcessor Opens local files, remote sources are opened on process.
+func (a *App) newFileProcessor(path string) (*file_processor.FileProcessor, error) {
+	var f *os.File
+	if isLocal(path) {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := []file_processor.Option{
+		file_processor.WithStopAfterUniques(a.cfg.stopAfterUniques),
+		file_processor.WithSaturationCeiling(a.cfg.saturationCeiling),
+		file_processor.WithPcapDestination(a.cfg.pcapDst),
+		file_processor.WithBalancer(a.cfg.balance),
+		file_processor.WithParquetColumn(a.cfg.parquetCol),
+		file_processor.WithCSVColumn(a.cfg.csvCol),
+		file_processor.WithHeader(a.cfg.header),
+		file_processor.WithFormat(a.cfg.format),
+		file_processor.WithGrok(a.cfg.grok),
+		file_processor.WithInvalidSamples(a.cfg.invalidSamples),
+		file_processor.WithDelimiter(a.cfg.delim),
+		file_processor.WithSkipPrefixes(a.cfg.skipPrefixes),
+		file_processor.WithInlineComments(a.cfg.comments),
+		file_processor.WithExtractAll(a.cfg.extractAll),
+		file_processor.WithStripPort(a.cfg.stripPort),
+		file_processor.WithTrimSpace(a.cfg.trimSpace),
+		file_processor.WithIntegerAddresses(a.cfg.intAddr),
+		file_processor.WithStrictIPv4(a.cfg.strictIPv4),
+		file_processor.WithMaxLineBytes(int(a.cfg.maxLineBytes)),
+		file_processor.WithCIDR(a.cfg.cidr),
+		file_processor.WithIPv6Prefix(a.cfg.ipv6Prefix),
+		file_processor.WithStatusDimension(a.cfg.statusCol),
+		file_processor.WithUserAgentDimension(a.cfg.uaCol),
+		file_processor.WithForce(a.cfg.force),
+		file_processor.WithFailFast(a.cfg.failFast),
+		file_processor.WithMaxInvalidPct(a.cfg.maxInvalidPct),
+	}
+	if a.cfg.ipv6Spill != "" {
+		opts = append(opts, file_processor.WithIPv6Spill(ipv6_set.NewSpill(a.cfg.ipv6Spill, a.cfg.ipv6RunKeys)))
+	}
+	if a.invalid != nil {
+		opts = append(opts, file_processor.WithInvalidOut(a.invalid, path))
+	}
+	if a.resolver != nil {
+		opts = append(opts, file_processor.WithResolver(a.resolver))
+	}
+	if a.cfg.usePlan != "" {
+		plan, err := file_processor.ReadPlan(a.cfg.usePlan)
+		if err != nil {
+			if f != nil {
+				_ = f.Close()
+			}
+			return nil, err
+		}
+		if plan.Path != path {
+			a.logger.Warn("plan was made for another path", zap.String("plan_path", plan.Path))
+		}
+		opts = append(opts, file_processor.WithPlan(plan))
+	}
+
+	return file_processor.New(a.logger, f, a.newBitset(), a.cfg.th, opts...), nil
+}
+
+// newBitset The set of an input, the sketch of -sketch hll, roaring containers of -backend roaring,
+// the flat array of -backend flat: one array for the whole run, the inputs are counted one by one.
+func (a *App) newBitset() *ipv4_bitset.Bitset {
+	if a.cfg.backend == "flat" {
+		if a.flat == nil {
+			a.flat = ipv4_bitset.NewFlat()
+		} else {
+			a.flat = a.flat.Recycle()
+		}
+		return a.flat
+	}
+	if a.cfg.sketch == "hll" {
+		return ipv4_bitset.NewSketch(uint8(a.cfg.sketchPrecision))
+	}
+	if a.cfg.backend == "roaring" {
+		return ipv4_bitset.NewRoaring()
+	}
+
+	return ipv4_bitset.New()
+}
+
+// writePlan Writes the shard plan of a local file to -emit-plan.
+func (a *App) writePlan(ctx context.Context, path string) error {
+	fp, err := a.newFileProcessor(path)
+	if err != nil {
+		return err
+	}
+	defer fp.GetFile().Close()
+
+	fi, err := fp.GetFile().Stat()
+	if err != nil {
+		return err
+	}
+	plan, err := fp.Plan(ctx, path, fi)
+	if err != nil {
+		return err
+	}
+	if err = file_processor.WritePlan(a.cfg.emitPlan, plan); err != nil {
+		return err
+	}
+	a.logger.Info("shard plan written", zap.String("plan", a.cfg.emitPlan), zap.Int("shards", len(plan.Shards)))
+
+	return nil
+}
+
+// process Picks the processing strategy by the input path.
+func (a *App) process(ctx context.Context, fp *file_processor.FileProcessor, path string) error {
+	if remote_source.IsS3(path) {
+		o, err := remote_source.OpenS3(ctx, path)
+		if err != nil {
+			return err
+		}
+		return fp.ProcessReaderAt(ctx, o, o.Size())
+	}
+	if remote_source.IsHTTP(path) {
+		o, err := remote_source.OpenHTTP(ctx, path)
+		if err != nil {
+			return err
+		}
+		if o.Ranged() {
+			return fp.ProcessReaderAt(ctx, o, o.Size())
+		}
+		a.logger.Info("server does not support range requests, streaming sequentially")
+		body, err := o.Stream(ctx)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		return fp.ProcessReader(ctx, body)
+	}
+
+	fi, err := fp.GetFile().Stat()
+	if err != nil {
+		return err
+	}
+	// FIFOs and character devices report size 0 and cannot be sharded
+	if !fi.Mode().IsRegular() {
+		a.logger.Info("not a regular file, streaming sequentially", zap.String("mode", fi.Mode().Type().String()))
+		return fp.ProcessReader(ctx, fp.GetFile())
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip":
+		return fp.ProcessZip(ctx, fi)
+	case ".pcap", ".pcapng":
+		return fp.ProcessPcap(ctx, fi)
+	case ".parquet":
+		return fp.ProcessParquet(ctx, fi)
+	case ".bin":
+		return fp.ProcessBinary(ctx, fi)
+	case ".zst", ".zstd":
+		return fp.ProcessZstd(ctx, fi)
+	case ".gz", ".bgz":
+		return fp.ProcessGzip(ctx, fi)
+	}
+
+	return fp.ProcessFile(ctx, fi)
+}
+
 func (a *App) Logger() *zap.Logger { return a.logger }
+
+func isLocal(path string) bool { return !remote_source.IsS3(path) && !remote_source.IsHTTP(path) }
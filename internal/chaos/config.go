@@ -0,0 +1,72 @@
+// Package chaos Fault injection for integration tests: failing reads, slow shards and partial writes at
+// configurable rates. The hooks are compiled in with the "chaos" build tag only, other builds get no-ops.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvVar Spec read on start by binaries built with -tags chaos, see Parse.
+const EnvVar = "UIP_CHAOS"
+
+// ErrInjected Error of an injected fault.
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Config Rates are probabilities(0..1) per call.
+type Config struct {
+	ReadError    float64       // a Read fails with ErrInjected
+	SlowShard    float64       // a shard sleeps Delay before reading
+	Delay        time.Duration // of a slow shard
+	PartialWrite float64       // a Write stores a prefix of its bytes and fails with ErrInjected
+	Seed         int64         // of the fault sequence, runs with the same seed inject the same faults
+}
+
+// Parse "read-error=0.01,slow-shard=0.5,delay=2s,partial-write=0.1,seed=7", missing keys are 0(delay - 1s).
+func Parse(spec string) (Config, error) {
+	c := Config{Delay: time.Second}
+	for _, kv := range strings.Split(spec, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return c, fmt.Errorf("chaos: %q is not key=value", kv)
+		}
+		var err error
+		switch k {
+		case "read-error":
+			c.ReadError, err = parseRate(v)
+		case "slow-shard":
+			c.SlowShard, err = parseRate(v)
+		case "partial-write":
+			c.PartialWrite, err = parseRate(v)
+		case "delay":
+			c.Delay, err = time.ParseDuration(v)
+		case "seed":
+			c.Seed, err = strconv.ParseInt(v, 10, 64)
+		default:
+			return c, fmt.Errorf("chaos: unknown key %q(want read-error, slow-shard, delay, partial-write, seed)", k)
+		}
+		if err != nil {
+			return c, fmt.Errorf("chaos: bad %s: %w", k, err)
+		}
+	}
+
+	return c, nil
+}
+
+func parseRate(v string) (float64, error) {
+	r, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, err
+	}
+	if r < 0 || r > 1 {
+		return 0, fmt.Errorf("%v is not a rate(0..1)", r)
+	}
+
+	return r, nil
+}
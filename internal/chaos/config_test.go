@@ -0,0 +1,26 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	c, err := Parse("read-error=0.01, slow-shard=0.5,delay=2s,partial-write=1,seed=7")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := Config{ReadError: 0.01, SlowShard: 0.5, Delay: 2 * time.Second, PartialWrite: 1, Seed: 7}
+	if c != want {
+		t.Fatalf("Parse => %+v; want %+v", c, want)
+	}
+	if c, _ = Parse(""); c != (Config{Delay: time.Second}) {
+		t.Fatalf("Parse(\"\") => %+v", c)
+	}
+	for _, spec := range []string{"read-error", "read-error=2", "read-error=-0.1", "slow=0.1", "delay=fast", "seed=x"} {
+		if _, err = Parse(spec); err == nil {
+			t.Fatalf("Parse(%q): expected error", spec)
+		}
+	}
+}
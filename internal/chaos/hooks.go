@@ -0,0 +1,106 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Enabled Reports whether the hooks are compiled in.
+const Enabled = true
+
+var (
+	mu  sync.Mutex
+	cfg Config
+	rnd = rand.New(rand.NewSource(0))
+)
+
+func init() {
+	spec := os.Getenv(EnvVar)
+	if spec == "" {
+		return
+	}
+	c, err := Parse(spec)
+	if err != nil {
+		log.Fatalf("bad %s: %v", EnvVar, err)
+	}
+	Configure(c)
+}
+
+// Configure Replaces the rates and restarts the fault sequence from c.Seed.
+func Configure(c Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	cfg, rnd = c, rand.New(rand.NewSource(c.Seed))
+}
+
+// roll Reports whether a fault of rate is injected, the returned float is a further draw in [0, 1).
+func roll(rate float64) (bool, float64) {
+	if rate <= 0 {
+		return false, 0
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	return rnd.Float64() < rate, rnd.Float64()
+}
+
+func current() Config {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return cfg
+}
+
+// Reader r with reads failing at the ReadError rate.
+func Reader(r io.Reader) io.Reader { return &reader{r: r} }
+
+type reader struct{ r io.Reader }
+
+func (r *reader) Read(p []byte) (int, error) {
+	if hit, _ := roll(current().ReadError); hit {
+		return 0, ErrInjected
+	}
+
+	return r.r.Read(p)
+}
+
+// Writer w with writes cut short at the PartialWrite rate: a prefix of p is written, then ErrInjected.
+func Writer(w io.Writer) io.Writer { return &writer{w: w} }
+
+type writer struct{ w io.Writer }
+
+func (w *writer) Write(p []byte) (int, error) {
+	hit, f := roll(current().PartialWrite)
+	if !hit {
+		return w.w.Write(p)
+	}
+	n, err := w.w.Write(p[:int(f*float64(len(p)))])
+	if err == nil {
+		err = ErrInjected
+	}
+
+	return n, err
+}
+
+// SlowShard Sleeps Delay at the SlowShard rate, returns early with the ctx error.
+func SlowShard(ctx context.Context) error {
+	c := current()
+	if hit, _ := roll(c.SlowShard); !hit {
+		return nil
+	}
+	t := time.NewTimer(c.Delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !chaos
+
+package chaos
+
+import (
+	"context"
+	"io"
+)
+
+// Enabled Reports whether the hooks are compiled in.
+const Enabled = false
+
+func Configure(Config) {}
+
+func Reader(r io.Reader) io.Reader { return r }
+
+func Writer(w io.Writer) io.Writer { return w }
+
+func SlowShard(context.Context) error { return nil }
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// command Subcommand entry point, args are the arguments after the subcommand name.
+type command func(ctx context.Context, logger *zap.Logger, args []string) error
+
+var commands = map[string]command{
+	"convert":     runConvert,
+	"doctor":      runDoctor,
+	"k8s":         runKube,
+	"push":        runPush,
+	"self-update": runSelfUpdate,
+	"serve":       runServe,
+	"serve-query": runServeQuery,
+	"set":         runSet,
+	"validate":    runValidate,
+}
+
+// RunCommand Runs the subcommand name, ok=false when there is no such subcommand
+// and the default counting mode must be used.
+func RunCommand(ctx context.Context, name string, args []string) (ok bool, err error) {
+	cmd, ok := commands[name]
+	if !ok {
+		return false, nil
+	}
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		return true, err
+	}
+	defer logger.Sync()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return true, cmd(ctx, logger, args)
+}
+
+// newFlagSet FlagSet of a subcommand with usage errors returned instead of exit.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
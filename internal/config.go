@@ -0,0 +1,355 @@
+package internal
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"unique-ip-counter/internal/enrich"
+	"unique-ip-counter/internal/file_processor"
+	"unique-ip-counter/internal/grok"
+	"unique-ip-counter/internal/hll"
+)
+
+// config Run args of the application.
+type config struct {
+	paths       []string
+	th          int
+	resultsPath string
+	matrixPath  string
+	manifest    string
+	historyPath string
+	historyTag  string
+
+	stopAfterUniques  uint64
+	saturationCeiling uint64
+	memoryWatermark   uint64
+	sketch            string
+	sketchPrecision   uint
+	backend           string
+	debugAddr         string
+	gops              bool
+	summary           *template.Template
+
+	emitPlan string
+	usePlan  string
+	pcapDst  bool
+	balance  bool
+
+	estimateOnly   bool
+	estimateBudget uint64
+	estimateRanges int
+
+	parquetCol string
+	csvCol     string
+	header     bool
+	format     string
+	grok       *grok.Grok
+
+	invalidSamples int
+	invalidOut     string
+	delim          string
+	skipPrefixes   []string
+	comments       bool
+	extractAll     bool
+	stripPort      bool
+	trimSpace      bool
+	force          bool
+	intAddr        bool
+	strictIPv4     bool
+	failFast       bool
+	maxInvalidPct  float64
+	maxLineBytes   uint64
+	resolve        bool
+	resolveConc    int
+	resolveTimeout time.Duration
+	cidr           string
+	ipv6Prefix     int
+	ipv6Spill      string
+	ipv6RunKeys    int
+	statusCol      string
+	uaCol          string
+
+	watchDir    string
+	watchPoll   time.Duration
+	watchReport time.Duration
+	tailFirst   uint64
+
+	enrichers []enrich.Enricher
+}
+
+// maxDelimiter Longest -d.
+const maxDelimiter = 64
+
+func parseConfig() config {
+	var c config
+	flag.Var((*stringsFlag)(&c.paths), "f", "path to file(repeatable, extra positional args are inputs too)")
+	flag.IntVar(&c.th, "th", runtime.NumCPU(), "count of goroutines + shards")
+	flag.StringVar(&c.debugAddr, "debug-addr", "", "serve expvar counters on this address(/debug/vars)")
+	flag.Uint64Var(&c.stopAfterUniques, "stop-after-uniques", 0, "stop reading once this many uniques are seen(0 - disabled)")
+	flag.Uint64Var(&c.saturationCeiling, "saturation-ceiling", 0, "report saturation and stop once this many uniques are seen(0 - 2^32)")
+	flag.StringVar(&c.sketch, "sketch", "", "count approximately with a fixed size sketch instead of the exact bitset: hll(HyperLogLog, ~0.8% error)")
+	flag.StringVar(&c.backend, "backend", "dense", "containers of the exact bitset: dense(an 8KB bitmap per used /16), roaring(sorted arrays until a /16 holds 4096 addresses) or flat(the whole 512MB up front as one array)")
+	flag.UintVar(&c.sketchPrecision, "sketch-precision", hll.DefaultPrecision, "-sketch hll: 2^p registers(4..18), the standard error is 1.04/sqrt(2^p)")
+	flag.Var((*byteSize)(&c.memoryWatermark), "memory-watermark", "once the RSS crosses this size(e.g. 6GiB) count addresses of new /16 blocks with a fixed size approximate sketch, the result is marked as mixed exactness(0 - disabled)")
+	flag.StringVar(&c.resultsPath, "results", "", "stream one NDJSON record per completed input to this file")
+	flag.StringVar(&c.matrixPath, "dims-matrix", "", "stream the matrix of the -status-col/-ua-col buckets(unique per bucket, per pair of buckets and per dimension) of every input to this file: CSV for a .csv path, NDJSON otherwise")
+	flag.StringVar(&c.historyPath, "history", "", "append the count of every input to this NDJSON history and report the change against the previous run of its series")
+	flag.StringVar(&c.historyTag, "history-tag", "", "-history: series the inputs belong to, e.g. 'edge-nightly'(default - the input path)")
+	flag.StringVar(&c.manifest, "manifest", "", "write a reproducibility manifest of the run(version, commit, effective options, input hashes, environment, results) to this JSON file")
+	flag.BoolVar(&c.gops, "gops", false, "start the gops agent(binary built with -tags gops)")
+	flag.StringVar(&c.emitPlan, "emit-plan", "", "write the shard byte ranges of the input to this JSON file and exit without counting")
+	flag.BoolVar(&c.estimateOnly, "estimate-only", false, "estimate the unique count of every input from a random sample of byte ranges and exit without counting")
+	c.estimateBudget = 64 << 20
+	flag.Var((*byteSize)(&c.estimateBudget), "estimate-budget", "-estimate-only: bytes read per input(e.g. 256MiB)")
+	flag.IntVar(&c.estimateRanges, "estimate-ranges", 256, "-estimate-only: random byte ranges the budget is split into(at least 64KB each)")
+	flag.StringVar(&c.usePlan, "use-plan", "", "process only the shard byte ranges of this JSON plan(-th is ignored)")
+	flag.StringVar(&c.parquetCol, "parquet-col", "", "column of .parquet inputs holding the address(dotted path for nested groups)")
+	flag.StringVar(&c.csvCol, "csv-col", "", "count a field of comma separated rows: 1-based index or header name")
+	flag.StringVar(&c.csvCol, "column", "", "same as -csv-col")
+	flag.BoolVar(&c.header, "header", false, "the first row of every CSV input is a header(skipped, -column may name its field)")
+	flag.StringVar(&c.format, "format", "", "extract the address from log lines of this format: "+strings.Join(file_processor.Formats(), ", "))
+	flag.IntVar(&c.invalidSamples, "invalid-samples", 10, "report up to this many distinct invalid lines per input(reservoir sampled, 0 - disabled)")
+	flag.StringVar(&c.invalidOut, "invalid-out", "", "write every invalid line as path<TAB>byte offset<TAB>line number(~ - estimated)<TAB>line to this file")
+	flag.StringVar(&c.watchDir, "watch", "", "count new and appended lines of the files of this directory until interrupted")
+	flag.DurationVar(&c.watchPoll, "watch-poll", time.Second, "-watch polling interval where native notifications are not available")
+	flag.Var((*byteSize)(&c.tailFirst), "tail-first", "-watch: follow files found larger than this size(e.g. 2GiB) from their last that many bytes at once and backfill their head in the background(0 - disabled)")
+	flag.DurationVar(&c.watchReport, "watch-report", 10*time.Second, "how often -watch logs the running unique count")
+	grokExpr := flag.String("grok", "", "extract the address with a grok expression, e.g. '%{IPORHOST:client}' or '%{COMBINEDAPACHELOG}'")
+	grokField := flag.String("grok-field", "", "named field of -grok holding the address(default - the first one)")
+	var grokDirs []string
+	flag.Var((*stringsFlag)(&grokDirs), "grok-patterns", "directory of grok pattern files(repeatable, override the builtin patterns)")
+	flag.Var((*stringsFlag)(&c.skipPrefixes), "skip-prefix", "skip lines starting with this prefix, e.g. '#'(repeatable, reported as skipped, not invalid)")
+	flag.BoolVar(&c.comments, "strip-comments", false, "strip inline comments from the first '#' or ';' of a line on, e.g. '1.2.3.4  # edge-router'(comment-only lines are reported as skipped)")
+	flag.BoolVar(&c.extractAll, "extract", false, "count every IPv4 address found anywhere in a line(unstructured logs), lines without one are reported as skipped")
+	flag.BoolVar(&c.stripPort, "strip-port", false, "accept addresses with a source port, e.g. 1.2.3.4:8080 or [2001:db8::1]:443(load balancer and proxy logs)")
+	flag.BoolVar(&c.force, "force", false, "count inputs whose head looks binary(NUL and control bytes) instead of failing")
+	flag.BoolVar(&c.intAddr, "int-addr", false, "also accept addresses written as a decimal(3232235521) or hex(0xC0A80001) integer")
+	flag.BoolVar(&c.trimSpace, "trim-space", false, "trim spaces and tabs around the address(padded columns of hand-assembled lists) instead of reporting the line as invalid")
+	flag.BoolVar(&c.strictIPv4, "strict-ipv4", false, "reject dotted addresses with leading zeros(001.002.003.004), as net/netip and other strict parsers do")
+	flag.BoolVar(&c.failFast, "strict", false, "abort an input on its first invalid line with its byte offset and content instead of counting it as invalid")
+	flag.Float64Var(&c.maxInvalidPct, "max-invalid-pct", 0, "fail an input once over this percentage of its lines is invalid(wrong column, binary file), 0 - disabled")
+	flag.Var((*byteSize)(&c.maxLineBytes), "max-line-bytes", "skip lines longer than this size(e.g. 64KiB) as invalid as soon as that much is buffered(0 - the 2MB reader buffer)")
+	flag.BoolVar(&c.resolve, "resolve", false, "resolve lines that are hostnames(mixed host/IP allowlists) and count their A records, names without one are invalid")
+	flag.IntVar(&c.resolveConc, "resolve-concurrency", 32, "-resolve: DNS lookups at the same time")
+	flag.DurationVar(&c.resolveTimeout, "resolve-timeout", 5*time.Second, "-resolve: timeout of a lookup")
+	flag.IntVar(&c.ipv6Prefix, "ipv6-prefix", 64, "also count the distinct IPv6 /n prefixes: 64(subnets), 48(sites) or 0 - none")
+	flag.StringVar(&c.ipv6Spill, "ipv6-spill", "", "count IPv6 addresses exactly from sorted runs written to this directory instead of an in-memory set")
+	flag.IntVar(&c.ipv6RunKeys, "ipv6-run-keys", 1<<22, "-ipv6-spill: addresses of a run, 16 bytes each are buffered")
+	flag.StringVar(&c.cidr, "cidr", "", "count prefix lines(10.0.0.0/24): range - every address of the prefix, prefix - the prefix as one unique")
+	flag.StringVar(&c.statusCol, "status-col", "", "count unique addresses per HTTP status class too: CSV column(1-based index or header name) or the status field of -format clf/combined")
+	flag.StringVar(&c.uaCol, "ua-col", "", "count unique addresses per user agent class too(known-bot, bot, human, unknown): CSV column or the agent field of -format combined")
+	nul := flag.Bool("0", false, "records are separated by NUL as well as by line breaks(find -print0 style exports)")
+	delim := flag.String("d", "", "records are separated by this delimiter as well as by line breaks, e.g. ',' or '<EOR>'(escapes: '\\t', '\\x00', '\\r\\n\\r\\n' - multi-line records)")
+	var enrichSpecs []string
+	flag.Var((*stringsFlag)(&enrichSpecs), "enrich", "post-processing of the unique set, name[:key=value,...](repeatable): "+strings.Join(enrich.Names(), ", "))
+	flag.BoolVar(&c.balance, "balance", false, "decompress and parse seekable zstd and BGZF inputs on one pool of -th goroutines shifted between the two by queue depth, instead of static groups of frames")
+	pcapAddr := flag.String("pcap-addr", "src", "address of .pcap/.pcapng packets to count: src or dst")
+	summaryTmpl := flag.String("summary-template", defaultSummaryTemplate, "text/template of the stdout summary line(.Unique .Seconds .Path .Threads .Stopped .Saturated .HoleBytes .Invalid .InvalidSamples .Skipped .Dimensions .IPv4 .IPv6 .Octets .Approx .ApproxStdErr .StdErr .CILow .CIHigh .Previous)")
+	flag.Parse()
+	c.paths = append(c.paths, flag.Args()...)
+	c.delim = "\n"
+	if *nul && *delim != "" {
+		log.Fatal("-0 and -d are exclusive")
+	}
+	if *nul {
+		*delim = `\x00`
+	}
+	if *delim != "" {
+		d, err := strconv.Unquote(`"` + *delim + `"`)
+		if err != nil || d == "" || len(d) > maxDelimiter {
+			log.Fatalf("bad -d %q: want 1..%d bytes", *delim, maxDelimiter)
+		}
+		if c.format == "w3c" || c.format == "iis" {
+			log.Fatal("-0/-d cannot be combined with line based -format w3c")
+		}
+		c.delim = d
+	}
+	if len(c.paths) == 0 && c.watchDir == "" {
+		log.Fatal("please provide path to file")
+	}
+	if c.watchDir != "" && (len(c.paths) > 0 || c.emitPlan != "" || c.usePlan != "") {
+		log.Fatal("-watch cannot be combined with inputs or shard plans")
+	}
+	if c.tailFirst > 0 && (c.watchDir == "" || c.format == "w3c" || c.format == "iis") {
+		log.Fatal("-tail-first needs -watch and a format without W3C directives")
+	}
+	if c.watchDir != "" && (c.failFast || c.maxInvalidPct > 0) {
+		log.Fatal("-strict and -max-invalid-pct cannot be combined with -watch")
+	}
+	if c.maxInvalidPct < 0 || c.maxInvalidPct >= 100 {
+		log.Fatalf("bad -max-invalid-pct %g: want 0..100", c.maxInvalidPct)
+	}
+	if c.resolve && (c.watchDir != "" || c.resolveConc < 1 || c.resolveTimeout <= 0) {
+		log.Fatal("-resolve cannot be combined with -watch, -resolve-concurrency and -resolve-timeout must be positive")
+	}
+	if c.maxLineBytes > 1<<30 {
+		log.Fatalf("bad -max-line-bytes %d: want at most 1GiB", c.maxLineBytes)
+	}
+	if c.watchReport <= 0 {
+		log.Fatal("-watch-report must be positive")
+	}
+
+	if (c.emitPlan != "" || c.usePlan != "") && (len(c.paths) != 1 || !isLocal(c.paths[0]) || !isPlainText(c.paths[0])) {
+		log.Fatal("-emit-plan and -use-plan need exactly one local text file")
+	}
+
+	if c.estimateOnly {
+		if c.watchDir != "" || c.emitPlan != "" || c.usePlan != "" || c.extractAll {
+			log.Fatal("-estimate-only cannot be combined with -watch, shard plans or -extract")
+		}
+		if c.estimateBudget == 0 || c.estimateRanges < 1 {
+			log.Fatal("-estimate-budget and -estimate-ranges must be positive")
+		}
+		for _, path := range c.paths {
+			if !isPlainText(path) {
+				log.Fatalf("-estimate-only needs uncompressed text inputs: %s", path)
+			}
+		}
+	}
+
+	for _, path := range c.paths {
+		if strings.EqualFold(filepath.Ext(path), ".parquet") && c.parquetCol == "" {
+			log.Fatal("please provide -parquet-col for parquet inputs")
+		}
+	}
+
+	if c.header && c.csvCol == "" {
+		log.Fatal("-header needs -column")
+	}
+
+	if !file_processor.IsFormat(c.format) {
+		log.Fatalf("bad -format %q: want one of %s", c.format, strings.Join(file_processor.Formats(), ", "))
+	}
+	if c.format != "" && c.format != "plain" && c.csvCol != "" {
+		log.Fatal("-format and -csv-col are exclusive")
+	}
+
+	if *grokExpr != "" {
+		if (c.format != "" && c.format != "plain") || c.csvCol != "" {
+			log.Fatal("-grok, -format and -csv-col are exclusive")
+		}
+		var err error
+		if c.grok, err = compileGrok(*grokExpr, *grokField, grokDirs); err != nil {
+			log.Fatalf("bad -grok: %v", err)
+		}
+	}
+
+	if c.invalidSamples < 0 || c.invalidSamples > 10000 {
+		log.Fatalf("bad -invalid-samples %d: want 0..10000", c.invalidSamples)
+	}
+
+	for _, spec := range enrichSpecs {
+		e, err := enrich.Parse(spec)
+		if err != nil {
+			log.Fatalf("bad -enrich: %v", err)
+		}
+		c.enrichers = append(c.enrichers, e)
+	}
+
+	for flagName, col := range map[string]string{"status-col": c.statusCol, "ua-col": c.uaCol} {
+		if col != "" && c.csvCol == "" && !slices.Contains(file_processor.FormatFields(c.format), col) {
+			log.Fatalf("bad -%s %q: want a column of -csv-col inputs or a field of -format clf/combined(%s)",
+				flagName, col, strings.Join(file_processor.FormatFields("combined"), ", "))
+		}
+	}
+
+	if c.matrixPath != "" && c.statusCol == "" && c.uaCol == "" {
+		log.Fatal("-dims-matrix needs -status-col or -ua-col")
+	}
+
+	switch c.cidr {
+	case "", file_processor.CIDRRange, file_processor.CIDRPrefix:
+	default:
+		log.Fatalf("bad -cidr %q: want %s or %s", c.cidr, file_processor.CIDRRange, file_processor.CIDRPrefix)
+	}
+
+	if c.ipv6Prefix != 0 && c.ipv6Prefix != 48 && c.ipv6Prefix != 64 {
+		log.Fatalf("bad -ipv6-prefix %d: want 64, 48 or 0", c.ipv6Prefix)
+	}
+	if c.ipv6RunKeys < 1 {
+		log.Fatalf("bad -ipv6-run-keys %d: want > 0", c.ipv6RunKeys)
+	}
+
+	switch c.sketch {
+	case "":
+	case "hll":
+		if c.sketchPrecision < 4 || c.sketchPrecision > 18 {
+			log.Fatalf("bad -sketch-precision %d: want 4..18", c.sketchPrecision)
+		}
+		if c.stopAfterUniques > 0 || c.saturationCeiling > 0 || c.memoryWatermark > 0 {
+			log.Fatal("-sketch hll counts nothing exactly, -stop-after-uniques, -saturation-ceiling and -memory-watermark do not apply")
+		}
+		if c.cidr == file_processor.CIDRRange {
+			log.Fatal("-cidr range sets the exact blocks of every prefix, -sketch hll does not apply(-cidr prefix does)")
+		}
+	default:
+		log.Fatalf("bad -sketch %q: want hll", c.sketch)
+	}
+	switch c.backend {
+	case "dense":
+	case "roaring":
+		if c.sketch != "" {
+			log.Fatal("-backend roaring is a backend of the exact bitset, -sketch hll replaces it")
+		}
+	case "flat":
+		if c.sketch != "" || c.memoryWatermark > 0 {
+			log.Fatal("-backend flat allocates the whole bitset up front, -sketch and -memory-watermark do not apply")
+		}
+	default:
+		log.Fatalf("bad -backend %q: want dense, roaring or flat", c.backend)
+	}
+
+	switch *pcapAddr {
+	case "src":
+	case "dst":
+		c.pcapDst = true
+	default:
+		log.Fatalf("bad -pcap-addr %q: want src or dst", *pcapAddr)
+	}
+
+	var err error
+	if c.summary, err = parseSummaryTemplate(*summaryTmpl); err != nil {
+		log.Fatalf("bad -summary-template: %v", err)
+	}
+
+	return c
+}
+
+// compileGrok Compiles expr against the builtin patterns extended by the pattern files of dirs.
+func compileGrok(expr, field string, dirs []string) (*grok.Grok, error) {
+	lib := grok.Builtin()
+	for _, dir := range dirs {
+		if err := lib.LoadDir(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return lib.Compile(expr, field)
+}
+
+// isPlainText Inputs of line oriented formats, containers(zip, pcap, parquet, zstd, gzip) and binary-be32 are detected by extension.
+func isPlainText(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip", ".pcap", ".pcapng", ".parquet", ".bin", ".zst", ".zstd", ".gz", ".bgz":
+		return false
+	}
+
+	return true
+}
+
+// stringsFlag Repeatable string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
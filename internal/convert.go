@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/chaos"
+	"unique-ip-counter/internal/file_processor"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// runConvert "convert -in access.log -format combined -out ips.bin" - extracts the addresses
+// of a text input and re-emits them pre-parsed without counting, ".bin" outputs are counted
+// later without any line parsing.
+func runConvert(ctx context.Context, logger *zap.Logger, args []string) error {
+	var in, out, format, csvCol, outFormat string
+	fs := newFlagSet("convert")
+	fs.StringVar(&in, "in", "", "text input file")
+	fs.StringVar(&out, "out", "", "output file")
+	fs.StringVar(&format, "format", "", "extract the address from log lines of this format: "+strings.Join(file_processor.Formats(), ", "))
+	fs.StringVar(&csvCol, "csv-col", "", "convert a field of comma separated rows: 1-based index or header name")
+	fs.StringVar(&outFormat, "out-format", file_processor.OutBinaryBE32, "output format: binary-be32 or text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if in == "" || out == "" {
+		return fmt.Errorf("please provide -in and -out")
+	}
+	if !file_processor.IsFormat(format) {
+		return fmt.Errorf("bad -format %q: want one of %s", format, strings.Join(file_processor.Formats(), ", "))
+	}
+
+	src, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// written next to out and renamed over, a failed run leaves no partial output
+	dst, err := os.CreateTemp(filepath.Dir(out), filepath.Base(out)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+
+	fp := file_processor.New(logger, src, ipv4_bitset.New(), 1,
+		file_processor.WithFormat(format),
+		file_processor.WithCSVColumn(csvCol),
+	)
+	n, err := fp.Convert(ctx, src, chaos.Writer(dst), outFormat)
+	if err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(dst.Name(), out); err != nil {
+		return err
+	}
+	logger.Info("converted", zap.String("out", out), zap.String("out_format", outFormat),
+		zap.Uint64("addresses", n), zap.Uint64("invalid", fp.InvalidCount()), zap.Strings("invalid_samples", fp.InvalidSamples()))
+
+	return nil
+}
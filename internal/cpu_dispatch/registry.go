@@ -0,0 +1,118 @@
+// Package cpu_dispatch is the registry of per-architecture implementations of hot routines
+// (parser, popcount, memory scan). Packages register a portable implementation plus any
+// optimized ones guarded by a CPU Feature, the best supported one is picked once on first use.
+// Packages resolve it into a package-level func variable at init, so the hot path pays neither
+// the sync.Once nor the lookup however many optimized paths accumulate.
+package cpu_dispatch
+
+import (
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/sys/cpu"
+)
+
+// Feature CPU capability an implementation requires.
+type Feature int
+
+const (
+	Generic Feature = iota // always available
+	AVX2                   // amd64
+	NEON                   // arm64 ASIMD
+)
+
+// forceGenericEnv disables every optimized implementation(benchmarks, bug hunting).
+const forceGenericEnv = "UIP_DISPATCH_GENERIC"
+
+var (
+	mu         sync.Mutex
+	registries []interface{ selected() (string, string) }
+)
+
+// Has Reports whether the running CPU supports f.
+func Has(f Feature) bool {
+	switch f {
+	case Generic:
+		return true
+	case AVX2:
+		return cpu.X86.HasAVX2
+	case NEON:
+		return cpu.ARM64.HasASIMD
+	default:
+		return false
+	}
+}
+
+type (
+	// Registry Implementations of one routine with signature F.
+	Registry[F any] struct {
+		name  string
+		impls []impl[F]
+		once  sync.Once
+		best  impl[F]
+	}
+	impl[F any] struct {
+		name     string
+		feature  Feature
+		priority int
+		fn       F
+	}
+)
+
+func NewRegistry[F any](name string) *Registry[F] {
+	r := &Registry[F]{name: name}
+	mu.Lock()
+	registries = append(registries, r)
+	mu.Unlock()
+
+	return r
+}
+
+// Register Adds an implementation, the highest priority supported one wins.
+// Must be called from init, before the first Get.
+func (r *Registry[F]) Register(name string, feature Feature, priority int, fn F) {
+	r.impls = append(r.impls, impl[F]{name: name, feature: feature, priority: priority, fn: fn})
+}
+
+// Get Returns the selected implementation.
+func (r *Registry[F]) Get() F {
+	r.once.Do(func() {
+		generic := os.Getenv(forceGenericEnv) != ""
+		cands := make([]impl[F], 0, len(r.impls))
+		for _, im := range r.impls {
+			if Has(im.feature) && (!generic || im.feature == Generic) {
+				cands = append(cands, im)
+			}
+		}
+		if len(cands) == 0 {
+			panic("cpu_dispatch: no usable implementation of " + r.name)
+		}
+		sort.SliceStable(cands, func(i, j int) bool { return cands[i].priority > cands[j].priority })
+		r.best = cands[0]
+	})
+
+	return r.best.fn
+}
+
+func (r *Registry[F]) selected() (string, string) {
+	if len(r.impls) == 0 {
+		return r.name, "<none>"
+	}
+	r.Get()
+
+	return r.name, r.best.name
+}
+
+// Selected Routine name => chosen implementation name, for startup logs.
+func Selected() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+	res := make(map[string]string, len(registries))
+	for _, r := range registries {
+		routine, im := r.selected()
+		res[routine] = im
+	}
+
+	return res
+}
@@ -0,0 +1,40 @@
+package cpu_dispatch
+
+import "testing"
+
+func TestRegistry_PicksBestSupported(t *testing.T) {
+	r := NewRegistry[func() string]("test-best")
+	r.Register("generic", Generic, 0, func() string { return "generic" })
+	r.Register("fast", Generic, 10, func() string { return "fast" })
+	// unknown features are never supported
+	r.Register("future", Feature(99), 100, func() string { return "future" })
+
+	if got := r.Get()(); got != "fast" {
+		t.Fatalf("selected %q; want fast", got)
+	}
+	if got := Selected()["test-best"]; got != "fast" {
+		t.Fatalf("Selected()[test-best]=%q; want fast", got)
+	}
+}
+
+func TestRegistry_ForceGeneric(t *testing.T) {
+	t.Setenv(forceGenericEnv, "1")
+	r := NewRegistry[func() string]("test-generic")
+	r.Register("generic", Generic, 0, func() string { return "generic" })
+	r.Register("avx2", AVX2, 10, func() string { return "avx2" })
+	r.Register("neon", NEON, 10, func() string { return "neon" })
+
+	if got := r.Get()(); got != "generic" {
+		t.Fatalf("selected %q; want generic", got)
+	}
+}
+
+func TestRegistry_NoImplPanics(t *testing.T) {
+	r := NewRegistry[func()]("test-empty")
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic without implementations")
+		}
+	}()
+	r.Get()
+}
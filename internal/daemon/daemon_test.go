@@ -0,0 +1,430 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/ingest"
+	"unique-ip-counter/internal/ipv4_bitset"
+	"unique-ip-counter/pkg/uipcounter"
+)
+
+const testToken = "s3cret"
+
+// adminRequest A request to /admin/* bearing testToken.
+func adminRequest(t *testing.T, method, url, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+
+	return resp
+}
+
+func TestDaemon_JobsAndStats(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	snaps, err := NewSnapshots(filepath.Join(dir, "snapshots"), Retention{KeepLast: 1})
+	if err != nil {
+		t.Fatalf("NewSnapshots: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := NewRunner(zap.NewNop(), store, 2, 8, Limits{})
+	go runner.Run(ctx, 2)
+	srv := httptest.NewServer(Handler(runner, store, snaps, testToken))
+	defer srv.Close()
+
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return path
+	}
+	submit := func(req JobRequest) Job {
+		body, _ := json.Marshal(req)
+		resp, err := http.Post(srv.URL+"/jobs", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST /jobs: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("POST /jobs status=%d; want 202", resp.StatusCode)
+		}
+		var job Job
+		_ = json.NewDecoder(resp.Body).Decode(&job)
+
+		// wait for the job
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if got, _ := runner.Get(job.ID); got.State == StateDone || got.State == StateFailed {
+				return got
+			}
+		}
+		t.Fatalf("job %s did not finish", job.ID)
+		return job
+	}
+
+	// day 1: two files, the second overlaps the first
+	a := submit(JobRequest{Path: write("a.txt", "1.1.1.1\n2.2.2.2\n"), Tag: "edge", Date: "2024-03-01"})
+	b := submit(JobRequest{Path: write("b.txt", "2.2.2.2\n3.3.3.3\n"), Tag: "edge", Date: "2024-03-01"})
+	if a.State != StateDone || b.State != StateDone || b.DayUnique != 3 {
+		t.Fatalf("jobs a=%+v b=%+v; want done, day_unique 3", a, b)
+	}
+	// day 2 and another tag
+	submit(JobRequest{Path: write("c.txt", "3.3.3.3\n4.4.4.4\n"), Tag: "edge", Date: "2024-03-02"})
+	submit(JobRequest{Path: write("d.txt", "9.9.9.9\n"), Tag: "other", Date: "2024-03-02"})
+	if failed := submit(JobRequest{Path: filepath.Join(dir, "missing"), Tag: "edge"}); failed.State != StateFailed || failed.Error == "" {
+		t.Fatalf("missing file job=%+v; want failed", failed)
+	}
+
+	getStats := func(query string) (Stats, int) {
+		resp, err := http.Get(srv.URL + "/stats/edge" + query)
+		if err != nil {
+			t.Fatalf("GET /stats: %v", err)
+		}
+		defer resp.Body.Close()
+		var st Stats
+		_ = json.NewDecoder(resp.Body).Decode(&st)
+		return st, resp.StatusCode
+	}
+
+	st, code := getStats("?from=2024-03-01&to=2024-03-31")
+	if code != http.StatusOK || len(st.Days) != 2 || st.Sum != 5 || st.Union != 4 {
+		t.Fatalf("stats=%+v code=%d; want 2 days, sum 5, union 4", st, code)
+	}
+	if st.Days[0] != (DayCount{Date: "2024-03-01", Unique: 3}) || st.Days[1] != (DayCount{Date: "2024-03-02", Unique: 2}) {
+		t.Fatalf("days=%+v", st.Days)
+	}
+	if st, _ = getStats("?from=2024-03-02&to=2024-03-02"); st.Sum != 2 || st.Union != 2 {
+		t.Fatalf("single day stats=%+v; want sum 2, union 2", st)
+	}
+	if _, code = getStats("?from=2024-03-05&to=2024-03-01"); code != http.StatusBadRequest {
+		t.Fatalf("from after to status=%d; want 400", code)
+	}
+	if _, code = getStats("?from=yesterday"); code != http.StatusBadRequest {
+		t.Fatalf("bad from status=%d; want 400", code)
+	}
+
+	resp, err := http.Post(srv.URL+"/jobs", "application/json", bytes.NewReader([]byte(`{"path":"x","tag":"../etc"}`)))
+	if err != nil {
+		t.Fatalf("POST /jobs: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad tag status=%d; want 400", resp.StatusCode)
+	}
+
+	// snapshots of the union, KeepLast 1 keeps the newest only
+	snapshot := func(body string) (Snapshot, int) {
+		resp := adminRequest(t, http.MethodPost, srv.URL+"/admin/snapshots", body)
+		defer resp.Body.Close()
+		var sn Snapshot
+		_ = json.NewDecoder(resp.Body).Decode(&sn)
+		return sn, resp.StatusCode
+	}
+	if _, code = snapshot(`{"name":"march","tag":"edge","from":"2024-03-01","to":"2024-03-31"}`); code != http.StatusCreated {
+		t.Fatalf("snapshot status=%d; want 201", code)
+	}
+	sn, code := snapshot(`{"name":"march","tag":"edge","from":"2024-03-01","to":"2024-03-31"}`)
+	if code != http.StatusCreated || sn.Name != "march" || sn.Bytes == 0 {
+		t.Fatalf("snapshot=%+v status=%d", sn, code)
+	}
+	if _, code = snapshot(`{"name":"../x","tag":"edge"}`); code != http.StatusBadRequest {
+		t.Fatalf("bad name status=%d; want 400", code)
+	}
+	list, err := snaps.List("")
+	if err != nil || len(list) != 1 || list[0].ID != sn.ID {
+		t.Fatalf("List=%+v, %v; want the newest snapshot only", list, err)
+	}
+	bs := ipv4_bitset.New()
+	if err = ingest.LoadState(filepath.Join(dir, "snapshots", "march", sn.ID+".pb"), bs); err != nil || bs.GetUniqueCount() != 4 {
+		t.Fatalf("snapshot set: unique=%d, %v; want the union 4", bs.GetUniqueCount(), err)
+	}
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/admin/snapshots/march/" + sn.ID, http.StatusOK},
+		{"/admin/snapshots/march/" + sn.ID, http.StatusNotFound},
+		{"/admin/snapshots/march/yesterday", http.StatusBadRequest},
+	} {
+		resp := adminRequest(t, http.MethodDelete, srv.URL+tt.path, "")
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Fatalf("DELETE %s status=%d; want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestRetention_keep(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	var snaps []Snapshot
+	// two snapshots a day for 40 days
+	for d := 39; d >= 0; d-- {
+		for _, h := range []int{1, 9} {
+			snaps = append(snaps, Snapshot{Time: time.Date(2024, 3, 31-d, h, 0, 0, 0, time.UTC)})
+		}
+	}
+	count := func(keep []bool) (n int) {
+		for _, k := range keep {
+			if k {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := count(Retention{KeepLast: 3}.keep(snaps, now)); n != 3 {
+		t.Fatalf("KeepLast 3 kept %d", n)
+	}
+	keep := Retention{KeepDaily: 30}.keep(snaps, now)
+	if n := count(keep); n != 30 {
+		t.Fatalf("KeepDaily 30 kept %d; want one per day", n)
+	}
+	if !keep[len(keep)-1] || keep[len(keep)-2] || keep[len(keep)-60] || !keep[len(keep)-59] {
+		t.Fatalf("KeepDaily keeps the wrong snapshots of the day or of the range")
+	}
+	// the union of both rules
+	if n := count(Retention{KeepLast: 3, KeepDaily: 30}.keep(snaps, now)); n != 31 {
+		t.Fatalf("KeepLast 3 + KeepDaily 30 kept %d; want 31", n)
+	}
+}
+
+func TestRunner_Limits(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	// 3 /16s => 24KB of shards per file set
+	for i, data := range []string{"1.1.0.1\n2.2.0.1\n", "3.3.0.1\n1.1.0.2\n"} {
+		if err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("in%d.txt", i)), []byte(data), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		limits  Limits
+		wantErr error
+	}{
+		{"unlimited", Limits{}, nil},
+		{"one-fd", Limits{MaxFDs: 1}, nil},
+		{"duration", Limits{MaxDuration: time.Nanosecond}, ErrLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := NewRunner(zap.NewNop(), store, 1, 1, tt.limits)
+			job := &Job{JobRequest: JobRequest{Path: filepath.Join(dir, "in*.txt"), Tag: tt.name}, day: time.Now()}
+
+			res, err := r.count(context.Background(), job)
+			if tt.wantErr == nil {
+				if err != nil || res.files != 2 || res.unique != 4 {
+					t.Fatalf("count=%+v, %v; want 2 files, 4 uniques", res, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("count err=%v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunner_Root(t *testing.T) {
+	t.Parallel()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("EvalSymlinks: %v", err)
+	}
+	root := filepath.Join(dir, "root")
+	store, err := NewStore(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if err = os.MkdirAll(root, 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, path := range []string{filepath.Join(root, "in.txt"), filepath.Join(dir, "secret.txt")} {
+		if err = os.WriteFile(path, []byte("1.1.1.1\n"), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err = os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	r := NewRunner(zap.NewNop(), store, 1, 8, Limits{Root: root})
+
+	for _, path := range []string{filepath.Join(dir, "secret.txt"), "../secret.txt", filepath.Join(root, "..", "*.txt")} {
+		if _, err = r.Submit(JobRequest{Path: path, Tag: "edge"}); !errors.Is(err, ErrBadJob) {
+			t.Fatalf("Submit(%q) err=%v; want ErrBadJob", path, err)
+		}
+	}
+	job, err := r.Submit(JobRequest{Path: "in.txt", Tag: "edge"})
+	if err != nil || job.Path != filepath.Join(root, "in.txt") {
+		t.Fatalf("Submit(in.txt)=%+v, %v; want the path under the root", job, err)
+	}
+	if res, err := r.count(context.Background(), &Job{JobRequest: job.JobRequest, day: time.Now()}); err != nil || res.unique != 1 {
+		t.Fatalf("count(in.txt)=%+v, %v; want 1 unique", res, err)
+	}
+	// the glob matches the symlink leaving the root
+	link := &Job{JobRequest: JobRequest{Path: filepath.Join(root, "*.txt"), Tag: "edge"}, day: time.Now()}
+	if _, err = r.count(context.Background(), link); err == nil || !strings.Contains(err.Error(), "outside the root") {
+		t.Fatalf("count(*.txt) err=%v; want the symlink rejected", err)
+	}
+}
+
+func TestHandler_AdminToken(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	snaps, err := NewSnapshots(filepath.Join(dir, "snapshots"), Retention{})
+	if err != nil {
+		t.Fatalf("NewSnapshots: %v", err)
+	}
+	runner := NewRunner(zap.NewNop(), store, 1, 8, Limits{})
+
+	for _, tt := range []struct {
+		token, auth string
+		want        int
+	}{
+		{testToken, "Bearer " + testToken, http.StatusOK},
+		{testToken, "", http.StatusUnauthorized},
+		{testToken, "Bearer wrong", http.StatusUnauthorized},
+		{testToken, testToken, http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusForbidden},
+	} {
+		srv := httptest.NewServer(Handler(runner, store, snaps, tt.token))
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/admin/parallelism", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("GET /admin/parallelism: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Fatalf("token=%q auth=%q status=%d; want %d", tt.token, tt.auth, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestRunner_SetParallelism(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	input := filepath.Join(dir, "in.txt")
+	if err = os.WriteFile(input, []byte("1.1.1.1\n2.2.2.2\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	snaps, err := NewSnapshots(filepath.Join(dir, "snapshots"), Retention{})
+	if err != nil {
+		t.Fatalf("NewSnapshots: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := NewRunner(zap.NewNop(), store, 1, 8, Limits{})
+	go runner.Run(ctx, 1)
+	srv := httptest.NewServer(Handler(runner, store, snaps, testToken))
+	defer srv.Close()
+
+	put := func(body string) (int, Parallelism) {
+		resp := adminRequest(t, http.MethodPut, srv.URL+"/admin/parallelism", body)
+		defer resp.Body.Close()
+		var p Parallelism
+		_ = json.NewDecoder(resp.Body).Decode(&p)
+		return resp.StatusCode, p
+	}
+	running := func(want int) {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			runner.mu.Lock()
+			n := runner.running
+			runner.mu.Unlock()
+			if n == want {
+				return
+			}
+		}
+		t.Fatalf("running workers never reached %d", want)
+	}
+	running(1)
+
+	// nighttime: more shards per job and more jobs at once
+	if code, p := put(`{"th":8,"workers":4}`); code != http.StatusOK || p != (Parallelism{Th: 8, Workers: 4}) {
+		t.Fatalf("PUT status=%d parallelism=%+v; want 200, th 8, workers 4", code, p)
+	}
+	running(4)
+	job, err := runner.Submit(JobRequest{Path: input, Tag: "night"})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if job, _ = runner.Get(job.ID); job.State == StateDone {
+			break
+		}
+	}
+	if job.State != StateDone || job.Threads != 8 || job.Unique != 2 {
+		t.Fatalf("job %+v; want done with th 8 and 2 uniques", job)
+	}
+
+	// zero fields are kept, idle surplus workers exit
+	if code, p := put(`{"workers":2}`); code != http.StatusOK || p != (Parallelism{Th: 8, Workers: 2}) {
+		t.Fatalf("PUT status=%d parallelism=%+v; want 200, th 8, workers 2", code, p)
+	}
+	running(2)
+	if code, _ := put(`{"th":-1}`); code != http.StatusBadRequest {
+		t.Fatalf("PUT th=-1 status=%d; want 400", code)
+	}
+	resp := adminRequest(t, http.MethodGet, srv.URL+"/admin/parallelism", "")
+	defer resp.Body.Close()
+	var p Parallelism
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil || p != (Parallelism{Th: 8, Workers: 2}) {
+		t.Fatalf("GET parallelism=%+v, %v; want th 8, workers 2", p, err)
+	}
+}
+
+func Test_watchMemory(t *testing.T) {
+	t.Parallel()
+	// 3 /16s => 24KB of shards
+	c := uipcounter.New(nil, 1)
+	if err := c.CountReader(context.Background(), strings.NewReader("1.1.0.1\n2.2.0.1\n3.3.0.1\n")); err != nil {
+		t.Fatalf("CountReader: %v", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	watchMemory(ctx, c, 16<<10, cancel)
+	if err := context.Cause(ctx); !errors.Is(err, ErrLimit) {
+		t.Fatalf("cause=%v; want ErrLimit", err)
+	}
+}
@@ -0,0 +1,198 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultRange Days of GET /stats/{tag} without a from parameter.
+const defaultRange = 30
+
+// Handler HTTP API of the daemon:
+//
+//	POST /jobs {"path":..,"tag":..,"date":..} -> 202 job
+//	GET  /jobs/{id}                           -> job
+//	GET  /stats/{tag}?from=YYYY-MM-DD&to=YYYY-MM-DD -> Stats(default: the last 30 days)
+//	GET  /admin/parallelism                   -> Parallelism
+//	PUT  /admin/parallelism {"th":..,"workers":..} -> Parallelism(zero fields are kept)
+//	POST /admin/snapshots {"name":..,"tag":..,"from":..,"to":..} -> 201 Snapshot of the union(range as of /stats)
+//	GET  /admin/snapshots?name=..             -> []Snapshot(every name without a name)
+//	DELETE /admin/snapshots/{name}[/{id}]     -> {"deleted":n}
+//
+// /admin/* requires the "Authorization: Bearer <adminToken>" header, an empty adminToken disables it.
+func Handler(runner *Runner, store *Store, snaps *Snapshots, adminToken string) http.Handler {
+	mux := http.NewServeMux()
+	admin := func(pattern string, h http.HandlerFunc) { mux.Handle(pattern, requireToken(adminToken, h)) }
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req JobRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		job, err := runner.Submit(req)
+		switch {
+		case errors.Is(err, ErrBadJob):
+			writeError(w, http.StatusBadRequest, err)
+		case errors.Is(err, ErrBusy):
+			writeError(w, http.StatusServiceUnavailable, err)
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusAccepted, job)
+		}
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := runner.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("no such job"))
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+	mux.HandleFunc("GET /stats/{tag}", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := statsRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		st, err := store.Stats(r.PathValue("tag"), from, to)
+		if errors.Is(err, ErrBadTag) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	})
+
+	admin("GET /admin/parallelism", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, runner.Parallelism())
+	})
+	admin("PUT /admin/parallelism", func(w http.ResponseWriter, r *http.Request) {
+		var p Parallelism
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&p); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		p, err := runner.SetParallelism(p)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	})
+
+	admin("POST /admin/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		var req SnapshotRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		from, to, err := statsRange(req.From, req.To)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Name == "" {
+			req.Name = req.Tag
+		}
+		union, err := store.Union(req.Tag, from, to)
+		if err == nil {
+			var sn Snapshot
+			if sn, err = snaps.Take(req.Name, union); err == nil {
+				writeJSON(w, http.StatusCreated, sn)
+				return
+			}
+		}
+		writeError(w, snapshotStatus(err), err)
+	})
+	admin("GET /admin/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		list, err := snaps.List(r.URL.Query().Get("name"))
+		if err != nil {
+			writeError(w, snapshotStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	deleteSnapshots := func(w http.ResponseWriter, r *http.Request) {
+		n, err := snaps.Delete(r.PathValue("name"), r.PathValue("id"))
+		if err != nil {
+			writeError(w, snapshotStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Deleted int `json:"deleted"`
+		}{n})
+	}
+	admin("DELETE /admin/snapshots/{name}", deleteSnapshots)
+	admin("DELETE /admin/snapshots/{name}/{id}", deleteSnapshots)
+
+	return mux
+}
+
+// requireToken Serves h to requests bearing token only.
+func requireToken(token string, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeError(w, http.StatusForbidden, errors.New("admin API is disabled: no token is configured"))
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or bad bearer token"))
+			return
+		}
+		h(w, r)
+	})
+}
+
+func snapshotStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrBadTag), errors.Is(err, ErrBadSnapshot):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNoSnapshot):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// statsRange Parses from/to(inclusive days), to defaults to today(UTC), from to defaultRange days before to.
+func statsRange(fromS, toS string) (from, to time.Time, err error) {
+	to = time.Now().UTC().Truncate(24 * time.Hour)
+	if toS != "" {
+		if to, err = time.Parse(DayLayout, toS); err != nil {
+			return from, to, errors.New("bad to: want YYYY-MM-DD")
+		}
+	}
+	from = to.AddDate(0, 0, -(defaultRange - 1))
+	if fromS != "" {
+		if from, err = time.Parse(DayLayout, fromS); err != nil {
+			return from, to, errors.New("bad from: want YYYY-MM-DD")
+		}
+	}
+	if from.After(to) {
+		return from, to, errors.New("from is after to")
+	}
+
+	return from, to, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
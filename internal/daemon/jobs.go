@@ -0,0 +1,372 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"unique-ip-counter/pkg/uipcounter"
+)
+
+// Job states.
+const (
+	StateQueued  = "queued"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+// maxFinished Finished jobs kept for GET /jobs/{id}, older ones are forgotten.
+const maxFinished = 1000
+
+var (
+	// ErrBusy returned by Submit when the queue is full.
+	ErrBusy = errors.New("job queue is full")
+	// ErrBadJob returned by Submit for an invalid request.
+	ErrBadJob = errors.New("bad job")
+	// ErrLimit fails a job exceeding its Limits.
+	ErrLimit = errors.New("job limit exceeded")
+	// ErrBadParallelism returned by SetParallelism for th or workers out of range.
+	ErrBadParallelism = errors.New("bad parallelism")
+)
+
+// maxParallelism Largest th and workers of SetParallelism.
+const maxParallelism = 1024
+
+// memoryCheckEvery How often the unique set of a running job is checked against Limits.MaxMemory.
+const memoryCheckEvery = 50 * time.Millisecond
+
+type (
+	// JobRequest Counts the local files matching Path(a file or a glob pattern) and merges
+	// their set into the day Date(YYYY-MM-DD, default today UTC) of Tag.
+	JobRequest struct {
+		Path string `json:"path"`
+		Tag  string `json:"tag"`
+		Date string `json:"date,omitempty"`
+	}
+	Job struct {
+		ID string `json:"id"`
+		JobRequest
+		State     string    `json:"state"`
+		Threads   int       `json:"th,omitempty"` // shards of the job, th in effect when it started
+		Files     int       `json:"files,omitempty"`
+		Unique    uint64    `json:"unique,omitempty"` // uniques of the files
+		Memory    int64     `json:"memory_bytes,omitempty"`
+		DayUnique uint64    `json:"day_unique,omitempty"` // uniques of the tag on the day after merging the file
+		Error     string    `json:"error,omitempty"`
+		Submitted time.Time `json:"submitted"`
+		Finished  time.Time `json:"finished,omitzero"`
+
+		day time.Time
+	}
+	// Limits Resources of a single job, zero - unlimited.
+	Limits struct {
+		MaxFDs      int           // input files a job keeps open at the same time
+		MaxMemory   int64         // bytes of the unique set of a job
+		MaxDuration time.Duration // counting and storing
+		// absolute directory without symlinks the files of a job must resolve under,
+		// a relative job path is relative to it
+		Root string
+	}
+	// Parallelism Shards per job(th) and jobs counted at the same time(workers), zero - unchanged by SetParallelism.
+	Parallelism struct {
+		Th      int `json:"th"`
+		Workers int `json:"workers"`
+	}
+	// Runner Runs submitted jobs on a pool of workers, both the pool and the shards of a job
+	// can be resized while running(SetParallelism).
+	Runner struct {
+		logger *zap.Logger
+		store  *Store
+		limits Limits
+		queue  chan *Job
+
+		mu       sync.Mutex
+		par      Parallelism
+		running  int           // workers started and not exited
+		resized  chan struct{} // closed on every change of par.Workers, wakes idle workers
+		ctx      context.Context
+		stopped  bool
+		wg       sync.WaitGroup
+		seq      int
+		jobs     map[string]*Job
+		finished []string // ids in finish order
+	}
+)
+
+// NewRunner queue is the number of jobs waiting for a worker before Submit returns ErrBusy.
+func NewRunner(logger *zap.Logger, store *Store, th, queue int, limits Limits) *Runner {
+	return &Runner{
+		logger:  logger,
+		store:   store,
+		par:     Parallelism{Th: max(th, 1)},
+		limits:  limits,
+		queue:   make(chan *Job, queue),
+		resized: make(chan struct{}),
+		jobs:    make(map[string]*Job),
+	}
+}
+
+// Run Processes jobs on workers goroutines until ctx is done.
+func (r *Runner) Run(ctx context.Context, workers int) {
+	r.mu.Lock()
+	r.ctx = ctx
+	r.mu.Unlock()
+	_, _ = r.SetParallelism(Parallelism{Workers: max(workers, 1)})
+
+	<-ctx.Done()
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// Parallelism The th and workers in effect.
+func (r *Runner) Parallelism() Parallelism {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.par
+}
+
+// SetParallelism Changes th of the jobs started from now on and the number of workers: new workers start
+// at once, surplus ones exit when idle(a running job is finished first). Returns the parallelism in effect.
+func (r *Runner) SetParallelism(p Parallelism) (Parallelism, error) {
+	if p.Th < 0 || p.Th > maxParallelism || p.Workers < 0 || p.Workers > maxParallelism {
+		return Parallelism{}, fmt.Errorf("%w: th and workers must be 0..%d", ErrBadParallelism, maxParallelism)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p.Th > 0 {
+		r.par.Th = p.Th
+	}
+	if p.Workers > 0 && p.Workers != r.par.Workers {
+		r.par.Workers = p.Workers
+		close(r.resized)
+		r.resized = make(chan struct{})
+	}
+	// workers are started by Run, not before it or after it stopped
+	if r.ctx != nil && !r.stopped {
+		for ; r.running < r.par.Workers; r.running++ {
+			r.wg.Go(r.work)
+		}
+	}
+	r.logger.Info("parallelism", zap.Int("th", r.par.Th), zap.Int("workers", r.par.Workers))
+
+	return r.par, nil
+}
+
+// work Worker loop, exits when ctx is done or the pool is shrunk below the running workers.
+func (r *Runner) work() {
+	for {
+		r.mu.Lock()
+		if r.running > r.par.Workers {
+			r.running--
+			r.mu.Unlock()
+			return
+		}
+		ctx, resized := r.ctx, r.resized
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-resized:
+		case job := <-r.queue:
+			r.run(ctx, job)
+		}
+	}
+}
+
+// Submit Validates and enqueues req.
+func (r *Runner) Submit(req JobRequest) (Job, error) {
+	if req.Path == "" {
+		return Job{}, fmt.Errorf("%w: empty path", ErrBadJob)
+	}
+	if !ValidTag(req.Tag) {
+		return Job{}, fmt.Errorf("%w: tag %q", ErrBadJob, req.Tag)
+	}
+	if root := r.limits.Root; root != "" {
+		if !filepath.IsAbs(req.Path) {
+			req.Path = filepath.Join(root, req.Path)
+		}
+		if req.Path = filepath.Clean(req.Path); !within(root, req.Path) {
+			return Job{}, fmt.Errorf("%w: path %q is outside the root", ErrBadJob, req.Path)
+		}
+	}
+	day := time.Now().UTC()
+	if req.Date != "" {
+		var err error
+		if day, err = time.Parse(DayLayout, req.Date); err != nil {
+			return Job{}, fmt.Errorf("%w: date %q", ErrBadJob, req.Date)
+		}
+	}
+	req.Date = day.Format(DayLayout)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	job := &Job{ID: strconv.Itoa(r.seq), JobRequest: req, State: StateQueued, Submitted: time.Now(), day: day}
+	select {
+	case r.queue <- job:
+	default:
+		return Job{}, ErrBusy
+	}
+	r.jobs[job.ID] = job
+
+	return *job, nil
+}
+
+// Get A copy of the job id.
+func (r *Runner) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	return *job, true
+}
+
+func (r *Runner) run(ctx context.Context, job *Job) {
+	r.update(job, func(j *Job) { j.State = StateRunning })
+	r.logger.Info("job started", zap.String("id", job.ID), zap.String("path", job.Path), zap.String("tag", job.Tag))
+
+	res, err := r.count(ctx, job)
+	r.update(job, func(j *Job) {
+		j.Finished = time.Now()
+		j.Files, j.Memory = res.files, res.memory
+		if err != nil {
+			j.State, j.Error = StateFailed, err.Error()
+			return
+		}
+		j.State, j.Unique, j.DayUnique = StateDone, res.unique, res.dayUnique
+	})
+	if err != nil {
+		r.logger.Error("job failed", zap.String("id", job.ID), zap.Error(err))
+		return
+	}
+	r.logger.Info("job done", zap.String("id", job.ID), zap.Uint64("unique", res.unique), zap.Uint64("day_unique", res.dayUnique))
+}
+
+type jobResult struct {
+	files             int
+	unique, dayUnique uint64
+	memory            int64
+}
+
+// count Counts the files of the job within its limits and merges their set into the store.
+func (r *Runner) count(ctx context.Context, job *Job) (res jobResult, err error) {
+	files, err := filepath.Glob(job.Path)
+	if err != nil {
+		return res, err
+	}
+	if len(files) == 0 {
+		return res, fmt.Errorf("no file matches %q", job.Path)
+	}
+	if root := r.limits.Root; root != "" {
+		// a symlink under the root may point out of it
+		for _, f := range files {
+			resolved, err := filepath.EvalSymlinks(f)
+			if err != nil {
+				return res, err
+			}
+			if !within(root, resolved) {
+				return res, fmt.Errorf("%s resolves outside the root", f)
+			}
+		}
+	}
+	res.files = len(files)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if d := r.limits.MaxDuration; d > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, d, fmt.Errorf("%w: max duration %s", ErrLimit, d))
+		defer stop()
+	}
+
+	th := r.Parallelism().Th
+	r.update(job, func(j *Job) { j.Threads = th })
+	c := uipcounter.New(r.logger, th)
+	if r.limits.MaxMemory > 0 {
+		go watchMemory(ctx, c, r.limits.MaxMemory, cancel)
+	}
+	defer func() { res.memory = c.MemoryBytes() }()
+
+	// every goroutine holds one input open
+	g, gctx := errgroup.WithContext(ctx)
+	if r.limits.MaxFDs > 0 {
+		g.SetLimit(r.limits.MaxFDs)
+	}
+	for _, path := range files {
+		g.Go(func() error { return c.CountFile(gctx, path) })
+	}
+	if err = g.Wait(); err != nil {
+		// report the exceeded limit rather than "context canceled"
+		if cause := context.Cause(ctx); errors.Is(cause, ErrLimit) {
+			err = cause
+		}
+		return res, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, werr := c.WriteTo(pw)
+		pw.CloseWithError(werr)
+	}()
+	res.dayUnique, err = r.store.Add(job.Tag, job.day, pr)
+	_ = pr.CloseWithError(err)
+	res.unique = c.UniqueCount()
+
+	return res, err
+}
+
+// within Reports whether the clean absolute path is root or under it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// watchMemory Cancels the job once the unique set of c grows beyond limit bytes.
+func watchMemory(ctx context.Context, c *uipcounter.Counter, limit int64, cancel context.CancelCauseFunc) {
+	t := time.NewTicker(memoryCheckEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if m := c.MemoryBytes(); m > limit {
+				cancel(fmt.Errorf("%w: memory %d > %d bytes", ErrLimit, m, limit))
+				return
+			}
+		}
+	}
+}
+
+func (r *Runner) update(job *Job, fn func(j *Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(job)
+	if job.State != StateDone && job.State != StateFailed {
+		return
+	}
+	r.finished = append(r.finished, job.ID)
+	if len(r.finished) > maxFinished {
+		delete(r.jobs, r.finished[0])
+		r.finished = r.finished[1:]
+	}
+}
@@ -0,0 +1,328 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"unique-ip-counter/internal/ingest"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// SnapshotLayout Time of a snapshot in its file name(UTC), sorts lexicographically.
+const SnapshotLayout = "20060102T150405.000Z"
+
+var (
+	// ErrBadSnapshot returned for snapshot names that are not tags or unknown snapshot times.
+	ErrBadSnapshot = errors.New("bad snapshot")
+	// ErrNoSnapshot returned when a snapshot does not exist.
+	ErrNoSnapshot = errors.New("no such snapshot")
+)
+
+type (
+	// Snapshots Named point in time copies of unique sets, stored as uipcounter.v1.Counter messages:
+	//
+	//	<dir>/<name>/<YYYYMMDDTHHMMSS.mmmZ>.pb
+	//
+	// every Take applies the Retention to the snapshots of its name.
+	Snapshots struct {
+		dir       string
+		retention Retention
+		now       func() time.Time
+		mu        sync.Mutex
+	}
+	// Retention Which snapshots of a name are kept, zero fields keep nothing by themselves;
+	// a zero Retention keeps every snapshot.
+	Retention struct {
+		KeepLast  int // the newest snapshots
+		KeepDaily int // the newest snapshot of each of the last days(UTC, today included)
+	}
+	Snapshot struct {
+		Name  string    `json:"name"`
+		ID    string    `json:"id"` // its time in SnapshotLayout
+		Time  time.Time `json:"time"`
+		Bytes int64     `json:"bytes"`
+	}
+	// SnapshotRequest Snapshots the union of Tag over [From, To](YYYY-MM-DD, default today UTC) as Name(default Tag).
+	SnapshotRequest struct {
+		Name string `json:"name,omitempty"`
+		Tag  string `json:"tag"`
+		From string `json:"from,omitempty"`
+		To   string `json:"to,omitempty"`
+	}
+)
+
+func NewSnapshots(dir string, retention Retention) (*Snapshots, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Snapshots{dir: dir, retention: retention, now: time.Now}, nil
+}
+
+// OpenSnapshots Snapshots of an existing directory for readers, nothing is created or pruned.
+func OpenSnapshots(dir string) (*Snapshots, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", dir)
+	}
+
+	return &Snapshots{dir: dir, now: time.Now}, nil
+}
+
+// Take Writes bs as a new snapshot of name and prunes the snapshots of name, returns the new one.
+func (s *Snapshots) Take(name string, bs *ipv4_bitset.Bitset) (Snapshot, error) {
+	if !ValidTag(name) {
+		return Snapshot{}, fmt.Errorf("%w: name %q", ErrBadSnapshot, name)
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, name), 0o755); err != nil {
+		return Snapshot{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.now().UTC().Truncate(time.Millisecond)
+	// two snapshots within a millisecond get consecutive times
+	for {
+		if _, err := os.Stat(s.path(name, t)); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		t = t.Add(time.Millisecond)
+	}
+	path := s.path(name, t)
+	if err := ingest.SaveState(path, bs); err != nil {
+		return Snapshot{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if _, err = s.prune(name); err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{Name: name, ID: t.Format(SnapshotLayout), Time: t, Bytes: fi.Size()}, nil
+}
+
+// List Snapshots of name(every name when empty), by name and time.
+func (s *Snapshots) List(name string) ([]Snapshot, error) {
+	names := []string{name}
+	if name == "" {
+		entries, err := os.ReadDir(s.dir)
+		if err != nil {
+			return nil, err
+		}
+		names = names[:0]
+		for _, e := range entries {
+			if e.IsDir() && ValidTag(e.Name()) {
+				names = append(names, e.Name())
+			}
+		}
+	} else if !ValidTag(name) {
+		return nil, fmt.Errorf("%w: name %q", ErrBadSnapshot, name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []Snapshot{}
+	for _, n := range names {
+		snaps, err := s.list(n)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, snaps...)
+	}
+
+	return list, nil
+}
+
+// Find The snapshot id of name, the newest one when id is empty.
+func (s *Snapshots) Find(name, id string) (Snapshot, error) {
+	if !ValidTag(name) {
+		return Snapshot{}, fmt.Errorf("%w: name %q", ErrBadSnapshot, name)
+	}
+
+	s.mu.Lock()
+	snaps, err := s.list(name)
+	s.mu.Unlock()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for i := len(snaps) - 1; i >= 0; i-- {
+		if id == "" || snaps[i].ID == id {
+			return snaps[i], nil
+		}
+	}
+
+	return Snapshot{}, fmt.Errorf("%w: %s/%s", ErrNoSnapshot, name, id)
+}
+
+// Load Reads the set of sn.
+func (s *Snapshots) Load(sn Snapshot) (*ipv4_bitset.Bitset, error) {
+	data, err := os.ReadFile(s.path(sn.Name, sn.Time))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNoSnapshot, sn.Name, sn.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	bs := ipv4_bitset.New()
+	if _, err = bs.UnmarshalProto(data); err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", sn.Name, sn.ID, err)
+	}
+
+	return bs, nil
+}
+
+// Delete Removes the snapshot id of name, every snapshot of name when id is empty.
+func (s *Snapshots) Delete(name, id string) (int, error) {
+	if !ValidTag(name) {
+		return 0, fmt.Errorf("%w: name %q", ErrBadSnapshot, name)
+	}
+	if id != "" {
+		if _, err := time.Parse(SnapshotLayout, id); err != nil {
+			return 0, fmt.Errorf("%w: id %q", ErrBadSnapshot, id)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snaps, err := s.list(name)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, sn := range snaps {
+		if id != "" && sn.ID != id {
+			continue
+		}
+		if err = os.Remove(s.path(name, sn.Time)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: %s/%s", ErrNoSnapshot, name, id)
+	}
+
+	return n, nil
+}
+
+// Prune Applies the Retention to every name, returns the removed snapshots.
+func (s *Snapshots) Prune() (int, error) {
+	snaps, err := s.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed int
+	for _, name := range uniqueNames(snaps) {
+		n, err := s.prune(name)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// prune Removes the snapshots of name the Retention does not keep, s.mu held.
+func (s *Snapshots) prune(name string) (int, error) {
+	if s.retention == (Retention{}) {
+		return 0, nil
+	}
+	snaps, err := s.list(name)
+	if err != nil {
+		return 0, err
+	}
+	keep := s.retention.keep(snaps, s.now())
+	var removed int
+	for i, sn := range snaps {
+		if keep[i] {
+			continue
+		}
+		if err = os.Remove(s.path(name, sn.Time)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// keep Marks the snapshots(of one name, oldest first) r keeps at now.
+func (r Retention) keep(snaps []Snapshot, now time.Time) []bool {
+	keep := make([]bool, len(snaps))
+	for i := max(len(snaps)-r.KeepLast, 0); i < len(snaps); i++ {
+		keep[i] = true
+	}
+	if r.KeepDaily > 0 {
+		first := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -(r.KeepDaily - 1))
+		for i, sn := range snaps {
+			// the newest of its day: the next one is on a later day
+			last := i == len(snaps)-1 || snaps[i+1].Time.Truncate(24*time.Hour) != sn.Time.Truncate(24*time.Hour)
+			if last && !sn.Time.Before(first) {
+				keep[i] = true
+			}
+		}
+	}
+
+	return keep
+}
+
+// list Snapshots of name, oldest first, s.mu held.
+func (s *Snapshots) list(name string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snaps []Snapshot
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".pb")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(SnapshotLayout, id)
+		if err != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, Snapshot{Name: name, ID: id, Time: t, Bytes: fi.Size()})
+	}
+	slices.SortFunc(snaps, func(a, b Snapshot) int { return a.Time.Compare(b.Time) })
+
+	return snaps, nil
+}
+
+func (s *Snapshots) path(name string, t time.Time) string {
+	return filepath.Join(s.dir, name, t.UTC().Format(SnapshotLayout)+".pb")
+}
+
+func uniqueNames(snaps []Snapshot) []string {
+	var names []string
+	for _, sn := range snaps {
+		if len(names) == 0 || names[len(names)-1] != sn.Name {
+			names = append(names, sn.Name)
+		}
+	}
+
+	return names
+}
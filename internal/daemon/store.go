@@ -0,0 +1,169 @@
+// Package daemon is the shared counting service: counting jobs submitted over HTTP
+// and per tag daily unique sets for trend reporting.
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"unique-ip-counter/internal/ingest"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// DayLayout Date format of days in requests, responses and file names.
+const DayLayout = "2006-01-02"
+
+var (
+	// ErrBadTag returned for tags that are not [A-Za-z0-9._-]{1,64}.
+	ErrBadTag = errors.New("bad tag")
+
+	tagRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+)
+
+type (
+	// Store Per tag daily unique sets, stored as uipcounter.v1.Counter messages:
+	//
+	//	<dir>/<tag>/<YYYY-MM-DD>.pb
+	//
+	// the exact sets(not only counts) are kept, so any range of days can be unioned.
+	Store struct {
+		dir string
+		mu  sync.Mutex
+	}
+	// Stats Aggregation of the days of a tag within [From, To].
+	Stats struct {
+		Tag   string     `json:"tag"`
+		From  string     `json:"from"`
+		To    string     `json:"to"`
+		Days  []DayCount `json:"days"`
+		Sum   uint64     `json:"sum"`   // sum of the daily uniques, an address seen on two days counts twice
+		Union uint64     `json:"union"` // distinct addresses over the whole range
+	}
+	DayCount struct {
+		Date   string `json:"date"`
+		Unique uint64 `json:"unique"`
+	}
+)
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// ValidTag Reports whether tag can be used as a store key.
+func ValidTag(tag string) bool { return tagRe.MatchString(tag) && tag != "." && tag != ".." }
+
+// Add Merges the bitset snapshot of a job into the set of tag on day, returns the day's unique count.
+func (s *Store) Add(tag string, day time.Time, snapshot io.Reader) (uint64, error) {
+	if !ValidTag(tag) {
+		return 0, fmt.Errorf("%w: %q", ErrBadTag, tag)
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, tag), 0o755); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(tag, day)
+	bs := ipv4_bitset.New()
+	if err := ingest.LoadState(path, bs); err != nil {
+		return 0, err
+	}
+	if _, err := bs.ReadFrom(snapshot); err != nil {
+		return 0, err
+	}
+	if err := ingest.SaveState(path, bs); err != nil {
+		return 0, err
+	}
+
+	return bs.GetUniqueCount(), nil
+}
+
+// Stats Daily counts, their sum and the union of tag within [from, to](days, inclusive),
+// days without data are omitted.
+func (s *Store) Stats(tag string, from, to time.Time) (Stats, error) {
+	st := Stats{Tag: tag, From: from.Format(DayLayout), To: to.Format(DayLayout), Days: []DayCount{}}
+	union := ipv4_bitset.New()
+	days, err := s.merge(union, tag, from, to)
+	if err != nil {
+		return st, err
+	}
+	st.Days = append(st.Days, days...)
+	for _, d := range days {
+		st.Sum += d.Unique
+	}
+	st.Union = union.GetUniqueCount()
+
+	return st, nil
+}
+
+// Union The distinct addresses of tag within [from, to](days, inclusive) as one set.
+func (s *Store) Union(tag string, from, to time.Time) (*ipv4_bitset.Bitset, error) {
+	union := ipv4_bitset.New()
+	_, err := s.merge(union, tag, from, to)
+
+	return union, err
+}
+
+// merge Merges every day of tag within [from, to] into union, returns the unique count of every day.
+func (s *Store) merge(union *ipv4_bitset.Bitset, tag string, from, to time.Time) ([]DayCount, error) {
+	if !ValidTag(tag) {
+		return nil, fmt.Errorf("%w: %q", ErrBadTag, tag)
+	}
+	fromS, toS := from.Format(DayLayout), to.Format(DayLayout)
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, tag))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, e := range entries {
+		day, ok := strings.CutSuffix(e.Name(), ".pb")
+		if !ok {
+			continue
+		}
+		if _, err = time.Parse(DayLayout, day); err != nil {
+			continue
+		}
+		// the layout sorts lexicographically
+		if day >= fromS && day <= toS {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+
+	// every day is merged into the union, the count written with the day is its unique count
+	var counts []DayCount
+	for _, day := range days {
+		data, err := os.ReadFile(filepath.Join(s.dir, tag, day+".pb"))
+		if err != nil {
+			return counts, err
+		}
+		meta, err := union.UnmarshalProto(data)
+		if err != nil {
+			return counts, fmt.Errorf("%s/%s: %w", tag, day, err)
+		}
+		counts = append(counts, DayCount{Date: day, Unique: meta.Unique})
+	}
+
+	return counts, nil
+}
+
+func (s *Store) path(tag string, day time.Time) string {
+	return filepath.Join(s.dir, tag, day.UTC().Format(DayLayout)+".pb")
+}
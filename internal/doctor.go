@@ -0,0 +1,403 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/file_processor"
+	"unique-ip-counter/internal/ipv4_bitset"
+	"unique-ip-counter/internal/ipv6_set"
+	"unique-ip-counter/internal/remote_source"
+)
+
+type (
+	// doctorOptions The flags of doctor, the counting flags a run would be given.
+	doctorOptions struct {
+		path            string
+		th              int
+		format          string
+		csvCol          string
+		header          bool
+		sampleBytes     int64
+		ranges          int
+		backend         string
+		sketch          string
+		sketchPrecision uint
+		memAvailable    int64 // 0 - read from /proc/meminfo
+	}
+	// checkup Findings of doctor as printed lines, every WARN and FAIL says what to change.
+	checkup struct {
+		w            io.Writer
+		warns, fails int
+	}
+)
+
+// doctorHead Bytes of the head sniffed for the format.
+const doctorHead = 64 << 10
+
+// runDoctor "doctor -f file" - checks an input before a multi-hour run: that it can be read, which format its
+// content is, how many lines of a sample are addresses and how much memory the chosen backend will take.
+// Fails when the input cannot be counted at all, warnings do not fail.
+func runDoctor(ctx context.Context, logger *zap.Logger, args []string) error {
+	var o doctorOptions
+	sampleBytes := byteSize(64 << 20)
+	fs := newFlagSet("doctor")
+	fs.StringVar(&o.path, "f", "", "path to file, s3:// or http(s):// URL")
+	fs.IntVar(&o.th, "th", runtime.NumCPU(), "count of goroutines + shards")
+	fs.StringVar(&o.format, "format", "", "-format of the run")
+	fs.StringVar(&o.csvCol, "csv-col", "", "-csv-col of the run")
+	fs.BoolVar(&o.header, "header", false, "-header of the run")
+	fs.Var(&sampleBytes, "sample-bytes", "bytes of the input sampled for the validity ratio(e.g. 256MiB)")
+	fs.IntVar(&o.ranges, "sample-ranges", 64, "random byte ranges the sample is made of")
+	fs.StringVar(&o.backend, "backend", "dense", "-backend of the run: dense, roaring or flat")
+	fs.StringVar(&o.sketch, "sketch", "", "-sketch of the run")
+	fs.UintVar(&o.sketchPrecision, "sketch-precision", 14, "-sketch-precision of the run")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if o.path == "" {
+		return fmt.Errorf("please provide path to file")
+	}
+	o.sampleBytes = int64(sampleBytes)
+
+	c := &checkup{w: os.Stdout}
+	if err := doctor(ctx, logger, c, o); err != nil {
+		return err
+	}
+	fmt.Printf("%d warnings, %d failures\n", c.warns, c.fails)
+	if c.fails > 0 {
+		return fmt.Errorf("%s cannot be counted as is", o.path)
+	}
+
+	return nil
+}
+
+// doctor Runs the checks of o.path, err is only set when ctx is done.
+func doctor(ctx context.Context, logger *zap.Logger, c *checkup, o doctorOptions) error {
+	src, size, ok := c.reach(ctx, o.path)
+	if !ok {
+		return nil
+	}
+	if cl, ok := src.(io.Closer); ok {
+		defer cl.Close()
+	}
+	if size == 0 {
+		c.warn("the input is empty, the count will be 0")
+		return nil
+	}
+
+	head := make([]byte, min(size, doctorHead))
+	if n, err := src.ReadAt(head, 0); n < len(head) && err != nil {
+		c.fail("cannot read the head: %v", err)
+		return nil
+	}
+	kind := sniffFormat(head)
+	byExt := extFormat(o.path)
+	switch {
+	case kind == "text" && byExt != "text":
+		c.warn("the content is text but the %s extension reads it as %s: rename it", filepath.Ext(o.path), byExt)
+	case kind != "text" && kind != byExt && !(kind == "utf-16" && byExt == "text"):
+		c.warn("the content is %s but the %s extension reads it as %s: rename it to %s", kind, orNone(filepath.Ext(o.path)), byExt, kindExt[kind])
+	default:
+		c.ok("format: %s", kind)
+	}
+	if kind != "text" {
+		c.ok("%s inputs are not sampled, the validity ratio and the memory estimate are skipped", kind)
+		return nil
+	}
+	c.lines(head, o)
+
+	fp := file_processor.New(logger, nil, ipv4_bitset.New(), o.th,
+		file_processor.WithFormat(o.format), file_processor.WithCSVColumn(o.csvCol), file_processor.WithHeader(o.header))
+	est, err := fp.Estimate(ctx, src, size, o.sampleBytes, o.ranges, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, file_processor.ErrBinary) {
+		c.fail("%v: not a log or a list of addresses, -force counts it anyway", err)
+		return nil
+	}
+	if err != nil {
+		c.fail("cannot sample the input: %v", err)
+		return nil
+	}
+	c.validity(est)
+	c.memory(est, o)
+
+	return nil
+}
+
+// reach Opens the input the way a run would, ok=false once the failure is reported.
+func (c *checkup) reach(ctx context.Context, path string) (io.ReaderAt, int64, bool) {
+	if remote_source.IsS3(path) || remote_source.IsHTTP(path) {
+		fp := file_processor.New(zap.NewNop(), nil, ipv4_bitset.New(), 1)
+		src, size, err := randomAccess(ctx, fp, path)
+		if err != nil {
+			c.fail("cannot reach %s: %v", path, err)
+			return nil, 0, false
+		}
+		c.ok("reachable: %s remote object", humanBytes(size))
+		return src, size, true
+	}
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		c.fail("%s does not exist: check the path(relative to %s)", path, workDir())
+		return nil, 0, false
+	case errors.Is(err, os.ErrPermission):
+		c.fail("no read permission on %s: run as its owner or grant read access", path)
+		return nil, 0, false
+	case err != nil:
+		c.fail("cannot open %s: %v", path, err)
+		return nil, 0, false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		c.fail("cannot stat %s: %v", path, err)
+		return nil, 0, false
+	}
+	if fi.IsDir() {
+		c.fail("%s is a directory: pass its files, or -watch it", path)
+		return nil, 0, false
+	}
+	if !fi.Mode().IsRegular() {
+		c.warn("%s is a %s, it is streamed sequentially and cannot be sampled", path, fi.Mode().Type())
+		return nil, 0, false
+	}
+	c.ok("readable: %s regular file", humanBytes(fi.Size()))
+
+	return f, fi.Size(), true
+}
+
+// lines Looks at the whole lines of the head for the classic mistakes: a CSV or a web server log counted
+// as a list of addresses.
+func (c *checkup) lines(head []byte, o doctorOptions) {
+	if o.format != "" || o.csvCol != "" {
+		return
+	}
+	var (
+		bs                         = ipv4_bitset.New()
+		lines, plain, csv, leading int
+		first                      string
+	)
+	sc := bufio.NewScanner(bytes.NewReader(head))
+	sc.Buffer(nil, len(head))
+	for sc.Scan() && lines < 1000 {
+		line := bytes.TrimRight(sc.Bytes(), "\r")
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if first == "" {
+			first = string(line)
+		}
+		lines++
+		tok, _, _ := bytes.Cut(line, []byte(" "))
+		_, v4 := bs.IPv4ByteToUint32(line)
+		_, v6 := ipv6_set.Parse(line)
+		_, tok4 := bs.IPv4ByteToUint32(tok)
+		switch {
+		case v4 || v6:
+			plain++
+		case bytes.IndexByte(line, ',') >= 0:
+			csv++
+		case tok4:
+			leading++
+		}
+	}
+	switch {
+	case lines == 0:
+	case plain*2 >= lines:
+		c.ok("lines: %d of the first %d are plain addresses", plain, lines)
+	case csv*2 >= lines:
+		c.warn("lines look like CSV rows(%q): pass -csv-col with the address column, -header if the first row names them", first)
+	case leading*2 >= lines:
+		c.warn("lines start with an address followed by more fields(%q): pass -format=combined(or clf)", first)
+	default:
+		c.warn("only %d of the first %d lines are plain addresses(%q): pick a -format, -grok or -extract", plain, lines, first)
+	}
+}
+
+// validity Reports the share of sampled lines that are not addresses.
+func (c *checkup) validity(est file_processor.Estimate) {
+	invalid := est.Invalid
+	addrs := est.Records + est.IPv6
+	lines := addrs + invalid
+	switch {
+	case lines == 0:
+		c.warn("no line in the sample: the input holds a header or comments only")
+	case addrs == 0:
+		c.fail("none of %d sampled lines is an address: wrong file, -format or -csv-col", lines)
+	case invalid*10 > lines:
+		c.warn("%.1f%% of %d sampled lines are not addresses: check -format/-csv-col, -invalid-samples shows examples",
+			float64(invalid)*100/float64(lines), lines)
+	case est.Records == 0:
+		c.ok("validity: %d of %d sampled lines are IPv6 addresses, their unique count is not estimated", addrs, lines)
+	case est.IPv6 > 0:
+		c.ok("validity: %d of %d sampled lines are addresses(%d IPv6), ~%d unique IPv4 expected", addrs, lines, est.IPv6, est.Unique)
+	default:
+		c.ok("validity: %d of %d sampled lines are addresses, ~%d unique expected", addrs, lines, est.Unique)
+	}
+}
+
+// memory Estimates the memory of the backend of o from the sample, against the memory available.
+func (c *checkup) memory(est file_processor.Estimate, o doctorOptions) {
+	if est.Records == 0 {
+		return
+	}
+	// the /16 blocks grow with the unique count from the ones seen in the sample
+	blocks := est.Blocks
+	if !est.Exact && est.Distinct > 0 {
+		blocks = max(blocks, est.Blocks*est.Unique/est.Distinct)
+	}
+	blocks = min(blocks, 1<<16)
+	dense := int64(blocks) * (8 << 10)
+	roaring := min(int64(est.Unique)*2, dense)
+
+	var (
+		backend string
+		need    int64
+	)
+	switch {
+	case o.sketch != "":
+		backend, need = "-sketch "+o.sketch, int64(4)<<o.sketchPrecision
+	case o.backend == "flat":
+		backend, need = "-backend flat", 512<<20
+	case o.backend == "roaring":
+		backend, need = "-backend roaring", roaring
+	default:
+		backend, need = "-backend dense", dense
+	}
+	avail := o.memAvailable
+	if avail == 0 {
+		avail = memAvailable()
+	}
+
+	switch {
+	case avail > 0 && need > avail:
+		c.warn("%s needs ~%s, %s is available: use -sketch hll or -memory-watermark", backend, humanBytes(need), humanBytes(avail))
+	case backend == "-backend dense" && roaring*4 < dense && avail > 0 && dense*4 > avail:
+		c.warn("%s needs ~%s for ~%d /16 blocks, the addresses are sparse: -backend roaring needs ~%s",
+			backend, humanBytes(need), blocks, humanBytes(roaring))
+	default:
+		c.ok("memory: %s needs ~%s", backend, humanBytes(need))
+	}
+}
+
+func (c *checkup) ok(format string, args ...any) { c.print("OK  ", format, args...) }
+
+func (c *checkup) warn(format string, args ...any) {
+	c.warns++
+	c.print("WARN", format, args...)
+}
+
+func (c *checkup) fail(format string, args ...any) {
+	c.fails++
+	c.print("FAIL", format, args...)
+}
+
+func (c *checkup) print(level, format string, args ...any) {
+	_, _ = fmt.Fprintf(c.w, "%s %s\n", level, fmt.Sprintf(format, args...))
+}
+
+// kindExt The extension a run reads a sniffed format by.
+var kindExt = map[string]string{
+	"gzip": ".gz", "zstd": ".zst", "zip": ".zip", "pcap": ".pcap", "parquet": ".parquet", "utf-16": ".txt",
+}
+
+// sniffFormat The format of the content by its magic bytes, "text" for none.
+func sniffFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return "gzip"
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "zstd"
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return "zip"
+	case bytes.HasPrefix(head, []byte{0xd4, 0xc3, 0xb2, 0xa1}), bytes.HasPrefix(head, []byte{0xa1, 0xb2, 0xc3, 0xd4}),
+		bytes.HasPrefix(head, []byte{0x4d, 0x3c, 0xb2, 0xa1}), bytes.HasPrefix(head, []byte{0x0a, 0x0d, 0x0d, 0x0a}):
+		return "pcap"
+	case bytes.HasPrefix(head, []byte("PAR1")):
+		return "parquet"
+	case bytes.HasPrefix(head, []byte{0xff, 0xfe}), bytes.HasPrefix(head, []byte{0xfe, 0xff}):
+		return "utf-16"
+	}
+
+	return "text"
+}
+
+// extFormat The format a run reads path as, see App.process.
+func extFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip":
+		return "zip"
+	case ".pcap", ".pcapng":
+		return "pcap"
+	case ".parquet":
+		return "parquet"
+	case ".bin":
+		return "binary"
+	case ".zst", ".zstd":
+		return "zstd"
+	case ".gz", ".bgz":
+		return "gzip"
+	}
+
+	return "text"
+}
+
+func orNone(ext string) string {
+	if ext == "" {
+		return "missing"
+	}
+
+	return ext
+}
+
+// memAvailable MemAvailable of /proc/meminfo, 0 where there is none.
+func memAvailable() int64 {
+	b, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for line := range strings.Lines(string(b)) {
+		if v, ok := strings.CutPrefix(line, "MemAvailable:"); ok {
+			kb, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+			return kb << 10
+		}
+	}
+
+	return 0
+}
+
+func workDir() string {
+	wd, _ := os.Getwd()
+	return wd
+}
+
+// humanBytes n in the largest binary unit below it, 1.5 GiB.
+func humanBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, i := float64(n)/(1<<10), 0
+	for v >= 1<<10 && i < len(units)-1 {
+		v /= 1 << 10
+		i++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", v, units[i])
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func Test_doctor(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	var plain, sparse, csv, combined, v6 bytes.Buffer
+	csv.WriteString("time,client,path\n")
+	for i := range 5000 {
+		fmt.Fprintf(&plain, "10.%d.%d.%d\n", i%7, i%251, i%13)
+		// a /16 each
+		fmt.Fprintf(&sparse, "%d.%d.0.1\n", i>>8, i&255)
+		fmt.Fprintf(&csv, "%d,10.0.0.%d,/\n", i, i%200)
+		fmt.Fprintf(&v6, "2001:db8::%x\n", i)
+		fmt.Fprintf(&combined, "10.0.0.%d - - [10/Oct/2000:13:55:36 -0700] \"GET / HTTP/1.0\" 200 2326\n", i%200)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(plain.Bytes())
+	_ = zw.Close()
+	empty := write("empty.txt", nil)
+
+	cases := []struct {
+		name        string
+		o           doctorOptions
+		warns, fail int
+		want        string
+	}{
+		{"plain", doctorOptions{path: write("ips.txt", plain.Bytes())}, 0, 0, "OK   validity: 5000 of 5000"},
+		{"csv", doctorOptions{path: write("rows.csv", csv.Bytes())}, 1, 1, "pass -csv-col"},
+		{"csv column", doctorOptions{path: write("rows2.csv", csv.Bytes()), csvCol: "client", header: true}, 0, 0, "OK   validity"},
+		{"log", doctorOptions{path: write("access.log", combined.Bytes())}, 1, 1, "-format=combined"},
+		{"gzip", doctorOptions{path: write("ips.log", gz.Bytes())}, 1, 0, "the content is gzip but the .log extension reads it as text"},
+		{"ipv6", doctorOptions{path: write("v6.txt", v6.Bytes())}, 0, 0, "OK   validity: 5000 of 5000 sampled lines are IPv6"},
+		{"missing", doctorOptions{path: filepath.Join(dir, "nope.txt")}, 0, 1, "does not exist"},
+		{"directory", doctorOptions{path: dir}, 0, 1, "is a directory"},
+		{"empty", doctorOptions{path: empty}, 1, 0, "empty"},
+		{"memory", doctorOptions{path: write("ips2.txt", plain.Bytes()), memAvailable: 1 << 10}, 1, 0, "use -sketch hll"},
+		{"sparse", doctorOptions{path: write("sparse.txt", sparse.Bytes()), memAvailable: 100 << 20}, 1, 0, "-backend roaring needs"},
+		{"roaring", doctorOptions{path: write("sparse2.txt", sparse.Bytes()), memAvailable: 100 << 20, backend: "roaring"}, 0, 0, "OK   memory: -backend roaring needs"},
+	}
+	for _, tt := range cases {
+		var out bytes.Buffer
+		c := &checkup{w: &out}
+		tt.o.th, tt.o.sampleBytes, tt.o.ranges = 2, 1<<20, 4
+		if tt.o.backend == "" {
+			tt.o.backend = "dense"
+		}
+		if err := doctor(context.Background(), zap.NewNop(), c, tt.o); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if c.warns != tt.warns || c.fails != tt.fail || !strings.Contains(out.String(), tt.want) {
+			t.Fatalf("%s: %d warnings, %d failures; want %d, %d and %q in:\n%s", tt.name, c.warns, c.fails, tt.warns, tt.fail, tt.want, out.String())
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package enrich
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MRT(RFC 6396) record types of a RIB dump
+const (
+	mrtTableDumpV2     = 13
+	mrtRIBIPv4Unicast  = 2
+	mrtHeaderLen       = 12
+	bgpAttrASPath      = 2
+	bgpAttrExtendedLen = 0x10
+	bgpASSequence      = 2
+)
+
+var ErrBadRIB = errors.New("bad RIB dump")
+
+type (
+	// ASN Counts the unique set per originating ASN by longest-prefix matching every address against
+	// a RIB: an MRT TABLE_DUMP_V2 dump(bview/rib files of RouteViews and RIPE RIS, .gz/.bz2 too) or
+	// a prefix to ASN table of "prefix,asn" rows(or CAIDA pfx2as "addr\tlen\tasn"), '#' starts a comment.
+	ASN struct {
+		name     string
+		ranges   []asnRange // disjoint, sorted, the ASN of the most specific prefix
+		prefixes int
+		top      int
+
+		i         int // ranges below are behind the observed addresses
+		counts    map[uint32]uint64
+		unmatched uint64
+	}
+	asnRange struct {
+		lo, hi uint32
+		asn    uint32
+	}
+
+	ASNReport struct {
+		Prefixes  int        `json:"prefixes"`
+		ASNs      int        `json:"asns"` // origin ASNs with at least one address
+		Matched   uint64     `json:"matched"`
+		Unmatched uint64     `json:"unmatched"` // not routed by the RIB
+		Top       []ASNCount `json:"top"`       // by unique addresses
+	}
+	ASNCount struct {
+		ASN    uint32 `json:"asn"`
+		Unique uint64 `json:"unique"`
+	}
+)
+
+// newASNEnricher "asn:path=rib.20240101.0000.bz2,top=20,name=asn"
+func newASNEnricher(params map[string]string) (Enricher, error) {
+	path := params["path"]
+	if path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	top, err := intParam(params, "top", 20)
+	if err != nil {
+		return nil, err
+	}
+	name := params["name"]
+	if name == "" {
+		name = "asn"
+	}
+
+	return LoadASN(name, path, top)
+}
+
+// LoadASN Reads the RIB dump or prefix table at path, top is the ASNs reported(0 - every one).
+func LoadASN(name, path string, top int) (*ASN, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case strings.HasSuffix(path, ".bz2"):
+		r = bzip2.NewReader(f)
+	}
+	br := bufio.NewReaderSize(r, 1<<20)
+
+	var prefixes []asnRange
+	if head, _ := br.Peek(mrtHeaderLen); isMRT(head) {
+		prefixes, err = readMRT(br)
+	} else {
+		prefixes, err = readPrefixTable(br)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &ASN{name: name, ranges: flattenPrefixes(prefixes), prefixes: len(prefixes), top: top}, nil
+}
+
+func (a *ASN) Name() string { return a.name }
+
+func (a *ASN) Begin(uint64) { a.i, a.counts, a.unmatched = 0, make(map[uint32]uint64), 0 }
+
+// Observe Addresses come in ascending order, so the matching range only moves forward.
+func (a *ASN) Observe(u32 uint32) {
+	for a.i < len(a.ranges) && a.ranges[a.i].hi < u32 {
+		a.i++
+	}
+	if a.i == len(a.ranges) || a.ranges[a.i].lo > u32 {
+		a.unmatched++
+		return
+	}
+	a.counts[a.ranges[a.i].asn]++
+}
+
+func (a *ASN) Report(context.Context) (any, error) {
+	rep := ASNReport{Prefixes: a.prefixes, ASNs: len(a.counts), Unmatched: a.unmatched, Top: make([]ASNCount, 0, len(a.counts))}
+	for asn, n := range a.counts {
+		rep.Matched += n
+		rep.Top = append(rep.Top, ASNCount{ASN: asn, Unique: n})
+	}
+	sort.Slice(rep.Top, func(i, j int) bool {
+		if rep.Top[i].Unique != rep.Top[j].Unique {
+			return rep.Top[i].Unique > rep.Top[j].Unique
+		}
+		return rep.Top[i].ASN < rep.Top[j].ASN
+	})
+	if a.top > 0 && len(rep.Top) > a.top {
+		rep.Top = rep.Top[:a.top]
+	}
+
+	return rep, nil
+}
+
+// flattenPrefixes Disjoint ranges covered by prefixes, each with the ASN of the most specific prefix
+// covering it. The first of duplicate prefixes wins.
+func flattenPrefixes(prefixes []asnRange) []asnRange {
+	ps := append([]asnRange(nil), prefixes...)
+	// outer prefixes before the ones they contain
+	sort.SliceStable(ps, func(i, j int) bool {
+		if ps[i].lo != ps[j].lo {
+			return ps[i].lo < ps[j].lo
+		}
+		return ps[i].hi > ps[j].hi
+	})
+
+	var (
+		out   []asnRange
+		stack []asnRange
+		cur   uint64 // the first address not emitted yet
+	)
+	emit := func(hi uint64, asn uint32) {
+		if cur > hi {
+			return
+		}
+		if n := len(out); n > 0 && out[n-1].asn == asn && uint64(out[n-1].hi)+1 == cur {
+			out[n-1].hi = uint32(hi)
+		} else {
+			out = append(out, asnRange{lo: uint32(cur), hi: uint32(hi), asn: asn})
+		}
+		cur = hi + 1
+	}
+	// closeBefore Emits the prefixes on the stack ending before lo
+	closeBefore := func(lo uint64) {
+		for len(stack) > 0 && uint64(stack[len(stack)-1].hi) < lo {
+			top := stack[len(stack)-1]
+			emit(uint64(top.hi), top.asn)
+			stack = stack[:len(stack)-1]
+		}
+	}
+	for i, p := range ps {
+		if i > 0 && p.lo == ps[i-1].lo && p.hi == ps[i-1].hi {
+			continue
+		}
+		closeBefore(uint64(p.lo))
+		if len(stack) > 0 && uint64(p.lo) > cur {
+			emit(uint64(p.lo)-1, stack[len(stack)-1].asn)
+		}
+		cur = max(cur, uint64(p.lo))
+		stack = append(stack, p)
+	}
+	closeBefore(1 << 32)
+
+	return out
+}
+
+// readPrefixTable Rows of "prefix,asn", "prefix asn" or "addr len asn"(tabs, spaces or commas), the ASN
+// optionally "AS"-prefixed, the first of a multi-origin "asn_asn" or AS set "{asn,asn}". Rows that
+// are not routes(a header) are skipped, IPv6 prefixes too.
+func readPrefixTable(r io.Reader) ([]asnRange, error) {
+	var out []asnRange
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.FieldsFunc(line, func(c rune) bool { return c == ',' || c == ' ' || c == '\t' })
+		if len(fields) < 2 {
+			continue
+		}
+		prefix, asn := fields[0], fields[1]
+		if !strings.Contains(prefix, "/") && len(fields) >= 3 {
+			prefix, asn = prefix+"/"+fields[1], fields[2]
+		}
+		p, err := netip.ParsePrefix(strings.Trim(prefix, `"`))
+		if err != nil {
+			if n == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if !p.Addr().Is4() {
+			continue
+		}
+		asn = strings.TrimPrefix(strings.TrimPrefix(strings.Trim(asn, `"{}`), "AS"), "as")
+		if i := strings.IndexAny(asn, "_,"); i >= 0 {
+			asn = asn[:i]
+		}
+		v, err := strconv.ParseUint(asn, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: ASN %q: %w", n, fields[1], err)
+		}
+		out = append(out, prefixRange(p, uint32(v)))
+	}
+
+	return out, sc.Err()
+}
+
+func prefixRange(p netip.Prefix, asn uint32) asnRange {
+	a := p.Masked().Addr().As4()
+	lo := binary.BigEndian.Uint32(a[:])
+
+	return asnRange{lo: lo, hi: lo | uint32(uint64(1)<<(32-p.Bits())-1), asn: asn}
+}
+
+// isMRT Reports whether head is the header of a TABLE_DUMP_V2 record.
+func isMRT(head []byte) bool {
+	return len(head) == mrtHeaderLen && binary.BigEndian.Uint16(head[4:]) == mrtTableDumpV2 &&
+		binary.BigEndian.Uint16(head[6:]) <= 12
+}
+
+// readMRT The IPv4 unicast routes of a TABLE_DUMP_V2 dump with the origin of their first RIB entry:
+// the last AS of the AS_PATH(the first of a trailing AS set). Other records are skipped.
+func readMRT(r io.Reader) ([]asnRange, error) {
+	var (
+		out  []asnRange
+		hdr  = make([]byte, mrtHeaderLen)
+		body []byte
+	)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return out, nil
+			}
+			return nil, fmt.Errorf("%w: header: %v", ErrBadRIB, err)
+		}
+		typ, sub, n := binary.BigEndian.Uint16(hdr[4:]), binary.BigEndian.Uint16(hdr[6:]), binary.BigEndian.Uint32(hdr[8:])
+		if n > 16<<20 {
+			return nil, fmt.Errorf("%w: record of %d bytes", ErrBadRIB, n)
+		}
+		if cap(body) < int(n) {
+			body = make([]byte, n)
+		}
+		body = body[:n]
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, fmt.Errorf("%w: record: %v", ErrBadRIB, err)
+		}
+		if typ != mrtTableDumpV2 || sub != mrtRIBIPv4Unicast {
+			continue
+		}
+		p, asn, ok, err := parseRIBIPv4(body)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, prefixRange(p, asn))
+		}
+	}
+}
+
+// parseRIBIPv4 A RIB_IPV4_UNICAST record: sequence(4), prefix length(1), prefix, entry count(2), then entries of
+// peer index(2), originated time(4), attribute length(2) and BGP path attributes. ok=false without an origin.
+func parseRIBIPv4(b []byte) (p netip.Prefix, asn uint32, ok bool, err error) {
+	if len(b) < 5 {
+		return p, 0, false, fmt.Errorf("%w: short RIB record", ErrBadRIB)
+	}
+	bits := int(b[4])
+	pl := (bits + 7) / 8
+	if bits > 32 || len(b) < 5+pl+2 {
+		return p, 0, false, fmt.Errorf("%w: prefix length %d", ErrBadRIB, bits)
+	}
+	var a [4]byte
+	copy(a[:], b[5:5+pl])
+	p = netip.PrefixFrom(netip.AddrFrom4(a), bits)
+	b = b[5+pl:]
+	entries := binary.BigEndian.Uint16(b)
+	b = b[2:]
+	for range entries {
+		if len(b) < 8 {
+			return p, 0, false, fmt.Errorf("%w: short RIB entry", ErrBadRIB)
+		}
+		al := int(binary.BigEndian.Uint16(b[6:]))
+		if len(b) < 8+al {
+			return p, 0, false, fmt.Errorf("%w: attributes of %d bytes", ErrBadRIB, al)
+		}
+		if asn, ok = originAS(b[8 : 8+al]); ok {
+			return p, asn, true, nil
+		}
+		b = b[8+al:]
+	}
+
+	return p, 0, false, nil
+}
+
+// originAS The origin of the AS_PATH attribute among attrs, ASNs are 4 bytes in TABLE_DUMP_V2.
+func originAS(attrs []byte) (uint32, bool) {
+	for len(attrs) >= 3 {
+		flags, typ := attrs[0], attrs[1]
+		hl, l := 3, int(attrs[2])
+		if flags&bgpAttrExtendedLen != 0 {
+			if len(attrs) < 4 {
+				return 0, false
+			}
+			hl, l = 4, int(binary.BigEndian.Uint16(attrs[2:]))
+		}
+		if len(attrs) < hl+l {
+			return 0, false
+		}
+		val := attrs[hl : hl+l]
+		attrs = attrs[hl+l:]
+		if typ != bgpAttrASPath {
+			continue
+		}
+
+		var (
+			origin uint32
+			found  bool
+		)
+		for len(val) >= 2 {
+			segType, count := val[0], int(val[1])
+			seg := val[2:]
+			if len(seg) < 4*count {
+				return 0, false
+			}
+			if count > 0 {
+				if segType == bgpASSequence {
+					origin = binary.BigEndian.Uint32(seg[4*(count-1):])
+				} else {
+					origin = binary.BigEndian.Uint32(seg)
+				}
+				found = true
+			}
+			val = seg[4*count:]
+		}
+		return origin, found
+	}
+
+	return 0, false
+}
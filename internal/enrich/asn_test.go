@@ -0,0 +1,121 @@
+package enrich
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+func TestFlattenPrefixes(t *testing.T) {
+	t.Parallel()
+	pr := func(s string, asn uint32) asnRange { return prefixRange(netip.MustParsePrefix(s), asn) }
+	got := flattenPrefixes([]asnRange{
+		pr("10.0.0.0/8", 1),
+		pr("10.1.0.0/16", 2),
+		pr("10.1.2.0/24", 3),
+		pr("10.1.0.0/16", 9), // duplicate, the first wins
+		pr("10.200.0.0/16", 1),
+		pr("0.0.0.0/0", 7),
+	})
+	want := []asnRange{
+		{lo: 0, hi: 0x09ffffff, asn: 7},
+		{lo: 0x0a000000, hi: 0x0a00ffff, asn: 1},
+		{lo: 0x0a010000, hi: 0x0a0101ff, asn: 2},
+		{lo: 0x0a010200, hi: 0x0a0102ff, asn: 3},
+		{lo: 0x0a010300, hi: 0x0a01ffff, asn: 2},
+		{lo: 0x0a020000, hi: 0x0affffff, asn: 1},
+		{lo: 0x0b000000, hi: 0xffffffff, asn: 7},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("flattenPrefixes=%x; want %x", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("range %d=%x; want %x", i, got[i], want[i])
+		}
+	}
+}
+
+func TestASN(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return path
+	}
+
+	var rib bytes.Buffer
+	zw := gzip.NewWriter(&rib)
+	_, _ = zw.Write(mrtRecord(1, nil)) // PEER_INDEX_TABLE, skipped
+	_, _ = zw.Write(mrtRIBRecord(netip.MustParsePrefix("10.0.0.0/8"), 3356, 64500))
+	_, _ = zw.Write(mrtRIBRecord(netip.MustParsePrefix("10.1.0.0/16"), 3356, 64501))
+	_, _ = zw.Write(mrtRIBRecord(netip.MustParsePrefix("192.168.1.0/24"), 174, 64502))
+	_ = zw.Close()
+
+	paths := []string{
+		write("prefixes.csv", []byte("prefix,asn\n10.0.0.0/8,AS64500\n10.1.0.0/16,64501 # more specific\n192.168.1.0/24,64502\n2001:db8::/32,64503\n")),
+		write("routeviews.pfx2as", []byte("10.0.0.0\t8\t64500\n10.1.0.0\t16\t64501_64999\n192.168.1.0\t24\t64502\n")),
+		write("rib.gz", rib.Bytes()),
+	}
+
+	bs := ipv4_bitset.New()
+	for _, s := range []string{"10.0.0.1", "10.0.0.2", "10.1.0.1", "10.2.0.1", "10.200.0.1", "192.168.1.1", "192.168.2.1"} {
+		bs.SetAddrIfNew(netip.MustParseAddr(s))
+		bs.AddUnique(1)
+	}
+	for _, path := range paths {
+		a, err := LoadASN("asn", path, 2)
+		if err != nil {
+			t.Fatalf("LoadASN(%s): %v", path, err)
+		}
+		rep := Run(context.Background(), bs, []Enricher{a})["asn"].(ASNReport)
+		want := []ASNCount{{ASN: 64500, Unique: 4}, {ASN: 64501, Unique: 1}}
+		if rep.Prefixes != 3 || rep.ASNs != 3 || rep.Matched != 6 || rep.Unmatched != 1 ||
+			len(rep.Top) != 2 || rep.Top[0] != want[0] || rep.Top[1] != want[1] {
+			t.Fatalf("%s: report %+v; want 3 prefixes, 3 ASNs, 6 matched, 1 unmatched, top %v", path, rep, want)
+		}
+	}
+
+	if _, err := LoadASN("asn", write("bad.csv", []byte("10.0.0.0/8,64500\n10.0.0.0/33,1\n")), 0); err == nil {
+		t.Fatalf("LoadASN of a bad prefix: want an error")
+	}
+}
+
+// mrtRecord A TABLE_DUMP_V2 record of subtype sub.
+func mrtRecord(sub uint16, body []byte) []byte {
+	rec := make([]byte, mrtHeaderLen, mrtHeaderLen+len(body))
+	binary.BigEndian.PutUint16(rec[4:], mrtTableDumpV2)
+	binary.BigEndian.PutUint16(rec[6:], sub)
+	binary.BigEndian.PutUint32(rec[8:], uint32(len(body)))
+
+	return append(rec, body...)
+}
+
+// mrtRIBRecord A RIB_IPV4_UNICAST record of one entry with the AS_PATH path.
+func mrtRIBRecord(p netip.Prefix, path ...uint32) []byte {
+	var attr bytes.Buffer
+	attr.Write([]byte{0x40, bgpAttrASPath, byte(2 + 4*len(path)), bgpASSequence, byte(len(path))})
+	for _, as := range path {
+		_ = binary.Write(&attr, binary.BigEndian, as)
+	}
+
+	var body bytes.Buffer
+	body.Write([]byte{0, 0, 0, 0, byte(p.Bits())})
+	a := p.Addr().As4()
+	body.Write(a[:(p.Bits()+7)/8])
+	body.Write([]byte{0, 1, 0, 0, 0, 0, 0, 0})
+	_ = binary.Write(&body, binary.BigEndian, uint16(attr.Len()))
+	body.Write(attr.Bytes())
+
+	return mrtRecord(mrtRIBIPv4Unicast, body.Bytes())
+}
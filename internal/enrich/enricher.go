@@ -0,0 +1,129 @@
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+type (
+	// Enricher Derives a report from the unique set. All enrichers of a run share a single
+	// pass over the set: Begin, then Observe for every address in ascending order, then Report.
+	// An enricher is reused for every input, so Begin must reset the per-input state.
+	Enricher interface {
+		// Name key of the result in the merged report
+		Name() string
+		Begin(total uint64)
+		Observe(u32 uint32)
+		Report(ctx context.Context) (any, error)
+	}
+
+	// factory Builds an enricher from the parameters of its -enrich spec.
+	factory func(params map[string]string) (Enricher, error)
+)
+
+var factories = map[string]factory{
+	"rdns": newRDNSEnricher,
+	"feed": newFeedEnricher,
+	"asn":  newASNEnricher,
+}
+
+// Parse Builds an enricher from a "name[:key=value,...]" spec, e.g. "rdns:sample=1000,budget=5s".
+func Parse(spec string) (Enricher, error) {
+	name, rest, _ := strings.Cut(spec, ":")
+	f, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown enricher %q, known: %s", name, strings.Join(Names(), ", "))
+	}
+
+	params := map[string]string{}
+	if rest != "" {
+		for _, kv := range strings.Split(rest, ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || k == "" {
+				return nil, fmt.Errorf("enricher %s: bad parameter %q, want key=value", name, kv)
+			}
+			params[k] = v
+		}
+	}
+
+	e, err := f(params)
+	if err != nil {
+		return nil, fmt.Errorf("enricher %s: %w", name, err)
+	}
+
+	return e, nil
+}
+
+// Names of the known enrichers.
+func Names() []string {
+	res := make([]string, 0, len(factories))
+	for n := range factories {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// Run Applies es to the unique set of bs, the results are merged by enricher name,
+// a failed enricher is reported as {"error": "..."} and does not stop the others.
+func Run(ctx context.Context, bs *ipv4_bitset.Bitset, es []Enricher) map[string]any {
+	if len(es) == 0 {
+		return nil
+	}
+
+	total := bs.GetUniqueCount()
+	for _, e := range es {
+		e.Begin(total)
+	}
+	for u32 := range bs.All() {
+		for _, e := range es {
+			e.Observe(u32)
+		}
+	}
+
+	res := make(map[string]any, len(es))
+	for _, e := range es {
+		r, err := e.Report(ctx)
+		if err != nil {
+			r = map[string]string{"error": err.Error()}
+		}
+		res[e.Name()] = r
+	}
+
+	return res
+}
+
+// params helpers
+
+func intParam(params map[string]string, key string, def int) (int, error) {
+	v, ok := params[key]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return n, nil
+}
+
+func durationParam(params map[string]string, key string, def time.Duration) (time.Duration, error) {
+	v, ok := params[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,71 @@
+package enrich
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	feed := filepath.Join(t.TempDir(), "feed.txt")
+	if err := os.WriteFile(feed, []byte("10.0.0.0/24\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	cases := []struct {
+		spec string
+		name string
+		ok   bool
+	}{
+		{"rdns", "rdns", true},
+		{"rdns:sample=10,budget=1s,workers=2", "rdns", true},
+		{"rdns:budget=soon", "", false},
+		{"feed:path=" + feed + ",name=tor", "tor", true},
+		{"feed", "", false},
+		{"asn", "", false},
+		{"geoip", "", false},
+		{"rdns:sample", "", false},
+	}
+	for _, tt := range cases {
+		e, err := Parse(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Fatalf("Parse(%q) error=%v; want ok=%v", tt.spec, err, tt.ok)
+		}
+		if err == nil && e.Name() != tt.name {
+			t.Fatalf("Parse(%q).Name()=%q; want %q", tt.spec, e.Name(), tt.name)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "feed.txt")
+	data := "# bad actors\n10.0.0.0/24\n10.0.0.128/25 # overlaps\n192.168.1.7\n\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	f, err := LoadFeed("bad", path, 2)
+	if err != nil {
+		t.Fatalf("LoadFeed: %v", err)
+	}
+
+	bs := ipv4_bitset.New()
+	for _, u := range []uint32{10<<24 | 1, 10<<24 | 200, 10<<24 | 1<<8, 192<<24 | 168<<16 | 1<<8 | 7, 192<<24 | 168<<16 | 1<<8 | 8} {
+		bs.SetIfNew(u)
+		bs.AddUnique(1)
+	}
+
+	// reports are per input
+	for i := 0; i < 2; i++ {
+		rep := Run(context.Background(), bs, []Enricher{f})["bad"].(FeedReport)
+		if rep.Entries != 3 || rep.Matched != 3 || len(rep.Sample) != 2 || rep.Sample[0] != "10.0.0.1" {
+			t.Fatalf("report %+v", rep)
+		}
+	}
+}
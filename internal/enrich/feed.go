@@ -0,0 +1,132 @@
+package enrich
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+type (
+	// Feed Matches the unique set against a list of addresses and CIDR ranges(threat feeds,
+	// allow/deny lists): one "a.b.c.d" or "a.b.c.d/nn" per line, '#' starts a comment.
+	Feed struct {
+		name    string
+		ranges  []ipRange // sorted, merged
+		entries int
+		samples int
+
+		matched uint64
+		sample  []string
+	}
+	ipRange struct{ lo, hi uint32 }
+
+	FeedReport struct {
+		Entries int      `json:"entries"`
+		Matched uint64   `json:"matched"`
+		Sample  []string `json:"sample,omitempty"`
+	}
+)
+
+// newFeedEnricher "feed:path=bad.txt,name=tor,samples=10"
+func newFeedEnricher(params map[string]string) (Enricher, error) {
+	path := params["path"]
+	if path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	samples, err := intParam(params, "samples", 10)
+	if err != nil {
+		return nil, err
+	}
+	name := params["name"]
+	if name == "" {
+		name = "feed"
+	}
+
+	return LoadFeed(name, path, samples)
+}
+
+// LoadFeed Reads the feed file, name is the key of its report.
+func LoadFeed(name, path string, samples int) (*Feed, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fd := &Feed{name: name, samples: samples}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		r, err := parseRange(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		fd.ranges = append(fd.ranges, r)
+		fd.entries++
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	fd.ranges = mergeRanges(fd.ranges)
+
+	return fd, nil
+}
+
+func (f *Feed) Name() string { return f.name }
+
+func (f *Feed) Begin(uint64) { f.matched, f.sample = 0, nil }
+
+func (f *Feed) Observe(u32 uint32) {
+	// first range ending at or after u32
+	i := sort.Search(len(f.ranges), func(i int) bool { return f.ranges[i].hi >= u32 })
+	if i == len(f.ranges) || f.ranges[i].lo > u32 {
+		return
+	}
+	f.matched++
+	if len(f.sample) < f.samples {
+		f.sample = append(f.sample, netip.AddrFrom4([4]byte{byte(u32 >> 24), byte(u32 >> 16), byte(u32 >> 8), byte(u32)}).String())
+	}
+}
+
+func (f *Feed) Report(context.Context) (any, error) {
+	return FeedReport{Entries: f.entries, Matched: f.matched, Sample: f.sample}, nil
+}
+
+func parseRange(s string) (ipRange, error) {
+	if !strings.Contains(s, "/") {
+		s += "/32"
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return ipRange{}, err
+	}
+	if !p.Addr().Is4() {
+		return ipRange{}, fmt.Errorf("%s: not an IPv4 range", s)
+	}
+	a := p.Masked().Addr().As4()
+	lo := uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3])
+
+	return ipRange{lo: lo, hi: lo | uint32(uint64(1)<<(32-p.Bits())-1)}, nil
+}
+
+func mergeRanges(rs []ipRange) []ipRange {
+	sort.Slice(rs, func(i, j int) bool { return rs[i].lo < rs[j].lo })
+	res := rs[:0]
+	for _, r := range rs {
+		if n := len(res); n > 0 && uint64(r.lo) <= uint64(res[n-1].hi)+1 {
+			res[n-1].hi = max(res[n-1].hi, r.hi)
+			continue
+		}
+		res = append(res, r)
+	}
+
+	return res
+}
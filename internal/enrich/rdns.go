@@ -0,0 +1,209 @@
+// Package enrich derives reports from the unique set after counting.
+package enrich
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+type (
+	// RDNS Resolves a sample of the unique addresses to PTR records and reports
+	// their unique second-level(registrable) domains. Lookups are cached for the
+	// lifetime of RDNS, so batch runs do not resolve an address twice.
+	RDNS struct {
+		sample  int
+		budget  time.Duration
+		workers int
+		lookup  func(ctx context.Context, addr string) ([]string, error)
+
+		mu    sync.Mutex
+		cache map[uint32][]string // nil value - no PTR record
+
+		// sampling state of the current input
+		step, seen uint64
+		addrs      []uint32
+	}
+
+	// RDNSReport Result of a run, addresses not resolved within the budget are Skipped.
+	RDNSReport struct {
+		Sampled       int           `json:"sampled"`
+		Resolved      int           `json:"resolved"`
+		Failed        int           `json:"failed"`
+		Skipped       int           `json:"skipped"`
+		CacheHits     int           `json:"cache_hits"`
+		UniqueDomains int           `json:"unique_domains"`
+		TopDomains    []DomainCount `json:"top_domains,omitempty"`
+	}
+	DomainCount struct {
+		Domain string `json:"domain"`
+		Count  int    `json:"count"`
+	}
+)
+
+// topDomains size of RDNSReport.TopDomains
+const topDomains = 10
+
+// newRDNSEnricher "rdns:sample=1000,budget=10s,workers=16"
+func newRDNSEnricher(params map[string]string) (Enricher, error) {
+	sample, err := intParam(params, "sample", 1000)
+	if err != nil {
+		return nil, err
+	}
+	budget, err := durationParam(params, "budget", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	workers, err := intParam(params, "workers", 16)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRDNS(sample, budget, workers), nil
+}
+
+// NewRDNS sample - number of unique addresses to resolve, budget - wall time limit of a report.
+func NewRDNS(sample int, budget time.Duration, workers int) *RDNS {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	return &RDNS{
+		sample:  sample,
+		budget:  budget,
+		workers: workers,
+		lookup:  net.DefaultResolver.LookupAddr,
+		cache:   map[uint32][]string{},
+	}
+}
+
+func (r *RDNS) Name() string { return "rdns" }
+
+// Begin The sample is evenly spread: every n-th address in ascending order.
+func (r *RDNS) Begin(total uint64) {
+	r.step, r.seen, r.addrs = 1, 0, nil
+	if r.sample > 0 {
+		r.step = max(total/uint64(r.sample), 1)
+	}
+}
+
+func (r *RDNS) Observe(u32 uint32) {
+	if len(r.addrs) < r.sample && r.seen%r.step == 0 {
+		r.addrs = append(r.addrs, u32)
+	}
+	r.seen++
+}
+
+// Report Resolves the sample within the budget.
+func (r *RDNS) Report(ctx context.Context) (any, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.budget)
+	defer cancel()
+
+	addrs := r.addrs
+	rep := RDNSReport{Sampled: len(addrs)}
+
+	var (
+		mu      sync.Mutex
+		domains = map[string]int{}
+		wg      sync.WaitGroup
+		jobs    = make(chan uint32)
+	)
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u32 := range jobs {
+				names, hit, err := r.resolve(ctx, u32)
+
+				mu.Lock()
+				switch {
+				case ctx.Err() != nil && err != nil:
+					rep.Skipped++
+				case err != nil:
+					rep.Failed++
+				default:
+					rep.Resolved++
+					for _, d := range registrableDomains(names) {
+						domains[d]++
+					}
+				}
+				if hit {
+					rep.CacheHits++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	sent := 0
+	for _, u32 := range addrs {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- u32:
+			sent++
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	rep.Skipped += len(addrs) - sent
+
+	rep.UniqueDomains = len(domains)
+	for d, n := range domains {
+		rep.TopDomains = append(rep.TopDomains, DomainCount{Domain: d, Count: n})
+	}
+	sort.Slice(rep.TopDomains, func(i, j int) bool {
+		a, b := rep.TopDomains[i], rep.TopDomains[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Domain < b.Domain
+	})
+	if len(rep.TopDomains) > topDomains {
+		rep.TopDomains = rep.TopDomains[:topDomains]
+	}
+
+	return rep, nil
+}
+
+// resolve Cached PTR lookup, "not found" is cached as well, other errors are not.
+func (r *RDNS) resolve(ctx context.Context, u32 uint32) (names []string, hit bool, err error) {
+	r.mu.Lock()
+	names, hit = r.cache[u32]
+	r.mu.Unlock()
+	if hit {
+		return names, true, nil
+	}
+
+	ip := net.IPv4(byte(u32>>24), byte(u32>>16), byte(u32>>8), byte(u32)).String()
+	names, err = r.lookup(ctx, ip)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		names, err = nil, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	r.mu.Lock()
+	r.cache[u32] = names
+	r.mu.Unlock()
+
+	return names, false, nil
+}
+
+// registrableDomains eTLD+1 of every PTR name("host-1.pool.example.co.uk." -> "example.co.uk").
+func registrableDomains(names []string) []string {
+	res := make([]string, 0, len(names))
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSuffix(n, "."))
+		if d, err := publicsuffix.EffectiveTLDPlusOne(n); err == nil {
+			res = append(res, d)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,81 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+func TestRDNS_Run(t *testing.T) {
+	t.Parallel()
+
+	bs := ipv4_bitset.New()
+	for i := uint32(1); i <= 100; i++ {
+		if bs.SetIfNew(10<<24 | i) {
+			bs.AddUnique(1)
+		}
+	}
+
+	var lookups atomic.Int64
+	r := NewRDNS(10, 5*time.Second, 4)
+	r.lookup = func(_ context.Context, addr string) ([]string, error) {
+		lookups.Add(1)
+		switch net.ParseIP(addr).To4()[3] % 3 {
+		case 0:
+			return []string{"h-" + addr + ".pool.example.co.uk."}, nil
+		case 1:
+			return []string{"edge.cdn.example.com."}, nil
+		default:
+			return nil, &net.DNSError{Err: "no such host", IsNotFound: true}
+		}
+	}
+
+	rep := Run(context.Background(), bs, []Enricher{r})["rdns"].(RDNSReport)
+	if rep.Sampled != 10 || rep.Resolved != 10 || rep.Failed != 0 || rep.Skipped != 0 {
+		t.Fatalf("report %+v", rep)
+	}
+	if rep.UniqueDomains != 2 {
+		t.Fatalf("UniqueDomains=%d; want 2(%+v)", rep.UniqueDomains, rep.TopDomains)
+	}
+	for _, d := range rep.TopDomains {
+		if d.Domain != "example.co.uk" && d.Domain != "example.com" {
+			t.Fatalf("unexpected domain %q", d.Domain)
+		}
+	}
+
+	// the second run is served from the cache
+	rep = Run(context.Background(), bs, []Enricher{r})["rdns"].(RDNSReport)
+	if rep.CacheHits != 10 || lookups.Load() != 10 {
+		t.Fatalf("cache hits=%d lookups=%d; want 10 and 10", rep.CacheHits, lookups.Load())
+	}
+}
+
+func TestRDNS_Budget(t *testing.T) {
+	t.Parallel()
+
+	bs := ipv4_bitset.New()
+	for i := uint32(0); i < 50; i++ {
+		bs.SetIfNew(i)
+		bs.AddUnique(1)
+	}
+
+	r := NewRDNS(50, 50*time.Millisecond, 2)
+	r.lookup = func(ctx context.Context, _ string) ([]string, error) {
+		<-ctx.Done()
+		return nil, errors.New("timeout")
+	}
+
+	start := time.Now()
+	rep := Run(context.Background(), bs, []Enricher{r})["rdns"].(RDNSReport)
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("budget not enforced: %v", time.Since(start))
+	}
+	if rep.Sampled != 50 || rep.Skipped != 50 || rep.Resolved != 0 {
+		t.Fatalf("report %+v", rep)
+	}
+}
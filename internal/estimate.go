@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/file_processor"
+	"unique-ip-counter/internal/remote_source"
+)
+
+// runEstimates -estimate-only: estimates the unique count of every input from a random sample
+// of its byte ranges instead of counting it.
+func (a *App) runEstimates(ctx context.Context) error {
+	var errs []error
+	for _, path := range a.cfg.paths {
+		if err := a.estimate(ctx, path); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			errs = append(errs, fmt.Errorf("estimate(%s): %w", path, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (a *App) estimate(ctx context.Context, path string) error {
+	start := time.Now()
+	fp, err := a.newFileProcessor(path)
+	if err != nil {
+		return err
+	}
+	if f := fp.GetFile(); f != nil {
+		defer f.Close()
+	}
+	src, size, err := randomAccess(ctx, fp, path)
+	if err != nil {
+		return err
+	}
+
+	est, err := fp.Estimate(ctx, src, size, int64(a.cfg.estimateBudget), a.cfg.estimateRanges,
+		rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+	if err != nil {
+		return err
+	}
+	a.logger.Info("estimate", zap.String("path", path), zap.Any("estimate", est))
+	writeEstimate(os.Stdout, est, time.Since(start))
+
+	return nil
+}
+
+// randomAccess The input as a random access source: a local regular file or a remote object with range reads.
+func randomAccess(ctx context.Context, fp *file_processor.FileProcessor, path string) (io.ReaderAt, int64, error) {
+	if remote_source.IsS3(path) {
+		o, err := remote_source.OpenS3(ctx, path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return o, o.Size(), nil
+	}
+	if remote_source.IsHTTP(path) {
+		o, err := remote_source.OpenHTTP(ctx, path)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !o.Ranged() {
+			return nil, 0, errors.New("server does not support range requests, nothing to sample")
+		}
+		return o, o.Size(), nil
+	}
+
+	fi, err := fp.GetFile().Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, 0, errors.New("not a regular file, nothing to sample")
+	}
+
+	return fp.GetFile(), fi.Size(), nil
+}
+
+func writeEstimate(w io.Writer, est file_processor.Estimate, took time.Duration) {
+	if est.Exact {
+		_, _ = fmt.Fprintf(w, "unique ip's: %v(the budget covered the whole input), total time: %.1f sec\n",
+			est.Unique, took.Seconds())
+		return
+	}
+	var pct float64
+	if est.InputBytes > 0 {
+		pct = float64(est.SampledBytes) / float64(est.InputBytes) * 100
+	}
+	_, _ = fmt.Fprintf(w, "estimated unique ip's: ~%v(GEE, sample consistent with %v..%v, Chao1 ~%v), "+
+		"%v addresses(%v distinct) in %.2f%% of the input(%v ranges), total time: %.1f sec\n",
+		est.Unique, est.Low, est.High, est.Chao1, est.Records, est.Distinct, pct, est.Ranges, took.Seconds())
+}
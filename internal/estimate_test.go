@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"unique-ip-counter/internal/file_processor"
+)
+
+func Test_writeEstimate(t *testing.T) {
+	t.Parallel()
+	var b strings.Builder
+	writeEstimate(&b, file_processor.Estimate{Ranges: 256, SampledBytes: 64 << 20, InputBytes: 100 << 30, Records: 4_200_000,
+		Distinct: 3_100_000, Unique: 91_000_000, Low: 3_100_000, High: 2_800_000_000, Chao1: 12_000_000}, 2*time.Second)
+	want := "estimated unique ip's: ~91000000(GEE, sample consistent with 3100000..2800000000, Chao1 ~12000000), " +
+		"4200000 addresses(3100000 distinct) in 0.06% of the input(256 ranges), total time: 2.0 sec\n"
+	if b.String() != want {
+		t.Fatalf("estimate line:\n%q\nwant\n%q", b.String(), want)
+	}
+
+	b.Reset()
+	writeEstimate(&b, file_processor.Estimate{Exact: true, Unique: 42, Low: 42, High: 42}, time.Second)
+	if !strings.HasPrefix(b.String(), "unique ip's: 42(the budget covered the whole input)") {
+		t.Fatalf("exact estimate line %q", b.String())
+	}
+}
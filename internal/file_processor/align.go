@@ -0,0 +1,154 @@
+package file_processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// maxDelimiterRun Bytes of overlapping delimiter matches read back to find the one the sequential reader takes.
+const maxDelimiterRun = 64 << 20
+
+// selfOverlapping Reports whether matches of delim may overlap: a proper prefix is also a suffix("aa", "\r\n\r\n").
+func selfOverlapping(delim []byte) bool {
+	for k := 1; k < len(delim); k++ {
+		if bytes.Equal(delim[:k], delim[len(delim)-k:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// takenEnd Corrects the end of a record terminator found by a search starting mid-file: a match of a
+// self-overlapping delimiter("\r\n\r\n" in "\r\n\r\n\r\n") is not necessarily the one the sequential reader
+// takes, the end of the one it takes is also a record start after end-len(delim).
+func (fp *FileProcessor) takenEnd(end int64) (int64, error) {
+	if !selfOverlapping(fp.delim) {
+		return end, nil
+	}
+	b := make([]byte, 1)
+	if _, err := fp.src.ReadAt(b, end-1); err != nil {
+		return 0, err
+	}
+	if b[0] == '\n' && fp.lineBreaks() {
+		return end, nil
+	}
+	d := int64(len(fp.delim))
+	t, err := fp.lastTaken(0, end-d)
+
+	return t + d, err
+}
+
+// isRecordStart Reports whether a record of src starts at off.
+func (fp *FileProcessor) isRecordStart(off int64) (bool, error) {
+	if off == 0 {
+		return true, nil
+	}
+	d := int64(len(fp.delim))
+	b := make([]byte, min(d, off))
+	if _, err := fp.src.ReadAt(b, off-int64(len(b))); err != nil {
+		return false, err
+	}
+	if b[len(b)-1] == '\n' && fp.lineBreaks() {
+		return true, nil
+	}
+	if !bytes.Equal(b, fp.delim) {
+		return false, nil
+	}
+	t, err := fp.lastTaken(0, off-d)
+
+	return t == off-d, err
+}
+
+// LastRecordEnd The offset after the last record terminator of src within [off, size), off when there is none:
+// a partially written last record of a growing file waits for more data. off must be a record start.
+func (fp *FileProcessor) LastRecordEnd(ctx context.Context, off, size int64) (int64, error) {
+	const chunk = 64 << 10
+	d := int64(len(fp.delim))
+	buf := make([]byte, chunk+d-1)
+	cp := newCheckpoint(ctx)
+	for end := size; end > off; end -= chunk {
+		if err := cp.spend(chunk); err != nil {
+			return off, err
+		}
+		start := max(end-chunk, off)
+		// overlap the following chunk, a delimiter may span them
+		n, err := fp.src.ReadAt(buf[:min(end+d-1, size)-start], start)
+		if err != nil && err != io.EOF {
+			return off, err
+		}
+		last := int64(-1)
+		if fp.lineBreaks() {
+			if j := bytes.LastIndexByte(buf[:n], '\n'); j >= 0 {
+				last = start + int64(j) + 1
+			}
+		}
+		if !fp.isLines() {
+			if p := bytes.LastIndex(buf[:n], fp.delim); p >= 0 {
+				t, err := fp.lastTaken(off, start+int64(p))
+				if err != nil {
+					return off, err
+				}
+				last = max(last, t+d)
+			}
+		}
+		if last >= 0 {
+			return last, nil
+		}
+	}
+
+	return off, nil
+}
+
+// lastTaken The start of the delimiter the sequential reader takes at the match at p: p itself, or an earlier
+// match of the same run of overlapping ones overlapping p. floor is a record start at or before p.
+func (fp *FileProcessor) lastTaken(floor, p int64) (int64, error) {
+	if !selfOverlapping(fp.delim) {
+		// matches never overlap, the reader takes every one
+		return p, nil
+	}
+	d := len(fp.delim)
+	for back := int64(4 << 10); ; back *= 2 {
+		from := max(p-back, floor)
+		buf := make([]byte, p-from+int64(d))
+		if n, err := fp.src.ReadAt(buf, from); n < len(buf) {
+			return 0, err
+		}
+		if i, ok := runStart(buf, int(p-from), fp.delim, from == floor); ok {
+			// the reader takes the run start, then the first match after every taken one
+			for {
+				k := bytes.Index(buf[i+d:], fp.delim)
+				if k < 0 {
+					return from + int64(i), nil
+				}
+				i += d + k
+			}
+		}
+		if back >= maxDelimiterRun {
+			return 0, fmt.Errorf("record delimiter %q repeats for over %d bytes at %d", fp.delim, maxDelimiterRun, p)
+		}
+	}
+}
+
+// runStart The first match of the run of overlapping delimiter matches holding the one at i of b, ok is false
+// when the run may start before b. atStart - b starts at a record start, nothing before it matters.
+func runStart(b []byte, i int, delim []byte, atStart bool) (int, bool) {
+	d := len(delim)
+	for {
+		lo := i - d + 1
+		if lo < 0 {
+			if !atStart {
+				return 0, false
+			}
+			lo = 0
+		}
+		// the leftmost match starting in [lo, i) overlaps the one at i
+		k := bytes.Index(b[lo:i+d-1], delim)
+		if k < 0 {
+			return i, true
+		}
+		i = lo + k
+	}
+}
@@ -0,0 +1,248 @@
+package file_processor
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// unitSize Decompressed bytes of a unit of work of the balancer(consecutive frames), BGZF blocks alone are 64KB.
+const unitSize = 1 << 20
+
+type (
+	// balancer Decompresses and parses the units of a framed input on one pool of goroutines: every goroutine
+	// takes the role the queue of decompressed units calls for, so cores move to decompression while parsers
+	// wait for data and back to parsing while decompressed units pile up.
+	balancer struct {
+		fp    *FileProcessor
+		units []unit
+		open  func(r io.Reader) (io.ReadCloser, error)
+		ahead int // units decompressed ahead of the parsers at most
+
+		mu         sync.Mutex
+		decoders   int // goroutines meant to decompress, adapted to the queue depth
+		decoding   int // goroutines decompressing ahead
+		nextDecode int // the next unit to decompress ahead
+		nextParse  int // the next unit to parse
+		ready      int // units decompressed and not taken by a parser yet(the queue depth)
+		parsed     []bool
+		low        int // units below are parsed, so is every record reaching into them
+		inline     int // units a parser had to decompress itself
+	}
+	// unit Consecutive frames decompressed at once.
+	unit struct {
+		first     int   // frame
+		off, size int64 // compressed
+		dOff      int64
+		dSize     int64
+
+		claimed bool
+		queued  bool          // decompressed ahead, not taken by a parser yet
+		done    chan struct{} // closed once data or err is set
+		data    []byte
+		err     error
+	}
+)
+
+// WithBalancer Framed compressed inputs(seekable zstd, BGZF) are decompressed and parsed by one pool of
+// -th goroutines shifted between the two by the depth of the queue of decompressed data, instead of
+// -th static groups that decompress and parse their own frames.
+func WithBalancer(on bool) Option {
+	return func(fp *FileProcessor) { fp.balance = on }
+}
+
+// balanceFrames processFrames under WithBalancer.
+func (fp *FileProcessor) balanceFrames(ctx context.Context, frames []frame, open func(r io.Reader) (io.ReadCloser, error)) error {
+	workers := max(fp.th, 1)
+	b := &balancer{
+		fp:       fp,
+		units:    frameUnits(frames),
+		open:     open,
+		ahead:    4 * workers,
+		decoders: max(workers/2, 1),
+	}
+	b.parsed = make([]bool, len(b.units))
+
+	g, ctx := errgroup.WithContext(ctx)
+	for range workers {
+		g.Go(func() error { return b.work(ctx, workers) })
+	}
+	err := g.Wait()
+	fp.logger.Info("balanced decompression",
+		zap.Int("units", len(b.units)), zap.Int("decompressed_by_parsers", b.inline), zap.Int("decoders", b.decoders))
+
+	return err
+}
+
+// frameUnits Groups frames into units of at least unitSize decompressed bytes.
+func frameUnits(frames []frame) []unit {
+	var units []unit
+	for i := 0; i < len(frames); {
+		u := unit{first: i, off: frames[i].off, dOff: frames[i].dOff, done: make(chan struct{})}
+		for i < len(frames) && (u.dSize < unitSize || i == u.first) {
+			u.size += frames[i].size
+			u.dSize += frames[i].dSize
+			i++
+		}
+		units = append(units, u)
+	}
+
+	return units
+}
+
+// work Decompresses ahead or parses until every unit is parsed.
+func (b *balancer) work(ctx context.Context, workers int) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		k, decode := b.next(workers)
+		switch {
+		case k < 0:
+			return nil
+		case decode:
+			err := b.decode(k)
+			b.mu.Lock()
+			b.decoding--
+			if err == nil && k >= b.nextParse {
+				b.units[k].queued = true
+				b.ready++
+			}
+			b.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		default:
+			if err := b.parse(ctx, k); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// next The unit to work on and whether to decompress it ahead, -1 once every unit is taken by a parser.
+func (b *balancer) next(workers int) (k int, decode bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// parsers starve - one more decoder, decompressed units pile up - one less
+	switch {
+	case b.ready == 0 && b.decoders < workers:
+		b.decoders++
+	case b.ready > workers && b.decoders > 1:
+		b.decoders--
+	}
+	for b.nextDecode < len(b.units) && b.units[b.nextDecode].claimed {
+		b.nextDecode++
+	}
+	if b.decoding < b.decoders && b.nextDecode < len(b.units) && b.nextDecode-b.nextParse < b.ahead {
+		b.units[b.nextDecode].claimed = true
+		b.decoding++
+		return b.nextDecode, true
+	}
+	if b.nextParse == len(b.units) {
+		return -1, false
+	}
+	k = b.nextParse
+	b.nextParse++
+	if u := &b.units[k]; u.queued {
+		u.queued = false
+		b.ready--
+	}
+
+	return k, false
+}
+
+// decode Decompresses unit k.
+func (b *balancer) decode(k int) error {
+	u := &b.units[k]
+	defer close(u.done)
+
+	rc, err := b.open(io.NewSectionReader(b.fp.src, u.off, u.size))
+	if err != nil {
+		u.err = err
+		return err
+	}
+	defer rc.Close()
+	u.data = make([]byte, u.dSize)
+	if _, err = io.ReadFull(rc, u.data); err != nil {
+		u.data, u.err = nil, err
+		return err
+	}
+	b.fp.progress.AddBytes(u.size)
+
+	return nil
+}
+
+// get The data of unit k, decompressed by the caller unless another goroutine has claimed it.
+func (b *balancer) get(k int) ([]byte, error) {
+	u := &b.units[k]
+	b.mu.Lock()
+	claim := !u.claimed
+	if claim {
+		u.claimed = true
+		b.inline++
+	}
+	b.mu.Unlock()
+	if claim {
+		_ = b.decode(k)
+	}
+	<-u.done
+
+	return u.data, u.err
+}
+
+// parse Counts the records unit k owns the same way a group of processFrames does, the last one is read
+// into the following units.
+func (b *balancer) parse(ctx context.Context, k int) error {
+	u := &b.units[k]
+	r, err := b.fp.newLineRange(&unitReader{b: b, k: k}, k > 0 || b.fp.headerLen > 0, u.dSize)
+	if err == nil {
+		err = b.fp.processReader(ctx, r, u.dOff+r.pos, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.parsed[k] = true
+	for b.low < len(b.units) && b.parsed[b.low] {
+		// no parser reads it any more
+		b.units[b.low].data = nil
+		b.low++
+	}
+
+	return nil
+}
+
+// unitReader The decompressed stream from unit k on.
+type unitReader struct {
+	b    *balancer
+	k    int
+	data []byte
+	read bool // data holds unit k
+}
+
+func (r *unitReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.read {
+			r.k++
+		}
+		if r.k >= len(r.b.units) {
+			return 0, io.EOF
+		}
+		data, err := r.b.get(r.k)
+		if err != nil {
+			return 0, err
+		}
+		r.data, r.read = data, true
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+
+	return n, nil
+}
@@ -0,0 +1,108 @@
+package file_processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/gzip"
+	"go.uber.org/zap"
+)
+
+// ErrBadBGZF returned on a malformed BGZF file.
+var ErrBadBGZF = errors.New("bad bgzf")
+
+const gzipFlagExtra = 1 << 2
+
+// ProcessGzip Counts a .gz/.bgz file. BGZF(blocked gzip, bgzip/samtools) files are split into groups
+// of blocks decompressed in parallel, other gzip files are decompressed by a single goroutine.
+func (fp *FileProcessor) ProcessGzip(ctx context.Context, fi os.FileInfo) error {
+	blocks, err := readBGZFBlocks(fp.src, fi.Size())
+	if err != nil {
+		return err
+	}
+	if blocks == nil {
+		fp.logger.Info("gzip file is not BGZF, decompressing sequentially")
+		defer fp.progress.Run(fi.Size())()
+		zr, err := gzip.NewReader(fp.progress.reader(io.NewSectionReader(fp.src, 0, fi.Size())))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		return fp.processStream(ctx, zr, false)
+	}
+
+	fp.logger.Info("bgzf", zap.Int("blocks", len(blocks)))
+
+	return fp.processFrames(ctx, blocks, func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	})
+}
+
+// readBGZFBlocks Blocks of a BGZF file found by walking the block sizes of the headers,
+// nil when the first member is a plain gzip member.
+func readBGZFBlocks(src io.ReaderAt, size int64) ([]frame, error) {
+	var (
+		blocks []frame
+		dOff   int64
+		hdr    = make([]byte, 12+0xffff)
+		isize  = make([]byte, 4)
+	)
+	for off := int64(0); off < size; {
+		n, err := src.ReadAt(hdr[:12], off)
+		if n < 12 {
+			if blocks == nil {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%w: truncated header at %d: %v", ErrBadBGZF, off, err)
+		}
+		bsize, ok := bgzfBlockSize(src, hdr, off)
+		if !ok {
+			if blocks == nil {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%w: block at %d is not a BGZF block", ErrBadBGZF, off)
+		}
+		if bsize < 12+8 || off+bsize > size {
+			return nil, fmt.Errorf("%w: block size %d at %d", ErrBadBGZF, bsize, off)
+		}
+		if _, err = src.ReadAt(isize, off+bsize-4); err != nil {
+			return nil, err
+		}
+		d := int64(binary.LittleEndian.Uint32(isize))
+		blocks = append(blocks, frame{off: off, size: bsize, dOff: dOff, dSize: d})
+		off += bsize
+		dOff += d
+	}
+
+	return blocks, nil
+}
+
+// bgzfBlockSize Total size of the gzip member at off from its "BC" extra subfield.
+func bgzfBlockSize(src io.ReaderAt, hdr []byte, off int64) (int64, bool) {
+	if hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[2] != 8 || hdr[3]&gzipFlagExtra == 0 {
+		return 0, false
+	}
+	xlen := int(binary.LittleEndian.Uint16(hdr[10:]))
+	extra := hdr[12 : 12+xlen]
+	if n, _ := src.ReadAt(extra, off+12); n < xlen {
+		return 0, false
+	}
+	for len(extra) >= 4 {
+		slen := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+slen {
+			return 0, false
+		}
+		if bytes.Equal(extra[:2], []byte("BC")) && slen == 2 {
+			return int64(binary.LittleEndian.Uint16(extra[4:])) + 1, true
+		}
+		extra = extra[4+slen:]
+	}
+
+	return 0, false
+}
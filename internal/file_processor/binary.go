@@ -0,0 +1,167 @@
+package file_processor
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrBadBinary returned on a binary-be32 file of a size that is not a multiple of 4.
+var ErrBadBinary = errors.New("bad binary-be32 file")
+
+// Output formats of Convert.
+const (
+	OutBinaryBE32 = "binary-be32" // 4 bytes per address, big endian(network order), no header
+	OutText       = "text"        // dotted address per line
+)
+
+// ProcessBinary Counts a binary-be32 file(see Convert), records have a fixed size
+// so shards are split at record boundaries without any scanning.
+func (fp *FileProcessor) ProcessBinary(ctx context.Context, fi os.FileInfo) error {
+	size := fi.Size()
+	if size%4 != 0 {
+		return fmt.Errorf("%w: size %d", ErrBadBinary, size)
+	}
+	if size == 0 {
+		return nil
+	}
+	defer fp.progress.Run(size)()
+
+	records := size / 4
+	n := int64(max(fp.th, 1))
+	if n > records {
+		n = records
+	}
+	part := records / n
+
+	g, ctx := errgroup.WithContext(ctx)
+	for i := int64(0); i < n; i++ {
+		start, end := i*part*4, (i+1)*part*4
+		if i == n-1 {
+			end = size
+		}
+		g.Go(func() error {
+			return fp.processBinaryShard(ctx, io.NewSectionReader(fp.src, start, end-start))
+		})
+	}
+
+	return g.Wait()
+}
+
+func (fp *FileProcessor) processBinaryShard(ctx context.Context, r io.Reader) error {
+	var localUniq uint64
+	defer func() { fp.bitset.AddUnique(localUniq) }()
+
+	buf := make([]byte, 256<<10) // multiple of 4
+	cp := newCheckpoint(ctx)
+	for {
+		n, err := io.ReadFull(r, buf)
+		for i := 0; i+4 <= n; i += 4 {
+			if fp.bitset.SetIfNew(binary.BigEndian.Uint32(buf[i:])) {
+				localUniq++
+			}
+		}
+		if cerr := cp.spend(n); cerr != nil {
+			return cerr
+		}
+		fp.progress.AddBytes(int64(n))
+		fp.progress.AddRecords(int64(n / 4))
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// publish uniques to detect saturation and the unique limit
+		fp.bitset.AddUnique(localUniq)
+		localUniq = 0
+		if c := fp.bitset.GetUniqueCount(); c >= fp.ceiling {
+			return ErrSaturated
+		} else if fp.stopAfter > 0 && c >= fp.stopAfter {
+			return ErrUniqueLimit
+		}
+	}
+}
+
+// Convert Extracts the address of every line of r(honouring WithFormat and WithCSVColumn)
+// and writes it to w in outFormat without counting, invalid lines are skipped and
+// counted as by the counting path. Returns the number of written addresses.
+func (fp *FileProcessor) Convert(ctx context.Context, r io.Reader, w io.Writer, outFormat string) (uint64, error) {
+	var put func(bw *bufio.Writer, u32 uint32) error
+	switch outFormat {
+	case OutBinaryBE32:
+		var b [4]byte
+		put = func(bw *bufio.Writer, u32 uint32) error {
+			binary.BigEndian.PutUint32(b[:], u32)
+			_, err := bw.Write(b[:])
+			return err
+		}
+	case OutText:
+		put = func(bw *bufio.Writer, u32 uint32) error {
+			_, err := bw.WriteString(net.IPv4(byte(u32>>24), byte(u32>>16), byte(u32>>8), byte(u32)).String() + "\n")
+			return err
+		}
+	default:
+		return 0, fmt.Errorf("unknown output format %q", outFormat)
+	}
+
+	br := decodeBOM(r)
+	if err := fp.readHeader(br); err != nil {
+		return 0, err
+	}
+
+	rr := fp.newRecordReader(br)
+	bw := bufio.NewWriterSize(w, 1<<20)
+	var written, invalid uint64
+	defer func() { fp.invalid.add(invalid) }()
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		line, err := rr.read()
+		// same as counting: an unterminated last line is not a record
+		if err == io.EOF {
+			return written, bw.Flush()
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			var head []byte
+			if head, _, err = rr.skip(); err != nil && err != io.EOF {
+				return written, err
+			}
+			invalid++
+			fp.invalid.observe(rr.trim(head), invalid)
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+		if !fp.isLines() {
+			if line = rr.trim(line); len(line) == 0 {
+				continue
+			}
+		}
+		if fp.directive(line) || fp.skip(line) {
+			continue
+		}
+		if line = fp.uncomment(line); line == nil {
+			continue
+		}
+		if u32, ok := fp.parse(fp.token(line)); ok {
+			if err = put(bw, u32); err != nil {
+				return written, err
+			}
+			written++
+			continue
+		}
+		invalid++
+		fp.invalid.observe(trimCRLF(line), invalid)
+	}
+}
@@ -0,0 +1,27 @@
+package file_processor
+
+import "context"
+
+// cancelEvery Bytes of work between cancellation checks of scans that do not stop at records.
+const cancelEvery = 4 << 20
+
+// checkpoint Checks ctx once per cancelEvery bytes instead of per record, so scans over huge regions
+// without records(alignment past a multi-GB line, binary-be32 shards) still stop promptly on Ctrl+C.
+type checkpoint struct {
+	ctx  context.Context
+	left int
+}
+
+func newCheckpoint(ctx context.Context) *checkpoint {
+	return &checkpoint{ctx: ctx, left: cancelEvery}
+}
+
+// spend Accounts n bytes of work, returns the ctx error once the budget is used up and ctx is done.
+func (c *checkpoint) spend(n int) error {
+	if c.left -= n; c.left > 0 {
+		return nil
+	}
+	c.left = cancelEvery
+
+	return c.ctx.Err()
+}
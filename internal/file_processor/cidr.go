@@ -0,0 +1,100 @@
+package file_processor
+
+import (
+	"bytes"
+	"sync"
+)
+
+// CIDR modes of WithCIDR.
+const (
+	CIDRRange  = "range"  // every address of the prefix is seen
+	CIDRPrefix = "prefix" // the prefix is one unique entity
+)
+
+// prefixSet Distinct prefixes of CIDRPrefix, shared by every shard.
+type prefixSet struct {
+	mu sync.Mutex
+	m  map[uint64]struct{}
+}
+
+// WithCIDR Counts prefix lines(10.0.0.0/24 of blocklists) as the range of their addresses(CIDRRange)
+// or as one entity each(CIDRPrefix), "" - prefixes are invalid lines. A /32 is its address in both modes.
+func WithCIDR(mode string) Option {
+	return func(fp *FileProcessor) {
+		fp.cidr = mode
+		if mode == CIDRPrefix {
+			fp.prefixes = &prefixSet{m: make(map[uint64]struct{})}
+		}
+	}
+}
+
+// addCIDR Counts a prefix token, ok is false when tok is not one or prefixes are not counted.
+func (fp *FileProcessor) addCIDR(tok []byte, localUniq *uint64) (bool, error) {
+	if fp.cidr == "" {
+		return false, nil
+	}
+	addr, bits, ok := fp.parseCIDR(tok)
+	if !ok {
+		return false, nil
+	}
+	// host bits are ignored: 10.0.0.5/24 is 10.0.0.0/24
+	mask := ^uint32(0)
+	if bits < 32 {
+		mask = ^(^uint32(0) >> bits)
+	}
+	first := addr & mask
+	if bits == 32 {
+		return true, fp.add(first, localUniq)
+	}
+	if fp.cidr == CIDRRange {
+		return true, fp.count(fp.bitset.AddRange(first, first|^mask), localUniq)
+	}
+	if fp.prefixes.add(uint64(first)<<8 | uint64(bits)) {
+		return true, fp.count(1, localUniq)
+	}
+
+	return true, nil
+}
+
+// parseCIDR Splits an "address/bits" token.
+func (fp *FileProcessor) parseCIDR(tok []byte) (uint32, int, bool) {
+	i := bytes.IndexByte(tok, '/')
+	if i < 0 || len(tok)-i-1 < 1 || len(tok)-i-1 > 2 {
+		return 0, 0, false
+	}
+	addr, ok := fp.dotted(tok[:i])
+	if !ok {
+		return 0, 0, false
+	}
+	bits := 0
+	for _, c := range tok[i+1:] {
+		if !isDigit(c) {
+			return 0, 0, false
+		}
+		bits = bits*10 + int(c-'0')
+	}
+	if bits > 32 {
+		return 0, 0, false
+	}
+
+	return addr, bits, true
+}
+
+// add Reports whether the prefix is new.
+func (s *prefixSet) add(key uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		return false
+	}
+	s.m[key] = struct{}{}
+
+	return true
+}
+
+func (s *prefixSet) len() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return uint64(len(s.m))
+}
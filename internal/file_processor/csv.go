@@ -0,0 +1,167 @@
+package file_processor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WithCSVColumn Counts a field of comma separated rows instead of whole lines:
+// col is a 1-based index or a name of the header(first line).
+func WithCSVColumn(col string) Option {
+	return func(fp *FileProcessor) {
+		if col == "" {
+			return
+		}
+		fp.csv = true
+		if i, err := strconv.Atoi(col); err == nil && i > 0 {
+			fp.csvIndex = i - 1
+			return
+		}
+		fp.csvName, fp.csvIndex = col, -1
+	}
+}
+
+// WithHeader The first row of every input is a header: it is skipped(not an invalid record) and
+// resolves a column given by name. A column name implies a header.
+func WithHeader(header bool) Option {
+	return func(fp *FileProcessor) { fp.header = header }
+}
+
+// hasHeader Reports whether every input starts with a header(CSV header row, W3C directives).
+func (fp *FileProcessor) hasHeader() bool {
+	return fp.header || fp.csvName != "" || fp.w3c || fp.dimsByName()
+}
+
+// resolveHeader Resolves the column of the address from the header at the start of r,
+// every input has its own header.
+func (fp *FileProcessor) resolveHeader(r io.Reader) error {
+	if !fp.hasHeader() {
+		return nil
+	}
+
+	return fp.readHeader(bufio.NewReaderSize(r, 64<<10))
+}
+
+// readHeader Consumes the header lines(CSV header row or W3C directives up to #Fields) of br.
+func (fp *FileProcessor) readHeader(br *bufio.Reader) error {
+	if fp.w3c {
+		return fp.readW3CFields(br)
+	}
+	if !fp.hasHeader() {
+		return nil
+	}
+	rr := fp.newRecordReader(br)
+	line, err := rr.read()
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("csv header: %w", err)
+	}
+	// sharded reads start past the header
+	fp.headerLen = int64(len(line))
+	if fp.dimsByName() {
+		fp.setDimensionHeader(trimCRLF(trimBOM(rr.trim(line))))
+	}
+	if fp.csvName == "" {
+		return nil
+	}
+
+	return fp.setCSVHeader(rr.trim(line))
+}
+
+func (fp *FileProcessor) setCSVHeader(line []byte) error {
+	line = trimCRLF(trimBOM(line))
+	for i := 0; ; i++ {
+		f, ok := csvField(line, i)
+		if !ok {
+			return fmt.Errorf("csv column %q not found in the header", fp.csvName)
+		}
+		if string(bytes.TrimSpace(f)) == fp.csvName {
+			fp.csvIndex = i
+			return nil
+		}
+	}
+}
+
+// token The bytes of a line holding the address.
+func (fp *FileProcessor) token(line []byte) []byte {
+	tok := fp.field(line)
+	if fp.trimSpace {
+		tok = trimSpace(tok)
+	}
+	if fp.stripPort {
+		return stripPort(tok)
+	}
+
+	return tok
+}
+
+// field The field of a line holding the address(the whole line for plain input).
+func (fp *FileProcessor) field(line []byte) []byte {
+	line = trimCRLF(line)
+	if fp.w3c {
+		f, _ := spaceField(line, fp.w3cIndex)
+		return f
+	}
+	if fp.extract != nil {
+		return fp.extract(line)
+	}
+	if !fp.csv {
+		return line
+	}
+	f, _ := csvField(line, fp.csvIndex)
+
+	return f
+}
+
+// csvField Returns the idx-th(0-based) field of a comma separated line(RFC 4180 quoting), a quoted field
+// is returned without its quotes(commas inside quotes do not split, "" is not unescaped). The scan is
+// zero-allocation and jumps between separators and quotes with bytes.IndexByte.
+func csvField(line []byte, idx int) ([]byte, bool) {
+	if idx < 0 {
+		return nil, false
+	}
+	for i := 0; ; i++ {
+		var f []byte
+		if len(line) > 0 && line[0] == '"' {
+			// closing quote: the first '"' not followed by another '"'
+			j := 1
+			for {
+				k := bytes.IndexByte(line[j:], '"')
+				if k < 0 {
+					j = len(line)
+					break
+				}
+				j += k
+				if j+1 < len(line) && line[j+1] == '"' {
+					j += 2
+					continue
+				}
+				break
+			}
+			f = line[1:j]
+			line = line[min(j+1, len(line)):]
+			// garbage after the closing quote belongs to the field
+			if k := bytes.IndexByte(line, ','); k >= 0 {
+				line = line[k:]
+			} else {
+				line = line[len(line):]
+			}
+		} else {
+			k := bytes.IndexByte(line, ',')
+			if k < 0 {
+				k = len(line)
+			}
+			f, line = line[:k], line[k:]
+		}
+
+		if i == idx {
+			return f, true
+		}
+		if len(line) == 0 {
+			return nil, false
+		}
+		line = line[1:] // ','
+	}
+}
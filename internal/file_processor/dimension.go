@@ -0,0 +1,256 @@
+package file_processor
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+type (
+	// dimension A secondary dimension: records are classified into a few buckets by a field,
+	// every bucket counts its own unique addresses in the same pass.
+	dimension struct {
+		name     string
+		col      string // CSV column(1-based index or header name) or field name of -format
+		index    int    // resolved field, -1 - unresolved
+		buckets  []string
+		classify func(f []byte) int // bucket of a field value
+		sets     []*ipv4_bitset.Bitset
+	}
+	// DimensionCount Unique addresses per bucket of a dimension.
+	DimensionCount struct {
+		Name    string
+		Buckets []BucketCount
+	}
+	BucketCount struct {
+		Value  string
+		Unique uint64
+	}
+	// DimensionMatrix Buckets of all dimensions against each other, labelled "dimension=bucket".
+	DimensionMatrix struct {
+		Cells []MatrixCell
+		// Unions addresses of any bucket per dimension
+		Unions map[string]uint64
+	}
+	// MatrixCell Unique addresses seen in both buckets(not necessarily in the same record),
+	// a bucket against itself is its own unique count.
+	MatrixCell struct {
+		Row, Col string
+		Unique   uint64
+	}
+)
+
+// accessLogFields Field names of the access log formats, usable as dimension columns.
+var accessLogFields = map[string][]string{
+	"clf":      {"host", "ident", "user", "time", "request", "status", "bytes"},
+	"combined": {"host", "ident", "user", "time", "request", "status", "bytes", "referer", "agent"},
+}
+
+// statusClasses Buckets of the status dimension.
+var statusClasses = []string{"1xx", "2xx", "3xx", "4xx", "5xx", "other"}
+
+// WithStatusDimension Counts unique addresses per HTTP status class(2xx, 5xx etc.) of col:
+// a CSV column(1-based index or header name) or the status field of -format clf/combined.
+func WithStatusDimension(col string) Option {
+	return func(fp *FileProcessor) {
+		if col != "" {
+			fp.dims = append(fp.dims, newDimension("status", col, statusClasses, statusClass))
+		}
+	}
+}
+
+func newDimension(name, col string, buckets []string, classify func(f []byte) int) *dimension {
+	d := &dimension{name: name, col: col, index: -1, buckets: buckets, classify: classify}
+	for range buckets {
+		d.sets = append(d.sets, ipv4_bitset.New())
+	}
+
+	return d
+}
+
+// statusClass "5xx" for 500-599 etc., anything but a three digit status is "other".
+func statusClass(f []byte) int {
+	f = bytes.TrimSpace(f)
+	if len(f) != 3 || f[0] < '1' || f[0] > '5' || !isDigit(f[1]) || !isDigit(f[2]) {
+		return len(statusClasses) - 1
+	}
+
+	return int(f[0] - '1')
+}
+
+// FormatFields Field names of a format usable as a dimension column, nil - none.
+func FormatFields(format string) []string { return accessLogFields[format] }
+
+// bindDimensions Resolves the dimension columns given by index or by a format field name,
+// CSV header names are resolved with the header.
+func (fp *FileProcessor) bindDimensions() {
+	for _, d := range fp.dims {
+		if fields, ok := accessLogFields[fp.format]; ok {
+			d.index = slices.Index(fields, d.col)
+			continue
+		}
+		if i, err := strconv.Atoi(d.col); err == nil && i > 0 {
+			d.index = i - 1
+		}
+	}
+}
+
+// dimsByName Reports whether a dimension column is a CSV header name.
+func (fp *FileProcessor) dimsByName() bool {
+	if !fp.csv {
+		return false
+	}
+	for _, d := range fp.dims {
+		if _, err := strconv.Atoi(d.col); err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setDimensionHeader Resolves the dimension columns named in the CSV header row.
+func (fp *FileProcessor) setDimensionHeader(header []byte) {
+	for _, d := range fp.dims {
+		if _, err := strconv.Atoi(d.col); err == nil {
+			continue
+		}
+		d.index = -1
+		for i := 0; ; i++ {
+			f, ok := csvField(header, i)
+			if !ok {
+				break
+			}
+			if string(bytes.TrimSpace(f)) == d.col {
+				d.index = i
+				break
+			}
+		}
+	}
+}
+
+// addDimensions Counts the address in the bucket of every dimension of the record.
+func (fp *FileProcessor) addDimensions(line []byte, u32 uint32) {
+	line = trimCRLF(line)
+	for _, d := range fp.dims {
+		var f []byte
+		switch {
+		case d.index < 0:
+		case fp.csv:
+			f, _ = csvField(line, d.index)
+		default:
+			f = accessLogField(line, d.index)
+		}
+		if s := d.sets[d.classify(f)]; s.SetIfNew(u32) {
+			s.AddUnique(1)
+		}
+	}
+}
+
+// Dimensions Unique addresses per bucket of every configured dimension.
+func (fp *FileProcessor) Dimensions() []DimensionCount {
+	out := make([]DimensionCount, 0, len(fp.dims))
+	for _, d := range fp.dims {
+		dc := DimensionCount{Name: d.name, Buckets: make([]BucketCount, len(d.buckets))}
+		for i, b := range d.buckets {
+			dc.Buckets[i] = BucketCount{Value: b, Unique: d.sets[i].GetUniqueCount()}
+		}
+		out = append(out, dc)
+	}
+
+	return out
+}
+
+// Matrix The full matrix of the dimension buckets and the union of every dimension.
+// Intersections and unions are only exact once every record is counted, for a partial
+// input(stopped early, saturated) only the bucket counts themselves are returned.
+func (fp *FileProcessor) Matrix(partial bool) DimensionMatrix {
+	type bucket struct {
+		label string
+		set   *ipv4_bitset.Bitset
+	}
+	var buckets []bucket
+	for _, d := range fp.dims {
+		for i, b := range d.buckets {
+			buckets = append(buckets, bucket{d.name + "=" + b, d.sets[i]})
+		}
+	}
+
+	var m DimensionMatrix
+	for i, r := range buckets {
+		for j, c := range buckets {
+			switch {
+			case i == j:
+				m.Cells = append(m.Cells, MatrixCell{r.label, c.label, r.set.GetUniqueCount()})
+			case !partial && j < i:
+				// symmetric, counted once
+				m.Cells = append(m.Cells, MatrixCell{r.label, c.label, m.cell(j, i, len(buckets)).Unique})
+			case !partial:
+				m.Cells = append(m.Cells, MatrixCell{r.label, c.label, ipv4_bitset.IntersectionCount(r.set, c.set)})
+			}
+		}
+	}
+	if partial {
+		return m
+	}
+	m.Unions = make(map[string]uint64, len(fp.dims))
+	for _, d := range fp.dims {
+		m.Unions[d.name] = ipv4_bitset.UnionCount(d.sets...)
+	}
+
+	return m
+}
+
+// cell The cell at row i, column j of a full n x n matrix.
+func (m DimensionMatrix) cell(i, j, n int) MatrixCell { return m.Cells[i*n+j] }
+
+// accessLogField The idx-th(0-based) field of a Common/Combined Log Format line,
+// a [time] or a "quoted" field(\" escapes) is one field, returned without its brackets or quotes.
+func accessLogField(line []byte, idx int) []byte {
+	for i := 0; ; i++ {
+		line = bytes.TrimLeft(line, " ")
+		if len(line) == 0 {
+			return nil
+		}
+		var f []byte
+		next := len(line)
+		switch line[0] {
+		case '[':
+			end := bytes.IndexByte(line, ']')
+			if end < 0 {
+				end = len(line)
+			}
+			f, next = line[1:end], min(end+1, len(line))
+		case '"':
+			end := quoteEnd(line)
+			f, next = line[1:end], min(end+1, len(line))
+		default:
+			if end := bytes.IndexByte(line, ' '); end >= 0 {
+				next = end
+			}
+			f = line[:next]
+		}
+		if i == idx {
+			return f
+		}
+		line = line[next:]
+	}
+}
+
+// quoteEnd The index of the quote closing the field starting with a quote, len(line) when there is none.
+func quoteEnd(line []byte) int {
+	for j := 1; j < len(line); {
+		k := bytes.IndexByte(line[j:], '"')
+		if k < 0 {
+			break
+		}
+		if line[j+k-1] != '\\' {
+			return j + k
+		}
+		j += k + 1
+	}
+
+	return len(line)
+}
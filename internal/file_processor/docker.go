@@ -0,0 +1,59 @@
+package file_processor
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+var dockerLogKey = []byte(`"log":`)
+
+// dockerLog The "log" string of a line of the docker json-file logging driver:
+//
+//	{"log":"10.0.0.1 - - [...] \"GET / HTTP/1.1\" 200 12\n","stream":"stdout","time":"..."}
+//
+// without its trailing line break. Nil when the line has no such field.
+func dockerLog(line []byte) []byte {
+	i := bytes.Index(line, dockerLogKey)
+	if i < 0 {
+		return nil
+	}
+	v := bytes.TrimLeft(line[i+len(dockerLogKey):], " \t")
+	if len(v) == 0 || v[0] != '"' {
+		return nil
+	}
+
+	// closing quote, escapes are skipped
+	end := -1
+	for j := 1; j < len(v); j++ {
+		if v[j] == '\\' {
+			j++
+			continue
+		}
+		if v[j] == '"' {
+			end = j
+			break
+		}
+	}
+	if end < 0 {
+		return nil
+	}
+	raw := v[1:end]
+
+	// fast path: the only escape is the line break docker appends
+	for _, eol := range []string{`\r\n`, `\n`} {
+		if s, ok := bytes.CutSuffix(raw, []byte(eol)); ok {
+			raw = s
+			break
+		}
+	}
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return raw
+	}
+
+	var s string
+	if err := json.Unmarshal(v[:end+1], &s); err != nil {
+		return nil
+	}
+
+	return trimCRLF([]byte(s))
+}
@@ -0,0 +1,67 @@
+package file_processor
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// utf16Endianness Byte order of a UTF-16 BOM at the start of b.
+func utf16Endianness(b []byte) (unicode.Endianness, bool) {
+	switch {
+	case bytes.HasPrefix(b, bomUTF16LE):
+		return unicode.LittleEndian, true
+	case bytes.HasPrefix(b, bomUTF16BE):
+		return unicode.BigEndian, true
+	}
+
+	return unicode.BigEndian, false
+}
+
+// isUTF16 Reports whether src starts with a UTF-16 BOM, such inputs are transcoded sequentially.
+func isUTF16(src io.ReaderAt) bool {
+	var b [2]byte
+	if _, err := src.ReadAt(b[:], 0); err != nil {
+		return false
+	}
+	_, ok := utf16Endianness(b[:])
+
+	return ok
+}
+
+// decodeBOM Buffered UTF-8 text of r: a UTF-8 BOM is dropped, UTF-16(Windows exports) is
+// transcoded on the fly, input without a BOM is passed as is.
+func decodeBOM(r io.Reader) *bufio.Reader {
+	br := bufio.NewReaderSize(r, 2<<20)
+	head, _ := br.Peek(len(bomUTF8))
+	if bytes.HasPrefix(head, bomUTF8) {
+		_, _ = br.Discard(len(bomUTF8))
+		return br
+	}
+	e, ok := utf16Endianness(head)
+	if !ok {
+		return br
+	}
+
+	// ExpectBOM consumes the BOM
+	dec := unicode.UTF16(e, unicode.ExpectBOM).NewDecoder()
+
+	return bufio.NewReaderSize(dec.Reader(br), 2<<20)
+}
+
+// trimBOM Drops a UTF-8 BOM from the first line of a randomly accessed input.
+func trimBOM(line []byte) []byte {
+	if len(line) > 0 && line[0] == bomUTF8[0] {
+		return bytes.TrimPrefix(line, bomUTF8)
+	}
+
+	return line
+}
@@ -0,0 +1,200 @@
+package file_processor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+
+	"unique-ip-counter/internal/ipv6_set"
+)
+
+// minSampleRange Bytes of the smallest sampled range, smaller ones would mostly read partial records.
+const minSampleRange = 64 << 10
+
+// Estimate Unique count estimated from a sample of byte ranges by Estimate.
+type Estimate struct {
+	Ranges       int    // sampled byte ranges
+	SampledBytes int64  // bytes of the sampled records
+	InputBytes   int64  // bytes of records in the input(the header excluded)
+	Records      uint64 // IPv4 addresses in the sample
+	IPv6         uint64 // IPv6 addresses in the sample, valid records the estimates leave out
+	Invalid      uint64 // records of the sample that are not an address
+	Distinct     uint64 // distinct addresses in the sample
+	Singletons   uint64 // addresses seen once in the sample(f1)
+	Doubletons   uint64 // addresses seen twice in the sample(f2)
+	Blocks       uint64 // distinct /16 blocks in the sample
+	Total        uint64 // addresses of the input, scaled by the sampled bytes
+	Unique       uint64 // GEE estimate
+	Low, High    uint64 // the unique counts the sample is consistent with
+	Chao1        uint64 // Chao1 estimate, a second opinion within [Low, High]
+	Exact        bool   // the budget covered the input, Unique is its count
+}
+
+// Estimate Reads about budget bytes of src in ranges random byte ranges(one in each of as many equal strata)
+// and estimates the unique count of the input from the addresses found in them: the GEE estimator
+// scales the addresses seen once by sqrt(input/sample), which keeps its ratio error within the best
+// possible for a sample of that size, Low and High are the counts where every address seen once is
+// the only one of its value in the input and where none of them repeats outside the sample.
+// IPv6 records are only counted(IPv6), CIDR and -extract records are not sampled.
+func (fp *FileProcessor) Estimate(ctx context.Context, src io.ReaderAt, size, budget int64, ranges int, rnd *rand.Rand) (Estimate, error) {
+	sub := fp.withSource(src)
+	if err := sub.checkSource(src, size); err != nil {
+		return Estimate{}, err
+	}
+	if err := sub.resolveHeader(io.NewSectionReader(src, 0, size)); err != nil {
+		return Estimate{}, err
+	}
+	body := size - sub.headerLen
+	est := Estimate{InputBytes: body}
+	if body <= 0 {
+		est.Exact = true
+		return est, nil
+	}
+
+	var addrs []uint32
+	if budget >= body {
+		// the whole input, nothing to estimate
+		var err error
+		if addrs, est.SampledBytes, err = sub.sampleRange(ctx, src, sub.headerLen, size, size, addrs, &est); err != nil {
+			return est, err
+		}
+		est.Ranges, est.Exact = 1, true
+	} else {
+		ranges = int(max(min(int64(ranges), budget/minSampleRange), 1))
+		chunk, stratum := budget/int64(ranges), body/int64(ranges)
+		for i := range ranges {
+			start := sub.headerLen + int64(i)*stratum
+			if stratum > chunk {
+				start += rnd.Int64N(stratum - chunk)
+			}
+			var (
+				n   int64
+				err error
+			)
+			if addrs, n, err = sub.sampleRange(ctx, src, start, start+chunk, size, addrs, &est); err != nil {
+				return est, err
+			}
+			est.SampledBytes += n
+		}
+		est.Ranges = ranges
+	}
+	est.estimate(addrs)
+
+	return est, nil
+}
+
+// sampleRange Appends the addresses of the records starting in [start, end) to addrs, the record start
+// is at is skipped unless it is the first one of the input. n - bytes of the read records, the IPv6 and
+// Invalid counts of est grow by the records of the range.
+func (fp *FileProcessor) sampleRange(ctx context.Context, src io.ReaderAt, start, end, size int64, addrs []uint32, est *Estimate) (out []uint32, n int64, err error) {
+	r := fp.newRecordReader(io.NewSectionReader(src, start, size-start))
+	// read reports the bytes of the next record, a record longer than the reader is skipped
+	read := func() (line []byte, k int64, err error) {
+		line, err = r.read()
+		if errors.Is(err, bufio.ErrBufferFull) {
+			_, k, err = r.skip()
+			return nil, k, err
+		}
+		return line, int64(len(line)), err
+	}
+
+	off := start
+	if start > fp.headerLen {
+		// most likely the tail of a record of the previous range
+		_, k, err := read()
+		if err != nil {
+			return addrs, 0, ignoreEOF(err)
+		}
+		off += k
+	}
+	for off < end {
+		if err = ctx.Err(); err != nil {
+			return addrs, n, err
+		}
+		line, k, err := read()
+		if err != nil {
+			return addrs, n, ignoreEOF(err)
+		}
+		off += k
+		n += k
+		if !fp.isLines() {
+			if line = r.trim(line); len(line) == 0 {
+				continue
+			}
+		}
+		line = trimBOM(line)
+		if len(line) == 0 || fp.directive(line) || fp.skip(line) {
+			continue
+		}
+		if line = fp.uncomment(line); line == nil {
+			continue
+		}
+		tok := fp.token(line)
+		if u32, ok := fp.parse(tok); ok {
+			addrs = append(addrs, u32)
+		} else if _, ok = ipv6_set.Parse(tok); ok {
+			est.IPv6++
+		} else {
+			est.Invalid++
+		}
+	}
+
+	return addrs, n, nil
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+// estimate Fills the estimates from the sampled addresses, SampledBytes and InputBytes.
+func (e *Estimate) estimate(addrs []uint32) {
+	slices.Sort(addrs)
+	for i := 0; i < len(addrs); {
+		j := i + 1
+		for j < len(addrs) && addrs[j] == addrs[i] {
+			j++
+		}
+		if e.Distinct == 0 || addrs[i]>>16 != addrs[i-1]>>16 {
+			e.Blocks++
+		}
+		e.Distinct++
+		switch j - i {
+		case 1:
+			e.Singletons++
+		case 2:
+			e.Doubletons++
+		}
+		i = j
+	}
+	e.Records = uint64(len(addrs))
+	if e.Records == 0 || e.SampledBytes == 0 {
+		return
+	}
+
+	scale := 1.0
+	if !e.Exact {
+		scale = max(float64(e.InputBytes)/float64(e.SampledBytes), 1)
+	}
+	total := float64(e.Records) * scale
+	repeated := float64(e.Distinct - e.Singletons)
+	f1, f2 := float64(e.Singletons), float64(e.Doubletons)
+	high := min(repeated+f1*scale, total, 1<<32)
+	clip := func(v float64) uint64 { return uint64(math.Round(min(max(v, float64(e.Distinct)), high))) }
+
+	e.Total = uint64(math.Round(total))
+	e.Low, e.High = e.Distinct, clip(high)
+	e.Unique = clip(repeated + f1*math.Sqrt(scale))
+	if f2 > 0 {
+		e.Chao1 = clip(float64(e.Distinct) + f1*f1/(2*f2))
+	} else {
+		e.Chao1 = clip(float64(e.Distinct) + f1*(f1-1)/2)
+	}
+}
@@ -0,0 +1,88 @@
+package file_processor
+
+import "bytes"
+
+// WithExtractAll Counts every dotted quad found anywhere in the record(or its field/format token)
+// instead of a record holding exactly one address, for unstructured application logs.
+func WithExtractAll(on bool) Option {
+	return func(fp *FileProcessor) { fp.extractAll = on }
+}
+
+// addAll Counts the addresses of tok, reports whether there was any.
+func (fp *FileProcessor) addAll(tok []byte, localUniq *uint64) (bool, error) {
+	found := false
+	for from := 0; ; {
+		start, end := nextIPv4(tok, from)
+		if start < 0 {
+			return found, nil
+		}
+		from = end
+		u32, ok := fp.dotted(tok[start:end])
+		if !ok {
+			// an octet above 255, a leading zero under WithStrictIPv4
+			continue
+		}
+		found = true
+		if err := fp.add(u32, localUniq); err != nil {
+			return found, err
+		}
+	}
+}
+
+// nextIPv4 The span of the next dotted quad candidate of line at or after from, -1 - none.
+// The scan jumps between dots with bytes.IndexByte: text without dots costs one vectorized pass.
+// Candidates inside longer dotted numbers(versions 1.2.3.4.5, v2.10.3.4, OIDs) are rejected.
+func nextIPv4(line []byte, from int) (int, int) {
+	for from < len(line) {
+		k := bytes.IndexByte(line[from:], '.')
+		if k < 0 {
+			return -1, -1
+		}
+		dot := from + k
+		from = dot + 1
+
+		// the first octet ends at this dot
+		start := dot
+		for start > 0 && dot-start < 3 && isDigit(line[start-1]) {
+			start--
+		}
+		if start == dot || (start > 0 && (isWord(line[start-1]) || line[start-1] == '.')) {
+			continue
+		}
+		if end, ok := dottedQuad(line, start); ok {
+			return start, end
+		}
+	}
+
+	return -1, -1
+}
+
+// dottedQuad The end of four dot separated groups of 1-3 digits at i, not continued by a digit or ".digit".
+func dottedQuad(line []byte, i int) (int, bool) {
+	for g := 0; g < 4; g++ {
+		if g > 0 {
+			if i >= len(line) || line[i] != '.' {
+				return 0, false
+			}
+			i++
+		}
+		n := 0
+		for i < len(line) && isDigit(line[i]) && n <= 3 {
+			i++
+			n++
+		}
+		if n == 0 || n > 3 {
+			return 0, false
+		}
+	}
+	if i+1 < len(line) && line[i] == '.' && isDigit(line[i+1]) {
+		return 0, false
+	}
+
+	return i, true
+}
+
+func isDigit(c byte) bool { return c-'0' <= 9 }
+
+// isWord ASCII letter or digit.
+func isWord(c byte) bool { return isDigit(c) || (c|0x20)-'a' <= 'z'-'a' }
@@ -0,0 +1,25 @@
+package file_processor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidLine The first invalid line under WithFailFast.
+var ErrInvalidLine = errors.New("invalid line")
+
+// WithFailFast Aborts processing on the first line that is not an address instead of counting it as invalid.
+// Shards run in parallel, so with th > 1 the reported line is the first one found, not always the earliest.
+func WithFailFast(on bool) Option {
+	return func(fp *FileProcessor) { fp.failFast = on }
+}
+
+// invalidLineError ErrInvalidLine with the byte offset(in the input), the line number("~" - estimated)
+// and the content of the line.
+func invalidLineError(off int64, lineNo string, line []byte) error {
+	if len(line) > maxInvalidSample {
+		line = line[:maxInvalidSample]
+	}
+
+	return fmt.Errorf("%w at byte %d(line %s): %q", ErrInvalidLine, off, lineNo, line)
+}
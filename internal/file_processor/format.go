@@ -0,0 +1,119 @@
+package file_processor
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+
+	"unique-ip-counter/internal/grok"
+)
+
+// formats Extractors of the address from a line(without line break) of a known log format.
+var formats = map[string]func(line []byte) []byte{
+	"plain": func(line []byte) []byte { return line },
+	// %h of the Common Log Format, combined only appends referer and user agent
+	"clf":      leadingField,
+	"combined": leadingField,
+	// squid native access.log: time elapsed client code/status bytes method URL ...
+	"squid": func(line []byte) []byte { return blankField(line, 2) },
+	// W3C extended, the c-ip column is resolved from the #Fields directives
+	"w3c": nil,
+	// IIS logs in the W3C extended format
+	"iis": nil,
+}
+
+// envelopes Formats wrapping the line of an inner format, "envelope,inner"(inner defaults to plain).
+var envelopes = map[string]func(line []byte) []byte{
+	"docker-json": dockerLog,
+}
+
+// WithFormat Extracts the address from lines of a known log format(see Formats), "" - the whole line.
+func WithFormat(name string) Option {
+	return func(fp *FileProcessor) {
+		fp.format = name
+		if isW3C(name) {
+			fp.w3c = true
+			return
+		}
+		if name != "" && name != "plain" {
+			fp.extract, _ = extractor(name)
+		}
+	}
+}
+
+// WithGrok Extracts the address with a compiled grok expression, nil - disabled.
+func WithGrok(g *grok.Grok) Option {
+	return func(fp *FileProcessor) {
+		if g != nil {
+			fp.extract = g.Field
+		}
+	}
+}
+
+// extractor Resolves a format name, an envelope is unwrapped before its inner format is applied.
+func extractor(name string) (func(line []byte) []byte, bool) {
+	outer, inner, _ := strings.Cut(name, ",")
+	unwrap, ok := envelopes[outer]
+	if !ok {
+		fn, ok := formats[name]
+		return fn, ok && fn != nil
+	}
+	if inner == "" {
+		inner = "plain"
+	}
+	fn, ok := formats[inner]
+	if !ok || fn == nil {
+		// header driven formats(w3c) cannot be wrapped
+		return nil, false
+	}
+
+	return func(line []byte) []byte { return fn(unwrap(line)) }, true
+}
+
+// Formats Names accepted by WithFormat.
+func Formats() []string {
+	names := make([]string, 0, len(formats)+len(envelopes))
+	for name := range formats {
+		names = append(names, name)
+	}
+	for name := range envelopes {
+		names = append(names, name+"[,inner]")
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// IsFormat Reports whether name is accepted by WithFormat.
+func IsFormat(name string) bool {
+	if name == "" || isW3C(name) {
+		return true
+	}
+	_, ok := extractor(name)
+
+	return ok
+}
+
+// isW3C Reports whether the format is the header driven W3C extended format.
+func isW3C(name string) bool { return name == "w3c" || name == "iis" }
+
+// leadingField The first space separated field, e.g. the client of access log lines.
+func leadingField(line []byte) []byte { return blankField(line, 0) }
+
+// blankField The idx-th(0-based) field separated by runs of spaces/tabs(aligned columns).
+func blankField(line []byte, idx int) []byte {
+	for i := 0; ; i++ {
+		line = bytes.TrimLeft(line, " \t")
+		k := bytes.IndexAny(line, " \t")
+		if k < 0 {
+			k = len(line)
+		}
+		if i == idx {
+			return line[:k]
+		}
+		if k == len(line) {
+			return nil
+		}
+		line = line[k:]
+	}
+}
@@ -2,24 +2,74 @@ package file_processor
 
 import (
 	"bufio"
-	"bytes"
 	"context"
+	"errors"
 	"io"
 	"os"
+	"sync/atomic"
 
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
+	"unique-ip-counter/internal/chaos"
+	"unique-ip-counter/internal/cpu_dispatch"
 	"unique-ip-counter/internal/ipv4_bitset"
+	"unique-ip-counter/internal/ipv6_set"
 )
 
 type (
 	FileProcessor struct {
 		logger   *zap.Logger
 		file     *os.File
+		src      io.ReaderAt
 		bitset   *ipv4_bitset.Bitset
 		th       int
 		progress *Progress
+
+		stopAfter uint64
+		ceiling   uint64
+		plan      *Plan
+		pcapDst   bool
+		holeBytes int64
+		invalid   *invalidSamples
+		delim     []byte // record separator
+
+		extractAll    bool // WithExtractAll
+		stripPort     bool // WithStripPort
+		trimSpace     bool // WithTrimSpace
+		force         bool // WithForce
+		intAddr       bool // WithIntegerAddresses
+		strict        bool // WithStrictIPv4
+		cidr          string
+		prefixes      *prefixSet // CIDRPrefix
+		v6            *ipv6_set.Set
+		v6Prefixes    *ipv6_set.PrefixSet // WithIPv6Prefix
+		v6Spill       *ipv6_set.Spill     // WithIPv6Spill
+		v6Spilled     uint64              // distinct addresses of v6Spill, set by MergeIPv6Spill
+		skipPrefixes  [][]byte
+		comments      bool           // WithInlineComments
+		invalidOut    *InvalidWriter // WithInvalidOut
+		invalidSource string
+		failFast      bool           // WithFailFast
+		maxInvalidPct float64        // WithMaxInvalidPct
+		maxLine       int            // WithMaxLineBytes
+		resolver      *Resolver      // WithResolver
+		balance       bool           // WithBalancer
+		hosts         *hostnames     // WithResolver: collected hostnames
+		skipped       *atomic.Uint64 // shared by every shard
+
+		parquetCol string
+		csv        bool
+		csvName    string
+		csvIndex   int
+		header     bool                     // WithHeader
+		headerLen  int64                    // bytes of the CSV header row at the start of src
+		srcOff     int64                    // offset of src in the input(ProcessAppended)
+		extract    func(line []byte) []byte // WithFormat
+		format     string
+		dims       []*dimension // secondary dimensions
+		w3c        bool
+		w3cIndex   int
 	}
 	shard struct {
 		Start, End int64
@@ -27,46 +77,207 @@ type (
 	shards []shard
 )
 
+// memory scan routine of shard alignment, see cpu_dispatch
+var (
+	indexByte = cpu_dispatch.NewRegistry[func(b []byte, c byte) int]("memory-scan")
+	scanByte  func(b []byte, c byte) int // the selected indexByte, resolved once
+)
+
+func init() {
+	indexByte.Register("generic", cpu_dispatch.Generic, 0, indexByteGeneric)
+	registerIndexByte()
+	scanByte = indexByte.Get()
+}
+
+// indexByteGeneric portable implementation of the memory scan
+func indexByteGeneric(b []byte, c byte) int {
+	for i, x := range b {
+		if x == c {
+			return i
+		}
+	}
+
+	return -1
+}
+
 func New(
 	logger *zap.Logger,
 	file *os.File,
 	bitset *ipv4_bitset.Bitset,
 	th int,
+	opts ...Option,
 ) *FileProcessor {
-	return &FileProcessor{
+	fp := &FileProcessor{
 		logger:   logger,
 		file:     file,
+		src:      file,
 		bitset:   bitset,
 		th:       th,
 		progress: NewProgress(logger),
+		ceiling:  fullCoverage,
+		invalid:  &invalidSamples{},
+		delim:    []byte{'\n'},
+		skipped:  &atomic.Uint64{},
+		v6:       ipv6_set.New(),
+	}
+	for _, opt := range opts {
+		opt(fp)
 	}
+	fp.bindDimensions()
+
+	return fp
 }
 
 func (fp *FileProcessor) ProcessFile(ctx context.Context, fi os.FileInfo) error {
-	if fi.Size() <= 0 {
+	if isUTF16(fp.src) {
+		fp.logger.Info("UTF-16 input, transcoding sequentially")
+		return fp.ProcessReader(ctx, io.NewSectionReader(fp.src, 0, fi.Size()))
+	}
+	if err := fp.checkSource(fp.src, fi.Size()); err != nil {
+		return err
+	}
+	if err := fp.resolveHeader(io.NewSectionReader(fp.src, 0, fi.Size())); err != nil {
+		return err
+	}
+	if fp.plan == nil {
+		if ok, err := fp.processSparse(ctx, fi.Size()); ok || err != nil {
+			return err
+		}
+		return fp.processSource(ctx, fi.Size())
+	}
+
+	shs, err := fp.planShards(fi.Size())
+	if err != nil {
+		return err
+	}
+	var planned int64
+	for _, s := range shs {
+		planned += s.End - s.Start
+	}
+
+	return fp.processShards(ctx, planned, shs)
+}
+
+// ProcessReaderAt Processes any random access source(remote objects etc.) in parallel shards.
+func (fp *FileProcessor) ProcessReaderAt(ctx context.Context, r io.ReaderAt, size int64) error {
+	sub := fp.withSource(r)
+	if isUTF16(r) {
+		return sub.ProcessReader(ctx, io.NewSectionReader(r, 0, size))
+	}
+	if err := sub.checkSource(r, size); err != nil {
+		return err
+	}
+	if err := sub.resolveHeader(io.NewSectionReader(r, 0, size)); err != nil {
+		return err
+	}
+
+	return sub.processSource(ctx, size)
+}
+
+// ProcessAppended Processes the bytes [off, end) of a growing file in parallel shards,
+// end must be a record start(see LastRecordEnd). The header(CSV, W3C) is resolved on the call with off == 0.
+func (fp *FileProcessor) ProcessAppended(ctx context.Context, off, end int64) error {
+	if off == 0 {
+		if err := fp.resolveHeader(io.NewSectionReader(fp.src, 0, end)); err != nil {
+			return err
+		}
+	}
+
+	sub := fp.withSource(io.NewSectionReader(fp.src, off, end-off))
+	sub.srcOff = fp.srcOff + off
+	if off > 0 {
+		sub.headerLen = 0
+	}
+	err := sub.processSource(ctx, end-off)
+	fp.w3cIndex = sub.w3cIndex
+
+	return err
+}
+
+// ResolveHeader Resolves the header(CSV) from the head of src, for ProcessAppended calls that do not start at 0
+// (a tail processed before the head).
+func (fp *FileProcessor) ResolveHeader(size int64) error {
+	return fp.resolveHeader(io.NewSectionReader(fp.src, 0, size))
+}
+
+// ProcessReader Processes a non seekable stream sequentially by a single goroutine,
+// a UTF-16 stream(BOM) is transcoded to UTF-8.
+func (fp *FileProcessor) ProcessReader(ctx context.Context, r io.Reader) error {
+	return fp.processStream(ctx, r, true)
+}
+
+// processStream ProcessReader, lineBytes - whether the bytes of the lines are the bytes read of progress,
+// false for a decompressed stream whose compressed reader counts them.
+func (fp *FileProcessor) processStream(ctx context.Context, r io.Reader, lineBytes bool) error {
+	br := decodeBOM(r)
+	// the head of the first read only, a slow stream is not waited for
+	head, _ := br.Peek(min(br.Buffered(), headSample))
+	if err := fp.checkText(head); err != nil {
+		return err
+	}
+	// the header is consumed from the stream, it is not an address anyway
+	if err := fp.readHeader(br); err != nil {
+		return err
+	}
+
+	return fp.processReader(ctx, br, fp.headerLen, lineBytes)
+}
+
+// processSource Splits fp.src into aligned shards and processes them in parallel.
+func (fp *FileProcessor) processSource(ctx context.Context, size int64) error {
+	if size <= 0 {
 		return nil
 	}
-	defer fp.progress.Run(fi.Size())()
 
-	shs, err := fp.splitToShards(fi.Size(), fp.th)
+	shs, err := fp.splitToShards(ctx, size, fp.th)
 	if err != nil {
 		return err
 	}
 
+	return fp.processShards(ctx, size, shs)
+}
+
+// processShards Processes shs of fp.src in parallel, size is the total bytes for progress.
+func (fp *FileProcessor) processShards(ctx context.Context, size int64, shs shards) error {
+	if size <= 0 {
+		return nil
+	}
+	var starts []int
+	if fp.w3c {
+		var err error
+		if starts, err = fp.w3cStarts(ctx, shs); err != nil {
+			return err
+		}
+	}
+	defer fp.progress.Run(size)()
+
 	g, ctx := errgroup.WithContext(ctx)
-	for _, s := range shs {
+	last := fp
+	for i, s := range shs {
+		if s.Start == 0 && fp.headerLen > 0 {
+			// only the first shard holds the header row
+			s.Start = min(fp.headerLen, s.End)
+		}
+		sub := fp
+		if fp.w3c {
+			// every shard follows the #Fields directives on its own copy
+			cp := *fp
+			cp.w3cIndex = starts[i]
+			sub = &cp
+		}
+		last = sub
 		g.Go(func() error {
-			return fp.processShard(ctx, fp.file, s)
+			return sub.processShard(ctx, fp.src, s)
 		})
 	}
-	if err = g.Wait(); err != nil {
-		return err
-	}
+	err := g.Wait()
+	// the column in effect at the end, appended data continues with it
+	fp.w3cIndex = last.w3cIndex
 
-	return nil
+	return err
 }
 
-func (fp *FileProcessor) splitToShards(size int64, n int) (shards, error) {
+func (fp *FileProcessor) splitToShards(ctx context.Context, size int64, n int) (shards, error) {
 	if size <= 0 {
 		return nil, nil
 	}
@@ -89,7 +300,7 @@ func (fp *FileProcessor) splitToShards(size int64, n int) (shards, error) {
 
 		cur := shard{Start: start, End: end}
 		if i > 0 {
-			aligned, err := fp.moveStartToNewline(cur)
+			aligned, err := fp.moveStartToNewline(ctx, cur)
 			if err != nil {
 				return nil, err
 			}
@@ -102,85 +313,260 @@ func (fp *FileProcessor) splitToShards(size int64, n int) (shards, error) {
 	return shs, nil
 }
 
-// moveStartToNewline Moves the start of the shard forward to the first line break character "\n"
-// to avoid possible start from the middle of the line.
-func (fp *FileProcessor) moveStartToNewline(s shard) (shard, error) {
+// moveStartToNewline Moves the start of the shard forward past the first record terminator("\n" or the delimiter)
+// to avoid possible start from the middle of the line. A line may be gigabytes long, ctx is checked while scanning.
+func (fp *FileProcessor) moveStartToNewline(ctx context.Context, s shard) (shard, error) {
 	if s.Start == 0 {
 		return s, nil
 	}
 
 	buf := make([]byte, 64<<10) // 64KB scan size
+	cp := newCheckpoint(ctx)
 	off := s.Start
 	for {
+		if err := cp.spend(len(buf)); err != nil {
+			return s, err
+		}
 		if off >= s.End {
 			return shard{Start: s.End, End: s.End}, nil
 		}
-		n, err := fp.file.ReadAt(buf, off)
+		n, err := fp.src.ReadAt(buf, off)
 		if n == 0 && err != nil {
 			return s, err
 		}
-		idx := bytes.IndexByte(buf[:n], '\n')
-		if idx >= 0 {
-			return shard{Start: off + int64(idx) + 1, End: s.End}, nil
+		if end := fp.recordEnd(buf[:n]); end >= 0 {
+			start, err := fp.takenEnd(off + int64(end))
+			return shard{Start: start, End: s.End}, err
+		}
+		if n < len(buf) {
+			return shard{Start: s.End, End: s.End}, nil
 		}
-		off += int64(n)
+		// a multi-byte delimiter may span the reads
+		off += int64(n - len(fp.delim) + 1)
 	}
 }
 
-func (fp *FileProcessor) processShard(ctx context.Context, f *os.File, s shard) error {
-	r := bufio.NewReaderSize(io.NewSectionReader(f, s.Start, s.End-s.Start), 2<<20) // 2MB
+func (fp *FileProcessor) processShard(ctx context.Context, src io.ReaderAt, s shard) error {
+	if err := chaos.SlowShard(ctx); err != nil {
+		return err
+	}
+
+	return fp.processReader(ctx, io.NewSectionReader(src, s.Start, s.End-s.Start), fp.srcOff+s.Start, true)
+}
+
+// processReader Reads lines sequentially from rd and feeds them into the bitset, rd starts at off of the input.
+// lineBytes - see processStream.
+func (fp *FileProcessor) processReader(ctx context.Context, rd io.Reader, off int64, lineBytes bool) error {
+	r := fp.newRecordReader(chaos.Reader(rd))
 
 	// progress
 	var (
-		local     int64
-		localUniq uint64
+		local        int64
+		localRecords int64
+		localUniq    uint64
+		localInvalid uint64
+		localLines   uint64
+		lines        = fp.newLineNumbers(off)
 	)
 	const flushEvery = int64(256 << 10) // 256 KB
 	flushProgress := func() {
 		if fp.progress != nil && local != 0 {
-			fp.progress.Add(local)
-			local = 0
+			if lineBytes {
+				fp.progress.AddBytes(local)
+			}
+			fp.progress.AddRecords(localRecords)
+			local, localRecords = 0, 0
 		}
 	}
 	defer func() {
 		if localUniq > 0 {
 			fp.bitset.AddUnique(localUniq)
 		}
+		fp.invalid.add(localInvalid)
+		fp.invalid.read.Add(localLines)
 		flushProgress()
 	}()
 
+	// reject Counts the line at lineOff as invalid, or fails on it under WithFailFast
+	reject := func(lineOff int64, line []byte) error {
+		if fp.failFast {
+			return invalidLineError(lineOff, lines.String(off), line)
+		}
+		localInvalid++
+		fp.invalid.observe(line, localInvalid)
+		if fp.invalidOut != nil {
+			fp.invalidOut.write(fp.invalidSource, lineOff, &lines, off, line)
+		}
+		return nil
+	}
+
+	fast := fp.fastLines()
 	for {
 		// gracefully stop if parent send cancel signal
 		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		line, err := r.ReadSlice('\n')
+		line, err := r.read()
 		if err == io.EOF {
 			return nil
 		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// a record longer than the buffer(a corrupt or binary blob) is an invalid line, not a failed shard
+			head, n, err := r.skip()
+			if err != nil && err != io.EOF {
+				return err
+			}
+			lineOff := off
+			off += n
+			lines.n++
+			local += n
+			localLines++
+			localRecords++
+			if rerr := reject(lineOff, r.trim(head)); rerr != nil {
+				return rerr
+			}
+			if err == io.EOF {
+				return nil
+			}
+			continue
+		}
 		if err != nil {
 			return err
 		}
+		lineOff := off
+		off += int64(len(line))
+		lines.n++
+		// progress, the separators too
+		local += int64(len(line))
+		if !fp.isLines() {
+			line = r.trim(line)
+			if len(line) == 0 {
+				// empty field between separators, e.g. a trailing ','
+				continue
+			}
+		}
 
 		if len(line) > 0 {
-			// progress
-			local += int64(len(line))
+			localLines++
+			localRecords++
 			if local >= flushEvery {
 				flushProgress()
+
+				// publish uniques to detect saturation
+				fp.bitset.AddUnique(localUniq)
+				fp.invalid.add(localInvalid)
+				fp.invalid.read.Add(localLines)
+				localUniq, localInvalid, localLines = 0, 0, 0
+				if fp.saturated() {
+					return ErrSaturated
+				}
+				if err = fp.invalid.checkRatio(fp.maxInvalidPct, minRatioLines); err != nil {
+					return err
+				}
+			}
+
+			if fast {
+				// the line without its '\n', anything the line parser rejects takes the general path below
+				if u32, ok := fp.bitset.IPv4LineToUint32(line[:len(line)-1]); ok {
+					if err = fp.add(u32, &localUniq); err != nil {
+						return err
+					}
+					continue
+				}
 			}
 
-			if ipUint32, ok := fp.bitset.IPv4ByteToUint32(trimCRLF(line)); ok {
-				if fp.bitset.SetIfNew(ipUint32) {
-					localUniq++
+			line = trimBOM(line)
+			if fp.directive(line) || fp.skip(line) {
+				continue
+			}
+			if line = fp.uncomment(line); line == nil {
+				continue
+			}
+			if fp.extractAll {
+				found, err := fp.addAll(fp.token(line), &localUniq)
+				if err != nil {
+					return err
+				}
+				if !found {
+					fp.skipped.Add(1)
+				}
+				continue
+			}
+			tok := fp.token(line)
+			ipUint32, ok := fp.parse(tok)
+			if !ok {
+				if ok, err = fp.addIPv6(tok, &localUniq); err != nil {
+					return err
+				}
+				if !ok {
+					if ok, err = fp.addCIDR(tok, &localUniq); err != nil {
+						return err
+					}
+				}
+				if !ok {
+					ok = fp.addHostname(tok)
+				}
+				if !ok {
+					if err = reject(lineOff, trimCRLF(line)); err != nil {
+						return err
+					}
 				}
+				continue
 			}
+			if len(fp.dims) > 0 {
+				fp.addDimensions(line, ipUint32)
+			}
+			if err = fp.add(ipUint32, &localUniq); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fastLines Reports whether a plain line is the address itself, so a line the line parser accepts
+// needs none of the trimming, field or prefix handling of processReader.
+func (fp *FileProcessor) fastLines() bool {
+	return fp.isLines() && !fp.csv && fp.extract == nil && !fp.w3c && !fp.extractAll && !fp.stripPort && !fp.strict &&
+		len(fp.skipPrefixes) == 0 && !fp.comments && len(fp.dims) == 0
+}
+
+// add Sets an address, a new one is counted.
+func (fp *FileProcessor) add(u32 uint32, localUniq *uint64) error {
+	if !fp.bitset.SetIfNew(u32) {
+		return nil
+	}
+
+	return fp.count(1, localUniq)
+}
+
+// count Counts n new uniques in localUniq, or publishes them at once under a unique limit.
+func (fp *FileProcessor) count(n uint64, localUniq *uint64) error {
+	if fp.stopAfter > 0 {
+		// publish immediately so the limit is seen by every shard
+		fp.bitset.AddUnique(n)
+		if fp.bitset.GetUniqueCount() >= fp.stopAfter {
+			return ErrUniqueLimit
 		}
+		return nil
 	}
+	*localUniq += n
+
+	return nil
 }
 
-func (fp *FileProcessor) GetFile() *os.File   { return fp.file }
-func (fp *FileProcessor) UniqueCount() uint64 { return fp.bitset.GetUniqueCount() }
+func (fp *FileProcessor) GetFile() *os.File { return fp.file }
+
+// UniqueCount Exact uniques plus the estimate of the addresses sketched after the bitset was degraded.
+func (fp *FileProcessor) UniqueCount() uint64 {
+	n, _ := fp.bitset.ApproxCount()
+	return fp.bitset.GetUniqueCount() + n
+}
+
+func (fp *FileProcessor) Bitset() *ipv4_bitset.Bitset { return fp.bitset }
+
+// HoleBytes Bytes of sparse file holes skipped by ProcessFile.
+func (fp *FileProcessor) HoleBytes() int64 { return fp.holeBytes }
 
 func trimCRLF(b []byte) []byte {
 	for n := len(b); n > 0; n-- {
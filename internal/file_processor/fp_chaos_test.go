@@ -0,0 +1,79 @@
+//go:build chaos
+
+package file_processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/chaos"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// not parallel: the fault rates are process wide, parallel tests of the package wait for these to finish
+func Test_ProcessFile_ChaosReadError(t *testing.T) {
+	t.Cleanup(func() { chaos.Configure(chaos.Config{}) })
+	var data bytes.Buffer
+	for i := range 100000 {
+		fmt.Fprintf(&data, "10.%d.%d.%d\n", i>>16&0xff, i>>8&0xff, i&0xff)
+	}
+	f := mustTempFile(t, "ips.txt", data.Bytes())
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	chaos.Configure(chaos.Config{ReadError: 1})
+	fp := New(zap.NewNop(), f, ipv4_bitset.New(), 4)
+	if err := fp.ProcessFile(context.Background(), fi); !errors.Is(err, chaos.ErrInjected) {
+		t.Fatalf("ProcessFile err=%v; want %v", err, chaos.ErrInjected)
+	}
+
+	// the same seed injects the same faults
+	counts := make([]uint64, 2)
+	for i := range counts {
+		chaos.Configure(chaos.Config{ReadError: 0.5, Seed: 3})
+		fp = New(zap.NewNop(), nil, ipv4_bitset.New(), 1)
+		_ = fp.ProcessReader(context.Background(), bytes.NewReader(data.Bytes()))
+		counts[i] = fp.UniqueCount()
+	}
+	if counts[0] != counts[1] {
+		t.Fatalf("unique %d, %d; want the same for the same seed", counts[0], counts[1])
+	}
+
+	chaos.Configure(chaos.Config{})
+	fp = New(zap.NewNop(), f, ipv4_bitset.New(), 4)
+	if err := fp.ProcessFile(context.Background(), fi); err != nil || fp.UniqueCount() != 100000 {
+		t.Fatalf("ProcessFile err=%v unique=%d; want 100000", err, fp.UniqueCount())
+	}
+}
+
+func Test_ProcessFile_ChaosSlowShard(t *testing.T) {
+	t.Cleanup(func() { chaos.Configure(chaos.Config{}) })
+	f := mustTempFile(t, "ips.txt", []byte("1.1.1.1\n2.2.2.2\n3.3.3.3\n4.4.4.4\n"))
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	// a stalled shard still stops on cancellation
+	chaos.Configure(chaos.Config{SlowShard: 1, Delay: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	fp := New(zap.NewNop(), f, ipv4_bitset.New(), 2)
+	start := time.Now()
+	if err := fp.ProcessFile(ctx, fi); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ProcessFile err=%v; want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("cancellation took %v", time.Since(start))
+	}
+
+	chaos.Configure(chaos.Config{SlowShard: 1, Delay: 10 * time.Millisecond})
+	fp = New(zap.NewNop(), f, ipv4_bitset.New(), 2)
+	if err := fp.ProcessFile(context.Background(), fi); err != nil || fp.UniqueCount() != 4 {
+		t.Fatalf("ProcessFile err=%v unique=%d; want 4", err, fp.UniqueCount())
+	}
+}
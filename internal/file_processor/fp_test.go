@@ -1,17 +1,34 @@
 package file_processor
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"go.uber.org/zap"
 
+	"unique-ip-counter/internal/grok"
 	"unique-ip-counter/internal/ipv4_bitset"
+	"unique-ip-counter/internal/ipv6_set"
 )
 
 func mustTempFile(t *testing.T, name string, data []byte) *os.File {
@@ -73,7 +90,7 @@ func Test_splitToShards_Alignment(t *testing.T) {
 	fp := New(logger, f, ipv4_bitset.New(), 3)
 	size := fileSize(t, f)
 
-	shs, err := fp.splitToShards(size, 3)
+	shs, err := fp.splitToShards(context.Background(), size, 3)
 	if err != nil {
 		t.Fatalf("splitToShards error: %v", err)
 	}
@@ -119,7 +136,7 @@ func Test_splitToShards_SmallFiles(t *testing.T) {
 	fp := New(logger, f, ipv4_bitset.New(), 100)
 	size := fileSize(t, f)
 
-	shs, err := fp.splitToShards(size, 100)
+	shs, err := fp.splitToShards(context.Background(), size, 100)
 	if err != nil {
 		t.Fatalf("splitToShards error: %v", err)
 	}
@@ -141,7 +158,7 @@ func Test_moveStartToNewline(t *testing.T) {
 
 	start := int64(bytes.Index(data, []byte("BBBBB"))) + 2
 	s := shard{Start: start, End: int64(len(data))}
-	got, err := fp.moveStartToNewline(s)
+	got, err := fp.moveStartToNewline(context.Background(), s)
 	if err != nil {
 		t.Fatalf("moveStartToNewline error: %v", err)
 	}
@@ -269,7 +286,7 @@ func Test_splitToShards_ZeroSize(t *testing.T) {
 	defer f.Close()
 
 	fp := New(logger, f, ipv4_bitset.New(), 4)
-	shs, err := fp.splitToShards(0, 4)
+	shs, err := fp.splitToShards(context.Background(), 0, 4)
 	if err != nil {
 		t.Fatalf("splitToShards err: %v", err)
 	}
@@ -287,7 +304,7 @@ func Test_moveStartToNewline_OffBeyondEnd(t *testing.T) {
 
 	fp := New(logger, f, ipv4_bitset.New(), 1)
 	end := int64(len(data))
-	got, err := fp.moveStartToNewline(shard{Start: end, End: end})
+	got, err := fp.moveStartToNewline(context.Background(), shard{Start: end, End: end})
 	if err != nil {
 		t.Fatalf("moveStartToNewline err: %v", err)
 	}
@@ -314,3 +331,2297 @@ func Test_processShard_CRWithoutLFAtEOF(t *testing.T) {
 		t.Fatalf("UniqueCount=%d; want 0", fp.UniqueCount())
 	}
 }
+
+func Test_ProcessZip_StoredAndDeflated(t *testing.T) {
+	logger := zap.NewNop()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	entries := []struct {
+		name   string
+		method uint16
+		data   string
+	}{
+		{"stored.txt", zip.Store, "1.1.1.1\n2.2.2.2\n1.1.1.1\n"},
+		{"deflated.txt", zip.Deflate, "2.2.2.2\n3.3.3.3\nbad\n"},
+	}
+	for _, e := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatalf("create zip entry: %v", err)
+		}
+		if _, err = w.Write([]byte(e.data)); err != nil {
+			t.Fatalf("write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+
+	f := mustTempFile(t, "ips.zip", buf.Bytes())
+	defer f.Close()
+
+	fp := New(logger, f, ipv4_bitset.New(), 4)
+	fi, _ := f.Stat()
+	if err := fp.ProcessZip(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessZip error: %v", err)
+	}
+	if got := fp.UniqueCount(); got != 3 {
+		t.Fatalf("UniqueCount=%d; want 3", got)
+	}
+}
+
+func Test_ProcessFile_StopAfterUniques(t *testing.T) {
+	logger := zap.NewNop()
+
+	var buf bytes.Buffer
+	for i := 0; i < 200; i++ {
+		buf.WriteString(fmt.Sprintf("10.0.%d.%d\n", i/256, i%256))
+	}
+	f := mustTempFile(t, "limit.txt", buf.Bytes())
+	defer f.Close()
+
+	fp := New(logger, f, ipv4_bitset.New(), 1, WithStopAfterUniques(50))
+	fi, _ := f.Stat()
+	if err := fp.ProcessFile(context.Background(), fi); !errors.Is(err, ErrUniqueLimit) {
+		t.Fatalf("ProcessFile error=%v; want ErrUniqueLimit", err)
+	}
+	if got := fp.UniqueCount(); got != 50 {
+		t.Fatalf("UniqueCount=%d; want 50", got)
+	}
+}
+
+func Test_ProcessFile_SaturationCeiling(t *testing.T) {
+	logger := zap.NewNop()
+
+	// > 256KB so saturation is checked before EOF
+	var buf bytes.Buffer
+	for i := 0; i < 40000; i++ {
+		buf.WriteString(fmt.Sprintf("10.%d.%d.%d\n", i>>16, (i>>8)&0xFF, i&0xFF))
+	}
+	f := mustTempFile(t, "saturated.txt", buf.Bytes())
+	defer f.Close()
+
+	fp := New(logger, f, ipv4_bitset.New(), 1, WithSaturationCeiling(100))
+	fi, _ := f.Stat()
+	if err := fp.ProcessFile(context.Background(), fi); !errors.Is(err, ErrSaturated) {
+		t.Fatalf("ProcessFile error=%v; want ErrSaturated", err)
+	}
+	if got := fp.UniqueCount(); got < 100 || got >= 40000 {
+		t.Fatalf("UniqueCount=%d; want stop between ceiling and full read", got)
+	}
+}
+
+func Test_ProcessFile_Plan(t *testing.T) {
+	logger := zap.NewNop()
+
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		buf.WriteString(fmt.Sprintf("10.0.%d.%d\n", i/256, i%256))
+	}
+	f := mustTempFile(t, "plan.txt", buf.Bytes())
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	plan, err := New(logger, f, ipv4_bitset.New(), 4).Plan(context.Background(), f.Name(), fi)
+	if err != nil {
+		t.Fatalf("Plan error: %v", err)
+	}
+	if len(plan.Shards) != 4 {
+		t.Fatalf("shards=%d; want 4", len(plan.Shards))
+	}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err = WritePlan(path, plan); err != nil {
+		t.Fatalf("WritePlan error: %v", err)
+	}
+	plan, err = ReadPlan(path)
+	if err != nil {
+		t.Fatalf("ReadPlan error: %v", err)
+	}
+
+	// every shard on its own "machine" sums to the full count
+	var total uint64
+	for _, r := range plan.Shards {
+		part := *plan
+		part.Shards = []Range{r}
+		fp := New(logger, f, ipv4_bitset.New(), 1, WithPlan(&part))
+		if err = fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("ProcessFile error: %v", err)
+		}
+		total += fp.UniqueCount()
+	}
+	if total != 1000 {
+		t.Fatalf("total=%d; want 1000", total)
+	}
+
+	bad := []Plan{
+		{Size: fi.Size() + 1, Shards: plan.Shards},
+		{Size: fi.Size(), Shards: []Range{{Start: 3, End: 20}}},
+		{Size: fi.Size(), Shards: []Range{{Start: 0, End: 20}, {Start: 0, End: 10}}},
+		{Size: fi.Size(), Shards: []Range{{Start: 0, End: fi.Size() + 1}}},
+	}
+	for i := range bad {
+		fp := New(logger, f, ipv4_bitset.New(), 1, WithPlan(&bad[i]))
+		if err = fp.ProcessFile(context.Background(), fi); !errors.Is(err, ErrBadPlan) {
+			t.Fatalf("bad plan %d: error=%v; want ErrBadPlan", i, err)
+		}
+	}
+}
+
+// ethIPv4 Ethernet frame(optionally 802.1Q tagged) with a minimal IPv4 header.
+func ethIPv4(src, dst [4]byte, vlan bool) []byte {
+	frame := make([]byte, 12, 64)
+	if vlan {
+		frame = append(frame, 0x81, 0x00, 0x00, 0x07)
+	}
+	frame = append(frame, 0x08, 0x00)
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	copy(ip[12:], src[:])
+	copy(ip[16:], dst[:])
+
+	return append(frame, ip...)
+}
+
+func Test_ProcessPcap(t *testing.T) {
+	logger := zap.NewNop()
+
+	pkts := [][]byte{
+		ethIPv4([4]byte{10, 0, 0, 1}, [4]byte{8, 8, 8, 8}, false),
+		ethIPv4([4]byte{10, 0, 0, 2}, [4]byte{8, 8, 8, 8}, true),
+		ethIPv4([4]byte{10, 0, 0, 1}, [4]byte{1, 1, 1, 1}, false),
+		append(make([]byte, 12), 0x86, 0xdd, 0x60), // IPv6, skipped
+	}
+
+	le := binary.LittleEndian
+	var pcap bytes.Buffer
+	hdr := make([]byte, 24)
+	le.PutUint32(hdr, pcapMagicMicro)
+	le.PutUint16(hdr[4:], 2)
+	le.PutUint16(hdr[6:], 4)
+	le.PutUint32(hdr[16:], 65535)
+	le.PutUint32(hdr[20:], linkEthernet)
+	pcap.Write(hdr)
+	for _, p := range pkts {
+		rec := make([]byte, 16)
+		le.PutUint32(rec[8:], uint32(len(p)))
+		le.PutUint32(rec[12:], uint32(len(p)))
+		pcap.Write(rec)
+		pcap.Write(p)
+	}
+
+	block := func(buf *bytes.Buffer, typ uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		total := uint32(12 + len(body))
+		b := le.AppendUint32(nil, typ)
+		b = le.AppendUint32(b, total)
+		b = append(b, body...)
+		buf.Write(le.AppendUint32(b, total))
+	}
+	var pcapng bytes.Buffer
+	block(&pcapng, pcapngSHB, []byte{0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	block(&pcapng, 1, []byte{linkEthernet, 0, 0, 0, 0xff, 0xff, 0, 0})
+	for _, p := range pkts {
+		body := make([]byte, 20, 20+len(p))
+		le.PutUint32(body[12:], uint32(len(p)))
+		le.PutUint32(body[16:], uint32(len(p)))
+		block(&pcapng, 6, append(body, p...))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		dst  bool
+		want uint64
+	}{
+		{"pcap src", pcap.Bytes(), false, 2},
+		{"pcap dst", pcap.Bytes(), true, 2},
+		{"pcapng src", pcapng.Bytes(), false, 2},
+		{"pcapng dst", pcapng.Bytes(), true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := mustTempFile(t, "capture", tt.data)
+			defer f.Close()
+			fi, _ := f.Stat()
+
+			bs := ipv4_bitset.New()
+			fp := New(logger, f, bs, 1, WithPcapDestination(tt.dst))
+			if err := fp.ProcessPcap(context.Background(), fi); err != nil {
+				t.Fatalf("ProcessPcap error: %v", err)
+			}
+			if got := fp.UniqueCount(); got != tt.want {
+				t.Fatalf("UniqueCount=%d; want %d", got, tt.want)
+			}
+			probe := uint32(10<<24 | 1)
+			if tt.dst {
+				probe = 8<<24 | 8<<16 | 8<<8 | 8
+			}
+			if bs.SetIfNew(probe) {
+				t.Fatalf("address %08x was not counted", probe)
+			}
+		})
+	}
+
+	// packets take the counting path of lines: the unique limit stops both formats
+	for name, data := range map[string][]byte{"pcap": pcap.Bytes(), "pcapng": pcapng.Bytes()} {
+		f := mustTempFile(t, "capture", data)
+		fi, _ := f.Stat()
+		fp := New(logger, f, ipv4_bitset.New(), 1, WithStopAfterUniques(1))
+		if err := fp.ProcessPcap(context.Background(), fi); !errors.Is(err, ErrUniqueLimit) {
+			t.Fatalf("%s: error=%v; want ErrUniqueLimit", name, err)
+		}
+		_ = f.Close()
+	}
+
+	f := mustTempFile(t, "broken.pcap", pcap.Bytes()[:len(pcap.Bytes())-3])
+	defer f.Close()
+	fi, _ := f.Stat()
+	if err := New(logger, f, ipv4_bitset.New(), 1).ProcessPcap(context.Background(), fi); !errors.Is(err, ErrBadPcap) {
+		t.Fatalf("truncated capture error=%v; want ErrBadPcap", err)
+	}
+}
+
+func Test_ProcessFile_Sparse(t *testing.T) {
+	logger := zap.NewNop()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "sparse.log"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer f.Close()
+	// lines touching a hole contain NULs and are invalid in a dense read too
+	writes := []struct {
+		off  int64
+		data string
+	}{
+		{0, "1.1.1.1\n2.2.2.2\n9.9.9.9"},
+		{1 << 20, "4.4.4.4\n5.5.5.5\n6.6.6.6\n"},
+		{2 << 20, "\n7.7.7.7\n"},
+	}
+	for _, w := range writes {
+		if _, err = f.WriteAt([]byte(w.data), w.off); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	// holes stay below the 2MB line buffer for the dense read
+	if err = f.Truncate(3 << 20); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	fi, _ := f.Stat()
+
+	dense := New(logger, nil, ipv4_bitset.New(), 1)
+	if err = dense.ProcessReader(context.Background(), io.NewSectionReader(f, 0, fi.Size())); err != nil {
+		t.Fatalf("ProcessReader error: %v", err)
+	}
+
+	fp := New(logger, f, ipv4_bitset.New(), 4)
+	if err = fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile error: %v", err)
+	}
+	if got, want := fp.UniqueCount(), dense.UniqueCount(); got != want || want != 5 {
+		t.Fatalf("UniqueCount=%d, dense=%d; want 5", got, want)
+	}
+	if fp.HoleBytes() == 0 {
+		t.Logf("filesystem does not report holes")
+	}
+}
+
+func Test_csvField(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		line string
+		idx  int
+		want string
+		ok   bool
+	}{
+		{"a,1.2.3.4,c", 1, "1.2.3.4", true},
+		{"a,1.2.3.4,c", 0, "a", true},
+		{"a,1.2.3.4,c", 2, "c", true},
+		{"a,1.2.3.4,c", 3, "", false},
+		{`"x, y",1.2.3.4`, 1, "1.2.3.4", true},
+		{`"x, y",1.2.3.4`, 0, "x, y", true},
+		{`a,"1.2.3.4"`, 1, "1.2.3.4", true},
+		{`"say ""hi"", ok",5.6.7.8`, 1, "5.6.7.8", true},
+		{"a,,c", 1, "", true},
+		{`"unterminated,1.2.3.4`, 1, "", false},
+		{`"unterminated`, 0, "unterminated", true},
+		// RFC 4180: escaped quotes around separators, empty and trailing quoted fields
+		{`"Mozilla/5.0 ""compatible, bot"", x",9.9.9.9,z`, 1, "9.9.9.9", true},
+		{`"",1.2.3.4`, 1, "1.2.3.4", true},
+		{`"",1.2.3.4`, 0, "", true},
+		{`a,"1.2.3.4"`, 2, "", false},
+		{`a,b,`, 2, "", true},
+		{`"x"y,1.2.3.4`, 1, "1.2.3.4", true},
+		// a quote inside an unquoted field is a literal
+		{`a"b,1.2.3.4`, 0, `a"b`, true},
+		{`"""",1.2.3.4`, 1, "1.2.3.4", true},
+	}
+	for _, tt := range cases {
+		got, ok := csvField([]byte(tt.line), tt.idx)
+		if ok != tt.ok || string(got) != tt.want {
+			t.Fatalf("csvField(%q, %d) = %q, %v; want %q, %v", tt.line, tt.idx, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func Test_csvField_NoAllocs(t *testing.T) {
+	line := []byte(`1700000000,"GET /a?q=1,2 HTTP/1.1","Mozilla/5.0 (X11; Linux x86_64) ""quoted, part""",203.0.113.9,200`)
+	if allocs := testing.AllocsPerRun(100, func() { _, _ = csvField(line, 3) }); allocs != 0 {
+		t.Fatalf("csvField allocs=%v; want 0", allocs)
+	}
+}
+
+func Test_ProcessFile_CSVColumn(t *testing.T) {
+	logger := zap.NewNop()
+
+	data := []byte("ts,\"agent, version\",client_ip\r\n" +
+		"1,\"curl, 8\",1.1.1.1\r\n" +
+		"2,x,2.2.2.2\r\n" +
+		"3,\"a,b,c\",1.1.1.1\r\n" +
+		"4,y,\"3.3.3.3\"\r\n" +
+		"5,z\r\n")
+
+	// the header row is skipped by name and with -header, an index without it counts the header as invalid
+	for _, tt := range []struct {
+		col     string
+		header  bool
+		invalid uint64
+	}{
+		{col: "client_ip", invalid: 1},
+		{col: "3", header: true, invalid: 1},
+		{col: "3", invalid: 2},
+	} {
+		f := mustTempFile(t, "rows.csv", data)
+		fi, _ := f.Stat()
+		fp := New(logger, f, ipv4_bitset.New(), 3, WithCSVColumn(tt.col), WithHeader(tt.header))
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("ProcessFile(%s) error: %v", tt.col, err)
+		}
+		if got := fp.UniqueCount(); got != 3 {
+			t.Fatalf("UniqueCount(%s)=%d; want 3", tt.col, got)
+		}
+		if got := fp.InvalidCount(); got != tt.invalid {
+			t.Fatalf("InvalidCount(%s, header=%v)=%d; want %d", tt.col, tt.header, got, tt.invalid)
+		}
+
+		// streamed inputs resolve the header too
+		stream := New(logger, nil, ipv4_bitset.New(), 1, WithCSVColumn(tt.col), WithHeader(tt.header))
+		if err := stream.ProcessReader(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatalf("ProcessReader(%s) error: %v", tt.col, err)
+		}
+		if got := stream.UniqueCount(); got != 3 || stream.InvalidCount() != tt.invalid {
+			t.Fatalf("streamed UniqueCount(%s)=%d invalid=%d; want 3, %d", tt.col, got, stream.InvalidCount(), tt.invalid)
+		}
+		_ = f.Close()
+	}
+
+	f := mustTempFile(t, "rows.csv", data)
+	defer f.Close()
+	fi, _ := f.Stat()
+	if err := New(logger, f, ipv4_bitset.New(), 1, WithCSVColumn("nope")).ProcessFile(context.Background(), fi); err == nil {
+		t.Fatalf("expected error for a missing column")
+	}
+}
+
+func Test_ProcessFile_InvalidCountPublished(t *testing.T) {
+	t.Parallel()
+	// several MB per shard: the shards publish their invalid counts while reading and at their end
+	var buf bytes.Buffer
+	for i := range 400000 {
+		fmt.Fprintf(&buf, "10.%d.%d.%d\n", i>>16&0xff, i>>8&0xff, i&0xff)
+		if i%4 == 0 {
+			buf.WriteString("garbage\n")
+		}
+	}
+	f := mustTempFile(t, "mixed.txt", buf.Bytes())
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	for _, th := range []int{1, 4, 16} {
+		fp := New(zap.NewNop(), f, ipv4_bitset.New(), th, WithInvalidSamples(3))
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("ProcessFile: %v", err)
+		}
+		if fp.UniqueCount() != 400000 || fp.InvalidCount() != 100000 {
+			t.Fatalf("th=%d unique=%d invalid=%d; want 400000, 100000", th, fp.UniqueCount(), fp.InvalidCount())
+		}
+		if got := fp.InvalidSamples(); len(got) != 1 || got[0] != "garbage" {
+			t.Fatalf("th=%d InvalidSamples=%q; want [garbage]", th, got)
+		}
+	}
+}
+
+func Test_ProcessFile_InvalidOut(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	want := make(map[string]int) // row without the line number -> line number
+	for i := range 200000 {
+		if i%1000 == 7 {
+			want[fmt.Sprintf("in.txt\t%d\tbad %d", buf.Len(), i)] = i + 1
+			fmt.Fprintf(&buf, "bad %d\r\n", i)
+			continue
+		}
+		fmt.Fprintf(&buf, "10.%d.%d.%d\n", i>>16&0xff, i>>8&0xff, i&0xff)
+	}
+	f := mustTempFile(t, "in.txt", buf.Bytes())
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	var out bytes.Buffer
+	w := NewInvalidWriter(&out)
+	fp := New(zap.NewNop(), f, ipv4_bitset.New(), 8, WithInvalidOut(w, "in.txt"))
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(got) != len(want) || fp.InvalidCount() != uint64(len(want)) {
+		t.Fatalf("%d invalid lines written, %d counted; want %d", len(got), fp.InvalidCount(), len(want))
+	}
+	var exact int
+	for _, l := range got {
+		// the first shard knows its line numbers, the others estimate them from their own line lengths
+		// (shorter at the start of this input)
+		f := strings.SplitN(l, "\t", 4)
+		no, ok := want[f[0]+"\t"+f[1]+"\t"+f[3]]
+		if !ok {
+			t.Fatalf("unexpected invalid line %q", l)
+		}
+		got, err := strconv.Atoi(strings.TrimPrefix(f[2], "~"))
+		if !strings.HasPrefix(f[2], "~") {
+			exact++
+			if got != no {
+				t.Fatalf("line %q: number %d; want %d", l, got, no)
+			}
+		}
+		if err != nil || got < no*85/100 || got > no*115/100 {
+			t.Fatalf("line %q: number %s; want ~%d", l, f[2], no)
+		}
+	}
+	if exact == 0 || exact == len(got) {
+		t.Fatalf("%d of %d line numbers exact; want the first shard only", exact, len(got))
+	}
+
+	// streams: offsets count the header, multi-line records are quoted
+	out.Reset()
+	fp = New(zap.NewNop(), nil, ipv4_bitset.New(), 1, WithCSVColumn("ip"), WithHeader(true), WithInvalidOut(w, "-"))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("ip,n\n1.1.1.1,1\nx,2\n")); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	fp = New(zap.NewNop(), nil, ipv4_bitset.New(), 1, WithDelimiter("\n\n"), WithInvalidOut(w, "-"))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("1.1.1.1\n\nbad\nrecord\n\n")); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	_ = w.Flush()
+	if want := "-\t15\t3\tx,2\n-\t9\t2\t\"bad\\nrecord\"\n"; out.String() != want {
+		t.Fatalf("invalid out %q; want %q", out.String(), want)
+	}
+}
+
+func Test_ProcessFile_InvalidSamples(t *testing.T) {
+	logger := zap.NewNop()
+
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		buf.WriteString(fmt.Sprintf("10.0.%d.%d\n", i/256, i%256))
+		if i%10 == 0 {
+			buf.WriteString(fmt.Sprintf("bad-%d\r\n", i%30))
+		}
+	}
+	buf.WriteString(string(bytes.Repeat([]byte("x"), 1000)) + "\n")
+	f := mustTempFile(t, "invalid.txt", buf.Bytes())
+	defer f.Close()
+
+	fp := New(logger, f, ipv4_bitset.New(), 4, WithInvalidSamples(5))
+	fi, _ := f.Stat()
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if got := fp.UniqueCount(); got != 1000 {
+		t.Fatalf("UniqueCount=%d; want 1000", got)
+	}
+	if got := fp.InvalidCount(); got != 101 {
+		t.Fatalf("InvalidCount=%d; want 101", got)
+	}
+
+	samples := fp.InvalidSamples()
+	if len(samples) == 0 || len(samples) > 5 {
+		t.Fatalf("InvalidSamples=%q; want 1..5 samples", samples)
+	}
+	seen := make(map[string]bool)
+	for _, s := range samples {
+		if seen[s] {
+			t.Fatalf("InvalidSamples=%q; want distinct samples", samples)
+		}
+		seen[s] = true
+		if s != "bad-0" && s != "bad-10" && s != "bad-20" && s != strings.Repeat("x", maxInvalidSample) {
+			t.Fatalf("unexpected sample %q", s)
+		}
+	}
+
+	// disabled: counted, not kept
+	f2 := mustTempFile(t, "invalid.txt", buf.Bytes())
+	defer f2.Close()
+	fp = New(logger, f2, ipv4_bitset.New(), 1)
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if fp.InvalidCount() != 101 || len(fp.InvalidSamples()) != 0 {
+		t.Fatalf("InvalidCount=%d InvalidSamples=%q; want 101 and none", fp.InvalidCount(), fp.InvalidSamples())
+	}
+}
+
+func Test_ProcessFile_Format(t *testing.T) {
+	logger := zap.NewNop()
+	data := []byte(`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+10.0.0.2 - - [10/Oct/2000:13:55:37 -0700] "GET / HTTP/1.1" 200 12 "http://example.com/" "Mozilla/5.0 (X11)"
+127.0.0.1 - - [10/Oct/2000:13:55:38 -0700] "GET /a HTTP/1.1" 404 0 "-" "curl/8.0"
+host.example.com - - [10/Oct/2000:13:55:39 -0700] "GET / HTTP/1.1" 200 1
+`)
+	g, err := grok.Builtin().Compile("%{COMMONAPACHELOG}", "")
+	if err != nil {
+		t.Fatalf("grok: %v", err)
+	}
+	for format, opt := range map[string]Option{
+		"clf":                     WithFormat("clf"),
+		"combined":                WithFormat("combined"),
+		"grok %{COMMONAPACHELOG}": WithGrok(g),
+	} {
+		f := mustTempFile(t, "access.log", data)
+		defer f.Close()
+
+		fp := New(logger, f, ipv4_bitset.New(), 2, opt)
+		fi, _ := f.Stat()
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("%s: ProcessFile: %v", format, err)
+		}
+		if got := fp.UniqueCount(); got != 2 {
+			t.Fatalf("%s: UniqueCount=%d; want 2", format, got)
+		}
+		if got := fp.InvalidCount(); got != 1 {
+			t.Fatalf("%s: InvalidCount=%d; want 1(unresolved host)", format, got)
+		}
+	}
+}
+
+func Test_Convert_ProcessBinary(t *testing.T) {
+	logger := zap.NewNop()
+
+	var text bytes.Buffer
+	for i := 0; i < 5000; i++ {
+		text.WriteString(fmt.Sprintf("10.%d.%d.%d - - [10/Oct/2000:13:55:36 -0700] \"GET / HTTP/1.1\" 200 1\n", i%7, (i>>8)&0xFF, i&0xFF))
+	}
+	text.WriteString("garbage\n")
+	src := mustTempFile(t, "access.log", text.Bytes())
+	defer src.Close()
+	direct := New(logger, src, ipv4_bitset.New(), 3, WithFormat("combined"))
+	fi, _ := src.Stat()
+	if err := direct.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+
+	conv := New(logger, src, ipv4_bitset.New(), 1, WithFormat("combined"))
+	var bin bytes.Buffer
+	n, err := conv.Convert(context.Background(), bytes.NewReader(text.Bytes()), &bin, OutBinaryBE32)
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if n != 5000 || bin.Len() != 5000*4 || conv.InvalidCount() != 1 {
+		t.Fatalf("Convert n=%d bytes=%d invalid=%d; want 5000, 20000, 1", n, bin.Len(), conv.InvalidCount())
+	}
+	if got := binary.BigEndian.Uint32(bin.Bytes()); got != 10<<24 {
+		t.Fatalf("first record=%08x; want 0a000000", got)
+	}
+
+	for _, th := range []int{1, 3, 8} {
+		f := mustTempFile(t, "ips.bin", bin.Bytes())
+		defer f.Close()
+		fp := New(logger, f, ipv4_bitset.New(), th)
+		fi, _ := f.Stat()
+		if err = fp.ProcessBinary(context.Background(), fi); err != nil {
+			t.Fatalf("th=%d: ProcessBinary: %v", th, err)
+		}
+		if fp.UniqueCount() != direct.UniqueCount() {
+			t.Fatalf("th=%d: UniqueCount=%d; want %d", th, fp.UniqueCount(), direct.UniqueCount())
+		}
+	}
+
+	var out bytes.Buffer
+	if _, err = New(logger, nil, ipv4_bitset.New(), 1).Convert(context.Background(), strings.NewReader("1.2.3.4\nx\n"), &out, OutText); err != nil || out.String() != "1.2.3.4\n" {
+		t.Fatalf("Convert(text)=%q, %v; want \"1.2.3.4\\n\"", out.String(), err)
+	}
+
+	f := mustTempFile(t, "bad.bin", []byte{1, 2, 3})
+	defer f.Close()
+	fi, _ = f.Stat()
+	if err = New(logger, f, ipv4_bitset.New(), 1).ProcessBinary(context.Background(), fi); !errors.Is(err, ErrBadBinary) {
+		t.Fatalf("ProcessBinary(3 bytes) err=%v; want ErrBadBinary", err)
+	}
+}
+
+func Test_ProcessFile_W3C(t *testing.T) {
+	logger := zap.NewNop()
+	data := []byte("#Software: Microsoft Internet Information Services 10.0\r\n" +
+		"#Version: 1.0\r\n" +
+		"#Date: 2024-01-01 00:00:00\r\n" +
+		"#Fields: date time s-ip cs-method cs-uri-stem s-port c-ip cs(User-Agent) sc-status\r\n" +
+		"2024-01-01 00:00:01 10.0.0.1 GET / 443 203.0.113.7 Mozilla/5.0+(Windows) 200\r\n" +
+		"2024-01-01 00:00:02 10.0.0.1 GET /a 443 198.51.100.2 curl/8.0 404\r\n" +
+		"#Date: 2024-01-01 12:00:00\r\n" +
+		"2024-01-01 12:00:03 10.0.0.1 GET / 443 203.0.113.7 - 200\r\n" +
+		"2024-01-01 12:00:04 10.0.0.1 GET / 443 - - 200\r\n")
+
+	f := mustTempFile(t, "u_ex240101.log", data)
+	defer f.Close()
+	fp := New(logger, f, ipv4_bitset.New(), 3, WithFormat("w3c"))
+	fi, _ := f.Stat()
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if got := fp.UniqueCount(); got != 2 {
+		t.Fatalf("UniqueCount=%d; want 2", got)
+	}
+	if got := fp.InvalidCount(); got != 1 {
+		t.Fatalf("InvalidCount=%d; want 1(directives are not records)", got)
+	}
+
+	// streamed
+	fp = New(logger, nil, ipv4_bitset.New(), 1, WithFormat("w3c"))
+	if err := fp.ProcessReader(context.Background(), bytes.NewReader(data)); err != nil || fp.UniqueCount() != 2 {
+		t.Fatalf("ProcessReader err=%v unique=%d; want 2", err, fp.UniqueCount())
+	}
+
+	// no c-ip field
+	fp = New(logger, nil, ipv4_bitset.New(), 1, WithFormat("w3c"))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("#Fields: date time s-ip\n2024-01-01 00:00:01 10.0.0.1\n")); err == nil {
+		t.Fatalf("ProcessReader without c-ip: want error")
+	}
+}
+
+func Test_ProcessFile_W3C_FieldsBlocks(t *testing.T) {
+	logger := zap.NewNop()
+
+	// a restart changes the columns: the server address takes the old c-ip column, then c-ip is dropped
+	var buf bytes.Buffer
+	buf.WriteString("#Version: 1.0\r\n#Fields: date time s-ip cs-method cs-uri-stem s-port c-ip sc-status\r\n")
+	for i := 0; i < 40000; i++ {
+		fmt.Fprintf(&buf, "2024-01-01 00:00:01 10.9.9.9 GET /index.html 443 10.1.%d.%d 200\r\n", i>>8, i&0xFF)
+	}
+	buf.WriteString("#Software: Microsoft Internet Information Services 10.0\r\n#Fields: date time c-ip cs-method cs-uri-stem s-port s-ip sc-status\r\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "2024-01-01 00:00:02 10.2.%d.%d GET / 443 10.9.9.8 200\r\n", i>>8, i&0xFF)
+	}
+	buf.WriteString("#Fields: date time s-ip sc-status\r\n")
+	for i := 0; i < 500; i++ {
+		buf.WriteString("2024-01-01 00:00:03 10.9.9.7 200\r\n")
+	}
+	data := buf.Bytes()
+
+	for _, th := range []int{1, 2, 3, 8, 64} {
+		f := mustTempFile(t, "u_ex240101.log", data)
+		defer f.Close()
+		fp := New(logger, f, ipv4_bitset.New(), th, WithFormat("iis"))
+		fi, _ := f.Stat()
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("th=%d: ProcessFile: %v", th, err)
+		}
+		if got := fp.UniqueCount(); got != 41000 {
+			t.Fatalf("th=%d: UniqueCount=%d; want 41000", th, got)
+		}
+		if got := fp.InvalidCount(); got != 500 {
+			t.Fatalf("th=%d: InvalidCount=%d; want 500(records of the block without c-ip)", th, got)
+		}
+	}
+
+	fp := New(logger, nil, ipv4_bitset.New(), 1, WithFormat("w3c"))
+	if err := fp.ProcessReader(context.Background(), bytes.NewReader(data)); err != nil || fp.UniqueCount() != 41000 {
+		t.Fatalf("ProcessReader err=%v unique=%d; want 41000", err, fp.UniqueCount())
+	}
+}
+
+func Test_blankField(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		line string
+		idx  int
+		want string
+	}{
+		{"1286536309.450    170 192.168.0.68 TCP_MISS/200 507 GET http://x/ - DIRECT/1.2.3.4 text/html", 2, "192.168.0.68"},
+		{"1286536309.586 1245 10.0.0.9 TCP_TUNNEL/200 3042 CONNECT x:443 - HIER_DIRECT/1.2.3.4 -", 2, "10.0.0.9"},
+		{"  1.2.3.4\t- -", 0, "1.2.3.4"},
+		{"a b", 2, ""},
+		{"", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := blankField([]byte(tt.line), tt.idx); string(got) != tt.want {
+			t.Fatalf("blankField(%q, %d)=%q; want %q", tt.line, tt.idx, got, tt.want)
+		}
+	}
+}
+
+func Test_dockerLog(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		line, want string
+	}{
+		{`{"log":"10.0.0.1\n","stream":"stdout","time":"2024-01-01T00:00:00Z"}`, "10.0.0.1"},
+		{`{"log":"10.0.0.1 - - [01/Jan/2024:00:00:00 +0000] \"GET / HTTP/1.1\" 200 1\r\n","stream":"stdout"}`, `10.0.0.1 - - [01/Jan/2024:00:00:00 +0000] "GET / HTTP/1.1" 200 1`},
+		{`{"log": "1.2.3.4\n"}`, "1.2.3.4"},
+		{`{"stream":"stdout"}`, ""},
+		{`{"log":"unterminated`, ""},
+		{`not json`, ""},
+	}
+	for _, tt := range tests {
+		if got := dockerLog([]byte(tt.line)); string(got) != tt.want {
+			t.Fatalf("dockerLog(%q)=%q; want %q", tt.line, got, tt.want)
+		}
+	}
+
+	for _, name := range []string{"docker-json", "docker-json,combined", "docker-json,plain"} {
+		if !IsFormat(name) {
+			t.Fatalf("IsFormat(%q)=false", name)
+		}
+	}
+	for _, name := range []string{"docker-json,w3c", "docker-json,nope", "json"} {
+		if IsFormat(name) {
+			t.Fatalf("IsFormat(%q)=true", name)
+		}
+	}
+}
+
+func Test_ProcessFile_DockerJSON(t *testing.T) {
+	logger := zap.NewNop()
+	data := []byte(`{"log":"10.0.0.1 - - [01/Jan/2024:00:00:00 +0000] \"GET / HTTP/1.1\" 200 1\n","stream":"stdout","time":"2024-01-01T00:00:00.1Z"}
+{"log":"10.0.0.2 - - [01/Jan/2024:00:00:01 +0000] \"GET /a HTTP/1.1\" 404 0\n","stream":"stdout","time":"2024-01-01T00:00:01.1Z"}
+{"log":"2024/01/01 00:00:02 [error] upstream timed out\n","stream":"stderr","time":"2024-01-01T00:00:02.1Z"}
+{"log":"10.0.0.1 - - [01/Jan/2024:00:00:03 +0000] \"GET / HTTP/1.1\" 200 1\n","stream":"stdout","time":"2024-01-01T00:00:03.1Z"}
+`)
+	f := mustTempFile(t, "abc-json.log", data)
+	defer f.Close()
+
+	fp := New(logger, f, ipv4_bitset.New(), 2, WithFormat("docker-json,combined"))
+	fi, _ := f.Stat()
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if fp.UniqueCount() != 2 || fp.InvalidCount() != 1 {
+		t.Fatalf("UniqueCount=%d InvalidCount=%d; want 2 and 1", fp.UniqueCount(), fp.InvalidCount())
+	}
+}
+
+// seekableZstd Compresses data as one frame per cut(byte offsets, lines may span frames) plus a seek table.
+func seekableZstd(t *testing.T, data []byte, cuts []int, checksum bool) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("zstd writer: %v", err)
+	}
+	defer enc.Close()
+
+	var out, table []byte
+	prev := 0
+	for _, c := range append(cuts, len(data)) {
+		frame := enc.EncodeAll(data[prev:c], nil)
+		out = append(out, frame...)
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(frame)))
+		table = binary.LittleEndian.AppendUint32(table, uint32(c-prev))
+		if checksum {
+			table = binary.LittleEndian.AppendUint32(table, 0)
+		}
+		prev = c
+	}
+	desc := byte(0)
+	if checksum {
+		desc = 0x80
+	}
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(cuts)+1))
+	table = append(table, desc)
+	table = binary.LittleEndian.AppendUint32(table, seekableMagic)
+
+	out = binary.LittleEndian.AppendUint32(out, skippableMagic)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(table)))
+
+	return append(out, table...)
+}
+
+func Test_ProcessZstd_Seekable(t *testing.T) {
+	logger := zap.NewNop()
+
+	var buf bytes.Buffer
+	for i := 0; i < 3000; i++ {
+		buf.WriteString(fmt.Sprintf("10.%d.%d.%d\n", i%3, (i>>8)&0xFF, i&0xFF))
+	}
+	data := buf.Bytes()
+	// frames end mid-line, exactly after a '\n' and right before one
+	nl := bytes.IndexByte(data[5000:], '\n') + 5000
+	cuts := []int{3, 1000, 1001, nl, nl + 1, 20000, 20007}
+
+	for _, tt := range []struct {
+		name string
+		file []byte
+	}{
+		{"seekable", seekableZstd(t, data, cuts, false)},
+		{"seekable checksums", seekableZstd(t, data, cuts, true)},
+		{"single frame", seekableZstd(t, data, nil, false)},
+	} {
+		for _, th := range []int{1, 3, 8} {
+			f := mustTempFile(t, "ips.zst", tt.file)
+			defer f.Close()
+			fp := New(logger, f, ipv4_bitset.New(), th)
+			fi, _ := f.Stat()
+			if err := fp.ProcessZstd(context.Background(), fi); err != nil {
+				t.Fatalf("%s th=%d: ProcessZstd: %v", tt.name, th, err)
+			}
+			if got := fp.UniqueCount(); got != 3000 {
+				t.Fatalf("%s th=%d: UniqueCount=%d; want 3000", tt.name, th, got)
+			}
+			if got := fp.InvalidCount(); got != 0 {
+				t.Fatalf("%s th=%d: InvalidCount=%d; want 0(lines split by frames)", tt.name, th, got)
+			}
+		}
+	}
+
+	// a plain zstd stream is decompressed sequentially
+	enc, _ := zstd.NewWriter(nil)
+	f := mustTempFile(t, "plain.zst", enc.EncodeAll(data, nil))
+	defer f.Close()
+	fp := New(logger, f, ipv4_bitset.New(), 4)
+	fi, _ := f.Stat()
+	if err := fp.ProcessZstd(context.Background(), fi); err != nil || fp.UniqueCount() != 3000 {
+		t.Fatalf("plain zstd: err=%v unique=%d; want 3000", err, fp.UniqueCount())
+	}
+}
+
+// bgzf Compresses data as one BGZF block per cut plus the empty EOF block.
+func bgzf(t *testing.T, data []byte, cuts []int) []byte {
+	t.Helper()
+	var out []byte
+	prev := 0
+	for _, c := range append(cuts, len(data), len(data)) {
+		var blk bytes.Buffer
+		zw := gzip.NewWriter(&blk)
+		zw.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+		_, _ = zw.Write(data[prev:c])
+		if err := zw.Close(); err != nil {
+			t.Fatalf("gzip: %v", err)
+		}
+		b := blk.Bytes()
+		binary.LittleEndian.PutUint16(b[16:], uint16(len(b)-1))
+		out = append(out, b...)
+		prev = c
+	}
+
+	return out
+}
+
+func Test_ProcessGzip_BGZF(t *testing.T) {
+	logger := zap.NewNop()
+
+	var buf bytes.Buffer
+	buf.WriteString("client_ip,ts\n")
+	for i := 0; i < 3000; i++ {
+		buf.WriteString(fmt.Sprintf("10.%d.%d.%d,1700000000\n", i%3, (i>>8)&0xFF, i&0xFF))
+	}
+	data := buf.Bytes()
+	cuts := []int{7, 1000, 1001, 20000, 20013, 40000}
+
+	for _, th := range []int{1, 3, 8} {
+		f := mustTempFile(t, "ips.csv.bgz", bgzf(t, data, cuts))
+		defer f.Close()
+		fp := New(logger, f, ipv4_bitset.New(), th, WithCSVColumn("client_ip"))
+		fi, _ := f.Stat()
+		if err := fp.ProcessGzip(context.Background(), fi); err != nil {
+			t.Fatalf("th=%d: ProcessGzip: %v", th, err)
+		}
+		if got := fp.UniqueCount(); got != 3000 {
+			t.Fatalf("th=%d: UniqueCount=%d; want 3000", th, got)
+		}
+		if got := fp.InvalidCount(); got != 0 {
+			t.Fatalf("th=%d: InvalidCount=%d; want 0(the header is skipped)", th, got)
+		}
+	}
+
+	// plain gzip is decompressed sequentially
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+	f := mustTempFile(t, "ips.csv.gz", gz.Bytes())
+	defer f.Close()
+	fp := New(logger, f, ipv4_bitset.New(), 4, WithCSVColumn("1"))
+	fi, _ := f.Stat()
+	if err := fp.ProcessGzip(context.Background(), fi); err != nil || fp.UniqueCount() != 3000 {
+		t.Fatalf("plain gzip: err=%v unique=%d; want 3000", err, fp.UniqueCount())
+	}
+}
+
+func Test_ProcessFile_PlainLines(t *testing.T) {
+	t.Parallel()
+	// CRLF, BOM and padded lines of a plain input, lines the line parser rejects must count as before
+	text := "1.1.1.1\n1.1.1.1\r\n2.2.2.2\r\r\n\xEF\xBB\xBF3.3.3.3\n" +
+		"bad\n4.4.4.4 \n\n3232235777\n10.0.0.0/30\n"
+	cases := []struct {
+		name            string
+		opts            []Option
+		unique, invalid uint64
+	}{
+		{"plain", nil, 3, 5},
+		{"int-addr", []Option{WithIntegerAddresses(true)}, 4, 4},
+		{"cidr", []Option{WithCIDR(CIDRRange)}, 7, 4},
+		{"skip-prefix", []Option{WithSkipPrefixes([]string{"1."})}, 2, 5},
+	}
+	for _, tt := range cases {
+		f := mustTempFile(t, "ips.txt", []byte(text))
+		fi, _ := f.Stat()
+		fp := New(zap.NewNop(), f, ipv4_bitset.New(), 2, tt.opts...)
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("%s: ProcessFile: %v", tt.name, err)
+		}
+		_ = f.Close()
+		if fp.UniqueCount() != tt.unique || fp.InvalidCount() != tt.invalid {
+			t.Fatalf("%s: unique=%d invalid=%d; want %d, %d", tt.name, fp.UniqueCount(), fp.InvalidCount(), tt.unique, tt.invalid)
+		}
+	}
+}
+
+func Test_ProcessFile_IPv6(t *testing.T) {
+	t.Parallel()
+	text := "1.1.1.1\n2001:db8::1\n2001:DB8:0:0:0:0:0:1\r\n::ffff:1.1.1.1\n::ffff:2.2.2.2\nfe80::1%eth0\n" +
+		"fe80::1\n2001:db8::zz\n::1\n::ffff:01.2.3.4\n"
+	f := mustTempFile(t, "dual.txt", []byte(text))
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	// the family is detected per line
+	fp := New(zap.NewNop(), f, ipv4_bitset.New(), 3)
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	// 1.1.1.1, 2.2.2.2 + 2001:db8::1, fe80::1, ::1
+	if fp.UniqueCount() != 5 || fp.IPv4Count() != 2 || fp.IPv6Count() != 3 || fp.InvalidCount() != 2 {
+		t.Fatalf("unique=%d ipv4=%d ipv6=%d invalid=%d; want 5, 2, 3, 2",
+			fp.UniqueCount(), fp.IPv4Count(), fp.IPv6Count(), fp.InvalidCount())
+	}
+	if fp.Bitset().GetUniqueCount() != 5 {
+		t.Fatalf("bitset unique=%d; want 5", fp.Bitset().GetUniqueCount())
+	}
+	if n, bits := fp.IPv6PrefixCount(); n != 0 || bits != 0 {
+		t.Fatalf("prefixes=%d/%d; want none without WithIPv6Prefix", n, bits)
+	}
+
+	// 2001:db8::/64, fe80::/64, ::/64
+	fp = New(zap.NewNop(), f, ipv4_bitset.New(), 3, WithIPv6Prefix(64))
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if n, bits := fp.IPv6PrefixCount(); n != 3 || bits != 64 || fp.UniqueCount() != 5 {
+		t.Fatalf("prefixes=%d/%d unique=%d; want 3 /64s and 5", n, bits, fp.UniqueCount())
+	}
+
+	// spilled to runs, counted once merged
+	fp = New(zap.NewNop(), f, ipv4_bitset.New(), 3, WithIPv6Spill(ipv6_set.NewSpill(t.TempDir(), 1)))
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if fp.UniqueCount() != 2 || fp.IPv6Count() != 0 {
+		t.Fatalf("unique=%d ipv6=%d before the merge; want the ipv4 addresses only", fp.UniqueCount(), fp.IPv6Count())
+	}
+	if err := fp.MergeIPv6Spill(); err != nil {
+		t.Fatalf("MergeIPv6Spill: %v", err)
+	}
+	if fp.UniqueCount() != 5 || fp.IPv4Count() != 2 || fp.IPv6Count() != 3 {
+		t.Fatalf("unique=%d ipv4=%d ipv6=%d; want 5, 2, 3", fp.UniqueCount(), fp.IPv4Count(), fp.IPv6Count())
+	}
+
+	// an unbracketed IPv6 keeps its last group, a bracketed one loses the port
+	fp = New(zap.NewNop(), nil, ipv4_bitset.New(), 1, WithStripPort(true))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("[2001:db8::1]:443\n2001:db8::1\n2001:db8::1:443\n")); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	if fp.IPv6Count() != 2 {
+		t.Fatalf("ipv6=%d; want 2", fp.IPv6Count())
+	}
+}
+
+func Test_ProcessFile_IPv6Saturation(t *testing.T) {
+	t.Parallel()
+	// an IPv4-only input keeps the default ceiling, a dual-stack one lifts it
+	fp := New(zap.NewNop(), nil, ipv4_bitset.New(), 1)
+	if fp.saturated() {
+		t.Fatalf("saturated on an empty set")
+	}
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("2001:db8::1\n")); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	fp.bitset.AddUnique(fullCoverage)
+	if fp.saturated() {
+		t.Fatalf("saturated after an IPv6 address")
+	}
+}
+
+func Test_ProcessFile_BOM(t *testing.T) {
+	logger := zap.NewNop()
+
+	text := "client_ip,ts\r\n10.0.0.1,1\r\n10.0.0.2,2\r\n10.0.0.1,3\r\n192.168.0.7,4\r\n"
+	utf16 := func(order binary.AppendByteOrder, bom []byte) []byte {
+		b := append([]byte(nil), bom...)
+		for _, r := range text {
+			b = order.AppendUint16(b, uint16(r))
+		}
+		return b
+	}
+	inputs := map[string][]byte{
+		"utf-8":     []byte(text),
+		"utf-8 bom": append([]byte{0xEF, 0xBB, 0xBF}, text...),
+		"utf-16le":  utf16(binary.LittleEndian, []byte{0xFF, 0xFE}),
+		"utf-16be":  utf16(binary.BigEndian, []byte{0xFE, 0xFF}),
+	}
+
+	for name, data := range inputs {
+		for _, col := range []string{"client_ip", "1"} {
+			f := mustTempFile(t, "ips.csv", data)
+			defer f.Close()
+			fp := New(logger, f, ipv4_bitset.New(), 3, WithCSVColumn(col))
+			fi, _ := f.Stat()
+			if err := fp.ProcessFile(context.Background(), fi); err != nil {
+				t.Fatalf("%s col=%s: ProcessFile: %v", name, col, err)
+			}
+			if got := fp.UniqueCount(); got != 3 {
+				t.Fatalf("%s col=%s: UniqueCount=%d; want 3", name, col, got)
+			}
+
+			fp = New(logger, nil, ipv4_bitset.New(), 1, WithCSVColumn(col))
+			if err := fp.ProcessReader(context.Background(), bytes.NewReader(data)); err != nil || fp.UniqueCount() != 3 {
+				t.Fatalf("%s col=%s: ProcessReader err=%v unique=%d; want 3", name, col, err, fp.UniqueCount())
+			}
+		}
+	}
+}
+
+func Test_ProcessFile_NULDelimiter(t *testing.T) {
+	logger := zap.NewNop()
+
+	var buf bytes.Buffer
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&buf, "10.%d.%d.%d\x00", i%2, (i>>8)&0xFF, i&0xFF)
+	}
+	buf.WriteString("not an address\x00192.168.1.1\r\n\x00")
+	data := buf.Bytes()
+
+	for _, th := range []int{1, 3, 16} {
+		f := mustTempFile(t, "ips.nul", data)
+		defer f.Close()
+		fp := New(logger, f, ipv4_bitset.New(), th, WithDelimiter("\x00"))
+		fi, _ := f.Stat()
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("th=%d: ProcessFile: %v", th, err)
+		}
+		if got := fp.UniqueCount(); got != 5001 {
+			t.Fatalf("th=%d: UniqueCount=%d; want 5001", th, got)
+		}
+		if got := fp.InvalidCount(); got != 1 {
+			t.Fatalf("th=%d: InvalidCount=%d; want 1", th, got)
+		}
+	}
+
+	fp := New(logger, nil, ipv4_bitset.New(), 1, WithDelimiter("\x00"), WithCSVColumn("ip"))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("ip,n\x0010.0.0.1,1\x0010.0.0.2,2\x00")); err != nil || fp.UniqueCount() != 2 {
+		t.Fatalf("ProcessReader err=%v unique=%d; want 2", err, fp.UniqueCount())
+	}
+}
+
+func Test_ProcessFile_SkipPrefixes(t *testing.T) {
+	logger := zap.NewNop()
+	data := []byte("# exported 2024-01-01\n;; list v2\n10.0.0.1\n10.0.0.2\n# end of block\nnot an address\n10.0.0.1\n")
+
+	f := mustTempFile(t, "list.txt", data)
+	defer f.Close()
+	fp := New(logger, f, ipv4_bitset.New(), 3, WithSkipPrefixes([]string{"#", ";;", ""}))
+	fi, _ := f.Stat()
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if got := fp.UniqueCount(); got != 2 {
+		t.Fatalf("UniqueCount=%d; want 2", got)
+	}
+	if got := fp.SkippedCount(); got != 3 {
+		t.Fatalf("SkippedCount=%d; want 3", got)
+	}
+	if got := fp.InvalidCount(); got != 1 {
+		t.Fatalf("InvalidCount=%d; want 1", got)
+	}
+}
+
+func Test_recordReader(t *testing.T) {
+	t.Parallel()
+
+	// a small buffer makes the reader refill inside records and delimiters
+	newReader := func(delim, data string, size int) *recordReader {
+		rr := New(zap.NewNop(), nil, nil, 1, WithDelimiter(delim)).newRecordReader(nil)
+		rr.r = bufio.NewReaderSize(strings.NewReader(data), size)
+		return rr
+	}
+	tests := []struct {
+		name  string
+		delim string
+		data  string
+		want  []string
+	}{
+		{name: "lines", delim: "\n", data: "a\nbb\r\nc", want: []string{"a\n", "bb\r\n"}},
+		{name: "comma and lines", delim: ",", data: "1.1.1.1,2.2.2.2\n3.3.3.3,\n4.4.4.4\n5", want: []string{"1.1.1.1,", "2.2.2.2\n", "3.3.3.3,", "\n", "4.4.4.4\n"}},
+		{name: "rare delimiter", delim: ";", data: "aaaaaaaaaa\nbbbbbbbbbbbb\ncccccccccccccccc\nd;e;", want: []string{"aaaaaaaaaa\n", "bbbbbbbbbbbb\n", "cccccccccccccccc\n", "d;", "e;"}},
+		{name: "long field refills", delim: "\x00", data: "0123456789abcdefghij\x00x\x00", want: []string{"0123456789abcdefghij\x00", "x\x00"}},
+		{name: "unterminated tail", delim: ",", data: "a,b", want: []string{"a,"}},
+		{name: "sentinel and lines", delim: "<EOR>", data: "1.1.1.1<EOR>2.2.2.2\n3.3.3.3<E<EOR>x<EO", want: []string{"1.1.1.1<EOR>", "2.2.2.2\n", "3.3.3.3<E<EOR>"}},
+		{name: "multi-line records", delim: "\r\n\r\n", data: "ip: 1.1.1.1\r\nua: x\r\n\r\nip: 2.2.2.2\r\n\r\nip: 3", want: []string{"ip: 1.1.1.1\r\nua: x\r\n\r\n", "ip: 2.2.2.2\r\n\r\n"}},
+		{name: "delimiter across fills", delim: "||||", data: "aaaaaaaaaaaaaa||||bbbbbbbbbbbbbbbbbbbbbbbbbbb||||", want: []string{"aaaaaaaaaaaaaa||||", "bbbbbbbbbbbbbbbbbbbbbbbbbbb||||"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			rr := newReader(tt.delim, tt.data, 32)
+			var got []string
+			for {
+				rec, err := rr.read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("read: %v", err)
+				}
+				got = append(got, string(rec))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("records=%q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	// a record longer than the buffer
+	rr := newReader(",", strings.Repeat("x", 64)+",", 16)
+	if _, err := rr.read(); !errors.Is(err, bufio.ErrBufferFull) {
+		t.Fatalf("long record err=%v; want ErrBufferFull", err)
+	}
+	rr = newReader("\n", strings.Repeat("x", 64)+"\n", 16)
+	if _, err := rr.read(); !errors.Is(err, bufio.ErrBufferFull) {
+		t.Fatalf("long line err=%v; want ErrBufferFull", err)
+	}
+
+	// skipped up to the terminator, the records after it are read as usual
+	for _, delim := range []string{"\n", ",", "<EOR>"} {
+		rr = newReader(delim, strings.Repeat("x", 64)+delim+"1.1.1.1"+delim+strings.Repeat("y", 40), 16)
+		if _, err := rr.read(); !errors.Is(err, bufio.ErrBufferFull) {
+			t.Fatalf("%q: long record err=%v; want ErrBufferFull", delim, err)
+		}
+		head, n, err := rr.skip()
+		if err != nil || n != int64(64+len(delim)) || string(head) != strings.Repeat("x", 64)+delim {
+			t.Fatalf("%q: skip=%q, %d, %v", delim, head, n, err)
+		}
+		if rec, err := rr.read(); err != nil || string(rec) != "1.1.1.1"+delim {
+			t.Fatalf("%q: record after the long one=%q, %v", delim, rec, err)
+		}
+		// unterminated at the end
+		if _, err = rr.read(); !errors.Is(err, bufio.ErrBufferFull) {
+			t.Fatalf("%q: unterminated long record err=%v", delim, err)
+		}
+		if _, n, err = rr.skip(); err != io.EOF || n != 40 {
+			t.Fatalf("%q: unterminated skip=%d, %v; want 40, EOF", delim, n, err)
+		}
+	}
+}
+
+func Test_recordReader_Lines(t *testing.T) {
+	t.Parallel()
+
+	// lines of varying length over many buffer fills, the same as ReadSlice line by line
+	var buf bytes.Buffer
+	for i := 0; i < 3079; i++ {
+		fmt.Fprintf(&buf, "%s\n", strings.Repeat("x", i%37))
+	}
+	buf.WriteString("tail")
+	rr := New(zap.NewNop(), nil, nil, 1).newRecordReader(nil)
+	rr.r = bufio.NewReaderSize(bytes.NewReader(buf.Bytes()), 4096)
+	ref := bufio.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		got, err := rr.read()
+		want, werr := ref.ReadSlice('\n')
+		if string(got) != string(want) || err != werr {
+			t.Fatalf("read=%q, %v; want %q, %v", got, err, want, werr)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	// a reader sharing the bufio.Reader continues right after the returned line
+	br := bufio.NewReaderSize(strings.NewReader("header\n1.1.1.1\n2.2.2.2\n"), 2<<20)
+	fp := New(zap.NewNop(), nil, nil, 1)
+	if rec, err := fp.newRecordReader(br).read(); err != nil || string(rec) != "header\n" {
+		t.Fatalf("first read=%q, %v; want header", rec, err)
+	}
+	if rec, err := fp.newRecordReader(br).read(); err != nil || string(rec) != "1.1.1.1\n" {
+		t.Fatalf("shared read=%q, %v; want 1.1.1.1", rec, err)
+	}
+}
+
+func Test_ProcessFile_Delimiter(t *testing.T) {
+	logger := zap.NewNop()
+
+	var buf bytes.Buffer
+	for i := 0; i < 4000; i++ {
+		sep := ","
+		if i%7 == 6 {
+			sep = ",\r\n"
+		}
+		fmt.Fprintf(&buf, "10.%d.%d.%d%s", i%2, (i>>8)&0xFF, i&0xFF, sep)
+	}
+	buf.WriteString("bad\n10.9.9.9\n")
+	data := buf.Bytes()
+
+	for _, th := range []int{1, 4, 32} {
+		f := mustTempFile(t, "export.txt", data)
+		defer f.Close()
+		fp := New(logger, f, ipv4_bitset.New(), th, WithDelimiter(","))
+		fi, _ := f.Stat()
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("th=%d: ProcessFile: %v", th, err)
+		}
+		if got := fp.UniqueCount(); got != 4001 {
+			t.Fatalf("th=%d: UniqueCount=%d; want 4001", th, got)
+		}
+		if got := fp.InvalidCount(); got != 1 {
+			t.Fatalf("th=%d: InvalidCount=%d; want 1(empty fields are not records)", th, got)
+		}
+	}
+}
+
+func Test_ProcessFile_MultiByteDelimiter(t *testing.T) {
+	logger := zap.NewNop()
+
+	// appliance export: multi-line records separated by an empty line, the address is the first line
+	var buf bytes.Buffer
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&buf, "10.0.%d.%d\r\nuser: u%d\r\n\r\n", i>>8, i&0xFF, i)
+	}
+	data := buf.Bytes()
+	first := func(line []byte) []byte {
+		if i := bytes.IndexByte(line, '\r'); i >= 0 {
+			return line[:i]
+		}
+		return line
+	}
+
+	for _, th := range []int{1, 3, 16} {
+		f := mustTempFile(t, "export.txt", data)
+		defer f.Close()
+		fp := New(logger, f, ipv4_bitset.New(), th, WithDelimiter("\r\n\r\n"))
+		fp.extract = first
+		fi, _ := f.Stat()
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("th=%d: ProcessFile: %v", th, err)
+		}
+		if got := fp.UniqueCount(); got != 3000 {
+			t.Fatalf("th=%d: UniqueCount=%d; want 3000", th, got)
+		}
+		if got := fp.InvalidCount(); got != 0 {
+			t.Fatalf("th=%d: InvalidCount=%d; want 0", th, got)
+		}
+	}
+}
+
+func Test_nextIPv4(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{line: "Failed password for root from 203.0.113.5 port 22 ssh2", want: []string{"203.0.113.5"}},
+		{line: "1.2.3.4", want: []string{"1.2.3.4"}},
+		{line: "src=10.0.0.1,dst=10.0.0.2;", want: []string{"10.0.0.1", "10.0.0.2"}},
+		{line: "X-Forwarded-For: 198.51.100.7, 192.0.2.1.", want: []string{"198.51.100.7", "192.0.2.1"}},
+		{line: "version 1.2.3.4.5 oid 1.3.6.1.4.1", want: nil},
+		{line: "1234.5.6.7 5.6.7.8910 v2.10.3.4", want: nil},
+		{line: "999.1.1.1 and [::ffff:8.8.8.8]:53", want: []string{"999.1.1.1", "8.8.8.8"}},
+		{line: "no dots here", want: nil},
+		{line: "ends with 1.2.3.", want: nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		line := []byte(tt.line)
+		for from := 0; ; {
+			start, end := nextIPv4(line, from)
+			if start < 0 {
+				break
+			}
+			got = append(got, string(line[start:end]))
+			from = end
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Fatalf("nextIPv4(%q)=%q; want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func Test_ProcessFile_ExtractAll(t *testing.T) {
+	logger := zap.NewNop()
+	data := []byte("Jan 1 sshd[1]: Failed password for root from 203.0.113.5 port 22 ssh2\n" +
+		"Jan 1 app: proxy 10.0.0.1 -> upstream 10.0.0.2 (retry from 203.0.113.5)\n" +
+		"Jan 1 app: started version 1.2.3.4.5\n" +
+		"Jan 1 app: bad 300.1.1.1\n")
+
+	f := mustTempFile(t, "app.log", data)
+	defer f.Close()
+	fp := New(logger, f, ipv4_bitset.New(), 2, WithExtractAll(true))
+	fi, _ := f.Stat()
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if got := fp.UniqueCount(); got != 3 {
+		t.Fatalf("UniqueCount=%d; want 3", got)
+	}
+	if got, inv := fp.SkippedCount(), fp.InvalidCount(); got != 2 || inv != 0 {
+		t.Fatalf("SkippedCount=%d InvalidCount=%d; want 2, 0(lines without an address)", got, inv)
+	}
+
+	// a CSV field holding an address list(X-Forwarded-For)
+	fp = New(logger, nil, ipv4_bitset.New(), 1, WithExtractAll(true), WithCSVColumn("xff"))
+	csv := "ts,xff\n1,\"198.51.100.7, 10.0.0.1\"\n2,192.0.2.1\n"
+	if err := fp.ProcessReader(context.Background(), strings.NewReader(csv)); err != nil || fp.UniqueCount() != 3 {
+		t.Fatalf("ProcessReader err=%v unique=%d; want 3", err, fp.UniqueCount())
+	}
+}
+
+func Test_LastRecordEnd(t *testing.T) {
+	t.Parallel()
+	long := strings.Repeat("x", 70<<10) // beyond one scan buffer
+	cases := []struct {
+		delim     string
+		data      string
+		off, want int64
+	}{
+		{"\n", "1.1.1.1\n2.2.2.2\n", 0, 16},
+		{"\n", "1.1.1.1\n2.2.", 0, 8},
+		{"\n", "1.1.1.1\n2.2.", 8, 8},
+		{"\n", "", 0, 0},
+		{"\n", "a\n" + long, 0, 2},
+		{"\n", "a\n" + long, 2, 2},
+		{",", "a,b\nc", 0, 4},
+		{"<EOR>", "a<EOR>b<EO", 0, 6},
+		{"<EOR>", "a<EOR>b\nc<EOR>", 0, 14},
+		{"<EOR>", "a<EOR>" + long[:64<<10-2], 0, 6}, // the delimiter spans the scan buffers
+		{"\r\n\r\n", "a\r\n\r\n\r\n", 0, 5},         // the reader takes the first match of the run
+		{"\r\n\r\n", "a\r\n\r\n\r\n\r\n", 0, 9},     // two matches of the run
+		{"\r\n\r\n", "\r\n\r\n\r\n", 2, 6},          // off is a record start
+	}
+	for _, tt := range cases {
+		fp := New(zap.NewNop(), nil, nil, 1, WithDelimiter(tt.delim)).withSource(strings.NewReader(tt.data))
+		got, err := fp.LastRecordEnd(context.Background(), tt.off, int64(len(tt.data)))
+		if err != nil || got != tt.want {
+			t.Fatalf("LastRecordEnd(%q, %.20q, %d)=%d, %v; want %d", tt.delim, tt.data, tt.off, got, err, tt.want)
+		}
+	}
+}
+
+// Test_recordBoundaries Shard alignment, plan validation and compressed ranges must agree with the records
+// of a sequential read for any delimiter, including self-overlapping ones.
+func Test_recordBoundaries(t *testing.T) {
+	t.Parallel()
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, delim := range []string{"\n", ",", "<EOR>", "aa", "aba", "\r\n\r\n"} {
+		for round := 0; round < 20; round++ {
+			// data dense in delimiter bytes
+			alphabet := delim + "ab\n\r1."
+			data := make([]byte, 300)
+			for i := range data {
+				data[i] = alphabet[rng.IntN(len(alphabet))]
+			}
+			fp := New(zap.NewNop(), nil, nil, 1, WithDelimiter(delim)).withSource(bytes.NewReader(data))
+
+			// the record starts of a sequential read
+			starts := map[int64]bool{0: true}
+			var records []string
+			rr := fp.newRecordReader(bytes.NewReader(data))
+			pos := int64(0)
+			for {
+				rec, err := rr.read()
+				if err != nil {
+					break
+				}
+				pos += int64(len(rec))
+				starts[pos] = true
+				records = append(records, string(rec))
+			}
+
+			for x := int64(0); x <= int64(len(data)); x++ {
+				ok, err := fp.isRecordStart(x)
+				if err != nil || ok != starts[x] {
+					t.Fatalf("%q: isRecordStart(%d)=%v, %v; want %v in %q", delim, x, ok, err, starts[x], data)
+				}
+				s, err := fp.moveStartToNewline(context.Background(), shard{Start: x, End: int64(len(data))})
+				if err != nil || (s.Start != int64(len(data)) && (!starts[s.Start] || (x > 0 && s.Start <= x))) {
+					t.Fatalf("%q: moveStartToNewline(%d)=%d, %v; not a record start after it in %q", delim, x, s.Start, err, data)
+				}
+				end, err := fp.LastRecordEnd(context.Background(), 0, x)
+				if err != nil || !starts[end] || end > x {
+					t.Fatalf("%q: LastRecordEnd(0, %d)=%d, %v; not a record start in %q", delim, x, end, err, data)
+				}
+			}
+
+			if selfOverlapping([]byte(delim)) {
+				continue
+			}
+			// ranges of a decompressed stream own the same records
+			var got []string
+			for lo := 0; lo < len(data); lo += 37 {
+				lr, err := fp.newLineRange(bytes.NewReader(data[lo:]), lo > 0, int64(min(37, len(data)-lo)))
+				if err != nil {
+					t.Fatalf("newLineRange: %v", err)
+				}
+				b, err := io.ReadAll(lr)
+				if err != nil {
+					t.Fatalf("lineRange: %v", err)
+				}
+				sub := fp.newRecordReader(bytes.NewReader(b))
+				for {
+					rec, err := sub.read()
+					if err != nil {
+						break
+					}
+					got = append(got, string(rec))
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(records) {
+				t.Fatalf("%q: ranges=%q; want %q", delim, got, records)
+			}
+		}
+	}
+}
+
+func Test_stripPort(t *testing.T) {
+	t.Parallel()
+	cases := []struct{ in, want string }{
+		{"1.2.3.4:8080", "1.2.3.4"},
+		{"1.2.3.4:1", "1.2.3.4"},
+		{"1.2.3.4", "1.2.3.4"},
+		{"1.2.3.4:", "1.2.3.4:"},
+		{"1.2.3.4:123456", "1.2.3.4:123456"},
+		{"1.2.3.4:http", "1.2.3.4:http"},
+		{"::ffff:1.2.3.4", "::ffff:1.2.3.4"},
+		{"2001:db8::1:443", "2001:db8::1:443"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"[1.2.3.4]:80", "1.2.3.4"},
+		{"[2001:db8::1]:", "[2001:db8::1]:"},
+		{"[2001:db8::1]x", "[2001:db8::1]x"},
+		{"[2001:db8::1", "[2001:db8::1"},
+	}
+	for _, tt := range cases {
+		if got := string(stripPort([]byte(tt.in))); got != tt.want {
+			t.Fatalf("stripPort(%q)=%q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_ProcessFile_StripPort(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+	data := []byte("1.1.1.1:443\n2.2.2.2:51234\n1.1.1.1\n3.3.3.3:x\n[4.4.4.4]:8443\n")
+	f := mustTempFile(t, "elb.log", data)
+	defer f.Close()
+
+	fp := New(logger, f, ipv4_bitset.New(), 2, WithStripPort(true))
+	fi, _ := f.Stat()
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if got, inv := fp.UniqueCount(), fp.InvalidCount(); got != 3 || inv != 1 {
+		t.Fatalf("UniqueCount=%d InvalidCount=%d; want 3, 1", got, inv)
+	}
+}
+
+func Test_ProcessFile_Binary(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+	archive := make([]byte, 4096) // compressed content
+	for i := range archive {
+		archive[i] = byte(i * 7)
+	}
+	padded := append([]byte("1.1.1.1\n"), make([]byte, 1024)...) // holes, preallocated space
+	tests := []struct {
+		name    string
+		data    []byte
+		opts    []Option
+		wantErr error
+	}{
+		{name: "text", data: []byte("1.1.1.1\n\t2.2.2.2\r\n\x1b[0m\n")},
+		{name: "archive", data: archive, wantErr: ErrBinary},
+		{name: "forced", data: archive, opts: []Option{WithForce(true)}},
+		{name: "NUL padding", data: padded},
+		{name: "NUL interleaved", data: []byte("1\x00.\x001\x00.\x001\x00.\x001\x00\n\x00"), wantErr: ErrBinary}, // UTF-16 without BOM
+		{name: "NUL delimited", data: []byte("1.1.1.1\x002.2.2.2\x00"), opts: []Option{WithDelimiter("\x00")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			f := mustTempFile(t, "in.log", tt.data)
+			defer f.Close()
+			fi, _ := f.Stat()
+			fp := New(logger, f, ipv4_bitset.New(), 2, tt.opts...)
+			if err := fp.ProcessFile(context.Background(), fi); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ProcessFile err=%v; want %v", err, tt.wantErr)
+			}
+			fp = New(logger, nil, ipv4_bitset.New(), 1, tt.opts...)
+			if err := fp.ProcessReader(context.Background(), bytes.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ProcessReader err=%v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_moveStartToNewline_Canceled(t *testing.T) {
+	t.Parallel()
+	// a region without records longer than the cancellation budget
+	data := bytes.Repeat([]byte("x"), 2*cancelEvery)
+	fp := New(zap.NewNop(), nil, nil, 1).withSource(bytes.NewReader(data))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := fp.moveStartToNewline(ctx, shard{Start: 1, End: int64(len(data))}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("moveStartToNewline err=%v; want %v", err, context.Canceled)
+	}
+	if _, err := fp.LastRecordEnd(ctx, 0, int64(len(data))); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LastRecordEnd err=%v; want %v", err, context.Canceled)
+	}
+}
+
+func Test_ProcessFile_IntegerAddresses(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+	data := []byte("192.168.0.1\n3232235521\n0xC0A80002\n0xc0a80003\n4294967296\n")
+	f := mustTempFile(t, "flows.log", data)
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	tests := []struct {
+		opts        []Option
+		uniq, inval uint64
+	}{
+		{opts: nil, uniq: 1, inval: 4},
+		{opts: []Option{WithIntegerAddresses(true)}, uniq: 3, inval: 1},
+	}
+	for _, tt := range tests {
+		fp := New(logger, f, ipv4_bitset.New(), 2, tt.opts...)
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("ProcessFile: %v", err)
+		}
+		if got, inv := fp.UniqueCount(), fp.InvalidCount(); got != tt.uniq || inv != tt.inval {
+			t.Fatalf("UniqueCount=%d InvalidCount=%d; want %d, %d", got, inv, tt.uniq, tt.inval)
+		}
+	}
+}
+
+func Test_ProcessFile_StrictIPv4(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+	data := []byte("1.2.3.4\n001.002.003.004\n10.0.0.1\r\n10.0.0.01\n0.0.0.0\n")
+	f := mustTempFile(t, "zeros.txt", data)
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	tests := []struct {
+		opts        []Option
+		uniq, inval uint64
+	}{
+		{opts: nil, uniq: 3, inval: 0},
+		{opts: []Option{WithStrictIPv4(true)}, uniq: 3, inval: 2},
+		{opts: []Option{WithStrictIPv4(true), WithExtractAll(true)}, uniq: 3, inval: 0},
+	}
+	for _, tt := range tests {
+		fp := New(logger, f, ipv4_bitset.New(), 2, tt.opts...)
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("ProcessFile: %v", err)
+		}
+		if got, inv := fp.UniqueCount(), fp.InvalidCount(); got != tt.uniq || inv != tt.inval {
+			t.Fatalf("UniqueCount=%d InvalidCount=%d; want %d, %d", got, inv, tt.uniq, tt.inval)
+		}
+	}
+}
+
+func Test_ProcessFile_TrimSpace(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+	data := []byte("  10.0.0.1\n10.0.0.2\t\r\n\t 10.0.0.3 \n10.0.0.1\n10.0 .0.4\n   \n")
+	f := mustTempFile(t, "padded.txt", data)
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	tests := []struct {
+		opts        []Option
+		uniq, inval uint64
+	}{
+		{opts: nil, uniq: 1, inval: 5},
+		{opts: []Option{WithTrimSpace(true)}, uniq: 3, inval: 2},
+	}
+	for _, tt := range tests {
+		fp := New(logger, f, ipv4_bitset.New(), 2, tt.opts...)
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("ProcessFile: %v", err)
+		}
+		if got, inv := fp.UniqueCount(), fp.InvalidCount(); got != tt.uniq || inv != tt.inval {
+			t.Fatalf("UniqueCount=%d InvalidCount=%d; want %d, %d", got, inv, tt.uniq, tt.inval)
+		}
+	}
+
+	// padded CSV fields, the port is stripped after trimming
+	fp := New(logger, nil, ipv4_bitset.New(), 1, WithCSVColumn("2"), WithTrimSpace(true), WithStripPort(true))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("web, 10.0.0.1:443 ,x\ndb,\t10.0.0.2\t,y\n")); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	if fp.UniqueCount() != 2 || fp.InvalidCount() != 0 {
+		t.Fatalf("csv unique=%d invalid=%d; want 2, 0", fp.UniqueCount(), fp.InvalidCount())
+	}
+}
+
+func Test_ProcessFile_CIDR(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+	data := []byte("10.0.0.0/24\n10.0.0.5\n10.0.0.128/25\n10.0.0.7/24\n192.0.2.1/32\n192.0.2.1\n10.0.0.0/33\n")
+	f := mustTempFile(t, "drop.txt", data)
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	tests := []struct {
+		mode        string
+		uniq, inval uint64
+	}{
+		{mode: "", uniq: 2, inval: 5},
+		{mode: CIDRRange, uniq: 257, inval: 1},
+		{mode: CIDRPrefix, uniq: 4, inval: 1}, // 10.0.0.0/24, 10.0.0.128/25 and the two addresses
+	}
+	for _, tt := range tests {
+		fp := New(logger, f, ipv4_bitset.New(), 2, WithCIDR(tt.mode))
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("%q: ProcessFile: %v", tt.mode, err)
+		}
+		if got, inv := fp.UniqueCount(), fp.InvalidCount(); got != tt.uniq || inv != tt.inval {
+			t.Fatalf("%q: UniqueCount=%d InvalidCount=%d; want %d, %d", tt.mode, got, inv, tt.uniq, tt.inval)
+		}
+	}
+}
+
+func Test_accessLogField(t *testing.T) {
+	t.Parallel()
+	line := []byte(`1.2.3.4 - frank [10/Oct/2000:13:55:36 -0700] "GET /a\" b HTTP/1.0" 503 2326 "-" "Mozilla/5.0 (X11)"`)
+	want := []string{"1.2.3.4", "-", "frank", "10/Oct/2000:13:55:36 -0700", `GET /a\" b HTTP/1.0`, "503", "2326", "-", "Mozilla/5.0 (X11)", ""}
+	for i, w := range want {
+		if got := string(accessLogField(line, i)); got != w {
+			t.Fatalf("accessLogField(%d)=%q; want %q", i, got, w)
+		}
+	}
+}
+
+func Test_ProcessFile_StatusDimension(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+	want := map[string]uint64{"1xx": 0, "2xx": 2, "3xx": 0, "4xx": 1, "5xx": 2, "other": 1}
+	dims := func(fp *FileProcessor) map[string]uint64 {
+		t.Helper()
+		ds := fp.Dimensions()
+		if len(ds) != 1 || ds[0].Name != "status" {
+			t.Fatalf("Dimensions=%+v; want status", ds)
+		}
+		m := map[string]uint64{}
+		for _, b := range ds[0].Buckets {
+			m[b.Value] = b.Unique
+		}
+		return m
+	}
+
+	combined := `1.1.1.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 1 "-" "curl"
+2.2.2.2 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 502 1 "-" "curl"
+1.1.1.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 503 1 "-" "curl"
+1.1.1.1 - - [10/Oct/2000:13:55:36 -0700] "GET /x HTTP/1.0" 500 1 "-" "curl"
+3.3.3.3 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 404 1 "-" "curl"
+3.3.3.3 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 1 "-" "curl"
+4.4.4.4 - - [10/Oct/2000:13:55:36 -0700] "-" - 1 "-" "curl"
+`
+	f := mustTempFile(t, "access.log", []byte(combined))
+	defer f.Close()
+	fi, _ := f.Stat()
+	fp := New(logger, f, ipv4_bitset.New(), 3, WithFormat("combined"), WithStatusDimension("status"))
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if got := dims(fp); fmt.Sprint(got) != fmt.Sprint(want) || fp.UniqueCount() != 4 {
+		t.Fatalf("status=%v unique=%d; want %v, 4", got, fp.UniqueCount(), want)
+	}
+
+	// CSV by header name, the address column by index
+	csv := "ip,status\n1.1.1.1,200\n2.2.2.2,502\n1.1.1.1,503\n3.3.3.3,404\n3.3.3.3,200\n4.4.4.4,\n"
+	fp = New(logger, nil, ipv4_bitset.New(), 1, WithCSVColumn("1"), WithStatusDimension("status"))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader(csv)); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	if got := dims(fp); fmt.Sprint(got) != fmt.Sprint(want) || fp.InvalidCount() != 0 {
+		t.Fatalf("status=%v invalid=%d; want %v, 0", got, fp.InvalidCount(), want)
+	}
+}
+
+func Test_userAgentClass(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		ua   string
+		want string
+	}{
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "known-bot"},
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.0)", "known-bot"},
+		{"Mozilla/5.0 (compatible; SomeNewBot/0.1)", "bot"},
+		{"curl/8.4.0", "bot"},
+		{"python-requests/2.31", "bot"},
+		{"Go-http-client/1.1", "bot"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36", "human"},
+		{"mozilla/5.0 (iPhone)", "human"},
+		{"-", "unknown"},
+		{"", "unknown"},
+	}
+	for _, tt := range cases {
+		if got := userAgentClasses[userAgentClass([]byte(tt.ua))]; got != tt.want {
+			t.Fatalf("userAgentClass(%q)=%s; want %s", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func Test_ProcessFile_UserAgentDimension(t *testing.T) {
+	t.Parallel()
+	combined := `1.1.1.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 1 "-" "Mozilla/5.0 (X11; Linux x86_64) Firefox/121.0"
+2.2.2.2 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 1 "-" "Mozilla/5.0 (compatible; bingbot/2.0)"
+1.1.1.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 500 1 "-" "curl/8.4.0"
+3.3.3.3 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 1 "-" "-"
+`
+	f := mustTempFile(t, "access.log", []byte(combined))
+	defer f.Close()
+	fi, _ := f.Stat()
+	fp := New(zap.NewNop(), f, ipv4_bitset.New(), 2, WithFormat("combined"),
+		WithStatusDimension("status"), WithUserAgentDimension("agent"))
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	got := fmt.Sprint(fp.Dimensions())
+	want := "[{status [{1xx 0} {2xx 3} {3xx 0} {4xx 0} {5xx 1} {other 0}]} {user_agent [{known-bot 1} {bot 1} {human 1} {unknown 1}]}]"
+	if got != want {
+		t.Fatalf("Dimensions=%s; want %s", got, want)
+	}
+
+	m := fp.Matrix(false)
+	if len(m.Cells) != 10*10 {
+		t.Fatalf("cells=%d; want 100", len(m.Cells))
+	}
+	cells := map[string]uint64{}
+	for _, c := range m.Cells {
+		cells[c.Row+"|"+c.Col] = c.Unique
+	}
+	for k, v := range map[string]uint64{
+		"status=2xx|status=2xx":       3,
+		"status=2xx|status=5xx":       1, // 1.1.1.1 got both
+		"status=5xx|status=2xx":       1,
+		"status=5xx|user_agent=bot":   1,
+		"status=2xx|user_agent=bot":   1, // same address, different records
+		"status=4xx|status=4xx":       0,
+		"user_agent=human|status=2xx": 1,
+	} {
+		if cells[k] != v {
+			t.Fatalf("cell %s=%d; want %d", k, cells[k], v)
+		}
+	}
+	if m.Unions["status"] != 3 || m.Unions["user_agent"] != 3 {
+		t.Fatalf("unions=%v; want 3, 3", m.Unions)
+	}
+
+	m = fp.Matrix(true)
+	if len(m.Cells) != 10 || m.Unions != nil {
+		t.Fatalf("partial matrix cells=%d unions=%v; want the 10 buckets only", len(m.Cells), m.Unions)
+	}
+}
+
+func Test_ProcessReader_Degraded(t *testing.T) {
+	t.Parallel()
+	var sb strings.Builder
+	for i := range 3000 {
+		// 10.0.x.y stays exact, 20.x.y.z goes to the sketch
+		fmt.Fprintf(&sb, "10.0.%d.%d\n20.%d.%d.1\n", i/256, i%256, i/256, i%256)
+	}
+	bs := ipv4_bitset.New()
+	bs.SetIfNew(0x0A000000) // 10.0.0.0 allocates the exact shard
+	bs.AddUnique(1)
+	bs.Degrade()
+
+	fp := New(zap.NewNop(), nil, bs, 2)
+	if err := fp.ProcessReader(context.Background(), strings.NewReader(sb.String())); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	if got := bs.GetUniqueCount(); got != 3000 {
+		t.Fatalf("exact part=%d; want 3000", got)
+	}
+	approx, stdErr := bs.ApproxCount()
+	if d := math.Abs(float64(approx) - 3000); d > 3000*4*stdErr {
+		t.Fatalf("approx part=%d(±%.4f); want ~3000", approx, stdErr)
+	}
+	if fp.UniqueCount() != 3000+approx {
+		t.Fatalf("UniqueCount=%d; want exact+approx %d", fp.UniqueCount(), 3000+approx)
+	}
+}
+
+func Test_ProcessFile_FailFast(t *testing.T) {
+	t.Parallel()
+	data := []byte("10.0.0.1\n10.0.0.2\n\tnot an ip\r\n10.0.0.3\n")
+	f := mustTempFile(t, "strict.txt", data)
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	fp := New(zap.NewNop(), f, ipv4_bitset.New(), 1, WithFailFast(true))
+	err := fp.ProcessFile(context.Background(), fi)
+	if !errors.Is(err, ErrInvalidLine) {
+		t.Fatalf("ProcessFile: %v; want ErrInvalidLine", err)
+	}
+	if want := `invalid line at byte 18(line 3): "\tnot an ip"`; err.Error() != want {
+		t.Fatalf("error %q; want %q", err, want)
+	}
+	if fp.InvalidCount() != 0 {
+		t.Fatalf("InvalidCount=%d; want the line reported, not counted", fp.InvalidCount())
+	}
+
+	// skipped and IPv6 lines are not invalid
+	fp = New(zap.NewNop(), nil, ipv4_bitset.New(), 1, WithFailFast(true), WithSkipPrefixes([]string{"#"}))
+	if err = fp.ProcessReader(context.Background(), strings.NewReader("# header\n10.0.0.1\n2001:db8::1\n")); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+}
+
+func Test_ProcessFile_MaxInvalidPct(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	for i := range 300_000 {
+		if i%10 == 0 {
+			buf.WriteString("GET / HTTP/1.1\n")
+			continue
+		}
+		fmt.Fprintf(&buf, "10.%d.%d.%d\n", i>>16&0xff, i>>8&0xff, i&0xff)
+	}
+	f := mustTempFile(t, "mixed.txt", buf.Bytes())
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	// 10% invalid: aborted mid-input under 5%, fine under 15%
+	fp := New(zap.NewNop(), f, ipv4_bitset.New(), 4, WithMaxInvalidPct(5))
+	if err := fp.ProcessFile(context.Background(), fi); !errors.Is(err, ErrInvalidRatio) {
+		t.Fatalf("ProcessFile: %v; want ErrInvalidRatio", err)
+	}
+	fp = New(zap.NewNop(), f, ipv4_bitset.New(), 4, WithMaxInvalidPct(15))
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if err := fp.CheckInvalidRatio(); err != nil {
+		t.Fatalf("CheckInvalidRatio: %v", err)
+	}
+
+	// too short to abort mid-input, the final check fails it
+	fp = New(zap.NewNop(), nil, ipv4_bitset.New(), 1, WithMaxInvalidPct(5))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("1.1.1.1\nx\n2.2.2.2\n")); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	if err := fp.CheckInvalidRatio(); !errors.Is(err, ErrInvalidRatio) || !strings.Contains(err.Error(), "1 of 3(33.33%)") {
+		t.Fatalf("CheckInvalidRatio: %v; want 1 of 3 invalid", err)
+	}
+}
+
+func Test_ProcessFile_LongLines(t *testing.T) {
+	t.Parallel()
+	long := strings.Repeat("x", 5<<20) // longer than the 2MB reader buffer
+	data := []byte("1.1.1.1\n" + long + "\n2.2.2.2\n" + long + "\r\n3.3.3.3\n")
+	f := mustTempFile(t, "long.txt", data)
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	for _, th := range []int{1, 4} {
+		var out bytes.Buffer
+		w := NewInvalidWriter(&out)
+		fp := New(zap.NewNop(), f, ipv4_bitset.New(), th, WithInvalidOut(w, "long.txt"))
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("th=%d ProcessFile: %v", th, err)
+		}
+		_ = w.Flush()
+		if fp.UniqueCount() != 3 || fp.InvalidCount() != 2 {
+			t.Fatalf("th=%d unique=%d invalid=%d; want 3, 2", th, fp.UniqueCount(), fp.InvalidCount())
+		}
+		// truncated like the samples, the offsets are exact
+		want := fmt.Sprintf("long.txt\t8\t2\t%s\nlong.txt\t%d\t", long[:maxInvalidSample], 8+len(long)+1+8)
+		if !strings.HasPrefix(sortedLines(out.String()), want) {
+			t.Fatalf("th=%d invalid out %.80q...; want %.80q...", th, sortedLines(out.String()), want)
+		}
+	}
+
+	// BGZF blocks cut inside the long lines, a block range starts and ends within them
+	z := mustTempFile(t, "long.bgz", bgzf(t, data, []int{3, 1 << 20, 3 << 20, 5<<20 + 12, 6 << 20, 9 << 20}))
+	defer z.Close()
+	zi, _ := z.Stat()
+	for _, th := range []int{1, 3, 8} {
+		fp := New(zap.NewNop(), z, ipv4_bitset.New(), th)
+		if err := fp.ProcessGzip(context.Background(), zi); err != nil {
+			t.Fatalf("th=%d ProcessGzip: %v", th, err)
+		}
+		if fp.UniqueCount() != 3 || fp.InvalidCount() != 2 {
+			t.Fatalf("th=%d bgzf unique=%d invalid=%d; want 3, 2", th, fp.UniqueCount(), fp.InvalidCount())
+		}
+	}
+
+	// streams and other delimiters
+	fp := New(zap.NewNop(), nil, ipv4_bitset.New(), 1, WithDelimiter(","))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("1.1.1.1,"+long+",2.2.2.2\n"+long)); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	if fp.UniqueCount() != 2 || fp.InvalidCount() != 2 {
+		t.Fatalf("stream unique=%d invalid=%d; want 2, 2", fp.UniqueCount(), fp.InvalidCount())
+	}
+}
+
+func Test_ProcessFile_MaxLineBytes(t *testing.T) {
+	t.Parallel()
+	blob := strings.Repeat("\x00", 100)
+	data := "255.255.255.255\n1.1.1.1 and-more\r\n2.2.2.2\r\n" + blob + "\n3.3.3.3\n"
+	f := mustTempFile(t, "blob.txt", []byte(data))
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	for _, tc := range []struct {
+		max, unique, invalid int
+	}{
+		{0, 3, 2},  // 2MB, only not addresses
+		{15, 3, 2}, // the longest address still fits, its terminator and '\r' are not counted
+		{14, 2, 3},
+	} {
+		for _, th := range []int{1, 3} {
+			fp := New(zap.NewNop(), f, ipv4_bitset.New(), th, WithMaxLineBytes(tc.max), WithInvalidSamples(4))
+			if err := fp.ProcessFile(context.Background(), fi); err != nil {
+				t.Fatalf("max=%d th=%d ProcessFile: %v", tc.max, th, err)
+			}
+			if fp.UniqueCount() != uint64(tc.unique) || fp.InvalidCount() != uint64(tc.invalid) {
+				t.Errorf("max=%d th=%d unique=%d invalid=%d; want %d, %d", tc.max, th, fp.UniqueCount(), fp.InvalidCount(),
+					tc.unique, tc.invalid)
+			}
+		}
+	}
+
+	// delimited records
+	fp := New(zap.NewNop(), nil, ipv4_bitset.New(), 1, WithDelimiter(","), WithMaxLineBytes(7))
+	if err := fp.ProcessReader(context.Background(), strings.NewReader("1.1.1.1,"+blob+",2.2.2.2\r\n10.10.10.10,3.3.3.3\n")); err != nil {
+		t.Fatalf("ProcessReader: %v", err)
+	}
+	if fp.UniqueCount() != 3 || fp.InvalidCount() != 2 {
+		t.Fatalf("delimited unique=%d invalid=%d; want 3, 2", fp.UniqueCount(), fp.InvalidCount())
+	}
+}
+
+// sortedLines The lines of s sorted by their offset field, shards write them in any order.
+func sortedLines(s string) string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	slices.SortFunc(lines, func(a, b string) int {
+		oa, _ := strconv.Atoi(strings.Split(a, "\t")[1])
+		ob, _ := strconv.Atoi(strings.Split(b, "\t")[1])
+		return oa - ob
+	})
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func Test_Estimate(t *testing.T) {
+	t.Parallel()
+	var (
+		repeated, distinct strings.Builder
+		r                  = rand.New(rand.NewPCG(1, 2))
+	)
+	repeated.WriteString("ip\n")
+	for range 400_000 {
+		// 20 000 addresses, 20 times each on average
+		u := r.Uint32N(20_000) * 7919
+		fmt.Fprintf(&repeated, "%d.%d.%d.%d\n", u>>24, u>>16&255, u>>8&255, u&255)
+	}
+	for i := range 200_000 {
+		fmt.Fprintf(&distinct, "10.%d.%d.%d\r\n", i>>16, i>>8&255, i&255)
+	}
+
+	for _, tc := range []struct {
+		name, data string
+		header     bool
+		budget     int64
+		exact      uint64 // 0 - estimated
+		tolerance  float64
+	}{
+		{"repeated", repeated.String(), true, 1 << 20, 0, 0.1},
+		{"distinct", distinct.String(), false, 1 << 20, 0, 0},
+		{"whole input", distinct.String(), false, 64 << 20, 200_000, 0},
+	} {
+		f := mustTempFile(t, "sample.txt", []byte(tc.data))
+		fi, _ := f.Stat()
+		var opts []Option
+		if tc.header {
+			opts = append(opts, WithHeader(true))
+		}
+		fp := New(zap.NewNop(), f, ipv4_bitset.New(), 1, opts...)
+		est, err := fp.Estimate(context.Background(), f, fi.Size(), tc.budget, 64, rand.New(rand.NewPCG(3, 4)))
+		_ = f.Close()
+		if err != nil {
+			t.Fatalf("%s: Estimate: %v", tc.name, err)
+		}
+		truth := uint64(20_000)
+		if tc.name != "repeated" {
+			truth = 200_000
+		}
+		if tc.exact > 0 {
+			if !est.Exact || est.Unique != tc.exact || est.Low != tc.exact || est.High != tc.exact {
+				t.Errorf("%s: %+v; want exactly %d", tc.name, est, tc.exact)
+			}
+			continue
+		}
+		if est.Exact || est.Ranges != 16 || est.SampledBytes < tc.budget*9/10 || est.SampledBytes > tc.budget*11/10 {
+			t.Errorf("%s: %+v; want 16 ranges of 64KB", tc.name, est)
+		}
+		// the bounds are scaled by the sampled bytes too
+		if truth < est.Low || float64(truth) > float64(est.High)*1.05 || est.Unique < est.Low || est.Unique > est.High {
+			t.Errorf("%s: unique=%d within %d..%d; want the truth %d within the bounds(±5%%)", tc.name, est.Unique, est.Low, est.High, truth)
+		}
+		if tc.tolerance > 0 && math.Abs(float64(est.Unique)/float64(truth)-1) > tc.tolerance {
+			t.Errorf("%s: unique=%d; want %d ±%.0f%%", tc.name, est.Unique, truth, tc.tolerance*100)
+		}
+		if d := math.Abs(float64(est.Total)/float64(len(strings.Split(tc.data, "\n"))-2) - 1); d > 0.05 {
+			t.Errorf("%s: total=%d off by %.0f%%", tc.name, est.Total, d*100)
+		}
+	}
+}
+
+func Test_Estimate_Counts(t *testing.T) {
+	t.Parallel()
+	text := "1.1.1.1\n1.1.2.2\n1.1.1.1\n2.2.2.2\n::1\r\n2001:db8::1\nbad\n# comment\n300.1.1.1\n"
+	f := mustTempFile(t, "sample.txt", []byte(text))
+	defer f.Close()
+	fp := New(zap.NewNop(), f, ipv4_bitset.New(), 1, WithSkipPrefixes([]string{"#"}))
+	est, err := fp.Estimate(context.Background(), f, int64(len(text)), 1<<20, 4, rand.New(rand.NewPCG(1, 2)))
+	if err != nil {
+		t.Fatalf("Estimate: %v", err)
+	}
+	if !est.Exact || est.Records != 4 || est.Distinct != 3 || est.Blocks != 2 || est.IPv6 != 2 || est.Invalid != 2 {
+		t.Fatalf("%+v; want 4 records, 3 distinct in 2 blocks, 2 IPv6 and 2 invalid", est)
+	}
+}
+
+func Test_isHostname(t *testing.T) {
+	t.Parallel()
+	for tok, want := range map[string]bool{
+		"example.com": true, "Mail-1.Example.COM.": true, "a.b": true, "xn--bcher-kva.example": true,
+		"localhost": false, "1.2.3.4": false, "1.2.3.256": false, "10.0.0.0/8": false, "example..com": false,
+		"-a.example.com": false, "a-.example.com": false, "under_score.example.com": false, "": false, ".": false,
+		"example.com:443": false, strings.Repeat("a", 64) + ".com": false, "host.123": false,
+	} {
+		if got := isHostname([]byte(tok)); got != want {
+			t.Errorf("isHostname(%q)=%v; want %v", tok, got, want)
+		}
+	}
+}
+
+func Test_ProcessFile_Hostnames(t *testing.T) {
+	t.Parallel()
+	data := "1.1.1.1\nallow.example.com\r\nALLOW.example.com.\nmulti.example.com\nnx.example.com\nnx.example.com\nnot a host\n"
+	f := mustTempFile(t, "allow.txt", []byte(data))
+	defer f.Close()
+	fi, _ := f.Stat()
+
+	var lookups atomic.Int32
+	lookup := func(ctx context.Context, host string) ([]netip.Addr, error) {
+		lookups.Add(1)
+		switch host {
+		case "allow.example.com":
+			return []netip.Addr{netip.MustParseAddr("1.1.1.1")}, nil
+		case "multi.example.com":
+			return []netip.Addr{netip.MustParseAddr("2.2.2.2"), netip.MustParseAddr("::ffff:3.3.3.3"), netip.MustParseAddr("2001:db8::1")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	r := NewResolver(lookup, 2, time.Second)
+
+	for run := range 2 {
+		fp := New(zap.NewNop(), f, ipv4_bitset.New(), 2, WithResolver(r), WithInvalidSamples(4))
+		if err := fp.ProcessFile(context.Background(), fi); err != nil {
+			t.Fatalf("ProcessFile: %v", err)
+		}
+		names, failed, err := fp.ResolveHostnames(context.Background())
+		if err != nil || names != 2 || failed != 1 {
+			t.Fatalf("ResolveHostnames=%d,%d,%v; want 2 resolved, 1 failed", names, failed, err)
+		}
+		// 1.1.1.1 twice, the AAAA record is not counted; nx lines and the junk line are invalid
+		if fp.UniqueCount() != 3 || fp.InvalidCount() != 3 {
+			t.Fatalf("unique=%d invalid=%d; want 3, 3", fp.UniqueCount(), fp.InvalidCount())
+		}
+		if !slices.Contains(fp.InvalidSamples(), "nx.example.com") {
+			t.Fatalf("samples %q; want the unresolved name", fp.InvalidSamples())
+		}
+		// the second input of the run is answered from the cache
+		if lookups.Load() != 3 {
+			t.Fatalf("run %d: %d lookups; want 3, once per name", run, lookups.Load())
+		}
+	}
+
+	// without a resolver a hostname is an invalid line
+	fp := New(zap.NewNop(), f, ipv4_bitset.New(), 1)
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if fp.UniqueCount() != 1 || fp.InvalidCount() != 6 {
+		t.Fatalf("no resolver unique=%d invalid=%d; want 1, 6", fp.UniqueCount(), fp.InvalidCount())
+	}
+}
+
+func Test_Progress_BytesAndRecords(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+	var (
+		text  bytes.Buffer
+		plain []byte
+	)
+	for i := 0; i < 3000; i++ {
+		text.WriteString(fmt.Sprintf("10.%d.%d.%d\n", i%3, (i>>8)&0xFF, i&0xFF))
+	}
+	plain = text.Bytes()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(plain)
+	_ = zw.Close()
+	be32 := []byte{1, 1, 1, 1, 2, 2, 2, 2, 1, 1, 1, 1}
+
+	tests := []struct {
+		name        string
+		file        string
+		data        []byte
+		opts        []Option
+		process     func(fp *FileProcessor, fi os.FileInfo) error
+		wantRecords int64
+		atLeast     bool // groups of frames may read ahead into the next one
+	}{
+		{name: "text", file: "in.log", data: []byte("1.1.1.1\n2.2.2.2\r\nbad\n"), wantRecords: 3},
+		{name: "separators", file: "in.log", data: []byte("1.1.1.1,,2.2.2.2,"), opts: []Option{WithDelimiter(",")}, wantRecords: 2},
+		{name: "gzip", file: "in.gz", data: gz.Bytes(), wantRecords: 3000,
+			process: func(fp *FileProcessor, fi os.FileInfo) error { return fp.ProcessGzip(context.Background(), fi) }},
+		{name: "bgzf", file: "in.bgz", data: bgzf(t, plain, []int{1000, 20000}), wantRecords: 3000, atLeast: true,
+			process: func(fp *FileProcessor, fi os.FileInfo) error { return fp.ProcessGzip(context.Background(), fi) }},
+		{name: "binary", file: "in.bin", data: be32, wantRecords: 3,
+			process: func(fp *FileProcessor, fi os.FileInfo) error { return fp.ProcessBinary(context.Background(), fi) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			f := mustTempFile(t, tt.file, tt.data)
+			defer f.Close()
+			fi, _ := f.Stat()
+			fp := New(logger, f, ipv4_bitset.New(), 3, tt.opts...)
+			process := tt.process
+			if process == nil {
+				process = func(fp *FileProcessor, fi os.FileInfo) error { return fp.ProcessFile(context.Background(), fi) }
+			}
+			if err := process(fp, fi); err != nil {
+				t.Fatalf("process: %v", err)
+			}
+			// bytes of the file as read, not of the decompressed lines
+			got, want := fp.progress.Bytes(), int64(len(tt.data))
+			if got != want && !(tt.atLeast && got > want) {
+				t.Errorf("bytes read=%d; want %d", got, want)
+			}
+			if got := fp.progress.Records(); got != tt.wantRecords {
+				t.Errorf("records=%d; want %d", got, tt.wantRecords)
+			}
+		})
+	}
+}
+
+func Test_ProcessFile_InlineComments(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+	data := []byte("# curated edge list\n1.2.3.4  # edge-router\n5.6.7.8\t; backup\n\t9.9.9.9#lab\n;; end\nnot an address # really\n1.2.3.4\n")
+
+	f := mustTempFile(t, "list.txt", data)
+	defer f.Close()
+	fi, _ := f.Stat()
+	fp := New(logger, f, ipv4_bitset.New(), 3, WithInlineComments(true))
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if got := fp.UniqueCount(); got != 3 {
+		t.Errorf("UniqueCount=%d; want 3", got)
+	}
+	if got := fp.SkippedCount(); got != 2 {
+		t.Errorf("SkippedCount=%d; want 2(the comment-only lines)", got)
+	}
+	if got := fp.InvalidCount(); got != 1 {
+		t.Errorf("InvalidCount=%d; want 1", got)
+	}
+
+	// without the option the commented lines are invalid
+	fp = New(logger, f, ipv4_bitset.New(), 3)
+	if err := fp.ProcessFile(context.Background(), fi); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	if got := fp.UniqueCount(); got != 1 {
+		t.Errorf("without WithInlineComments UniqueCount=%d; want 1", got)
+	}
+}
+
+func Test_ProcessFile_Balancer(t *testing.T) {
+	t.Parallel()
+	logger := zap.NewNop()
+
+	var buf bytes.Buffer
+	for i := 0; i < 200000; i++ {
+		if i == 100000 {
+			// a record spanning units
+			buf.Write(bytes.Repeat([]byte{'x'}, 3*unitSize/2))
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(fmt.Sprintf("10.%d.%d.%d\n", i>>16, (i>>8)&0xFF, i&0xFF))
+	}
+	data := buf.Bytes()
+	var cuts []int
+	for c := 60000; c < len(data); c += 60000 {
+		cuts = append(cuts, c)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		file    []byte
+		process func(fp *FileProcessor, fi os.FileInfo) error
+	}{
+		{"bgzf", bgzf(t, data, cuts), func(fp *FileProcessor, fi os.FileInfo) error { return fp.ProcessGzip(context.Background(), fi) }},
+		{"seekable zstd", seekableZstd(t, data, cuts, false), func(fp *FileProcessor, fi os.FileInfo) error { return fp.ProcessZstd(context.Background(), fi) }},
+	} {
+		for _, th := range []int{1, 3, 8} {
+			f := mustTempFile(t, "ips", tt.file)
+			defer f.Close()
+			fp := New(logger, f, ipv4_bitset.New(), th, WithBalancer(true))
+			fi, _ := f.Stat()
+			if err := tt.process(fp, fi); err != nil {
+				t.Fatalf("%s th=%d: %v", tt.name, th, err)
+			}
+			if got := fp.UniqueCount(); got != 200000 {
+				t.Errorf("%s th=%d: UniqueCount=%d; want 200000", tt.name, th, got)
+			}
+			if got := fp.InvalidCount(); got != 1 {
+				t.Errorf("%s th=%d: InvalidCount=%d; want 1(the long record)", tt.name, th, got)
+			}
+			if got, want := fp.progress.Bytes(), int64(len(tt.file)); got > want {
+				t.Errorf("%s th=%d: bytes read=%d; want at most %d", tt.name, th, got, want)
+			}
+		}
+	}
+}
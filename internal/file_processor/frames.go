@@ -0,0 +1,188 @@
+package file_processor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type (
+	// frame Independently decompressible unit of a compressed file(zstd frame, BGZF block):
+	// its position in the file and of its content in the decompressed stream.
+	frame struct {
+		off, size   int64 // compressed
+		dOff, dSize int64 // decompressed
+	}
+	// frameGroup Frames [start, end) decompressed by one shard.
+	frameGroup struct{ start, end int }
+)
+
+// processFrames Counts the decompressed content of frames in parallel groups of contiguous frames,
+// open wraps the compressed file from the first frame of a group on into a decompressing reader.
+// Progress is of the compressed bytes.
+func (fp *FileProcessor) processFrames(ctx context.Context, frames []frame, open func(r io.Reader) (io.ReadCloser, error)) error {
+	last := frames[len(frames)-1]
+	defer fp.progress.Run(last.off + last.size)()
+
+	if fp.w3c || selfOverlapping(fp.delim) {
+		// the #Fields directives of the decompressed stream are followed in order, the delimiter taken
+		// in a run of overlapping matches depends on the data before a group
+		fp.logger.Info("w3c input or self-overlapping delimiter, decompressing sequentially")
+		rc, err := open(fp.progress.reader(fp.framesFrom(frames, 0)))
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		return fp.processStream(ctx, rc, false)
+	}
+	if fp.hasHeader() {
+		rc, err := open(fp.framesFrom(frames, 0))
+		if err != nil {
+			return err
+		}
+		err = fp.resolveHeader(rc)
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if fp.balance {
+		return fp.balanceFrames(ctx, frames, open)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	for _, grp := range groupFrames(frames, fp.th) {
+		g.Go(func() error {
+			rc, err := open(fp.progress.reader(fp.framesFrom(frames, grp.start)))
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+
+			first, last := frames[grp.start], frames[grp.end-1]
+			// the first group skips the header row instead of a partial line
+			r, err := fp.newLineRange(rc, grp.start > 0 || fp.headerLen > 0, last.dOff+last.dSize-first.dOff)
+			if err != nil {
+				return err
+			}
+			return fp.processReader(ctx, r, first.dOff+r.pos, false)
+		})
+	}
+
+	return g.Wait()
+}
+
+// framesFrom The compressed file from frame i on.
+func (fp *FileProcessor) framesFrom(frames []frame, i int) io.Reader {
+	end := frames[len(frames)-1].off + frames[len(frames)-1].size
+
+	return io.NewSectionReader(fp.src, frames[i].off, end-frames[i].off)
+}
+
+// groupFrames Splits the frames into at most n contiguous groups of similar decompressed size.
+func groupFrames(frames []frame, n int) []frameGroup {
+	total := frames[len(frames)-1].dOff + frames[len(frames)-1].dSize
+	n = max(min(n, len(frames)), 1)
+	groups := make([]frameGroup, 0, n)
+	start := 0
+	for i := range frames {
+		// close the group once it reaches its share of the decompressed size
+		target := total * int64(len(groups)+1) / int64(n)
+		if frames[i].dOff+frames[i].dSize >= target || i == len(frames)-1 {
+			groups = append(groups, frameGroup{start: start, end: i + 1})
+			start = i + 1
+		}
+	}
+
+	return groups
+}
+
+// lineRange Records of a decompressed range of limit bytes the same way shards split a file:
+// a range owns the records whose preceding terminator starts inside it(plus the first record of the first range),
+// so the partial first record is skipped and the last record is read past the limit up to its terminator.
+type lineRange struct {
+	rr    *recordReader
+	pos   int64 // relative to the range start
+	limit int64
+	rest  []byte // unread bytes of the current record
+	done  bool
+	long  bool // rest is a piece of a record longer than the buffer
+	err   error
+}
+
+func (fp *FileProcessor) newLineRange(r io.Reader, skipFirst bool, limit int64) (*lineRange, error) {
+	lr := &lineRange{rr: fp.newRecordReader(r), limit: limit}
+	if !skipFirst {
+		return lr, nil
+	}
+	for {
+		rec, ended, err := lr.next()
+		if err == io.EOF {
+			lr.done = true
+			return lr, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lr.rest = nil
+		if ended {
+			// the skipped record ended in the next range, so does everything after it
+			lr.done = lr.pos-int64(lr.rr.termLen(rec)) >= limit
+			return lr, nil
+		}
+	}
+}
+
+// next Reads the next record into rest, a record longer than the buffer is read in pieces(ended - its last one)
+// and left to the reader of the range to reject.
+func (lr *lineRange) next() (rec []byte, ended bool, err error) {
+	if lr.long {
+		rec, ended, err = lr.rr.piece()
+	} else if rec, err = lr.rr.read(); errors.Is(err, bufio.ErrBufferFull) {
+		rec, ended, err = lr.rr.piece()
+	} else {
+		ended = true
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	lr.long = !ended
+	lr.pos += int64(len(rec))
+	lr.rest = rec
+
+	return rec, ended, nil
+}
+
+func (lr *lineRange) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(lr.rest) == 0 {
+			if lr.done || lr.err != nil {
+				break
+			}
+			rec, ended, err := lr.next()
+			if err != nil {
+				lr.err = err
+				break
+			}
+			// the record terminated in the next range is the last one
+			lr.done = ended && lr.pos-int64(lr.rr.termLen(rec)) >= lr.limit
+		}
+		k := copy(p[n:], lr.rest)
+		lr.rest = lr.rest[k:]
+		n += k
+	}
+	if n > 0 {
+		return n, nil
+	}
+	if lr.err != nil {
+		return 0, lr.err
+	}
+
+	return 0, io.EOF
+}
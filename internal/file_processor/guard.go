@@ -0,0 +1,78 @@
+package file_processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrBinary returned when the head of a text input looks like a binary file(a tarball, an image), see WithForce.
+var ErrBinary = errors.New("input looks binary")
+
+const (
+	headSample    = 64 << 10 // bytes of the head checked for binary content
+	binaryPercent = 5        // NUL and control bytes above this share of the head make an input binary
+	nulPadding    = 64       // a NUL run of this length is padding(holes, preallocated space), not content
+)
+
+// WithForce Counts inputs whose head looks binary instead of failing with ErrBinary.
+func WithForce(on bool) Option {
+	return func(fp *FileProcessor) { fp.force = on }
+}
+
+// checkSource Checks the head of a random access source, see checkText.
+func (fp *FileProcessor) checkSource(src io.ReaderAt, size int64) error {
+	if fp.force {
+		return nil
+	}
+	head := make([]byte, min(size, headSample))
+	n, err := src.ReadAt(head, 0)
+	if n < len(head) && err != nil {
+		return err
+	}
+
+	return fp.checkText(head)
+}
+
+// checkText Refuses a head with a high share of NUL and control bytes other than whitespace and the
+// record delimiter(NUL of -0), which would be counted for hours to a meaningless 0. Long NUL runs are
+// left out: the holes of a copytruncate rotated log are not binary content.
+func (fp *FileProcessor) checkText(head []byte) error {
+	if fp.force {
+		return nil
+	}
+	bad, content := 0, 0
+	for i := 0; i < len(head); i++ {
+		c := head[i]
+		if c == 0 {
+			j := i
+			for j < len(head) && head[j] == 0 {
+				j++
+			}
+			if j-i >= nulPadding {
+				i = j - 1
+				continue
+			}
+		}
+		content++
+		if isControl(c) && bytes.IndexByte(fp.delim, c) < 0 {
+			bad++
+		}
+	}
+	if bad*100 > content*binaryPercent {
+		return fmt.Errorf("%w: %d of the first %d bytes are NUL or control characters", ErrBinary, bad, len(head))
+	}
+
+	return nil
+}
+
+// isControl Reports whether c is an ASCII control character other than whitespace.
+func isControl(c byte) bool {
+	switch c {
+	case '\t', '\n', '\v', '\f', '\r':
+		return false
+	}
+
+	return c < 0x20 || c == 0x7f
+}
@@ -0,0 +1,205 @@
+package file_processor
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type (
+	// LookupFunc Resolves a hostname to its IPv4 addresses.
+	LookupFunc func(ctx context.Context, host string) ([]netip.Addr, error)
+	// Resolver Resolves the hostnames of inputs for WithResolver: at most concurrency lookups at a time,
+	// each bounded by timeout, every name is looked up once per run(a failure too) and cached.
+	Resolver struct {
+		lookup      LookupFunc
+		concurrency int
+		timeout     time.Duration
+
+		mu    sync.Mutex
+		cache map[string]resolved
+	}
+	resolved struct {
+		addrs []netip.Addr
+		err   error
+	}
+	// hostnames Lines of every hostname token of an input, resolved once the input is read.
+	hostnames struct {
+		mu    sync.Mutex
+		lines map[string]uint64
+	}
+)
+
+// NewResolver nil lookup - the A records of the system resolver.
+func NewResolver(lookup LookupFunc, concurrency int, timeout time.Duration) *Resolver {
+	if lookup == nil {
+		lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+		}
+	}
+
+	return &Resolver{lookup: lookup, concurrency: max(concurrency, 1), timeout: timeout, cache: make(map[string]resolved)}
+}
+
+// WithResolver Tokens that are hostnames(allowlist.example.com) are collected instead of rejected,
+// ResolveHostnames counts their A records once the input is read.
+func WithResolver(r *Resolver) Option {
+	return func(fp *FileProcessor) {
+		fp.resolver = r
+		if r != nil {
+			fp.hosts = &hostnames{lines: make(map[string]uint64)}
+		}
+	}
+}
+
+// addHostname Collects tok when it is a hostname under WithResolver.
+func (fp *FileProcessor) addHostname(tok []byte) bool {
+	if fp.hosts == nil || !isHostname(tok) {
+		return false
+	}
+	name := strings.ToLower(strings.TrimSuffix(string(tok), "."))
+	fp.hosts.mu.Lock()
+	fp.hosts.lines[name]++
+	fp.hosts.mu.Unlock()
+
+	return true
+}
+
+// ResolveHostnames Resolves the hostnames collected so far and counts their addresses, the lines of a name
+// without any A record are invalid. Returns the resolved and the failed names.
+func (fp *FileProcessor) ResolveHostnames(ctx context.Context) (names, failed int, err error) {
+	if fp.hosts == nil {
+		return 0, 0, nil
+	}
+	fp.hosts.mu.Lock()
+	lines := fp.hosts.lines
+	fp.hosts.lines = make(map[string]uint64)
+	fp.hosts.mu.Unlock()
+
+	list := make([]string, 0, len(lines))
+	for name := range lines {
+		list = append(list, name)
+	}
+	res, err := fp.resolver.resolve(ctx, list)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var localUniq, invalid uint64
+	defer func() {
+		fp.bitset.AddUnique(localUniq)
+		fp.invalid.add(invalid)
+	}()
+	for _, name := range list {
+		r := res[name]
+		if r.err != nil || len(r.addrs) == 0 {
+			failed++
+			invalid += lines[name]
+			fp.invalid.observe([]byte(name), invalid)
+			continue
+		}
+		names++
+		for _, a := range r.addrs {
+			b := a.Unmap().As4()
+			if err = fp.add(binary.BigEndian.Uint32(b[:]), &localUniq); err != nil {
+				return names, failed, err
+			}
+		}
+	}
+
+	return names, failed, nil
+}
+
+// resolve Looks up the names that are not cached yet, concurrency at a time.
+func (r *Resolver) resolve(ctx context.Context, names []string) (map[string]resolved, error) {
+	out := make(map[string]resolved, len(names))
+	var todo []string
+	r.mu.Lock()
+	for _, name := range names {
+		if res, ok := r.cache[name]; ok {
+			out[name] = res
+		} else {
+			todo = append(todo, name)
+		}
+	}
+	r.mu.Unlock()
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(r.concurrency)
+	for _, name := range todo {
+		g.Go(func() error {
+			lctx, cancel := context.WithTimeout(gctx, r.timeout)
+			addrs, err := r.lookup(lctx, name)
+			cancel()
+			if gctx.Err() != nil {
+				// canceled, not a name to remember as failed
+				return gctx.Err()
+			}
+			r.mu.Lock()
+			r.cache[name] = resolved{addrs: ipv4Only(addrs), err: err}
+			out[name] = r.cache[name]
+			r.mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func ipv4Only(addrs []netip.Addr) []netip.Addr {
+	out := addrs[:0]
+	for _, a := range addrs {
+		if a.Unmap().Is4() {
+			out = append(out, a)
+		}
+	}
+
+	return out
+}
+
+// isHostname Reports whether tok is a dotted hostname(an optional trailing dot) whose last label is not numeric,
+// so neither malformed addresses nor random words are looked up.
+func isHostname(tok []byte) bool {
+	if n := len(tok); n > 0 && tok[n-1] == '.' {
+		tok = tok[:n-1]
+	}
+	if len(tok) == 0 || len(tok) > 253 {
+		return false
+	}
+	var (
+		labels, start int
+		letter        bool // in the last label
+	)
+	for i := 0; i <= len(tok); i++ {
+		if i == len(tok) || tok[i] == '.' {
+			label := tok[start:i]
+			if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+				return false
+			}
+			labels++
+			start = i + 1
+			if i < len(tok) {
+				letter = false
+			}
+			continue
+		}
+		switch c := tok[i] | 0x20; {
+		case c >= 'a' && c <= 'z':
+			letter = true
+		case tok[i] >= '0' && tok[i] <= '9', tok[i] == '-':
+		default:
+			return false
+		}
+	}
+
+	return labels > 1 && letter
+}
@@ -0,0 +1,17 @@
+package file_processor
+
+import "unique-ip-counter/internal/ipv4_bitset"
+
+// WithIntegerAddresses Also accepts addresses written as a single decimal(3232235521) or hex(0xC0A80001) integer.
+func WithIntegerAddresses(on bool) Option {
+	return func(fp *FileProcessor) { fp.intAddr = on }
+}
+
+// parse The address of a token: dotted, or an integer under WithIntegerAddresses.
+func (fp *FileProcessor) parse(tok []byte) (uint32, bool) {
+	if u32, ok := fp.dotted(tok); ok || !fp.intAddr {
+		return u32, ok
+	}
+
+	return ipv4_bitset.IntegerToUint32(tok)
+}
@@ -0,0 +1,115 @@
+package file_processor
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// maxInvalidSample Longer invalid lines are truncated, keeps the reservoir memory bounded.
+const maxInvalidSample = 256
+
+// ErrInvalidRatio returned when the share of invalid lines exceeds WithMaxInvalidPct.
+var ErrInvalidRatio = errors.New("too many invalid lines")
+
+// minRatioLines Lines read before WithMaxInvalidPct aborts mid-input, a noisy head alone does not.
+const minRatioLines = 100_000
+
+// invalidSamples Invalid line counter and a reservoir of up to k distinct examples,
+// shared by every shard(and source) of a processor. Shards count invalid lines locally
+// and publish them with add, the same way as their uniques(AddUnique).
+type invalidSamples struct {
+	k     int
+	count atomic.Uint64
+	read  atomic.Uint64 // every record read, the base of the ratio
+
+	mu    sync.Mutex
+	lines []string
+}
+
+// WithInvalidSamples Keeps up to k distinct invalid lines(reservoir sampling over the whole input), 0 - disabled.
+func WithInvalidSamples(k int) Option {
+	return func(fp *FileProcessor) { fp.invalid.k = max(k, 0) }
+}
+
+// WithMaxInvalidPct Stops with ErrInvalidRatio once over pct percent of the lines are invalid: checked every
+// 256KB of a shard past minRatioLines lines and by CheckInvalidRatio, 0 - disabled.
+func WithMaxInvalidPct(pct float64) Option {
+	return func(fp *FileProcessor) { fp.maxInvalidPct = max(pct, 0) }
+}
+
+// CheckInvalidRatio ErrInvalidRatio when over the WithMaxInvalidPct share of the lines read is invalid,
+// the final check of a completed input.
+func (fp *FileProcessor) CheckInvalidRatio() error { return fp.invalid.checkRatio(fp.maxInvalidPct, 1) }
+
+// checkRatio ErrInvalidRatio when over pct percent of at least minLines lines are invalid.
+func (s *invalidSamples) checkRatio(pct float64, minLines uint64) error {
+	lines := s.read.Load()
+	if pct == 0 || lines < minLines {
+		return nil
+	}
+	inv := s.count.Load()
+	if float64(inv)*100 <= pct*float64(lines) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %d of %d(%.2f%%), the limit is %g%%", ErrInvalidRatio, inv, lines, float64(inv)*100/float64(lines), pct)
+}
+
+// add Publishes n invalid lines counted by a shard.
+func (s *invalidSamples) add(n uint64) {
+	if n > 0 {
+		s.count.Add(n)
+	}
+}
+
+// observe Offers an invalid line to the reservoir, local is the count of the shard not published yet
+// (this line included). line is copied only when kept.
+func (s *invalidSamples) observe(line []byte, local uint64) {
+	if s.k == 0 {
+		return
+	}
+	// the i-th invalid line replaces a random sample with probability k/i,
+	// i lags behind by the unpublished counts of other shards
+	n := s.count.Load() + local
+	slot := -1
+	if n > uint64(s.k) {
+		if r := rand.Uint64N(n); r < uint64(s.k) {
+			slot = int(r)
+		} else {
+			return
+		}
+	}
+
+	if len(line) > maxInvalidSample {
+		line = line[:maxInvalidSample]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.lines {
+		if l == string(line) {
+			return
+		}
+	}
+	if slot < 0 || len(s.lines) < s.k {
+		s.lines = append(s.lines, string(line))
+		return
+	}
+	s.lines[slot] = string(line)
+}
+
+func (s *invalidSamples) samples() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.lines...)
+}
+
+// InvalidCount Lines that are not an address.
+func (fp *FileProcessor) InvalidCount() uint64 { return fp.invalid.count.Load() }
+
+// InvalidSamples Distinct examples of invalid lines kept by WithInvalidSamples.
+func (fp *FileProcessor) InvalidSamples() []string { return fp.invalid.samples() }
@@ -0,0 +1,62 @@
+package file_processor
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// InvalidWriter Buffered "source<TAB>offset<TAB>line number<TAB>line" writer of rejected lines, shared by every shard
+// and every input of a run. Records holding a line break(multi-line records of -d) are written quoted.
+type InvalidWriter struct {
+	mu  sync.Mutex
+	bw  *bufio.Writer
+	buf []byte
+	err error // first write error, later lines are dropped
+}
+
+func NewInvalidWriter(w io.Writer) *InvalidWriter {
+	return &InvalidWriter{bw: bufio.NewWriterSize(w, 256<<10)}
+}
+
+// WithInvalidOut Writes every invalid line with its byte offset in the input(the decompressed text of
+// compressed inputs) to w, source names the input in the written lines.
+func WithInvalidOut(w *InvalidWriter, source string) Option {
+	return func(fp *FileProcessor) { fp.invalidOut, fp.invalidSource = w, source }
+}
+
+// write Writes the line at off, end is the offset after it(the base of an estimated line number).
+func (w *InvalidWriter) write(source string, off int64, lines *lineNumbers, end int64, line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	b := append(w.buf[:0], source...)
+	b = append(b, '\t')
+	b = strconv.AppendInt(b, off, 10)
+	b = append(b, '\t')
+	b = lines.append(b, end)
+	b = append(b, '\t')
+	if bytes.ContainsAny(line, "\r\n") {
+		b = strconv.AppendQuote(b, string(line))
+	} else {
+		b = append(b, line...)
+	}
+	b = append(b, '\n')
+	w.buf = b
+	_, w.err = w.bw.Write(b)
+}
+
+// Flush Writes the buffered lines, returns the first write error.
+func (w *InvalidWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.bw.Flush()
+}
@@ -0,0 +1,105 @@
+package file_processor
+
+import "unique-ip-counter/internal/ipv6_set"
+
+// addIPv6 Counts an IPv6 token, ok is false when tok is not one. The family is detected per record:
+// IPv6 addresses are kept in their own set outside the IPv4 bitset and count towards UniqueCount,
+// an IPv4-mapped address(::ffff:1.2.3.4) is its IPv4 address.
+func (fp *FileProcessor) addIPv6(tok []byte, localUniq *uint64) (bool, error) {
+	a, ok := ipv6_set.Parse(tok)
+	if !ok {
+		return false, nil
+	}
+	if u32, ok := a.IPv4(); ok {
+		return true, fp.add(u32, localUniq)
+	}
+	if fp.v6Spill != nil {
+		if fp.v6Prefixes != nil {
+			fp.v6Prefixes.Add(a)
+		}
+		return true, fp.v6Spill.Add(a)
+	}
+	if fp.v6.Add(a) {
+		if fp.v6Prefixes != nil {
+			// a known address has its prefix counted already
+			fp.v6Prefixes.Add(a)
+		}
+		return true, fp.count(1, localUniq)
+	}
+
+	return true, nil
+}
+
+// WithIPv6Prefix Also counts the distinct /bits prefixes(48, 64) of the IPv6 addresses, 0 - none.
+// Host level IPv6 counts are inflated by SLAAC and privacy addresses, a /64 is a subnet, a /48 a site.
+func WithIPv6Prefix(bits int) Option {
+	return func(fp *FileProcessor) {
+		fp.v6Prefixes = nil
+		if bits > 0 {
+			fp.v6Prefixes = ipv6_set.NewPrefixSet(bits)
+		}
+	}
+}
+
+// WithIPv6Spill IPv6 addresses go to sorted runs on disk instead of the in-memory set, MergeIPv6Spill
+// counts them once the input is read: the count stays exact while the memory is bounded by the run buffers.
+func WithIPv6Spill(s *ipv6_set.Spill) Option {
+	return func(fp *FileProcessor) { fp.v6Spill = s }
+}
+
+// MergeIPv6Spill Merges the runs of WithIPv6Spill and adds their distinct addresses to the unique count.
+func (fp *FileProcessor) MergeIPv6Spill() error {
+	if fp.v6Spill == nil {
+		return nil
+	}
+	n, err := fp.v6Spill.Count()
+	if err != nil {
+		return err
+	}
+	fp.v6Spilled += n
+	fp.bitset.AddUnique(n)
+
+	return nil
+}
+
+// CloseIPv6Spill Removes the runs a failed input left behind.
+func (fp *FileProcessor) CloseIPv6Spill() error {
+	if fp.v6Spill == nil {
+		return nil
+	}
+
+	return fp.v6Spill.Close()
+}
+
+// saturated Reports whether the unique count reached the ceiling. The default ceiling(full IPv4 coverage)
+// no longer applies once an IPv6 address is seen: a full IPv4 set says nothing about the IPv6 ones still to come.
+func (fp *FileProcessor) saturated() bool {
+	if fp.ceiling == fullCoverage && (fp.v6.Len() > 0 || fp.v6Spill != nil && fp.v6Spill.Added() > 0) {
+		return false
+	}
+
+	return fp.bitset.GetUniqueCount() >= fp.ceiling
+}
+
+// IPv6Count Distinct IPv6 addresses, included in UniqueCount. The spilled ones count once merged.
+func (fp *FileProcessor) IPv6Count() uint64 { return fp.v6.Len() + fp.v6Spilled }
+
+// IPv6PrefixCount Distinct IPv6 prefixes of WithIPv6Prefix and their length, 0, 0 without it.
+// Not included in UniqueCount.
+func (fp *FileProcessor) IPv6PrefixCount() (n uint64, bits int) {
+	if fp.v6Prefixes == nil {
+		return 0, 0
+	}
+
+	return fp.v6Prefixes.Len(), fp.v6Prefixes.Bits()
+}
+
+// IPv4Count Distinct IPv4 addresses, UniqueCount without the IPv6 addresses and the CIDRPrefix prefixes.
+func (fp *FileProcessor) IPv4Count() uint64 {
+	n := fp.UniqueCount() - fp.IPv6Count()
+	if fp.prefixes != nil {
+		n -= fp.prefixes.len()
+	}
+
+	return n
+}
@@ -0,0 +1,39 @@
+package file_processor
+
+import "strconv"
+
+// lineNumbers Line numbers of the records of a reader started at off of the input. Readers starting at the
+// input start(or right after its header) count exactly, the others(shards, appended ranges) do not know how many
+// lines come before them and estimate it from the average record length they have read so far.
+type lineNumbers struct {
+	start  int64  // offset of the reader in the input
+	before uint64 // lines before start, when exact
+	exact  bool
+	n      uint64 // records read so far, the current one included
+}
+
+func (fp *FileProcessor) newLineNumbers(off int64) lineNumbers {
+	switch {
+	case off == 0:
+		return lineNumbers{exact: true}
+	case off == fp.headerLen:
+		return lineNumbers{start: off, before: 1, exact: true}
+	}
+
+	return lineNumbers{start: off}
+}
+
+// append Appends the 1-based number of the current record ending at end, "~" marks an estimate.
+func (l *lineNumbers) append(b []byte, end int64) []byte {
+	if l.exact {
+		return strconv.AppendUint(b, l.before+l.n, 10)
+	}
+	before := uint64(0)
+	if read := end - l.start; read > 0 {
+		before = uint64(float64(l.start) * float64(l.n) / float64(read))
+	}
+
+	return strconv.AppendUint(append(b, '~'), before+l.n, 10)
+}
+
+func (l *lineNumbers) String(end int64) string { return string(l.append(nil, end)) }
@@ -0,0 +1,21 @@
+package file_processor
+
+// readerSize Buffer of a record reader, also the longest record without WithMaxLineBytes.
+const readerSize = 2 << 20
+
+// WithMaxLineBytes Records longer than n bytes(terminator and "\r" excluded) are skipped as invalid lines as soon as
+// n bytes are buffered without a terminator, bounding the memory of inputs with embedded binary blobs.
+// 0 - the reader buffer(2MB), a larger n grows the buffer.
+func WithMaxLineBytes(n int) Option {
+	return func(fp *FileProcessor) { fp.maxLine = max(n, 0) }
+}
+
+// lineLimit The longest record and the reader buffer it needs.
+func (fp *FileProcessor) lineLimit() (limit, size int) {
+	if fp.maxLine == 0 {
+		return readerSize, readerSize
+	}
+
+	// room for the terminator and a '\r' of a record of the limit
+	return fp.maxLine, max(readerSize, fp.maxLine+len(fp.delim)+2)
+}
@@ -0,0 +1,40 @@
+package file_processor
+
+import "errors"
+
+// ErrUniqueLimit returned when processing is stopped by WithStopAfterUniques.
+var ErrUniqueLimit = errors.New("unique limit reached")
+
+// ErrSaturated returned when the unique count reaches the saturation ceiling,
+// further reading cannot change the answer.
+var ErrSaturated = errors.New("unique set saturated")
+
+// fullCoverage every possible IPv4 address is set
+const fullCoverage = uint64(1) << 32
+
+// Option Optional FileProcessor behaviour.
+type Option func(*FileProcessor)
+
+// WithStopAfterUniques Cancels processing with ErrUniqueLimit once n uniques are seen, 0 - disabled.
+func WithStopAfterUniques(n uint64) Option {
+	return func(fp *FileProcessor) { fp.stopAfter = n }
+}
+
+// WithDelimiter Splits records at d as well as at line breaks(e.g. NUL of find -print0, ","), a delimiter
+// holding a line break("\r\n\r\n") splits multi-line records. A trailing "\r\n" is trimmed anyway.
+func WithDelimiter(d string) Option {
+	return func(fp *FileProcessor) {
+		if d != "" {
+			fp.delim = []byte(d)
+		}
+	}
+}
+
+// WithSaturationCeiling Stops with ErrSaturated once n uniques are seen, 0 - full IPv4 coverage(2^32).
+func WithSaturationCeiling(n uint64) Option {
+	return func(fp *FileProcessor) {
+		if n > 0 {
+			fp.ceiling = n
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package file_processor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrBadParquet returned on a malformed or unsupported Parquet file.
+var ErrBadParquet = errors.New("bad parquet")
+
+// zstdDecoder shared, DecodeAll is safe for concurrent use
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+
+// WithParquetColumn Column(dotted path for nested groups) of .parquet inputs holding the address.
+func WithParquetColumn(name string) Option {
+	return func(fp *FileProcessor) { fp.parquetCol = name }
+}
+
+// ProcessParquet Counts the addresses of a column of a Parquet file, row groups are the shards:
+// every row group's column chunk is decoded by its own goroutine, at most th at a time.
+// The column may hold text(BYTE_ARRAY), numeric(INT32/INT64) or 4 byte binary addresses.
+func (fp *FileProcessor) ProcessParquet(ctx context.Context, fi os.FileInfo) error {
+	if fp.parquetCol == "" {
+		return fmt.Errorf("parquet input needs a column name")
+	}
+	meta, err := readParquetMeta(fp.src, fi.Size())
+	if err != nil {
+		return err
+	}
+	leaf, err := meta.leaf(fp.parquetCol)
+	if err != nil {
+		return err
+	}
+	if leaf.maxRep > 0 {
+		return fmt.Errorf("%w: repeated column %q is not supported", ErrBadParquet, leaf.path)
+	}
+
+	var chunks []pqColumnChunk
+	var total int64
+	for _, g := range meta.rowGroups {
+		for _, c := range g.columns {
+			if c.path == leaf.path {
+				chunks = append(chunks, c)
+				total += c.totalCompressed
+			}
+		}
+	}
+	fp.logger.Info("processing parquet column",
+		zap.String("column", leaf.path), zap.Int("row_groups", len(chunks)))
+	defer fp.progress.Run(total)()
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(fp.th, 1))
+	for _, c := range chunks {
+		g.Go(func() error {
+			return fp.processColumnChunk(ctx, leaf, c)
+		})
+	}
+
+	return g.Wait()
+}
+
+// processColumnChunk Decodes the pages of a column chunk: an optional dictionary page
+// followed by v1/v2 data pages in PLAIN or dictionary encoding.
+func (fp *FileProcessor) processColumnChunk(ctx context.Context, leaf pqLeaf, c pqColumnChunk) error {
+	start := c.dataPageOffset
+	if c.hasDictPage && c.dictPageOffset > 0 && c.dictPageOffset < start {
+		start = c.dictPageOffset
+	}
+	r := bufio.NewReaderSize(io.NewSectionReader(fp.src, start, c.totalCompressed), 1<<20)
+	t := &thriftReader{r: r}
+
+	var (
+		dict     []uint32
+		dictOK   []bool
+		levels   []uint32
+		values   []uint32
+		localUnq uint64
+		raw      []byte
+	)
+	defer func() { fp.bitset.AddUnique(localUnq) }()
+	// the counting path of processReader, its first error(a unique limit) stops the page
+	var addErr error
+	add := func(u32 uint32) {
+		if addErr == nil {
+			addErr = fp.add(u32, &localUnq)
+		}
+	}
+
+	for read := int64(0); read < c.numValues; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		h := t.pageHeader()
+		if t.err != nil {
+			return t.err
+		}
+		if h.compressedSize < 0 || h.uncompressedSize < 0 {
+			return fmt.Errorf("%w: negative page size", ErrBadParquet)
+		}
+		raw = grow(raw, int(h.compressedSize))
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return fmt.Errorf("%w: page: %v", ErrBadParquet, err)
+		}
+		fp.progress.AddBytes(int64(h.compressedSize))
+
+		switch h.typ {
+		case pqDictPage:
+			page, err := decompress(c.codec, raw, int(h.uncompressedSize))
+			if err != nil {
+				return err
+			}
+			dict, dictOK = make([]uint32, h.numValues), make([]bool, h.numValues)
+			i := 0
+			if err = fp.plainValues(leaf, page, int(h.numValues), func(u32 uint32, ok bool) {
+				dict[i], dictOK[i] = u32, ok
+				i++
+			}); err != nil {
+				return err
+			}
+			continue
+
+		case pqDataPage, pqDataPageV2:
+		default:
+			continue // index pages
+		}
+
+		n := int(h.numValues)
+		read += int64(n)
+
+		var (
+			page []byte
+			err  error
+		)
+		nonNull := n
+		if h.typ == pqDataPage {
+			if page, err = decompress(c.codec, raw, int(h.uncompressedSize)); err != nil {
+				return err
+			}
+			if leaf.maxDef > 0 {
+				if len(page) < 4 {
+					return fmt.Errorf("%w: short page", ErrBadParquet)
+				}
+				l := int(binary.LittleEndian.Uint32(page))
+				if l > len(page)-4 {
+					return fmt.Errorf("%w: definition levels of %d bytes", ErrBadParquet, l)
+				}
+				levels = grow32(levels, n)
+				if err = decodeHybrid(page[4:4+l], bits.Len(uint(leaf.maxDef)), levels); err != nil {
+					return err
+				}
+				nonNull = countLevel(levels, uint32(leaf.maxDef))
+				page = page[4+l:]
+			}
+		} else {
+			lvl := int(h.repLevelsLen) + int(h.defLevelsLen)
+			if h.repLevelsLen < 0 || h.defLevelsLen < 0 || lvl > len(raw) {
+				return fmt.Errorf("%w: levels of %d bytes", ErrBadParquet, lvl)
+			}
+			if leaf.maxDef > 0 {
+				levels = grow32(levels, n)
+				if err = decodeHybrid(raw[h.repLevelsLen:lvl], bits.Len(uint(leaf.maxDef)), levels); err != nil {
+					return err
+				}
+				nonNull = countLevel(levels, uint32(leaf.maxDef))
+			}
+			page = raw[lvl:]
+			if h.compressed {
+				if page, err = decompress(c.codec, page, int(h.uncompressedSize)-lvl); err != nil {
+					return err
+				}
+			}
+		}
+
+		switch h.encoding {
+		case pqPlain:
+			err = fp.plainValues(leaf, page, nonNull, func(u32 uint32, ok bool) {
+				if ok {
+					add(u32)
+				}
+			})
+		case pqPlainDict, pqRLEDictionary:
+			if len(page) < 1 {
+				return fmt.Errorf("%w: empty dictionary indices", ErrBadParquet)
+			}
+			values = grow32(values, nonNull)
+			if err = decodeHybrid(page[1:], int(page[0]), values); err != nil {
+				return err
+			}
+			for _, idx := range values {
+				if int(idx) >= len(dict) {
+					return fmt.Errorf("%w: dictionary index %d out of %d", ErrBadParquet, idx, len(dict))
+				}
+				if dictOK[idx] {
+					add(dict[idx])
+				}
+				if addErr != nil {
+					break
+				}
+			}
+		default:
+			return fmt.Errorf("%w: unsupported encoding %d of column %q", ErrBadParquet, h.encoding, leaf.path)
+		}
+		if err == nil {
+			err = addErr
+		}
+		if err != nil {
+			return err
+		}
+		fp.progress.AddRecords(int64(nonNull))
+
+		// publish uniques every page to detect saturation
+		fp.bitset.AddUnique(localUnq)
+		localUnq = 0
+		if fp.saturated() {
+			return ErrSaturated
+		}
+	}
+
+	return nil
+}
+
+// plainValues Decodes n PLAIN values of the leaf type into addresses, ok=false for values
+// which are not IPv4 addresses.
+func (fp *FileProcessor) plainValues(leaf pqLeaf, b []byte, n int, fn func(u32 uint32, ok bool)) error {
+	for i := 0; i < n; i++ {
+		switch leaf.typ {
+		case pqByteArray:
+			if len(b) < 4 {
+				return fmt.Errorf("%w: short byte array", ErrBadParquet)
+			}
+			l := int(binary.LittleEndian.Uint32(b))
+			if l > len(b)-4 {
+				return fmt.Errorf("%w: byte array of %d bytes", ErrBadParquet, l)
+			}
+			fn(fp.parse(bytes.TrimSpace(b[4 : 4+l])))
+			b = b[4+l:]
+		case pqInt32:
+			if len(b) < 4 {
+				return fmt.Errorf("%w: short int32", ErrBadParquet)
+			}
+			fn(binary.LittleEndian.Uint32(b), true)
+			b = b[4:]
+		case pqInt64:
+			if len(b) < 8 {
+				return fmt.Errorf("%w: short int64", ErrBadParquet)
+			}
+			v := int64(binary.LittleEndian.Uint64(b))
+			fn(uint32(v), v >= 0 && v <= math.MaxUint32)
+			b = b[8:]
+		case pqFixedLen:
+			l := int(leaf.typeLength)
+			if l <= 0 || len(b) < l {
+				return fmt.Errorf("%w: short fixed length value", ErrBadParquet)
+			}
+			v := b[:l]
+			switch {
+			case l == 4:
+				fn(binary.BigEndian.Uint32(v), true)
+			case l == 16 && bytes.Equal(v[:12], v4InV6Prefix):
+				fn(binary.BigEndian.Uint32(v[12:]), true)
+			case l == 16:
+				fn(0, false)
+			default:
+				fn(fp.parse(bytes.TrimSpace(v)))
+			}
+			b = b[l:]
+		default:
+			return fmt.Errorf("%w: unsupported physical type %d of column %q", ErrBadParquet, leaf.typ, leaf.path)
+		}
+	}
+
+	return nil
+}
+
+var v4InV6Prefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+
+// decodeHybrid Decodes len(dst) values of the RLE/bit-packed hybrid encoding.
+func decodeHybrid(b []byte, width int, dst []uint32) error {
+	if width < 0 || width > 32 {
+		return fmt.Errorf("%w: bit width %d", ErrBadParquet, width)
+	}
+	byteWidth := (width + 7) / 8
+	for i := 0; i < len(dst); {
+		h, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("%w: truncated hybrid run", ErrBadParquet)
+		}
+		b = b[n:]
+
+		if h&1 == 0 { // RLE run
+			if len(b) < byteWidth {
+				return fmt.Errorf("%w: truncated rle value", ErrBadParquet)
+			}
+			var v uint32
+			for k := 0; k < byteWidth; k++ {
+				v |= uint32(b[k]) << (8 * k)
+			}
+			b = b[byteWidth:]
+			for cnt := h >> 1; cnt > 0 && i < len(dst); cnt-- {
+				dst[i] = v
+				i++
+			}
+			continue
+		}
+
+		// bit-packed groups of 8 values, LSB first
+		groups := int(h >> 1)
+		size := groups * width
+		if size > len(b) {
+			size = len(b) // the last run may be cut short
+		}
+		packed := b[:size]
+		b = b[size:]
+		mask := uint64(1)<<width - 1
+		for k := 0; k < groups*8 && i < len(dst); k++ {
+			bit := k * width
+			var v uint64
+			for j := 0; j < (width+7+bit%8)/8; j++ {
+				if idx := bit/8 + j; idx < len(packed) {
+					v |= uint64(packed[idx]) << (8 * j)
+				}
+			}
+			dst[i] = uint32(v >> (bit % 8) & mask)
+			i++
+		}
+	}
+
+	return nil
+}
+
+func countLevel(levels []uint32, max uint32) int {
+	n := 0
+	for _, l := range levels {
+		if l == max {
+			n++
+		}
+	}
+
+	return n
+}
+
+func decompress(codec int32, b []byte, size int) ([]byte, error) {
+	switch codec {
+	case pqUncompressed:
+		return b, nil
+	case pqSnappy:
+		return snappy.Decode(nil, b)
+	case pqGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	case pqZstd:
+		d, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return d.DecodeAll(b, make([]byte, 0, size))
+	case pqLz4Raw:
+		out := make([]byte, size)
+		n, err := lz4.UncompressBlock(b, out)
+		return out[:n], err
+	default:
+		return nil, fmt.Errorf("%w: unsupported compression codec %d", ErrBadParquet, codec)
+	}
+}
+
+func grow32(b []uint32, n int) []uint32 {
+	if cap(b) < n {
+		return make([]uint32, n)
+	}
+
+	return b[:n]
+}
@@ -0,0 +1,287 @@
+package file_processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parquet physical types, repetitions, page types, encodings and codecs
+// https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+const (
+	pqInt32     = 1
+	pqInt64     = 2
+	pqByteArray = 6
+	pqFixedLen  = 7
+
+	pqRequired = 0
+	pqOptional = 1
+	pqRepeated = 2
+
+	pqDataPage   = 0
+	pqDictPage   = 2
+	pqDataPageV2 = 3
+
+	pqPlain          = 0
+	pqPlainDict      = 2
+	pqRLE            = 3
+	pqRLEDictionary  = 8
+	pqUncompressed   = 0
+	pqSnappy         = 1
+	pqGzip           = 2
+	pqZstd           = 6
+	pqLz4Raw         = 7
+	parquetMagic     = "PAR1"
+	parquetFooterLen = 8
+)
+
+type (
+	pqSchemaElement struct {
+		typ         int32
+		typeLength  int32
+		repetition  int32
+		name        string
+		numChildren int32
+	}
+	// pqLeaf Resolved leaf column of the schema.
+	pqLeaf struct {
+		path       string // dotted path
+		typ        int32
+		typeLength int32
+		maxDef     int
+		maxRep     int
+	}
+	pqColumnChunk struct {
+		path            string
+		codec           int32
+		numValues       int64
+		totalCompressed int64
+		dataPageOffset  int64
+		dictPageOffset  int64
+		hasDictPage     bool
+	}
+	pqRowGroup struct {
+		columns []pqColumnChunk
+		numRows int64
+	}
+	pqFileMeta struct {
+		schema    []pqSchemaElement
+		rowGroups []pqRowGroup
+	}
+	pqPageHeader struct {
+		typ              int32
+		uncompressedSize int32
+		compressedSize   int32
+
+		numValues int32
+		encoding  int32
+
+		// v2 only
+		defLevelsLen int32
+		repLevelsLen int32
+		compressed   bool
+	}
+)
+
+// readParquetMeta Reads the footer: ... | FileMetaData | uint32 len | "PAR1".
+func readParquetMeta(src io.ReaderAt, size int64) (*pqFileMeta, error) {
+	if size < 4+parquetFooterLen {
+		return nil, fmt.Errorf("%w: file is too small", ErrBadParquet)
+	}
+	tail := make([]byte, parquetFooterLen)
+	if _, err := src.ReadAt(tail, size-parquetFooterLen); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != parquetMagic {
+		return nil, fmt.Errorf("%w: magic %q", ErrBadParquet, tail[4:])
+	}
+	n := int64(binary.LittleEndian.Uint32(tail))
+	if n <= 0 || n > size-4-parquetFooterLen {
+		return nil, fmt.Errorf("%w: footer of %d bytes", ErrBadParquet, n)
+	}
+	buf := make([]byte, n)
+	if _, err := src.ReadAt(buf, size-parquetFooterLen-n); err != nil {
+		return nil, err
+	}
+
+	t := &thriftReader{r: bytes.NewReader(buf)}
+	m := &pqFileMeta{}
+	t.structFields(func(id int16, typ byte) {
+		switch {
+		case id == 2 && typ == tList:
+			t.list(func(byte) { m.schema = append(m.schema, t.schemaElement()) })
+		case id == 4 && typ == tList:
+			t.list(func(byte) { m.rowGroups = append(m.rowGroups, t.rowGroup()) })
+		default:
+			t.skip(typ)
+		}
+	})
+
+	return m, t.err
+}
+
+func (t *thriftReader) schemaElement() pqSchemaElement {
+	var e pqSchemaElement
+	t.structFields(func(id int16, typ byte) {
+		switch {
+		case id == 1 && typ == tI32:
+			e.typ = t.i32()
+		case id == 2 && typ == tI32:
+			e.typeLength = t.i32()
+		case id == 3 && typ == tI32:
+			e.repetition = t.i32()
+		case id == 4 && typ == tBinary:
+			e.name = t.string()
+		case id == 5 && typ == tI32:
+			e.numChildren = t.i32()
+		default:
+			t.skip(typ)
+		}
+	})
+
+	return e
+}
+
+func (t *thriftReader) rowGroup() pqRowGroup {
+	var g pqRowGroup
+	t.structFields(func(id int16, typ byte) {
+		switch {
+		case id == 1 && typ == tList:
+			t.list(func(byte) { g.columns = append(g.columns, t.columnChunk()) })
+		case id == 3 && typ == tI64:
+			g.numRows = t.i64()
+		default:
+			t.skip(typ)
+		}
+	})
+
+	return g
+}
+
+func (t *thriftReader) columnChunk() pqColumnChunk {
+	var c pqColumnChunk
+	t.structFields(func(id int16, typ byte) {
+		if id != 3 || typ != tStruct {
+			t.skip(typ)
+			return
+		}
+		// ColumnMetaData
+		t.structFields(func(id int16, typ byte) {
+			switch {
+			case id == 3 && typ == tList:
+				var path []string
+				t.list(func(byte) { path = append(path, t.string()) })
+				c.path = strings.Join(path, ".")
+			case id == 4 && typ == tI32:
+				c.codec = t.i32()
+			case id == 5 && typ == tI64:
+				c.numValues = t.i64()
+			case id == 7 && typ == tI64:
+				c.totalCompressed = t.i64()
+			case id == 9 && typ == tI64:
+				c.dataPageOffset = t.i64()
+			case id == 11 && typ == tI64:
+				c.dictPageOffset, c.hasDictPage = t.i64(), true
+			default:
+				t.skip(typ)
+			}
+		})
+	})
+
+	return c
+}
+
+func (t *thriftReader) pageHeader() pqPageHeader {
+	h := pqPageHeader{compressed: true}
+	t.structFields(func(id int16, typ byte) {
+		switch {
+		case id == 1 && typ == tI32:
+			h.typ = t.i32()
+		case id == 2 && typ == tI32:
+			h.uncompressedSize = t.i32()
+		case id == 3 && typ == tI32:
+			h.compressedSize = t.i32()
+		case (id == 5 || id == 7) && typ == tStruct:
+			// DataPageHeader, DictionaryPageHeader: num_values, encoding, ...
+			t.structFields(func(id int16, typ byte) {
+				switch {
+				case id == 1 && typ == tI32:
+					h.numValues = t.i32()
+				case id == 2 && typ == tI32:
+					h.encoding = t.i32()
+				default:
+					t.skip(typ)
+				}
+			})
+		case id == 8 && typ == tStruct:
+			t.structFields(func(id int16, typ byte) {
+				switch {
+				case id == 1 && typ == tI32:
+					h.numValues = t.i32()
+				case id == 4 && typ == tI32:
+					h.encoding = t.i32()
+				case id == 5 && typ == tI32:
+					h.defLevelsLen = t.i32()
+				case id == 6 && typ == tI32:
+					h.repLevelsLen = t.i32()
+				case id == 7 && (typ == tTrue || typ == tFalse):
+					h.compressed = t.bool(typ)
+				default:
+					t.skip(typ)
+				}
+			})
+		default:
+			t.skip(typ)
+		}
+	})
+
+	return h
+}
+
+// leaf Resolves a column by its dotted path(or the name of a top-level column).
+func (m *pqFileMeta) leaf(path string) (pqLeaf, error) {
+	if len(m.schema) == 0 {
+		return pqLeaf{}, fmt.Errorf("%w: empty schema", ErrBadParquet)
+	}
+
+	var (
+		found  *pqLeaf
+		leaves []string
+		pos    = 1 // schema[0] is the root
+	)
+	var walk func(prefix string, n int32, def, rep int)
+	walk = func(prefix string, n int32, def, rep int) {
+		for i := int32(0); i < n && pos < len(m.schema); i++ {
+			e := m.schema[pos]
+			pos++
+			d, r := def, rep
+			switch e.repetition {
+			case pqOptional:
+				d++
+			case pqRepeated:
+				d, r = d+1, r+1
+			}
+			p := e.name
+			if prefix != "" {
+				p = prefix + "." + e.name
+			}
+			if e.numChildren > 0 {
+				walk(p, e.numChildren, d, r)
+				continue
+			}
+			leaves = append(leaves, p)
+			if p == path {
+				found = &pqLeaf{path: p, typ: e.typ, typeLength: e.typeLength, maxDef: d, maxRep: r}
+			}
+		}
+	}
+	walk("", m.schema[0].numChildren, 0, 0)
+
+	if found == nil {
+		return pqLeaf{}, fmt.Errorf("parquet column %q not found, columns: %s", path, strings.Join(leaves, ", "))
+	}
+
+	return *found, nil
+}
@@ -0,0 +1,426 @@
+package file_processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// thriftWriter Minimal compact protocol encoder to build test files.
+type thriftWriter struct {
+	bytes.Buffer
+	last []int16
+}
+
+func (w *thriftWriter) begin() { w.last = append(w.last, 0) }
+func (w *thriftWriter) end() {
+	w.WriteByte(tStop)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	top := &w.last[len(w.last)-1]
+	if d := id - *top; d > 0 && d <= 15 {
+		w.WriteByte(byte(d)<<4 | typ)
+	} else {
+		w.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	*top = id
+}
+
+func (w *thriftWriter) varint(v int64) {
+	w.Write(binary.AppendUvarint(nil, uint64(v<<1^v>>63)))
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, tI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, tI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) str(id int16, s string) {
+	w.field(id, tBinary)
+	w.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w.WriteString(s)
+}
+
+func (w *thriftWriter) list(id int16, typ byte, n int) {
+	w.field(id, tList)
+	if n < 15 {
+		w.WriteByte(byte(n)<<4 | typ)
+		return
+	}
+	w.WriteByte(0xf0 | typ)
+	w.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+type (
+	testColumn struct {
+		name     string
+		typ      int32
+		optional bool
+		// values of the row group, nil - null
+		groups [][]any
+		codec  int32
+		dict   bool
+		v2     bool
+	}
+	testChunk struct {
+		offset, dictOffset, size, numValues int64
+	}
+)
+
+// writeTestParquet Lays out every column chunk of every row group, then the footer.
+func writeTestParquet(t *testing.T, cols []testColumn) []byte {
+	t.Helper()
+
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+	chunks := make([][]testChunk, len(cols[0].groups))
+	for g := range chunks {
+		for _, c := range cols {
+			chunks[g] = append(chunks[g], writeTestChunk(t, &file, c, c.groups[g]))
+		}
+	}
+
+	var m thriftWriter
+	m.begin()
+	m.i32(1, 1)
+	m.list(2, tStruct, len(cols)+1)
+	m.begin()
+	m.str(4, "schema")
+	m.i32(5, int32(len(cols)))
+	m.end()
+	for _, c := range cols {
+		m.begin()
+		m.i32(1, c.typ)
+		rep := int32(pqRequired)
+		if c.optional {
+			rep = pqOptional
+		}
+		m.i32(3, rep)
+		m.str(4, c.name)
+		m.end()
+	}
+	m.i64(3, 0)
+	m.list(4, tStruct, len(chunks))
+	for g := range chunks {
+		m.begin()
+		m.list(1, tStruct, len(cols))
+		for i, c := range cols {
+			ch := chunks[g][i]
+			m.begin()
+			m.i64(2, ch.offset)
+			m.field(3, tStruct)
+			m.begin()
+			m.i32(1, c.typ)
+			m.list(3, tBinary, 1)
+			m.Write(binary.AppendUvarint(nil, uint64(len(c.name))))
+			m.WriteString(c.name)
+			m.i32(4, c.codec)
+			m.i64(5, ch.numValues)
+			m.i64(6, ch.size)
+			m.i64(7, ch.size)
+			m.i64(9, ch.offset)
+			if ch.dictOffset > 0 {
+				m.i64(11, ch.dictOffset)
+			}
+			m.end()
+			m.end()
+		}
+		m.i64(2, 0)
+		m.i64(3, int64(len(cols[0].groups[g])))
+		m.end()
+	}
+	m.end()
+
+	file.Write(m.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(m.Len())))
+	file.WriteString(parquetMagic)
+
+	return file.Bytes()
+}
+
+func writeTestChunk(t *testing.T, file *bytes.Buffer, c testColumn, vals []any) testChunk {
+	t.Helper()
+
+	ch := testChunk{numValues: int64(len(vals))}
+	start := int64(file.Len())
+
+	var (
+		levels  []uint32
+		nonNull []any
+	)
+	for _, v := range vals {
+		if v == nil {
+			levels = append(levels, 0)
+			continue
+		}
+		levels = append(levels, 1)
+		nonNull = append(nonNull, v)
+	}
+
+	encoding := int32(pqPlain)
+	var values []byte
+	if c.dict {
+		var (
+			dict []any
+			idx  []uint32
+			pos  = map[any]uint32{}
+		)
+		for _, v := range nonNull {
+			i, ok := pos[v]
+			if !ok {
+				i = uint32(len(dict))
+				pos[v] = i
+				dict = append(dict, v)
+			}
+			idx = append(idx, i)
+		}
+		ch.dictOffset = start
+		page := plainTestValues(dict)
+		writeTestPage(file, pqDictPage, c.codec, len(dict), pqPlain, nil, page, false)
+
+		width := 1
+		for 1<<width < len(dict) {
+			width++
+		}
+		values = append([]byte{byte(width)}, bitPackTest(idx, width)...)
+		encoding = pqRLEDictionary
+	} else {
+		values = plainTestValues(nonNull)
+	}
+
+	var lvl []byte
+	if c.optional {
+		lvl = rleTest(levels)
+	}
+	ch.offset = int64(file.Len())
+	typ := int32(pqDataPage)
+	if c.v2 {
+		typ = pqDataPageV2
+	}
+	writeTestPage(file, typ, c.codec, len(vals), encoding, lvl, values, c.optional)
+	ch.size = int64(file.Len()) - start
+
+	return ch
+}
+
+func writeTestPage(file *bytes.Buffer, typ, codec int32, n int, encoding int32, levels, values []byte, optional bool) {
+	compress := func(b []byte) []byte {
+		if codec == pqSnappy {
+			return snappy.Encode(nil, b)
+		}
+		return b
+	}
+
+	var body []byte
+	uncompressed := len(levels) + len(values)
+	switch {
+	case typ == pqDataPageV2:
+		body = append(append([]byte(nil), levels...), compress(values)...)
+	case optional:
+		page := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+		page = append(append(page, levels...), values...)
+		uncompressed = len(page)
+		body = compress(page)
+	default:
+		body = compress(values)
+	}
+
+	var h thriftWriter
+	h.begin()
+	h.i32(1, typ)
+	h.i32(2, int32(uncompressed))
+	h.i32(3, int32(len(body)))
+	switch typ {
+	case pqDictPage:
+		h.field(7, tStruct)
+		h.begin()
+		h.i32(1, int32(n))
+		h.i32(2, encoding)
+		h.end()
+	case pqDataPage:
+		h.field(5, tStruct)
+		h.begin()
+		h.i32(1, int32(n))
+		h.i32(2, encoding)
+		h.i32(3, pqRLE)
+		h.i32(4, pqRLE)
+		h.end()
+	case pqDataPageV2:
+		h.field(8, tStruct)
+		h.begin()
+		h.i32(1, int32(n))
+		h.i32(2, 0)
+		h.i32(3, int32(n))
+		h.i32(4, encoding)
+		h.i32(5, int32(len(levels)))
+		h.i32(6, 0)
+		isCompressed := byte(tFalse)
+		if codec != pqUncompressed {
+			isCompressed = tTrue
+		}
+		h.field(7, isCompressed)
+		h.end()
+	}
+	h.end()
+
+	file.Write(h.Bytes())
+	file.Write(body)
+}
+
+func plainTestValues(vals []any) []byte {
+	var b []byte
+	for _, v := range vals {
+		switch v := v.(type) {
+		case string:
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+		case int32:
+			b = binary.LittleEndian.AppendUint32(b, uint32(v))
+		}
+	}
+
+	return b
+}
+
+// rleTest RLE runs of 1 bit levels.
+func rleTest(levels []uint32) []byte {
+	var b []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		b = binary.AppendUvarint(b, uint64(j-i)<<1)
+		b = append(b, byte(levels[i]))
+		i = j
+	}
+
+	return b
+}
+
+// bitPackTest A single bit-packed run, LSB first.
+func bitPackTest(vals []uint32, width int) []byte {
+	groups := (len(vals) + 7) / 8
+	b := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	packed := make([]byte, groups*width)
+	for i, v := range vals {
+		for k := 0; k < width; k++ {
+			if v>>k&1 == 1 {
+				bit := i*width + k
+				packed[bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+
+	return append(b, packed...)
+}
+
+func Test_ProcessParquet(t *testing.T) {
+	logger := zap.NewNop()
+
+	ips := []testColumn{
+		{
+			name: "client_ip", typ: pqByteArray, optional: true, dict: true, codec: pqSnappy,
+			groups: [][]any{
+				{"1.1.1.1", nil, "2.2.2.2", "1.1.1.1", "bad", "3.3.3.3", "4.4.4.4", "5.5.5.5", "6.6.6.6", "7.7.7.7", "8.8.8.8"},
+				{"8.8.8.8", "9.9.9.9", nil},
+			},
+		},
+		{
+			name: "ip_num", typ: pqInt32, v2: true,
+			groups: [][]any{
+				{int32(0x0a000001), int32(0x0a000002)},
+				{int32(0x0a000002), int32(0x0a000003)},
+			},
+		},
+		{
+			name: "ip_opt", typ: pqInt32, optional: true, v2: true, codec: pqSnappy,
+			groups: [][]any{
+				{nil, int32(0x0a000001)},
+				{int32(0x0a000002), nil},
+			},
+		},
+	}
+	data := writeTestParquet(t, ips)
+
+	tests := []struct {
+		col  string
+		want uint64
+	}{
+		{"client_ip", 9},
+		{"ip_num", 3},
+		{"ip_opt", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.col, func(t *testing.T) {
+			f := mustTempFile(t, "ips.parquet", data)
+			defer f.Close()
+			fi, _ := f.Stat()
+
+			fp := New(logger, f, ipv4_bitset.New(), 2, WithParquetColumn(tt.col))
+			if err := fp.ProcessParquet(context.Background(), fi); err != nil {
+				t.Fatalf("ProcessParquet error: %v", err)
+			}
+			if got := fp.UniqueCount(); got != tt.want {
+				t.Fatalf("UniqueCount=%d; want %d", got, tt.want)
+			}
+		})
+	}
+
+	f := mustTempFile(t, "ips.parquet", data)
+	defer f.Close()
+	fi, _ := f.Stat()
+	if err := New(logger, f, ipv4_bitset.New(), 2, WithParquetColumn("nope")).ProcessParquet(context.Background(), fi); err == nil {
+		t.Fatalf("expected error for a missing column")
+	}
+
+	// the unique limit and the saturation ceiling stop a column like any other input
+	fp := New(logger, f, ipv4_bitset.New(), 1, WithParquetColumn("client_ip"), WithStopAfterUniques(3))
+	if err := fp.ProcessParquet(context.Background(), fi); !errors.Is(err, ErrUniqueLimit) || fp.UniqueCount() != 3 {
+		t.Fatalf("-stop-after-uniques: err=%v unique=%d; want ErrUniqueLimit at 3", err, fp.UniqueCount())
+	}
+	fp = New(logger, f, ipv4_bitset.New(), 1, WithParquetColumn("client_ip"), WithSaturationCeiling(5))
+	if err := fp.ProcessParquet(context.Background(), fi); !errors.Is(err, ErrSaturated) {
+		t.Fatalf("-saturation-ceiling: err=%v; want ErrSaturated", err)
+	}
+
+	broken := mustTempFile(t, "broken.parquet", data[:len(data)-1])
+	defer broken.Close()
+	fi, _ = broken.Stat()
+	if err := New(logger, broken, ipv4_bitset.New(), 2, WithParquetColumn("client_ip")).ProcessParquet(context.Background(), fi); !errors.Is(err, ErrBadParquet) {
+		t.Fatalf("truncated file error=%v; want ErrBadParquet", err)
+	}
+}
+
+func Test_decodeHybrid(t *testing.T) {
+	t.Parallel()
+
+	want := []uint32{5, 5, 5, 1, 2, 3, 4, 5, 6, 7, 0, 1}
+	// RLE run of 3 fives, then a bit-packed run of 9 values(2 groups, padded) of width 3
+	b := append(binary.AppendUvarint(nil, 3<<1), 5)
+	b = append(b, bitPackTest(want[3:], 3)...)
+	got := make([]uint32, len(want))
+	if err := decodeHybrid(b, 3, got); err != nil {
+		t.Fatalf("decodeHybrid error: %v", err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v; want %v", got, want)
+		}
+	}
+}
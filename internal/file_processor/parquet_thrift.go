@@ -0,0 +1,172 @@
+package file_processor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// thrift compact protocol types
+const (
+	tStop   = 0
+	tTrue   = 1
+	tFalse  = 2
+	tByte   = 3
+	tI16    = 4
+	tI32    = 5
+	tI64    = 6
+	tDouble = 7
+	tBinary = 8
+	tList   = 9
+	tSet    = 10
+	tMap    = 11
+	tStruct = 12
+)
+
+// maxThriftLen sanity limit of strings and containers of the metadata
+const maxThriftLen = 64 << 20
+
+type (
+	// thriftReader Minimal Thrift compact protocol decoder for the Parquet metadata,
+	// errors are sticky: after the first one every read returns zero values.
+	thriftReader struct {
+		r   thriftByteReader
+		err error
+	}
+	thriftByteReader interface {
+		io.Reader
+		io.ByteReader
+	}
+)
+
+func (t *thriftReader) fail(err error) {
+	if t.err == nil {
+		t.err = fmt.Errorf("%w: thrift: %v", ErrBadParquet, err)
+	}
+}
+
+func (t *thriftReader) byte() byte {
+	if t.err != nil {
+		return 0
+	}
+	b, err := t.r.ReadByte()
+	if err != nil {
+		t.fail(err)
+	}
+
+	return b
+}
+
+func (t *thriftReader) uvarint() uint64 {
+	if t.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		t.fail(err)
+	}
+
+	return v
+}
+
+func (t *thriftReader) varint() int64 {
+	v := t.uvarint()
+	return int64(v>>1) ^ -int64(v&1) // zigzag
+}
+
+func (t *thriftReader) i32() int32 { return int32(t.varint()) }
+func (t *thriftReader) i64() int64 { return t.varint() }
+
+func (t *thriftReader) binary() []byte {
+	n := t.uvarint()
+	if n > maxThriftLen {
+		t.fail(fmt.Errorf("binary of %d bytes", n))
+	}
+	if t.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(t.r, b); err != nil {
+		t.fail(err)
+	}
+
+	return b
+}
+
+func (t *thriftReader) string() string { return string(t.binary()) }
+
+// bool Value of a boolean struct field, encoded in its type.
+func (t *thriftReader) bool(typ byte) bool { return typ == tTrue }
+
+// structFields Calls fn for every field of a struct until STOP, fn must consume
+// the value(read or skip it).
+func (t *thriftReader) structFields(fn func(id int16, typ byte)) {
+	var last int16
+	for t.err == nil {
+		h := t.byte()
+		typ := h & 0x0f
+		if typ == tStop {
+			return
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(t.varint())
+		}
+		last = id
+		fn(id, typ)
+	}
+}
+
+// list Reads a list header and calls fn n times to consume the elements.
+func (t *thriftReader) list(fn func(elemType byte)) {
+	h := t.byte()
+	n, typ := uint64(h>>4), h&0x0f
+	if n == 15 {
+		n = t.uvarint()
+	}
+	if n > maxThriftLen {
+		t.fail(fmt.Errorf("list of %d elements", n))
+	}
+	for i := uint64(0); i < n && t.err == nil; i++ {
+		fn(typ)
+	}
+}
+
+func (t *thriftReader) skip(typ byte) {
+	switch typ {
+	case tTrue, tFalse:
+	case tByte:
+		t.byte()
+	case tI16, tI32, tI64:
+		t.uvarint()
+	case tDouble:
+		for i := 0; i < 8; i++ {
+			t.byte()
+		}
+	case tBinary:
+		t.binary()
+	case tList, tSet:
+		t.list(func(et byte) {
+			if et == tTrue || et == tFalse {
+				t.byte() // list booleans take a byte each
+				return
+			}
+			t.skip(et)
+		})
+	case tMap:
+		n := t.uvarint()
+		if n == 0 {
+			return
+		}
+		kv := t.byte()
+		for i := uint64(0); i < n && t.err == nil; i++ {
+			t.skip(kv >> 4)
+			t.skip(kv & 0x0f)
+		}
+	case tStruct:
+		t.structFields(func(_ int16, ft byte) { t.skip(ft) })
+	default:
+		t.fail(errors.New("unknown type"))
+	}
+}
@@ -0,0 +1,260 @@
+package file_processor
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrBadPcap returned on a malformed capture file.
+var ErrBadPcap = errors.New("bad pcap")
+
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+	pcapngSHB      = 0x0a0d0d0a
+	pcapngBOM      = 0x1a2b3c4d
+
+	// link types, https://www.tcpdump.org/linktypes.html
+	linkNull     = 0
+	linkEthernet = 1
+	linkRawDLT   = 12
+	linkRawDLT14 = 14
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkSLL2     = 276
+	linkIPv4     = 228
+
+	// maxPacket sanity limit of a single record/block
+	maxPacket = 16 << 20
+)
+
+// WithPcapDestination Counts destination instead of source addresses of pcap/pcapng packets.
+func WithPcapDestination(dst bool) Option {
+	return func(fp *FileProcessor) { fp.pcapDst = dst }
+}
+
+// ProcessPcap Counts the IPv4 addresses of the packets of a .pcap/.pcapng capture,
+// records are variable length so the file is read sequentially.
+func (fp *FileProcessor) ProcessPcap(ctx context.Context, fi os.FileInfo) error {
+	defer fp.progress.Run(fi.Size())()
+
+	r := bufio.NewReaderSize(io.NewSectionReader(fp.src, 0, fi.Size()), 2<<20)
+	magic, err := r.Peek(4)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadPcap, err)
+	}
+	if binary.LittleEndian.Uint32(magic) == pcapngSHB {
+		return fp.processPcapng(ctx, r)
+	}
+
+	return fp.processPcap(ctx, r)
+}
+
+func (fp *FileProcessor) processPcap(ctx context.Context, r *bufio.Reader) error {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return fmt.Errorf("%w: header: %v", ErrBadPcap, err)
+	}
+	var bo binary.ByteOrder
+	switch {
+	case isPcapMagic(binary.LittleEndian.Uint32(hdr)):
+		bo = binary.LittleEndian
+	case isPcapMagic(binary.BigEndian.Uint32(hdr)):
+		bo = binary.BigEndian
+	default:
+		return fmt.Errorf("%w: magic %x", ErrBadPcap, hdr[:4])
+	}
+	link := bo.Uint32(hdr[20:]) & 0x0fffffff // upper bits are FCS flags
+
+	var (
+		rec  = make([]byte, 16)
+		pkt  []byte
+		uniq uint64
+	)
+	defer func() { fp.bitset.AddUnique(uniq) }()
+	fp.progress.AddBytes(24)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, rec); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%w: record header: %v", ErrBadPcap, err)
+		}
+		n := bo.Uint32(rec[8:])
+		if n > maxPacket {
+			return fmt.Errorf("%w: record of %d bytes", ErrBadPcap, n)
+		}
+		pkt = grow(pkt, int(n))
+		if _, err := io.ReadFull(r, pkt); err != nil {
+			return fmt.Errorf("%w: record: %v", ErrBadPcap, err)
+		}
+		fp.progress.AddBytes(16 + int64(n))
+		if err := fp.addPacket(link, pkt, &uniq); err != nil {
+			return err
+		}
+		fp.progress.AddRecords(1)
+	}
+}
+
+func (fp *FileProcessor) processPcapng(ctx context.Context, r *bufio.Reader) error {
+	var (
+		bo    binary.ByteOrder = binary.LittleEndian
+		links []uint32         // link type per interface of the current section
+		hdr   = make([]byte, 8)
+		body  []byte
+		uniq  uint64
+	)
+	defer func() { fp.bitset.AddUnique(uniq) }()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%w: block header: %v", ErrBadPcap, err)
+		}
+
+		typ := binary.LittleEndian.Uint32(hdr) // SHB type is a palindrome
+		if typ == pcapngSHB {
+			// the byte order of the section follows the block length
+			bom, err := r.Peek(4)
+			if err != nil {
+				return fmt.Errorf("%w: section header: %v", ErrBadPcap, err)
+			}
+			switch {
+			case binary.LittleEndian.Uint32(bom) == pcapngBOM:
+				bo = binary.LittleEndian
+			case binary.BigEndian.Uint32(bom) == pcapngBOM:
+				bo = binary.BigEndian
+			default:
+				return fmt.Errorf("%w: byte order magic %x", ErrBadPcap, bom)
+			}
+			links = links[:0]
+		} else {
+			typ = bo.Uint32(hdr)
+		}
+
+		total := bo.Uint32(hdr[4:])
+		if total < 12 || total%4 != 0 || total > maxPacket {
+			return fmt.Errorf("%w: block of %d bytes", ErrBadPcap, total)
+		}
+		// body + trailing length
+		body = grow(body, int(total)-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return fmt.Errorf("%w: block: %v", ErrBadPcap, err)
+		}
+		fp.progress.AddBytes(int64(total))
+		body := body[:len(body)-4]
+
+		switch typ {
+		case 1: // interface description
+			if len(body) < 2 {
+				return fmt.Errorf("%w: short interface block", ErrBadPcap)
+			}
+			links = append(links, uint32(bo.Uint16(body)))
+		case 6: // enhanced packet
+			if len(body) < 20 {
+				return fmt.Errorf("%w: short packet block", ErrBadPcap)
+			}
+			iface, capLen := bo.Uint32(body), bo.Uint32(body[12:])
+			if int(iface) >= len(links) || int(capLen) > len(body)-20 {
+				return fmt.Errorf("%w: bad packet block", ErrBadPcap)
+			}
+			if err := fp.addPacket(links[iface], body[20:20+capLen], &uniq); err != nil {
+				return err
+			}
+			fp.progress.AddRecords(1)
+		case 3: // simple packet, always of the first interface
+			if len(body) < 4 || len(links) == 0 {
+				return fmt.Errorf("%w: bad simple packet block", ErrBadPcap)
+			}
+			data := body[4:]
+			if origLen := bo.Uint32(body); int(origLen) < len(data) {
+				data = data[:origLen]
+			}
+			if err := fp.addPacket(links[0], data, &uniq); err != nil {
+				return err
+			}
+			fp.progress.AddRecords(1)
+		}
+	}
+}
+
+// addPacket Adds the selected address of an IPv4 packet through the counting path of processReader.
+func (fp *FileProcessor) addPacket(link uint32, pkt []byte, localUniq *uint64) error {
+	ip, ok := ipv4Header(link, pkt)
+	if !ok {
+		return nil
+	}
+	off := 12
+	if fp.pcapDst {
+		off = 16
+	}
+
+	return fp.add(binary.BigEndian.Uint32(ip[off:]), localUniq)
+}
+
+// ipv4Header Strips the link layer, ok=false for non IPv4 packets.
+func ipv4Header(link uint32, pkt []byte) ([]byte, bool) {
+	switch link {
+	case linkEthernet:
+		if len(pkt) < 14 {
+			return nil, false
+		}
+		etherType, ip := binary.BigEndian.Uint16(pkt[12:]), pkt[14:]
+		// 802.1Q / 802.1ad tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(ip) >= 4 {
+			etherType, ip = binary.BigEndian.Uint16(ip[2:]), ip[4:]
+		}
+		if etherType != 0x0800 {
+			return nil, false
+		}
+		pkt = ip
+	case linkNull, linkLoop:
+		// address family in the byte order of the capturing host, AF_INET is 2 everywhere
+		if len(pkt) < 4 || (binary.LittleEndian.Uint32(pkt) != 2 && binary.BigEndian.Uint32(pkt) != 2) {
+			return nil, false
+		}
+		pkt = pkt[4:]
+	case linkSLL:
+		if len(pkt) < 16 || binary.BigEndian.Uint16(pkt[14:]) != 0x0800 {
+			return nil, false
+		}
+		pkt = pkt[16:]
+	case linkSLL2:
+		if len(pkt) < 20 || binary.BigEndian.Uint16(pkt) != 0x0800 {
+			return nil, false
+		}
+		pkt = pkt[20:]
+	case linkRaw, linkRawDLT, linkRawDLT14, linkIPv4:
+	default:
+		return nil, false
+	}
+
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return nil, false
+	}
+
+	return pkt, true
+}
+
+func isPcapMagic(m uint32) bool { return m == pcapMagicMicro || m == pcapMagicNano }
+
+func grow(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+
+	return b[:n]
+}
@@ -0,0 +1,108 @@
+package file_processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ErrBadPlan returned when a shard plan does not fit the file it is applied to.
+var ErrBadPlan = errors.New("bad shard plan")
+
+// Plan Exact newline aligned shard byte ranges of a file, exported with -emit-plan
+// and replayed with -use-plan. A plan may be edited to hold a subset of the shards,
+// so several machines can split one shared file between them.
+type Plan struct {
+	Path   string  `json:"path"`
+	Size   int64   `json:"size"`
+	Shards []Range `json:"shards"`
+}
+
+// Range Half-open [Start, End) byte range of a shard.
+type Range struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// WithPlan Processes local files by the plan shards instead of splitting them by th.
+func WithPlan(p *Plan) Option {
+	return func(fp *FileProcessor) { fp.plan = p }
+}
+
+// Plan Splits the file of fp into shards the same way ProcessFile does.
+func (fp *FileProcessor) Plan(ctx context.Context, path string, fi os.FileInfo) (*Plan, error) {
+	shs, err := fp.splitToShards(ctx, fi.Size(), fp.th)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{Path: path, Size: fi.Size(), Shards: make([]Range, 0, len(shs))}
+	for _, s := range shs {
+		if s.End > s.Start {
+			p.Shards = append(p.Shards, Range(s))
+		}
+	}
+
+	return p, nil
+}
+
+// planShards Validates the plan against the file: same size, ranges inside the file,
+// no overlaps and every range starts at a line start.
+func (fp *FileProcessor) planShards(size int64) (shards, error) {
+	if fp.plan.Size != size {
+		return nil, fmt.Errorf("%w: planned for %d bytes, file has %d", ErrBadPlan, fp.plan.Size, size)
+	}
+
+	shs := make(shards, len(fp.plan.Shards))
+	for i, r := range fp.plan.Shards {
+		if r.Start < 0 || r.End < r.Start || r.End > size {
+			return nil, fmt.Errorf("%w: range [%d, %d) is out of file", ErrBadPlan, r.Start, r.End)
+		}
+		shs[i] = shard(r)
+	}
+	sort.Slice(shs, func(i, j int) bool { return shs[i].Start < shs[j].Start })
+
+	for i, s := range shs {
+		if i > 0 && s.Start < shs[i-1].End {
+			return nil, fmt.Errorf("%w: range [%d, %d) overlaps [%d, %d)", ErrBadPlan, s.Start, s.End, shs[i-1].Start, shs[i-1].End)
+		}
+		if s.Start == 0 {
+			continue
+		}
+		ok, err := fp.isRecordStart(s.Start)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: range [%d, %d) starts in the middle of a record", ErrBadPlan, s.Start, s.End)
+		}
+	}
+
+	return shs, nil
+}
+
+// WritePlan Stores the plan as indented JSON, so it can be reviewed and edited by hand.
+func WritePlan(path string, p *Plan) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+func ReadPlan(path string) (*Plan, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Plan
+	if err = json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPlan, err)
+	}
+
+	return &p, nil
+}
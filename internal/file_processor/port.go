@@ -0,0 +1,44 @@
+package file_processor
+
+import "bytes"
+
+// WithStripPort Accepts address tokens with a source port(1.2.3.4:8080 of load balancer logs, [2001:db8::1]:443
+// of proxies), the port and the brackets are dropped.
+func WithStripPort(on bool) Option {
+	return func(fp *FileProcessor) { fp.stripPort = on }
+}
+
+// stripPort The token without a trailing ":port" of 1-5 digits. An IPv6 address is unwrapped from its brackets,
+// unbracketed tokens with more than one ':'(IPv6) are kept.
+func stripPort(tok []byte) []byte {
+	if len(tok) > 0 && tok[0] == '[' {
+		j := bytes.IndexByte(tok, ']')
+		if j < 0 {
+			return tok
+		}
+		if rest := tok[j+1:]; len(rest) > 0 && (rest[0] != ':' || !isPort(rest[1:])) {
+			return tok
+		}
+		return tok[1:j]
+	}
+	i := bytes.LastIndexByte(tok, ':')
+	if i < 0 || bytes.IndexByte(tok[:i], ':') >= 0 || !isPort(tok[i+1:]) {
+		return tok
+	}
+
+	return tok[:i]
+}
+
+// isPort Reports whether b is a port number of 1-5 digits.
+func isPort(b []byte) bool {
+	if len(b) == 0 || len(b) > 5 {
+		return false
+	}
+	for _, c := range b {
+		if !isDigit(c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -2,6 +2,7 @@ package file_processor
 
 import (
 	"fmt"
+	"io"
 	"runtime"
 	"sync/atomic"
 	"time"
@@ -11,10 +12,14 @@ import (
 
 const interval = 5 * time.Second
 
+// Progress Bytes read in the IO unit of the input(compressed bytes of compressed inputs, file bytes of
+// binary, parquet and pcap ones) and records parsed from them, counted apart so the throughput of either
+// is right whatever a record is.
 type Progress struct {
-	logger *zap.Logger
-	done   atomic.Int64
-	last   atomic.Int64
+	logger  *zap.Logger
+	done    atomic.Int64
+	records atomic.Int64
+	last    atomic.Int64
 }
 
 func NewProgress(
@@ -25,11 +30,35 @@ func NewProgress(
 	}
 }
 
-func (p *Progress) Add(n int64) { _ = p.done.Add(n) }
+// AddBytes n bytes of the input read.
+func (p *Progress) AddBytes(n int64) { _ = p.done.Add(n) }
+
+// AddRecords n records parsed(lines, addresses, values or packets).
+func (p *Progress) AddRecords(n int64) { _ = p.records.Add(n) }
+
+func (p *Progress) Bytes() int64 { return p.done.Load() }
+
+func (p *Progress) Records() int64 { return p.records.Load() }
+
+// reader Counts the bytes read from r, the compressed stream of a decompressed input.
+func (p *Progress) reader(r io.Reader) io.Reader { return &progressReader{r: r, p: p} }
+
+type progressReader struct {
+	r io.Reader
+	p *Progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.AddBytes(int64(n))
+
+	return n, err
+}
 
 func (p *Progress) Run(totalSize int64) (stop func()) {
 	t := time.NewTicker(interval)
 	done := make(chan struct{})
+	start, startBytes, startRecords := time.Now(), p.done.Load(), p.records.Load()
 
 	human := func(b uint64) string {
 		const (
@@ -69,9 +98,15 @@ func (p *Progress) Run(totalSize int64) (stop func()) {
 					var ms runtime.MemStats
 					runtime.ReadMemStats(&ms)
 
+					secs := max(time.Since(start).Seconds(), 1e-3)
+					read, records := p.done.Load()-startBytes, p.records.Load()-startRecords
 					p.logger.Sugar().Infof(
-						"progress: %d%% | alloc=%s heap_inuse=%s gc_cycles=%d | goroutines=%d ",
+						"progress: %d%% | read=%s %s/s records=%d %.0f/s | alloc=%s heap_inuse=%s gc_cycles=%d | goroutines=%d ",
 						pct,
+						human(uint64(max(read, 0))),
+						human(uint64(float64(max(read, 0))/secs)),
+						records,
+						float64(records)/secs,
 						human(ms.Alloc),
 						human(ms.HeapInuse),
 						ms.NumGC,
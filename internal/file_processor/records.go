@@ -0,0 +1,221 @@
+package file_processor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// recordReader Splits a stream into records: lines, or with a custom delimiter records terminated
+// by the delimiter or by a line break(many delimited addresses per physical line). A delimiter
+// holding a line break itself("\r\n\r\n") terminates multi-line records, line breaks do not.
+// A record is returned with its terminator and is valid until the next read, like ReadSlice.
+type recordReader struct {
+	r     *bufio.Reader
+	delim []byte
+	lines bool // line breaks terminate records too
+	limit int  // longest record, terminator and a trailing '\r' excluded
+
+	// the next delimiter is cached: files with rare delimiters are not rescanned for every line
+	dPos     int // offset of the next delimiter from the read position, -1 - not buffered
+	dScanned int // buffered bytes known to hold no delimiter start
+
+	head []byte // of the last skipped record
+
+	// the part of a record over the limit readLine consumed, the first piece, valid until the next read
+	taken      []byte
+	takenEnded bool // taken holds the terminator
+}
+
+func (fp *FileProcessor) newRecordReader(r io.Reader) *recordReader {
+	limit, size := fp.lineLimit()
+
+	return &recordReader{r: bufio.NewReaderSize(r, size), delim: fp.delim, lines: fp.lineBreaks(), limit: limit, dPos: -1}
+}
+
+// lineBreaks Reports whether a line break terminates a record.
+func (fp *FileProcessor) lineBreaks() bool {
+	return bytes.IndexByte(fp.delim, '\n') < 0 || fp.isLines()
+}
+
+// isLines Reports whether records are plain lines.
+func (fp *FileProcessor) isLines() bool { return len(fp.delim) == 1 && fp.delim[0] == '\n' }
+
+// read The next terminated record, io.EOF at the end(an unterminated last record is dropped).
+func (rr *recordReader) read() ([]byte, error) {
+	if len(rr.delim) == 1 && rr.delim[0] == '\n' {
+		return rr.readLine()
+	}
+
+	searched := 0 // buffered bytes without a line break
+	for {
+		b, _ := rr.r.Peek(rr.r.Buffered())
+		if rr.dPos < 0 {
+			if i := indexDelim(b[rr.dScanned:], rr.delim); i >= 0 {
+				rr.dPos = rr.dScanned + i
+			} else {
+				// a delimiter may start in the last bytes and end in the next fill
+				rr.dScanned = max(len(b)-len(rr.delim)+1, rr.dScanned)
+			}
+		}
+		end := -1
+		if rr.dPos >= 0 {
+			end = rr.dPos + len(rr.delim)
+		}
+		if rr.lines {
+			limit := len(b)
+			if end >= 0 {
+				limit = rr.dPos
+			}
+			// a delimiter completed by the last fill may start before the searched bytes
+			from := min(searched, limit)
+			if i := bytes.IndexByte(b[from:limit], '\n'); i >= 0 {
+				end = from + i + 1
+			} else {
+				searched = max(searched, limit)
+			}
+		}
+		if end < 0 {
+			// no terminator buffered, fill the buffer
+			if len(b) == rr.r.Size() || len(b) > rr.limit+len(rr.delim)+1 {
+				return nil, bufio.ErrBufferFull
+			}
+			if _, err := rr.r.Peek(len(b) + 1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		rec := b[:end]
+		if len(rec) > rr.limit+1 && len(rr.trim(rec)) > rr.limit {
+			// left buffered for skip
+			return nil, bufio.ErrBufferFull
+		}
+		_, _ = rr.r.Discard(end)
+		rr.dScanned = max(rr.dScanned-end, 0)
+		if rr.dPos -= end; rr.dPos < 0 {
+			rr.dPos = -1
+		}
+
+		return rec, nil
+	}
+}
+
+// readLine ReadSlice of the next '\n' terminated line. A line longer than the limit or than the buffer is
+// reported as bufio.ErrBufferFull, the part ReadSlice consumed of it is the first piece.
+func (rr *recordReader) readLine() ([]byte, error) {
+	line, err := rr.r.ReadSlice('\n')
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		rr.take(line, false)
+		return nil, err
+	case err == nil && len(line) > rr.limit+1 && len(trimCRLF(line)) > rr.limit:
+		rr.take(line, true)
+		return nil, bufio.ErrBufferFull
+	}
+
+	return line, err
+}
+
+func (rr *recordReader) take(b []byte, ended bool) { rr.taken, rr.takenEnded = b, ended }
+
+// skip Discards a record read returned bufio.ErrBufferFull for, up to and including its terminator.
+// head is a copy of its first maxInvalidSample bytes, n its length; io.EOF when the input ends within it.
+func (rr *recordReader) skip() (head []byte, n int64, err error) {
+	rr.head = rr.head[:0]
+	for {
+		b, ended, err := rr.piece()
+		if len(rr.head) < maxInvalidSample {
+			rr.head = append(rr.head, b[:min(len(b), maxInvalidSample-len(rr.head))]...)
+		}
+		n += int64(len(b))
+		if ended || err != nil {
+			return rr.head, n, err
+		}
+	}
+}
+
+// piece Discards and returns the next buffered part of a record read returned bufio.ErrBufferFull for,
+// up to and including its terminator when ended. Valid until the next read, like read.
+func (rr *recordReader) piece() (b []byte, ended bool, err error) {
+	if b = rr.taken; b != nil {
+		ended = rr.takenEnded
+		rr.taken, rr.takenEnded = nil, false
+		return b, ended, nil
+	}
+	rr.dPos, rr.dScanned = -1, 0
+	for {
+		b, _ = rr.r.Peek(rr.r.Buffered())
+		end := indexDelim(b, rr.delim)
+		if end >= 0 {
+			end += len(rr.delim)
+		}
+		if rr.lines {
+			limit := len(b)
+			if end >= 0 {
+				limit = end - len(rr.delim)
+			}
+			if i := bytes.IndexByte(b[:limit], '\n'); i >= 0 {
+				end = i + 1
+			}
+		}
+		if end >= 0 {
+			_, _ = rr.r.Discard(end)
+			return b[:end], true, nil
+		}
+		// keep the bytes a delimiter split by the next fill may start in
+		if k := len(b) - len(rr.delim) + 1; k > 0 {
+			_, _ = rr.r.Discard(k)
+			return b[:k], false, nil
+		}
+		if _, err = rr.r.Peek(len(b) + 1); err != nil {
+			_, _ = rr.r.Discard(len(b))
+			return b, false, err
+		}
+	}
+}
+
+// termLen Length of the terminator of a record returned by read.
+func (rr *recordReader) termLen(rec []byte) int {
+	if !bytes.HasSuffix(rec, rr.delim) {
+		// a line break
+		return 1
+	}
+
+	return len(rr.delim)
+}
+
+// trim The record without its terminator and a trailing "\r\n".
+func (rr *recordReader) trim(rec []byte) []byte {
+	return trimCRLF(bytes.TrimSuffix(rec, rr.delim))
+}
+
+// indexDelim Index of the first delimiter of b, a single byte one uses the dispatched scan.
+func indexDelim(b, delim []byte) int {
+	if len(delim) == 1 {
+		return scanByte(b, delim[0])
+	}
+
+	return bytes.Index(b, delim)
+}
+
+// recordEnd The offset after the first record terminator of b, -1 - none.
+func (fp *FileProcessor) recordEnd(b []byte) int {
+	end := -1
+	if i := indexDelim(b, fp.delim); i >= 0 {
+		end = i + len(fp.delim)
+	}
+	if fp.isLines() || !fp.lineBreaks() {
+		return end
+	}
+	limit := len(b)
+	if end >= 0 {
+		limit = end - len(fp.delim)
+	}
+	if j := bytes.IndexByte(b[:limit], '\n'); j >= 0 {
+		return j + 1
+	}
+
+	return end
+}
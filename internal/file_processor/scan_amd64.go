@@ -0,0 +1,10 @@
+package file_processor
+
+import (
+	"bytes"
+
+	"unique-ip-counter/internal/cpu_dispatch"
+)
+
+// registerIndexByte bytes.IndexByte runs the runtime's AVX2 scan on CPUs that have it.
+func registerIndexByte() { indexByte.Register("avx2", cpu_dispatch.AVX2, 10, bytes.IndexByte) }
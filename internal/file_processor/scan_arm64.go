@@ -0,0 +1,10 @@
+package file_processor
+
+import (
+	"bytes"
+
+	"unique-ip-counter/internal/cpu_dispatch"
+)
+
+// registerIndexByte bytes.IndexByte runs the runtime's NEON scan.
+func registerIndexByte() { indexByte.Register("neon", cpu_dispatch.NEON, 10, bytes.IndexByte) }
@@ -0,0 +1,12 @@
+//go:build !amd64 && !arm64
+
+package file_processor
+
+import (
+	"bytes"
+
+	"unique-ip-counter/internal/cpu_dispatch"
+)
+
+// registerIndexByte bytes.IndexByte is implemented in assembly for most other architectures too.
+func registerIndexByte() { indexByte.Register("runtime", cpu_dispatch.Generic, 10, bytes.IndexByte) }
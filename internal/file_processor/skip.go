@@ -0,0 +1,56 @@
+package file_processor
+
+import "bytes"
+
+// WithSkipPrefixes Skips records starting with one of prefixes(comments and headers of exported lists),
+// they are counted as skipped instead of invalid.
+func WithSkipPrefixes(prefixes []string) Option {
+	return func(fp *FileProcessor) {
+		for _, p := range prefixes {
+			if p != "" {
+				fp.skipPrefixes = append(fp.skipPrefixes, []byte(p))
+			}
+		}
+	}
+}
+
+// skip Counts and reports a record starting with a skip prefix.
+func (fp *FileProcessor) skip(line []byte) bool {
+	for _, p := range fp.skipPrefixes {
+		if bytes.HasPrefix(line, p) {
+			fp.skipped.Add(1)
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithInlineComments Strips inline comments of curated lists(`1.2.3.4  # edge-router`): everything
+// from the first '#' or ';' of a record on, the spaces and tabs around what is left are trimmed,
+// a record that is only a comment is skipped.
+func WithInlineComments(on bool) Option {
+	return func(fp *FileProcessor) { fp.comments = on }
+}
+
+// uncomment The record without its inline comment under WithInlineComments, nil(counted as skipped)
+// when nothing but a comment is left.
+func (fp *FileProcessor) uncomment(line []byte) []byte {
+	if !fp.comments {
+		return line
+	}
+	i := bytes.IndexAny(line, "#;")
+	if i < 0 {
+		return line
+	}
+	if line = trimSpace(line[:i]); len(line) == 0 {
+		fp.skipped.Add(1)
+		return nil
+	}
+
+	return line
+}
+
+// SkippedCount Records skipped by WithSkipPrefixes or WithInlineComments and records without any
+// address under WithExtractAll.
+func (fp *FileProcessor) SkippedCount() uint64 { return fp.skipped.Load() }
@@ -0,0 +1,19 @@
+package file_processor
+
+// WithTrimSpace Trims spaces and tabs around the address token(padded columns of hand-assembled lists)
+// instead of reporting "  1.2.3.4\t" as invalid.
+func WithTrimSpace(on bool) Option {
+	return func(fp *FileProcessor) { fp.trimSpace = on }
+}
+
+// trimSpace tok without leading and trailing spaces and tabs.
+func trimSpace(tok []byte) []byte {
+	for len(tok) > 0 && (tok[0] == ' ' || tok[0] == '\t') {
+		tok = tok[1:]
+	}
+	for n := len(tok); n > 0 && (tok[n-1] == ' ' || tok[n-1] == '\t'); n-- {
+		tok = tok[:n-1]
+	}
+
+	return tok
+}
@@ -0,0 +1,81 @@
+package file_processor
+
+import (
+	"context"
+	"io"
+
+	"go.uber.org/zap"
+)
+
+// processSparse Processes only the data extents of a sparse file, ok=false when the file
+// has no holes and must be processed as usual. A hole reads as NUL bytes, so a line crossing
+// a hole boundary is never a valid address: extents are cut to whole lines on both sides.
+func (fp *FileProcessor) processSparse(ctx context.Context, size int64) (ok bool, err error) {
+	if fp.file == nil {
+		return false, nil
+	}
+	extents, err := fp.extents(size)
+	if err != nil || extents == nil {
+		return false, err
+	}
+
+	var data int64
+	for _, e := range extents {
+		data += e.End - e.Start
+	}
+	fp.holeBytes = size - data
+	fp.logger.Info("sparse file, skipping holes",
+		zap.Int64("hole_bytes", fp.holeBytes), zap.Int("extents", len(extents)))
+
+	var shs shards
+	for _, e := range extents {
+		// shards per extent proportional to its share of the data
+		n := int(int64(fp.th) * (e.End - e.Start) / max(data, 1))
+		part, err := fp.splitRange(ctx, e, max(n, 1))
+		if err != nil {
+			return true, err
+		}
+		shs = append(shs, part...)
+	}
+
+	return true, fp.processShards(ctx, data, shs)
+}
+
+// extents Data extents of a file with holes, nil for a dense file or when holes are not reported.
+func (fp *FileProcessor) extents(size int64) ([]shard, error) {
+	extents, ok, err := dataExtents(fp.file, size)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if len(extents) == 1 && extents[0].Start == 0 && extents[0].End == size {
+		return nil, nil
+	}
+	if extents == nil {
+		extents = shards{} // all hole
+	}
+
+	return extents, nil
+}
+
+// splitRange Splits e into n newline aligned shards, a start inside the file is aligned too.
+func (fp *FileProcessor) splitRange(ctx context.Context, e shard, n int) (shards, error) {
+	if e.Start > 0 {
+		// the line before starts in the hole or in the previous extent
+		aligned, err := fp.moveStartToNewline(ctx, e)
+		if err != nil {
+			return nil, err
+		}
+		e = aligned
+	}
+
+	shs, err := fp.withSource(io.NewSectionReader(fp.src, e.Start, e.End-e.Start)).splitToShards(ctx, e.End-e.Start, n)
+	if err != nil {
+		return nil, err
+	}
+	for i := range shs {
+		shs[i].Start += e.Start
+		shs[i].End += e.Start
+	}
+
+	return shs, nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd
+
+package file_processor
+
+import "os"
+
+func dataExtents(*os.File, int64) (shards, bool, error) { return nil, false, nil }
@@ -0,0 +1,39 @@
+//go:build linux || darwin || freebsd
+
+package file_processor
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataExtents Data regions of f found with SEEK_DATA/SEEK_HOLE, ok=false when the filesystem
+// does not support them.
+func dataExtents(f *os.File, size int64) (res shards, ok bool, err error) {
+	fd := int(f.Fd())
+	for off := int64(0); off < size; {
+		start, err := unix.Seek(fd, off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // only a hole till EOF
+		}
+		if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTSUP) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, false, err
+		}
+		if end > size {
+			end = size
+		}
+		res = append(res, shard{Start: start, End: end})
+		off = end
+	}
+
+	return res, true, nil
+}
@@ -0,0 +1,18 @@
+package file_processor
+
+import "unique-ip-counter/internal/ipv4_bitset"
+
+// WithStrictIPv4 Accepts dotted addresses in their canonical form only(no leading zeros, as net/netip),
+// so counts match tooling that rejects 001.002.003.004 instead of reading it as 1.2.3.4.
+func WithStrictIPv4(on bool) Option {
+	return func(fp *FileProcessor) { fp.strict = on }
+}
+
+// dotted The address of a dotted token, strict or through the dispatched parser.
+func (fp *FileProcessor) dotted(tok []byte) (uint32, bool) {
+	if fp.strict {
+		return ipv4_bitset.StrictToUint32(tok)
+	}
+
+	return fp.bitset.IPv4ByteToUint32(tok)
+}
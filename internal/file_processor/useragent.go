@@ -0,0 +1,73 @@
+package file_processor
+
+// userAgentClasses Buckets of the user agent dimension.
+var userAgentClasses = []string{"known-bot", "bot", "human", "unknown"}
+
+// knownBots User agent tokens of well known crawlers and monitors, matched case-insensitively.
+var knownBots = [][]byte{
+	[]byte("googlebot"), []byte("bingbot"), []byte("yandexbot"), []byte("baiduspider"), []byte("duckduckbot"),
+	[]byte("applebot"), []byte("slurp"), []byte("facebookexternalhit"), []byte("twitterbot"), []byte("linkedinbot"),
+	[]byte("ahrefsbot"), []byte("semrushbot"), []byte("mj12bot"), []byte("petalbot"), []byte("gptbot"),
+	[]byte("ccbot"), []byte("uptimerobot"), []byte("pingdom"),
+}
+
+// botMarkers Generic signs of automated clients: self-declared bots and HTTP libraries.
+var botMarkers = [][]byte{
+	[]byte("bot"), []byte("crawl"), []byte("spider"), []byte("scrape"), []byte("curl/"), []byte("wget/"),
+	[]byte("python-"), []byte("go-http-client"), []byte("java/"), []byte("okhttp"), []byte("headless"),
+}
+
+// WithUserAgentDimension Counts unique addresses per user agent class(known-bot, bot, human, unknown) of col:
+// a CSV column(1-based index or header name) or the agent field of -format combined.
+func WithUserAgentDimension(col string) Option {
+	return func(fp *FileProcessor) {
+		if col != "" {
+			fp.dims = append(fp.dims, newDimension("user_agent", col, userAgentClasses, userAgentClass))
+		}
+	}
+}
+
+// userAgentClass known-bot - a crawler of the list, bot - a generic bot marker or an HTTP library,
+// human - a browser(Mozilla/ or Opera/ prefix), unknown - anything else including "-".
+func userAgentClass(f []byte) int {
+	for _, b := range knownBots {
+		if containsFold(f, b) {
+			return 0
+		}
+	}
+	for _, b := range botMarkers {
+		if containsFold(f, b) {
+			return 1
+		}
+	}
+	if hasPrefixFold(f, []byte("mozilla/")) || hasPrefixFold(f, []byte("opera/")) {
+		return 2
+	}
+
+	return 3
+}
+
+// containsFold Reports whether lower, an ASCII lower case token, is within s ignoring ASCII case, allocation free.
+func containsFold(s, lower []byte) bool {
+	for i := 0; i+len(lower) <= len(s); i++ {
+		if hasPrefixFold(s[i:], lower) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasPrefixFold Reports whether s starts with the ASCII lower case token lower ignoring ASCII case.
+func hasPrefixFold(s, lower []byte) bool {
+	if len(s) < len(lower) {
+		return false
+	}
+	for i, c := range lower {
+		if s[i]|0x20 != c && s[i] != c {
+			return false
+		}
+	}
+
+	return true
+}
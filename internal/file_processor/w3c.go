@@ -0,0 +1,155 @@
+package file_processor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// w3cClientField Field of the W3C extended log format holding the client address.
+const w3cClientField = "c-ip"
+
+var w3cFieldsPrefix = []byte("#Fields:")
+
+// readW3CFields Consumes the leading directives of br up to #Fields and resolves the c-ip column.
+func (fp *FileProcessor) readW3CFields(br *bufio.Reader) error {
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("w3c directives: %w", err)
+		}
+		line = trimBOM(line)
+		if !bytes.HasPrefix(line, []byte("#")) {
+			return fmt.Errorf("w3c: no #Fields directive before the first record")
+		}
+		if idx, ok := w3cFieldsIndex(line); ok {
+			if idx < 0 {
+				return fmt.Errorf("w3c: no %s field in %q", w3cClientField, bytes.TrimSpace(trimCRLF(line)))
+			}
+			fp.w3cIndex = idx
+			return nil
+		}
+		if err == io.EOF {
+			return fmt.Errorf("w3c: no #Fields directive")
+		}
+	}
+}
+
+// w3cFieldsIndex The c-ip column of a #Fields directive(-1 - no such field), ok is false for other lines.
+func w3cFieldsIndex(line []byte) (int, bool) {
+	fields, ok := bytes.CutPrefix(trimCRLF(line), w3cFieldsPrefix)
+	if !ok {
+		return 0, false
+	}
+	for i, f := range bytes.Fields(fields) {
+		if string(f) == w3cClientField {
+			return i, true
+		}
+	}
+
+	return -1, true
+}
+
+// directive Reports whether line is a W3C directive(#Version, #Fields, #Date etc.), not a record.
+// A #Fields directive(IIS writes a new block on restart or a logging change) remaps the c-ip column
+// of the following records, so fp must be owned by a single reader.
+func (fp *FileProcessor) directive(line []byte) bool {
+	if !fp.w3c || len(line) == 0 || line[0] != '#' {
+		return false
+	}
+	if idx, ok := w3cFieldsIndex(line); ok {
+		// a block without c-ip has no countable records
+		fp.w3cIndex = idx
+	}
+
+	return true
+}
+
+// w3cStarts The c-ip column in effect at the start of every shard: the shards are scanned for their
+// last #Fields directive in parallel before counting, a shard inherits the one of the shards before it.
+func (fp *FileProcessor) w3cStarts(ctx context.Context, shs shards) ([]int, error) {
+	type found struct {
+		idx int
+		ok  bool
+	}
+	last := make([]found, len(shs))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, s := range shs {
+		g.Go(func() error {
+			idx, ok, err := fp.lastW3CFields(ctx, s)
+			last[i] = found{idx: idx, ok: ok}
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	starts := make([]int, len(shs))
+	idx := fp.w3cIndex
+	for i := range shs {
+		starts[i] = idx
+		if last[i].ok {
+			idx = last[i].idx
+		}
+	}
+
+	return starts, nil
+}
+
+// lastW3CFields The c-ip column of the last #Fields directive of the shard, ok is false when there is none.
+// The shard is searched backward, a directive at the very file start is the header resolved before.
+func (fp *FileProcessor) lastW3CFields(ctx context.Context, s shard) (int, bool, error) {
+	const chunk = 1 << 20
+	needle := append([]byte{'\n'}, w3cFieldsPrefix...)
+	buf := make([]byte, chunk+len(needle))
+	// shards start after a '\n', include it to find a directive at the shard start
+	lo := max(s.Start-1, 0)
+	for hi := s.End; hi > lo; hi -= chunk {
+		if err := ctx.Err(); err != nil {
+			return 0, false, err
+		}
+		off := max(hi-chunk, lo)
+		// overlap the next chunk by the needle, a directive starting in this chunk may end in it
+		n, err := fp.src.ReadAt(buf[:min(hi-off+int64(len(needle))-1, s.End-off)], off)
+		if n == 0 && err != nil {
+			return 0, false, err
+		}
+		if k := bytes.LastIndex(buf[:n], needle); k >= 0 {
+			line := make([]byte, 64<<10)
+			n, err = fp.src.ReadAt(line, off+int64(k)+1)
+			if n == 0 && err != nil {
+				return 0, false, err
+			}
+			line = line[:n]
+			if e := bytes.IndexByte(line, '\n'); e >= 0 {
+				line = line[:e]
+			}
+			idx, _ := w3cFieldsIndex(line)
+			return idx, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// spaceField Returns the idx-th(0-based) space or tab separated field of line.
+func spaceField(line []byte, idx int) ([]byte, bool) {
+	for i := 0; ; i++ {
+		k := bytes.IndexAny(line, " \t")
+		if k < 0 {
+			k = len(line)
+		}
+		if i == idx {
+			return line[:k], true
+		}
+		if k == len(line) {
+			return nil, false
+		}
+		line = line[k+1:]
+	}
+}
@@ -0,0 +1,64 @@
+package file_processor
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ProcessZip Processes every member of a zip archive.
+// Stored(uncompressed) entries are sharded like a plain file,
+// compressed entries are streamed by a single goroutine.
+func (fp *FileProcessor) ProcessZip(ctx context.Context, fi os.FileInfo) error {
+	zr, err := zip.NewReader(fp.src, fi.Size())
+	if err != nil {
+		return fmt.Errorf("open zip: %w", err)
+	}
+
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		fp.logger.Sugar().Infof("processing zip entry: %s", zf.Name)
+		if err = fp.processZipEntry(ctx, zf); err != nil {
+			return fmt.Errorf("zip entry %s: %w", zf.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (fp *FileProcessor) processZipEntry(ctx context.Context, zf *zip.File) error {
+	size := int64(zf.UncompressedSize64)
+	if zf.Method == zip.Store {
+		off, err := zf.DataOffset()
+		if err != nil {
+			return err
+		}
+		return fp.ProcessReaderAt(ctx, io.NewSectionReader(fp.src, off, size), size)
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// the entry is read through zip's own ReaderAt, progress is of its decompressed bytes
+	sub := fp.withSource(nil)
+	defer sub.progress.Run(size)()
+
+	return sub.ProcessReader(ctx, rc)
+}
+
+// withSource Returns a shallow copy of fp reading from src with its own progress,
+// the bitset is shared so uniques are counted across all sources.
+func (fp *FileProcessor) withSource(src io.ReaderAt) *FileProcessor {
+	cp := *fp
+	cp.src = src
+	cp.progress = NewProgress(fp.logger)
+
+	return &cp
+}
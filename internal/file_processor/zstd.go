@@ -0,0 +1,105 @@
+package file_processor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+// ErrBadSeekTable returned on a malformed zstd seek table.
+var ErrBadSeekTable = errors.New("bad zstd seek table")
+
+// seekable zstd format, https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+const (
+	seekableMagic     = 0x8F92EAB1
+	skippableMagic    = 0x184D2A5E
+	seekFooterSize    = 9
+	maxSeekableFrames = 1 << 27 // format limit
+)
+
+// ProcessZstd Counts a .zst file. Files of the seekable format are split into groups of frames
+// decompressed in parallel(one decoder per shard), other files are decompressed by a single goroutine.
+func (fp *FileProcessor) ProcessZstd(ctx context.Context, fi os.FileInfo) error {
+	frames, err := readSeekTable(fp.src, fi.Size())
+	if err != nil {
+		return err
+	}
+	if frames == nil {
+		fp.logger.Info("zstd file is not seekable, decompressing sequentially")
+		defer fp.progress.Run(fi.Size())()
+		dec, err := zstd.NewReader(fp.progress.reader(io.NewSectionReader(fp.src, 0, fi.Size())), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		defer dec.Close()
+
+		return fp.processStream(ctx, dec, false)
+	}
+
+	fp.logger.Info("seekable zstd", zap.Int("frames", len(frames)))
+
+	return fp.processFrames(ctx, frames, func(r io.Reader) (io.ReadCloser, error) {
+		// the seek table is a skippable frame, the decoder steps over it
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	})
+}
+
+// readSeekTable Frames of a seekable zstd file, nil when the file has no seek table.
+func readSeekTable(src io.ReaderAt, size int64) ([]frame, error) {
+	if size < seekFooterSize+8 {
+		return nil, nil
+	}
+	footer := make([]byte, seekFooterSize)
+	if _, err := src.ReadAt(footer, size-seekFooterSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, nil
+	}
+	n := int64(binary.LittleEndian.Uint32(footer))
+	entrySize := int64(8)
+	if footer[4]&0x80 != 0 {
+		entrySize = 12 // + checksum
+	}
+	if n == 0 || n > maxSeekableFrames || footer[4]&0x7c != 0 {
+		return nil, fmt.Errorf("%w: %d frames, descriptor %#x", ErrBadSeekTable, n, footer[4])
+	}
+	tableSize := 8 + n*entrySize + seekFooterSize
+	if tableSize > size {
+		return nil, fmt.Errorf("%w: table of %d bytes", ErrBadSeekTable, tableSize)
+	}
+	table := make([]byte, tableSize)
+	if _, err := src.ReadAt(table, size-tableSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(table) != skippableMagic || int64(binary.LittleEndian.Uint32(table[4:])) != tableSize-8 {
+		return nil, fmt.Errorf("%w: skippable frame header", ErrBadSeekTable)
+	}
+
+	frames := make([]frame, n)
+	var off, dOff int64
+	for i := range frames {
+		e := table[8+int64(i)*entrySize:]
+		frames[i] = frame{
+			off: off, size: int64(binary.LittleEndian.Uint32(e)),
+			dOff: dOff, dSize: int64(binary.LittleEndian.Uint32(e[4:])),
+		}
+		off += frames[i].size
+		dOff += frames[i].dSize
+	}
+	if off != size-tableSize {
+		return nil, fmt.Errorf("%w: frames cover %d of %d bytes", ErrBadSeekTable, off, size-tableSize)
+	}
+
+	return frames, nil
+}
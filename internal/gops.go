@@ -0,0 +1,7 @@
+//go:build gops
+
+package internal
+
+import "github.com/google/gops/agent"
+
+func startGops() error { return agent.Listen(agent.Options{}) }
@@ -0,0 +1,5 @@
+//go:build !gops
+
+package internal
+
+func startGops() error { return errNoGops }
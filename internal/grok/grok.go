@@ -0,0 +1,180 @@
+package grok
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrBadPattern returned on a grok expression or pattern file that cannot be compiled.
+var ErrBadPattern = errors.New("bad grok pattern")
+
+// captureName Group of the extracted field in the compiled regexp.
+const captureName = "grok_field"
+
+// maxDepth Nesting limit of %{NAME} references(cycles).
+const maxDepth = 64
+
+var reference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::\w+)?\}`)
+
+type (
+	// Library Named patterns referenced as %{NAME} by grok expressions.
+	Library map[string]string
+
+	// Grok Compiled grok expression extracting one field of a line.
+	Grok struct {
+		re    *regexp.Regexp
+		index int
+		field string
+	}
+)
+
+// Builtin The library of the common Logstash patterns(IPORHOST, HTTPDATE, COMBINEDAPACHELOG, ...).
+func Builtin() Library {
+	l := Library{}
+	_ = l.read(strings.NewReader(builtin), "builtin")
+
+	return l
+}
+
+// LoadDir Adds the patterns of the files of dir("NAME regexp" lines, # comments) to l,
+// later definitions of a name replace earlier ones.
+func (l Library) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if err = l.LoadFile(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadFile Adds the patterns of one pattern file to l.
+func (l Library) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return l.read(f, path)
+}
+
+func (l Library) read(r io.Reader, name string) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		k, v, ok := strings.Cut(line, " ")
+		if !ok {
+			k, v, ok = strings.Cut(line, "\t")
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s:%d: want \"NAME regexp\"", ErrBadPattern, name, n)
+		}
+		l[k] = strings.TrimSpace(v)
+	}
+
+	return sc.Err()
+}
+
+// Compile Compiles expr, e.g. "%{IPORHOST:client}" or "%{COMBINEDAPACHELOG}", extracting field:
+// "" - the first named field of expr. Only the extracted field is captured, the others are
+// compiled to non-capturing groups.
+func (l Library) Compile(expr, field string) (*Grok, error) {
+	g := &Grok{field: field}
+	src, err := l.expand(expr, g, 0)
+	if err != nil {
+		return nil, err
+	}
+	if g.field == "" {
+		return nil, fmt.Errorf("%w: %q has no named field", ErrBadPattern, expr)
+	}
+	if g.index == 0 {
+		return nil, fmt.Errorf("%w: field %q not found in %q", ErrBadPattern, g.field, expr)
+	}
+	if g.re, err = regexp.Compile(src); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPattern, err)
+	}
+	g.index = g.re.SubexpIndex(captureName)
+
+	return g, nil
+}
+
+// expand Replaces the %{NAME[:field[:type]]} references of expr, index is set once the
+// group of the extracted field is emitted.
+func (l Library) expand(expr string, g *Grok, depth int) (string, error) {
+	if depth > maxDepth {
+		return "", fmt.Errorf("%w: references nested deeper than %d(cycle?)", ErrBadPattern, maxDepth)
+	}
+	// atomic groups are not supported by RE2, the plain group matches the same lines in practice
+	expr = strings.ReplaceAll(expr, "(?>", "(?:")
+
+	var (
+		b    strings.Builder
+		last int
+	)
+	for _, m := range reference.FindAllStringSubmatchIndex(expr, -1) {
+		b.WriteString(expr[last:m[0]])
+		last = m[1]
+
+		name := expr[m[2]:m[3]]
+		def, ok := l[name]
+		if !ok {
+			return "", fmt.Errorf("%w: unknown pattern %%{%s}", ErrBadPattern, name)
+		}
+		// decided before the nested references: the outermost group of the field is captured
+		capture := false
+		if m[4] >= 0 && g.index == 0 {
+			if g.field == "" {
+				g.field = expr[m[4]:m[5]]
+			}
+			if capture = g.field == expr[m[4]:m[5]]; capture {
+				g.index = 1
+			}
+		}
+		inner, err := l.expand(def, g, depth+1)
+		if err != nil {
+			return "", err
+		}
+		if capture {
+			b.WriteString("(?P<" + captureName + ">" + inner + ")")
+		} else {
+			b.WriteString("(?:" + inner + ")")
+		}
+	}
+	b.WriteString(expr[last:])
+
+	return b.String(), nil
+}
+
+// Field The extracted field of line, nil when the line does not match.
+func (g *Grok) Field(line []byte) []byte {
+	m := g.re.FindSubmatchIndex(line)
+	if m == nil || m[2*g.index] < 0 {
+		return nil
+	}
+
+	return line[m[2*g.index]:m[2*g.index+1]]
+}
+
+// Name The extracted field.
+func (g *Grok) Name() string { return g.field }
+
+// String The compiled regexp.
+func (g *Grok) String() string { return g.re.String() }
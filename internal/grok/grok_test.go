@@ -0,0 +1,98 @@
+package grok
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	t.Parallel()
+
+	const combined = `203.0.113.9 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "http://x/" "Mozilla/5.0"`
+
+	tests := []struct {
+		name, expr, field, line, want string
+	}{
+		{name: "iporhost", expr: "%{IPORHOST:client}", line: "10.0.0.1", want: "10.0.0.1"},
+		{name: "hostname", expr: "^%{IPORHOST:client} ", line: "example.com GET", want: "example.com"},
+		{name: "combined", expr: "%{COMBINEDAPACHELOG}", line: combined, want: "203.0.113.9"},
+		{name: "combined other field", expr: "%{COMBINEDAPACHELOG}", field: "auth", line: combined, want: "frank"},
+		{name: "first named field", expr: `%{WORD} user=%{USER:user} src=%{IP:src}`, line: "login user=bob src=10.1.2.3", want: "bob"},
+		{name: "explicit field", expr: `%{WORD} user=%{USER:user} src=%{IP:src}`, field: "src", line: "login user=bob src=10.1.2.3", want: "10.1.2.3"},
+		{name: "typed field", expr: `port=%{INT:port:int} %{IPV4:ip}`, field: "ip", line: "port=80 1.2.3.4", want: "1.2.3.4"},
+		{name: "atomic group", expr: `(?>a|b)%{IPV4:ip}`, line: "b9.9.9.9", want: "9.9.9.9"},
+		{name: "no match", expr: "^%{IPV4:ip}$", line: "nope", want: ""},
+	}
+	lib := Builtin()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			g, err := lib.Compile(tt.expr, tt.field)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tt.expr, err)
+			}
+			if got := string(g.Field([]byte(tt.line))); got != tt.want {
+				t.Fatalf("Field(%q)=%q; want %q(re %s)", tt.line, got, tt.want, g)
+			}
+		})
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	t.Parallel()
+
+	lib := Builtin()
+	lib["LOOP"] = "%{LOOP}"
+	for _, tt := range []struct{ expr, field string }{
+		{expr: "%{NOPE:x}"},
+		{expr: "%{IPV4}"},
+		{expr: "%{IPV4:ip}", field: "client"},
+		{expr: "%{LOOP:x}"},
+		{expr: "(?<=x)%{IPV4:ip}"},
+	} {
+		if _, err := lib.Compile(tt.expr, tt.field); !errors.Is(err, ErrBadPattern) {
+			t.Fatalf("Compile(%q, %q) err=%v; want ErrBadPattern", tt.expr, tt.field, err)
+		}
+	}
+}
+
+func TestLibrary_LoadDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	patterns := "# custom\nGATEWAY gw-%{INT}\n\nEDGELOG %{GATEWAY} client=%{IP:client}\n"
+	if err := os.WriteFile(filepath.Join(dir, "edge"), []byte(patterns), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lib := Builtin()
+	if err := lib.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	g, err := lib.Compile("%{EDGELOG}", "")
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if got := string(g.Field([]byte("gw-7 client=192.0.2.1"))); got != "192.0.2.1" || g.Name() != "client" {
+		t.Fatalf("Field=%q Name=%q; want 192.0.2.1 client", got, g.Name())
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "bad"), []byte("NOVALUE\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = Builtin().LoadDir(dir); !errors.Is(err, ErrBadPattern) {
+		t.Fatalf("LoadDir(bad) err=%v; want ErrBadPattern", err)
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	t.Parallel()
+
+	lib := Builtin()
+	for name := range lib {
+		if _, err := lib.Compile("%{"+name+":x}", ""); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
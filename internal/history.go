@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+type (
+	// history NDJSON log of the counts of past runs(-history), every counted input appends its record,
+	// the previous record of its series is what the summary compares against.
+	history struct {
+		path string
+		tag  string // the series of every input, empty - the series of an input is its path
+	}
+	historyRecord struct {
+		Tag     string    `json:"tag"`
+		Path    string    `json:"path"`
+		Time    time.Time `json:"time"`
+		Unique  uint64    `json:"unique"`
+		Seconds float64   `json:"seconds"`
+	}
+	// historyDelta The count against the previous run of the series.
+	historyDelta struct {
+		Time     time.Time `json:"time"`      // of the previous run
+		Unique   uint64    `json:"unique"`    // of the previous run
+		Delta    int64     `json:"delta"`     // Unique of this run - Unique of the previous one
+		DeltaPct float64   `json:"delta_pct"` // Delta in percent of the previous count, 0 when it was 0
+	}
+)
+
+func newHistory(path, tag string) *history { return &history{path: path, tag: tag} }
+
+// record Compares s with the previous run of its series and appends s to the history.
+func (h *history) record(s summary) (*historyDelta, error) {
+	rec := historyRecord{Tag: h.tag, Path: s.Path, Time: time.Now().UTC(), Unique: s.Unique, Seconds: s.Seconds}
+	if rec.Tag == "" {
+		rec.Tag = s.Path
+	}
+	prev, err := h.previous(rec.Tag)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.append(rec); err != nil {
+		return nil, err
+	}
+	if prev == nil {
+		return nil, nil
+	}
+
+	d := &historyDelta{Time: prev.Time, Unique: prev.Unique, Delta: int64(rec.Unique) - int64(prev.Unique)}
+	if prev.Unique > 0 {
+		d.DeltaPct = float64(d.Delta) * 100 / float64(prev.Unique)
+	}
+
+	return d, nil
+}
+
+// previous The last record of tag, nil without one or without a history file yet.
+func (h *history) previous(tag string) (*historyRecord, error) {
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var last *historyRecord
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec historyRecord
+		if json.Unmarshal(sc.Bytes(), &rec) != nil || rec.Tag != tag {
+			// a torn line of an interrupted run or another series
+			continue
+		}
+		last = &rec
+	}
+
+	return last, sc.Err()
+}
+
+func (h *history) append(rec historyRecord) error {
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	b, _ := json.Marshal(rec)
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
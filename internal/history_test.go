@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "history.ndjson")
+	h := newHistory(path, "")
+
+	if d, err := h.record(summary{Path: "a.log", Unique: 1000}); err != nil || d != nil {
+		t.Fatalf("first run: delta %+v, err=%v; want none", d, err)
+	}
+	if d, err := h.record(summary{Path: "b.log", Unique: 10}); err != nil || d != nil {
+		t.Fatalf("another series: delta %+v, err=%v; want none", d, err)
+	}
+	// a torn line of an interrupted run is ignored
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	_, _ = f.WriteString("{\"tag\":\"a.log\",\"uni\n")
+	_ = f.Close()
+
+	s := summary{Path: "a.log", Unique: 1250, Seconds: 1}
+	d, err := h.record(s)
+	if err != nil || d == nil || d.Unique != 1000 || d.Delta != 250 || d.DeltaPct != 25 {
+		t.Fatalf("second run: delta %+v, err=%v; want +250(+25%%) against 1000", d, err)
+	}
+	s.Previous = d
+	tmpl, err := parseSummaryTemplate(defaultSummaryTemplate)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err = s.write(&buf, tmpl); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if want := "unique ip's: 1250, vs previous run: +250(+25.00%), total time: 1 sec\n"; buf.String() != want {
+		t.Fatalf("got %q; want %q", buf.String(), want)
+	}
+	if r := newResult(s, nil); r.Previous == nil || r.Previous.Delta != 250 {
+		t.Fatalf("result %+v; want the delta", r)
+	}
+
+	// a tag makes a series of differently named inputs
+	h = newHistory(path, "nightly")
+	_, _ = h.record(summary{Path: "2024-01-01.log", Unique: 200})
+	if d, err = h.record(summary{Path: "2024-01-02.log", Unique: 150}); err != nil || d == nil || d.Delta != -50 || d.DeltaPct != -25 {
+		t.Fatalf("tagged run: delta %+v, err=%v; want -50(-25%%)", d, err)
+	}
+}
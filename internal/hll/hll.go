@@ -0,0 +1,108 @@
+// Package hll HyperLogLog cardinality sketch of IPv4 addresses: a fixed 2^p registers
+// whatever the count, at a relative standard error of 1.04/sqrt(2^p).
+package hll
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+// DefaultPrecision 16 384 registers(16 KB), ~0.8% standard error.
+const DefaultPrecision = 14
+
+// Sketch Concurrency safe: a register is a byte(ranks are at most 61) of a 32-bit word,
+// raised with a CAS of the word.
+type Sketch struct {
+	p     uint8
+	words []atomic.Uint32 // 4 registers each
+}
+
+// New p is clamped to 4..18.
+func New(p uint8) *Sketch {
+	p = min(max(p, 4), 18)
+	return &Sketch{p: p, words: make([]atomic.Uint32, 1<<p/4)}
+}
+
+// Add Counts u32.
+func (s *Sketch) Add(u32 uint32) {
+	h := mix(uint64(u32))
+	i := h >> (64 - s.p)
+	w, shift := &s.words[i/4], i%4*8
+	// rank: leading zeros of the remaining bits + 1, the sentinel bit bounds it
+	rank := uint32(bits.LeadingZeros64(h<<s.p|1<<(s.p-1)) + 1)
+	for {
+		old := w.Load()
+		if old>>shift&0xFF >= rank || w.CompareAndSwap(old, old&^(0xFF<<shift)|rank<<shift) {
+			return
+		}
+	}
+}
+
+// Estimate Estimated distinct addresses added, linear counting while registers are still empty.
+func (s *Sketch) Estimate() uint64 {
+	n := 1 << s.p
+	m := float64(n)
+	var (
+		sum   float64
+		zeros int
+	)
+	for i := range s.words {
+		w := s.words[i].Load()
+		for range 4 {
+			r := w & 0xFF
+			if r == 0 {
+				zeros++
+			}
+			sum += math.Ldexp(1, -int(r))
+			w >>= 8
+		}
+	}
+	if zeros == n {
+		return 0
+	}
+	e := alpha(n) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(math.Round(e))
+}
+
+// alpha Bias correction of the raw estimate for m registers, the constants of the HyperLogLog paper
+// below 128 registers.
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// Empty Nothing was added.
+func (s *Sketch) Empty() bool {
+	for i := range s.words {
+		if s.words[i].Load() != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// StdError Relative standard error of Estimate.
+func (s *Sketch) StdError() float64 { return 1.04 / math.Sqrt(float64(int(1)<<s.p)) }
+
+// mix splitmix64 finalizer, addresses are far from uniform.
+func mix(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ x>>30) * 0xBF58476D1CE4E5B9
+	x = (x ^ x>>27) * 0x94D049BB133111EB
+
+	return x ^ x>>31
+}
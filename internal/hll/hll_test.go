@@ -0,0 +1,104 @@
+package hll
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"testing"
+)
+
+func TestSketch_Estimate(t *testing.T) {
+	t.Parallel()
+	for _, n := range []int{0, 1, 100, 10_000, 1_000_000} {
+		s := New(DefaultPrecision)
+		for i := range n {
+			// sequential addresses: the worst case for a weak hash
+			s.Add(uint32(i))
+			s.Add(uint32(i)) // duplicates are free
+		}
+		got := float64(s.Estimate())
+		if n == 0 {
+			if got != 0 || !s.Empty() {
+				t.Fatalf("empty sketch: estimate %v", got)
+			}
+			continue
+		}
+		if e := math.Abs(got-float64(n)) / float64(n); e > 4*s.StdError() {
+			t.Fatalf("n=%d: estimate %v, error %.4f > 4 sigma(%.4f)", n, got, e, 4*s.StdError())
+		}
+	}
+}
+
+func TestSketch_Concurrent(t *testing.T) {
+	t.Parallel()
+	s := New(DefaultPrecision)
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// every worker adds an overlapping half
+			for i := w * 50_000; i < w*50_000+100_000; i++ {
+				s.Add(uint32(i))
+			}
+		}()
+	}
+	wg.Wait()
+	const n = 450_000
+	if e := math.Abs(float64(s.Estimate())-n) / n; e > 4*s.StdError() {
+		t.Fatalf("estimate %d, error %.4f", s.Estimate(), e)
+	}
+}
+
+func TestAlpha(t *testing.T) {
+	t.Parallel()
+	for m, want := range map[int]float64{16: 0.673, 32: 0.697, 64: 0.709, 128: 0.7213 / (1 + 1.079/128.0)} {
+		if got := alpha(m); got != want {
+			t.Fatalf("alpha(%d)=%v; want %v", m, got, want)
+		}
+	}
+}
+
+func TestSketch_EstimatePrecisions(t *testing.T) {
+	t.Parallel()
+	// the mean relative error of many disjoint runs past linear counting(n = 8m) is the bias of the raw estimate
+	const runs = 400
+	for _, p := range []uint8{4, 5, 6, DefaultPrecision} {
+		m := 1 << p
+		n := 8 * m
+		var sum float64
+		for r := range runs {
+			s := New(p)
+			for i := range n {
+				s.Add(uint32(r*n + i))
+			}
+			sum += (float64(s.Estimate()) - float64(n)) / float64(n)
+		}
+		mean := sum / runs
+		if lim := 4 * New(p).StdError() / math.Sqrt(runs); math.Abs(mean) > lim {
+			t.Fatalf("p=%d: mean relative error %.4f > %.4f", p, mean, lim)
+		}
+	}
+}
+
+func TestSketch_PackedRegisters(t *testing.T) {
+	t.Parallel()
+	const p = 6
+	s := New(p)
+	if got := len(s.words) * 4; got != 1<<p {
+		t.Fatalf("%d registers; want %d", got, 1<<p)
+	}
+	// reference registers: a neighbour in the same word must never lower or clobber another
+	want := make([]uint32, 1<<p)
+	for i := range uint32(5000) {
+		s.Add(i)
+		h := mix(uint64(i))
+		rank := uint32(bits.LeadingZeros64(h<<p|1<<(p-1)) + 1)
+		want[h>>(64-p)] = max(want[h>>(64-p)], rank)
+	}
+	for i := range want {
+		if got := s.words[i/4].Load() >> (i % 4 * 8) & 0xFF; got != want[i] {
+			t.Fatalf("register %d=%d; want %d", i, got, want[i])
+		}
+	}
+}
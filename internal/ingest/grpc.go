@@ -0,0 +1,333 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// grpcIngestPath method of proto/uipcounter/v1/ingest.proto
+const grpcIngestPath = "/uipcounter.v1.Ingest/Ingest"
+
+// grpcMaxMessage default receive limit of gRPC implementations
+const grpcMaxMessage = 4 << 20
+
+// gRPC status codes
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+)
+
+// GRPCConfig of the "serve grpc" mode.
+type GRPCConfig struct {
+	Addr string
+}
+
+// ServeGRPC Serves the uipcounter.v1.Ingest service over cleartext HTTP/2 until ctx is done,
+// every stream dedups its batches into the shared sink.
+func ServeGRPC(ctx context.Context, logger *zap.Logger, cfg GRPCConfig, sink *Sink) error {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("grpc listener started", zap.String("addr", ln.Addr().String()))
+
+	srv := &http.Server{Handler: GRPCHandler(logger, sink), ReadHeaderTimeout: 10 * time.Second}
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	if err = srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// GRPCHandler Handles the Ingest client streaming RPC: length prefixed IPBatch messages
+// are read until the client closes the stream, the IngestSummary is the single response.
+func GRPCHandler(logger *zap.Logger, sink *Sink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		if r.URL.Path != grpcIngestPath {
+			grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+			return
+		}
+
+		var sum IngestSummary
+		code, err := readBatches(r.Body, sink, &sum)
+		if err != nil {
+			logger.Warn("grpc stream failed", zap.String("remote", r.RemoteAddr), zap.Error(err))
+			grpcStatus(w, code, err.Error())
+			return
+		}
+		sum.Unique = sink.Unique()
+		_, _ = w.Write(grpcFrame(sum.marshal()))
+		grpcStatus(w, grpcOK, "")
+	})
+}
+
+// IngestSummary uipcounter.v1.IngestSummary
+type IngestSummary struct {
+	// Unique running count of the server when the stream closed
+	Unique uint64
+	// Added addresses of the stream that were new to the server
+	Added            uint64
+	Records, Invalid uint64
+}
+
+func (s IngestSummary) marshal() []byte {
+	var b []byte
+	for i, v := range []uint64{s.Unique, s.Added, s.Records, s.Invalid} {
+		if v != 0 {
+			b = binary.AppendUvarint(b, uint64(i+1)<<3|pbVarint)
+			b = binary.AppendUvarint(b, v)
+		}
+	}
+
+	return b
+}
+
+func (s *IngestSummary) unmarshal(b []byte) error {
+	return pbFields(b, func(num, typ int, v uint64, _ []byte) error {
+		if typ != pbVarint {
+			return nil
+		}
+		switch num {
+		case 1:
+			s.Unique = v
+		case 2:
+			s.Added = v
+		case 3:
+			s.Records = v
+		case 4:
+			s.Invalid = v
+		}
+		return nil
+	})
+}
+
+// readBatches Feeds the IPBatch messages of a stream into sink until EOF,
+// the status code is the one to answer with when an error is returned.
+func readBatches(r io.Reader, sink *Sink, sum *IngestSummary) (int, error) {
+	var hdr [5]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return grpcOK, nil
+			}
+			return grpcInternal, err
+		}
+		if hdr[0] != 0 {
+			return grpcUnimplemented, errors.New("compressed messages are not supported")
+		}
+		n := binary.BigEndian.Uint32(hdr[1:])
+		if n > grpcMaxMessage {
+			return grpcResourceExhausted, fmt.Errorf("message of %d bytes exceeds %d", n, grpcMaxMessage)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return grpcInternal, err
+		}
+		if err := addBatch(msg, sink, sum); err != nil {
+			return grpcInvalidArgument, err
+		}
+	}
+}
+
+// addBatch Decodes an IPBatch straight into sink, unknown fields are skipped.
+func addBatch(msg []byte, sink *Sink, sum *IngestSummary) error {
+	add := func(u32 uint32) {
+		sink.records.Add(1)
+		sum.Records++
+		if sink.AddUint32(u32) {
+			sum.Added++
+		}
+	}
+
+	return pbFields(msg, func(num, typ int, v uint64, payload []byte) error {
+		switch {
+		case num == 1 && typ == pbBytes:
+			if len(payload)%4 != 0 {
+				return errors.New("bad packed addrs")
+			}
+			for ; len(payload) > 0; payload = payload[4:] {
+				add(binary.LittleEndian.Uint32(payload))
+			}
+		case num == 1 && typ == pbI32:
+			// unpacked encoding of a repeated field is valid protobuf too
+			add(uint32(v))
+		case num == 2 && typ == pbBytes:
+			u32, ok := sink.bitset.IPv4ByteToUint32(bytes.TrimSpace(payload))
+			if !ok {
+				sink.records.Add(1)
+				sink.invalid.Add(1)
+				sum.Records++
+				sum.Invalid++
+				return nil
+			}
+			add(u32)
+		}
+		return nil
+	})
+}
+
+// PushGRPC Streams addrs to the Ingest service at addr(host:port, cleartext HTTP/2) as batches
+// of up to batch addresses, the summary is the answer of the server once the stream is closed.
+func PushGRPC(ctx context.Context, addr string, addrs iter.Seq[uint32], batch int) (IngestSummary, error) {
+	var sum IngestSummary
+	pr, pw := io.Pipe()
+	go func() {
+		msg := make([]byte, 0, 16+batch*4)
+		flush := func() error {
+			if len(msg) == 0 {
+				return nil
+			}
+			// field 1(packed fixed32) header in front of the values
+			hdr := binary.AppendUvarint([]byte{1<<3 | pbBytes}, uint64(len(msg)))
+			_, err := pw.Write(grpcFrame(append(hdr, msg...)))
+			msg = msg[:0]
+			return err
+		}
+		for u32 := range addrs {
+			msg = binary.LittleEndian.AppendUint32(msg, u32)
+			if len(msg) == batch*4 {
+				if err := flush(); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+		}
+		pw.CloseWithError(flush())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+grpcIngestPath, pr)
+	if err != nil {
+		_ = pr.Close()
+		return sum, err
+	}
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("TE", "trailers")
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	defer tr.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		_ = pr.CloseWithError(err)
+		return sum, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, grpcMaxMessage))
+	if err != nil {
+		return sum, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return sum, fmt.Errorf("ingest: HTTP %s", resp.Status)
+	}
+	// a trailers-only(error) response carries the status in the headers
+	status, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != strconv.Itoa(grpcOK) {
+		return sum, fmt.Errorf("ingest: grpc status %s: %s", status, msg)
+	}
+	if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:])) != len(body)-5 {
+		return sum, errors.New("ingest: bad response message")
+	}
+
+	return sum, sum.unmarshal(body[5:])
+}
+
+// grpcStatus Ends the response with the gRPC status trailers.
+func grpcStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", msg)
+	}
+}
+
+// grpcFrame Length prefixed(uncompressed) gRPC message.
+func grpcFrame(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// protobuf wire types
+const (
+	pbVarint = 0
+	pbI64    = 1
+	pbBytes  = 2
+	pbI32    = 5
+)
+
+// pbFields Calls fn for every field of a message, v is the value of varint/fixed fields,
+// payload of length delimited ones.
+func pbFields(b []byte, fn func(num, typ int, v uint64, payload []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("bad field key")
+		}
+		b = b[n:]
+		num, typ := int(key>>3), int(key&7)
+
+		var (
+			v       uint64
+			payload []byte
+		)
+		switch typ {
+		case pbVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("bad varint")
+			}
+			b = b[n:]
+		case pbI64:
+			if len(b) < 8 {
+				return errors.New("truncated fixed64")
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case pbI32:
+			if len(b) < 4 {
+				return errors.New("truncated fixed32")
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case pbBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return fmt.Errorf("truncated field %d", num)
+			}
+			payload, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return fmt.Errorf("unsupported wire type %d", typ)
+		}
+		if err := fn(num, typ, v, payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// ipBatch Encodes an uipcounter.v1.IPBatch.
+func ipBatch(addrs []uint32, text ...string) []byte {
+	var packed, b []byte
+	for _, a := range addrs {
+		packed = binary.LittleEndian.AppendUint32(packed, a)
+	}
+	if len(packed) > 0 {
+		b = append(b, 1<<3|pbBytes)
+		b = binary.AppendUvarint(b, uint64(len(packed)))
+		b = append(b, packed...)
+	}
+	for _, s := range text {
+		b = append(b, 2<<3|pbBytes)
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+
+	return b
+}
+
+func Test_ServeGRPC(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := NewSink(ipv4_bitset.New())
+	done := make(chan error, 1)
+	go func() { done <- ServeGRPC(ctx, zap.NewNop(), GRPCConfig{Addr: addr}, sink) }()
+
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: tr}
+	send := func(msgs ...[]byte) (*http.Response, []byte) {
+		var body bytes.Buffer
+		for _, m := range msgs {
+			body.Write(grpcFrame(m))
+		}
+		req, _ := http.NewRequest(http.MethodPost, "http://"+addr+grpcIngestPath, &body)
+		req.Header.Set("Content-Type", "application/grpc")
+		var resp *http.Response
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if resp, err = client.Do(req); err == nil {
+				break
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body.Bytes()))
+		}
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		defer resp.Body.Close()
+		out, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+
+		return resp, out
+	}
+
+	resp, out := send(
+		ipBatch([]uint32{0x01020304, 0x05060708}),
+		ipBatch([]uint32{0x01020304}, "5.6.7.8", "9.9.9.9", "bad"),
+	)
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Fatalf("grpc-status = %q(%s), want 0", got, resp.Trailer.Get("Grpc-Message"))
+	}
+	if len(out) < 5 || int(binary.BigEndian.Uint32(out[1:])) != len(out)-5 {
+		t.Fatalf("bad response frame %x", out)
+	}
+	got := map[int]uint64{}
+	if err = pbFields(out[5:], func(num, _ int, v uint64, _ []byte) error { got[num] = v; return nil }); err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if got[1] != 3 || got[2] != 3 || got[3] != 6 || got[4] != 1 {
+		t.Fatalf("summary unique=%d added=%d records=%d invalid=%d; want 3, 3, 6, 1", got[1], got[2], got[3], got[4])
+	}
+
+	// a second stream sees the running count of the shared set
+	_, out = send(ipBatch([]uint32{0x01020304, 0x0A000001}))
+	got = map[int]uint64{}
+	_ = pbFields(out[5:], func(num, _ int, v uint64, _ []byte) error { got[num] = v; return nil })
+	if got[1] != 4 || got[2] != 1 {
+		t.Fatalf("summary unique=%d added=%d; want 4, 1", got[1], got[2])
+	}
+
+	resp, _ = send([]byte{0x0A, 0x03, 0x01})
+	if got := resp.Trailer.Get("Grpc-Status"); got != "3" {
+		t.Fatalf("grpc-status of a bad batch = %q, want 3", got)
+	}
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatalf("ServeGRPC: %v", err)
+	}
+	if sink.Unique() != 4 || sink.Invalid() != 1 {
+		t.Fatalf("Unique=%d Invalid=%d; want 4, 1", sink.Unique(), sink.Invalid())
+	}
+}
+
+func Test_PushGRPC(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := NewSink(ipv4_bitset.New())
+	done := make(chan error, 1)
+	go func() { done <- ServeGRPC(ctx, zap.NewNop(), GRPCConfig{Addr: addr}, sink) }()
+
+	seq := func(from, n uint32) func(yield func(uint32) bool) {
+		return func(yield func(uint32) bool) {
+			for u := from; u < from+n; u++ {
+				if !yield(u) {
+					return
+				}
+			}
+		}
+	}
+	var sum IngestSummary
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if sum, err = PushGRPC(context.Background(), addr, seq(0x0A000000, 1000), 64); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("PushGRPC: %v", err)
+	}
+	if sum != (IngestSummary{Unique: 1000, Added: 1000, Records: 1000}) {
+		t.Fatalf("summary %+v; want 1000 added", sum)
+	}
+
+	// a second host overlapping by half
+	if sum, err = PushGRPC(context.Background(), addr, seq(0x0A000000+500, 1000), 1<<10); err != nil {
+		t.Fatalf("PushGRPC: %v", err)
+	}
+	if sum != (IngestSummary{Unique: 1500, Added: 500, Records: 1000}) {
+		t.Fatalf("summary %+v; want 500 of 1000 added, 1500 unique", sum)
+	}
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatalf("ServeGRPC: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// KafkaConfig of the "serve kafka" mode.
+type KafkaConfig struct {
+	Brokers        []string
+	Topic, GroupID string
+	// Field JSON field holding the address, empty - the whole message value is the address
+	Field string
+	// StatePath bitset snapshot persisted before every commit, empty - in memory only
+	StatePath      string
+	ReportInterval time.Duration
+}
+
+// kafkaOffsets The highest consumed offset of every partition, what a commit needs of the messages.
+type kafkaOffsets map[int]int64
+
+func (o kafkaOffsets) add(m kafka.Message) {
+	if off, ok := o[m.Partition]; !ok || m.Offset > off {
+		o[m.Partition] = m.Offset
+	}
+}
+
+// messages One message per partition carrying its highest offset, for CommitMessages.
+func (o kafkaOffsets) messages(topic string) []kafka.Message {
+	msgs := make([]kafka.Message, 0, len(o))
+	for p, off := range o {
+		msgs = append(msgs, kafka.Message{Topic: topic, Partition: p, Offset: off})
+	}
+
+	return msgs
+}
+
+// ConsumeKafka Feeds every message of the topic into sink until ctx is done.
+// Offsets are committed together with the periodic unique count report, after the state is persisted,
+// so a restart resumes from the group offsets without losing the addresses counted before them.
+func ConsumeKafka(ctx context.Context, logger *zap.Logger, cfg KafkaConfig, sink *Sink) error {
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: cfg.Brokers,
+		Topic:   cfg.Topic,
+		GroupID: cfg.GroupID,
+	})
+	defer r.Close()
+
+	var (
+		pending = kafkaOffsets{}
+		ticker  = time.NewTicker(cfg.ReportInterval)
+	)
+	defer ticker.Stop()
+
+	report := func() error {
+		if cfg.StatePath != "" {
+			if err := SaveState(cfg.StatePath, sink.Bitset()); err != nil {
+				return err
+			}
+		}
+		logger.Info("kafka unique count",
+			zap.Uint64("unique", sink.Unique()),
+			zap.Uint64("records", sink.Records()),
+			zap.Uint64("invalid", sink.Invalid()),
+		)
+		if len(pending) == 0 {
+			return nil
+		}
+		// commit with a fresh context so the final commit survives the shutdown
+		cctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := r.CommitMessages(cctx, pending.messages(cfg.Topic)...); err != nil {
+			return err
+		}
+		clear(pending)
+
+		return nil
+	}
+
+	for {
+		fctx, cancel := context.WithTimeout(ctx, cfg.ReportInterval)
+		m, err := r.FetchMessage(fctx)
+		cancel()
+		switch {
+		case err == nil:
+			sink.Add(messageValue(m.Value, cfg.Field))
+			pending.add(m)
+		case ctx.Err() != nil:
+			return report()
+		case !errors.Is(err, context.DeadlineExceeded):
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+			if err = report(); err != nil {
+				return err
+			}
+		default:
+		}
+	}
+}
+
+// messageValue Extracts field from a JSON object value, the raw value without field.
+func messageValue(v []byte, field string) []byte {
+	if field == "" {
+		return v
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(v, &obj); err != nil {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(obj[field], &s); err != nil {
+		return nil
+	}
+
+	return []byte(s)
+}
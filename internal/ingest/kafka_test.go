@@ -0,0 +1,44 @@
+package ingest
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func Test_messageValue(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		v, field, want string
+	}{
+		{"1.2.3.4", "", "1.2.3.4"},
+		{`{"client_ip":"1.2.3.4","ua":"x"}`, "client_ip", "1.2.3.4"},
+		{`{"client_ip":42}`, "client_ip", ""},
+		{`not json`, "client_ip", ""},
+		{`{"other":"1.2.3.4"}`, "client_ip", ""},
+	}
+	for _, tt := range cases {
+		if got := string(messageValue([]byte(tt.v), tt.field)); got != tt.want {
+			t.Fatalf("messageValue(%q, %q)=%q; want %q", tt.v, tt.field, got, tt.want)
+		}
+	}
+}
+
+func Test_kafkaOffsets(t *testing.T) {
+	t.Parallel()
+	o := kafkaOffsets{}
+	for _, m := range []kafka.Message{
+		{Partition: 0, Offset: 5, Value: []byte("1.1.1.1")},
+		{Partition: 1, Offset: 2},
+		{Partition: 0, Offset: 7},
+		{Partition: 0, Offset: 6},
+	} {
+		o.add(m)
+	}
+	msgs := o.messages("clicks")
+	slices.SortFunc(msgs, func(a, b kafka.Message) int { return a.Partition - b.Partition })
+	if len(msgs) != 2 || msgs[0].Offset != 7 || msgs[1].Offset != 2 || msgs[0].Topic != "clicks" || msgs[0].Value != nil {
+		t.Fatalf("messages=%+v; want the highest offset of partitions 0 and 1, without payloads", msgs)
+	}
+}
@@ -0,0 +1,137 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// NATSConfig of the "serve nats" mode.
+type NATSConfig struct {
+	URL, Subject string
+	// Stream JetStream stream to consume with the Durable consumer, empty - core NATS subscription
+	Stream, Durable string
+	// Field JSON field holding the address, empty - the whole message is the address
+	Field string
+	// StatePath bitset snapshot persisted every ReportInterval, empty - in memory only
+	StatePath      string
+	ReportInterval time.Duration
+}
+
+// ConsumeNATS Feeds messages of the subject(or JetStream stream) into sink until ctx is done.
+// With JetStream the messages are acked only after the state is persisted,
+// so a restart resumes from the durable consumer without re-counting from scratch,
+// redelivered messages are harmless since setting a bit twice is idempotent.
+func ConsumeNATS(ctx context.Context, logger *zap.Logger, cfg NATSConfig, sink *Sink) error {
+	nc, err := nats.Connect(cfg.URL, nats.Name("uip-counter"))
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	checkpoint := func() error {
+		if cfg.StatePath != "" {
+			if err := SaveState(cfg.StatePath, sink.Bitset()); err != nil {
+				return err
+			}
+		}
+		logger.Info("nats unique count",
+			zap.Uint64("unique", sink.Unique()),
+			zap.Uint64("records", sink.Records()),
+			zap.Uint64("invalid", sink.Invalid()),
+		)
+
+		return nil
+	}
+
+	if cfg.Stream == "" {
+		return consumeCoreNATS(ctx, nc, cfg, sink, checkpoint)
+	}
+
+	return consumeJetStream(ctx, nc, cfg, sink, checkpoint)
+}
+
+func consumeCoreNATS(ctx context.Context, nc *nats.Conn, cfg NATSConfig, sink *Sink, checkpoint func() error) error {
+	msgs := make(chan *nats.Msg, 4096)
+	sub, err := nc.ChanSubscribe(cfg.Subject, msgs)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	ticker := time.NewTicker(cfg.ReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-msgs:
+			sink.Add(messageValue(m.Data, cfg.Field))
+		case <-ticker.C:
+			if err = checkpoint(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return checkpoint()
+		}
+	}
+}
+
+func consumeJetStream(ctx context.Context, nc *nats.Conn, cfg NATSConfig, sink *Sink, checkpoint func() error) error {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return err
+	}
+	cons, err := js.CreateOrUpdateConsumer(ctx, cfg.Stream, jetstream.ConsumerConfig{
+		Durable:       cfg.Durable,
+		FilterSubject: cfg.Subject,
+		// acking the last message of a checkpoint acks everything before it
+		AckPolicy: jetstream.AckAllPolicy,
+	})
+	if err != nil {
+		return err
+	}
+
+	var (
+		last       jetstream.Msg
+		lastReport = time.Now()
+	)
+	flush := func() error {
+		if err := checkpoint(); err != nil {
+			return err
+		}
+		lastReport = time.Now()
+		if last == nil {
+			return nil
+		}
+		err := last.Ack()
+		last = nil
+
+		return err
+	}
+
+	for {
+		batch, err := cons.Fetch(1024, jetstream.FetchMaxWait(cfg.ReportInterval))
+		if err != nil {
+			if ctx.Err() != nil {
+				return flush()
+			}
+			return err
+		}
+		for m := range batch.Messages() {
+			sink.Add(messageValue(m.Data(), cfg.Field))
+			last = m
+		}
+		if err = batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			return err
+		}
+
+		if ctx.Err() != nil || time.Since(lastReport) >= cfg.ReportInterval {
+			if err = flush(); err != nil || ctx.Err() != nil {
+				return err
+			}
+		}
+	}
+}
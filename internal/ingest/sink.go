@@ -0,0 +1,45 @@
+package ingest
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// Sink Feeds single records(messages, datagrams, lines) of long-running sources into a bitset.
+// Unlike file shards there is no "end" to aggregate at, so uniques are published immediately.
+type Sink struct {
+	bitset  *ipv4_bitset.Bitset
+	records atomic.Uint64
+	invalid atomic.Uint64
+}
+
+func NewSink(bitset *ipv4_bitset.Bitset) *Sink { return &Sink{bitset: bitset} }
+
+// Add Parses rec(surrounding whitespace ignored) and reports whether it is a new address.
+func (s *Sink) Add(rec []byte) bool {
+	s.records.Add(1)
+	u32, ok := s.bitset.IPv4ByteToUint32(bytes.TrimSpace(rec))
+	if !ok {
+		s.invalid.Add(1)
+		return false
+	}
+
+	return s.AddUint32(u32)
+}
+
+// AddUint32 Adds an already parsed address.
+func (s *Sink) AddUint32(u32 uint32) bool {
+	if s.bitset.SetIfNew(u32) {
+		s.bitset.AddUnique(1)
+		return true
+	}
+
+	return false
+}
+
+func (s *Sink) Unique() uint64              { return s.bitset.GetUniqueCount() }
+func (s *Sink) Records() uint64             { return s.records.Load() }
+func (s *Sink) Invalid() uint64             { return s.invalid.Load() }
+func (s *Sink) Bitset() *ipv4_bitset.Bitset { return s.bitset }
@@ -0,0 +1,26 @@
+package ingest
+
+import (
+	"testing"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+func TestSink_Add(t *testing.T) {
+	t.Parallel()
+	s := NewSink(ipv4_bitset.New())
+
+	recs := []string{"1.1.1.1", " 2.2.2.2\n", "1.1.1.1", "bad"}
+	news := 0
+	for _, r := range recs {
+		if s.Add([]byte(r)) {
+			news++
+		}
+	}
+	if news != 2 || s.Unique() != 2 {
+		t.Fatalf("new=%d Unique=%d; want 2, 2", news, s.Unique())
+	}
+	if s.Records() != 4 || s.Invalid() != 1 {
+		t.Fatalf("Records=%d Invalid=%d; want 4, 1", s.Records(), s.Invalid())
+	}
+}
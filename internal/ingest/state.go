@@ -0,0 +1,84 @@
+package ingest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"unique-ip-counter/internal/chaos"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// LoadState Merges the state at path into b, a missing file is an empty state.
+// Both the UIPB snapshot and the uipcounter.v1.Counter protobuf message are accepted.
+func LoadState(path string, b *ipv4_bitset.Bitset) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(data, []byte("UIPB")) {
+		_, err = b.ReadFrom(bytes.NewReader(data))
+		return err
+	}
+	_, err = b.UnmarshalProto(data)
+
+	return err
+}
+
+// SaveState Writes the state next to path and renames it over,
+// so a crash mid-write never leaves a truncated state behind.
+// A ".pb" path is written as a uipcounter.v1.Counter protobuf message.
+func SaveState(path string, b *ipv4_bitset.Bitset) error {
+	return saveAtomic(path, func(w io.Writer) error { return writeState(w, path, b) })
+}
+
+// SaveSnapshot SaveState of a set with a snapshot format of its own, e.g. an IPv6 set.
+func SaveSnapshot(path string, s io.WriterTo) error {
+	return saveAtomic(path, func(w io.Writer) error {
+		_, err := s.WriteTo(w)
+		return err
+	})
+}
+
+func saveAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = write(chaos.Writer(tmp)); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeState(w io.Writer, path string, b *ipv4_bitset.Bitset) error {
+	if !strings.EqualFold(filepath.Ext(path), ".pb") {
+		_, err := b.WriteTo(w)
+		return err
+	}
+	host, _ := os.Hostname()
+	_, err := w.Write(b.MarshalProto(ipv4_bitset.ProtoMeta{
+		Source:          host,
+		CreatedUnixNano: time.Now().UnixNano(),
+	}))
+
+	return err
+}
@@ -0,0 +1,39 @@
+//go:build chaos
+
+package ingest
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"unique-ip-counter/internal/chaos"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// not parallel: the fault rates are process wide
+func TestSaveState_ChaosPartialWrite(t *testing.T) {
+	t.Cleanup(func() { chaos.Configure(chaos.Config{}) })
+	path := filepath.Join(t.TempDir(), "state.uipb")
+	b := ipv4_bitset.New()
+	b.SetIfNew(1)
+	if err := SaveState(path, b); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	// a torn write must leave the previous state in place
+	b.SetIfNew(2)
+	chaos.Configure(chaos.Config{PartialWrite: 1})
+	if err := SaveState(path, b); !errors.Is(err, chaos.ErrInjected) {
+		t.Fatalf("SaveState err=%v; want %v", err, chaos.ErrInjected)
+	}
+	chaos.Configure(chaos.Config{})
+
+	restored := ipv4_bitset.New()
+	if err := LoadState(path, restored); err != nil || restored.GetUniqueCount() != 1 {
+		t.Fatalf("LoadState err=%v unique=%d; want the previous state(1)", err, restored.GetUniqueCount())
+	}
+	if left, _ := filepath.Glob(path + ".tmp*"); len(left) > 0 {
+		t.Fatalf("temporary files left: %v", left)
+	}
+}
@@ -0,0 +1,46 @@
+package ingest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+func TestState_SaveLoad(t *testing.T) {
+	t.Parallel()
+	for _, name := range []string{"state.bin", "state.pb"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			testStateSaveLoad(t, filepath.Join(t.TempDir(), name))
+		})
+	}
+}
+
+func testStateSaveLoad(t *testing.T, path string) {
+
+	// missing state is empty
+	b := ipv4_bitset.New()
+	if err := LoadState(path, b); err != nil || b.GetUniqueCount() != 0 {
+		t.Fatalf("LoadState(missing) err=%v unique=%d", err, b.GetUniqueCount())
+	}
+
+	sink := NewSink(b)
+	sink.Add([]byte("1.2.3.4"))
+	sink.Add([]byte("5.6.7.8"))
+	if err := SaveState(path, b); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	restored := ipv4_bitset.New()
+	if err := LoadState(path, restored); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if restored.GetUniqueCount() != 2 {
+		t.Fatalf("restored unique=%d; want 2", restored.GetUniqueCount())
+	}
+	// replayed messages after a restart must not be counted twice
+	if NewSink(restored).Add([]byte("1.2.3.4")) {
+		t.Fatalf("restored state must already contain 1.2.3.4")
+	}
+}
@@ -0,0 +1,69 @@
+package ingest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// StatusHandler GET -> {"unique":N,"records":N,"invalid":N} of the live sink.
+func StatusHandler(sink *Sink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Unique  uint64 `json:"unique"`
+			Records uint64 `json:"records"`
+			Invalid uint64 `json:"invalid"`
+		}{sink.Unique(), sink.Records(), sink.Invalid()})
+	})
+}
+
+// IngestHandler POST newline delimited addresses(Content-Encoding: gzip accepted) ->
+// {"added":N,"unique":N}: the new uniques of the request and the running count of the live sink.
+func IngestHandler(sink *Sink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		switch enc := r.Header.Get("Content-Encoding"); {
+		case strings.EqualFold(enc, "gzip"):
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			body = zr
+		case enc != "" && !strings.EqualFold(enc, "identity"):
+			http.Error(w, "unsupported Content-Encoding "+enc, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		// addresses read before a failure stay in the sink, added reports them either way
+		added, err := readLines(body, sink)
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusBadRequest
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		resp := struct {
+			Added  uint64 `json:"added"`
+			Unique uint64 `json:"unique"`
+			Error  string `json:"error,omitempty"`
+		}{Added: added, Unique: sink.Unique()}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// LiveHandler Endpoint of the live sink: GET / -> StatusHandler, POST /ingest -> IngestHandler.
+func LiveHandler(sink *Sink) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /", StatusHandler(sink))
+	mux.Handle("POST /ingest", IngestHandler(sink))
+
+	return mux
+}
@@ -0,0 +1,66 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+func TestLiveHandler_Ingest(t *testing.T) {
+	t.Parallel()
+	sink := NewSink(ipv4_bitset.New())
+	srv := httptest.NewServer(LiveHandler(sink))
+	defer srv.Close()
+
+	post := func(body []byte, enc string) (int, uint64, uint64) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/ingest", bytes.NewReader(body))
+		if enc != "" {
+			req.Header.Set("Content-Encoding", enc)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
+		var out struct{ Added, Unique uint64 }
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+
+		return resp.StatusCode, out.Added, out.Unique
+	}
+
+	if code, added, unique := post([]byte("1.1.1.1\n2.2.2.2\nbad\n1.1.1.1"), ""); code != http.StatusOK || added != 2 || unique != 2 {
+		t.Fatalf("plain: code=%d added=%d unique=%d; want 200, 2, 2", code, added, unique)
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte("2.2.2.2\n3.3.3.3\n4.4.4.4\n"))
+	_ = zw.Close()
+	if code, added, unique := post(gz.Bytes(), "gzip"); code != http.StatusOK || added != 2 || unique != 4 {
+		t.Fatalf("gzip: code=%d added=%d unique=%d; want 200, 2, 4", code, added, unique)
+	}
+
+	if code, _, _ := post([]byte("5.5.5.5\n"), "br"); code != http.StatusUnsupportedMediaType {
+		t.Fatalf("br: code=%d; want 415", code)
+	}
+	if code, _, _ := post([]byte("not gzip"), "gzip"); code != http.StatusBadRequest {
+		t.Fatalf("bad gzip: code=%d; want 400", code)
+	}
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var st struct{ Unique, Records, Invalid uint64 }
+	_ = json.NewDecoder(resp.Body).Decode(&st)
+	if st.Unique != 4 || st.Records != 7 || st.Invalid != 1 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Fatalf("status %+v; want unique=4 records=7 invalid=1", st)
+	}
+}
@@ -0,0 +1,142 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"net"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// SyslogConfig of the "serve syslog" mode.
+type SyslogConfig struct {
+	Addr string
+	// FromSender count the datagram source address instead of the first IPv4 of the message
+	FromSender bool
+}
+
+// ServeSyslog Receives syslog datagrams until ctx is done, counting the first IPv4 address
+// found in every message(after the syslog header, so the HOST field is not counted).
+func ServeSyslog(ctx context.Context, logger *zap.Logger, cfg SyslogConfig, sink *Sink) error {
+	pc, err := net.ListenPacket("udp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("syslog listener started", zap.String("addr", pc.LocalAddr().String()))
+	go func() {
+		<-ctx.Done()
+		_ = pc.Close()
+	}()
+
+	buf := make([]byte, 64<<10) // max UDP datagram
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if cfg.FromSender {
+			if ua, ok := from.(*net.UDPAddr); ok {
+				if ip4 := ua.IP.To4(); ip4 != nil {
+					sink.AddUint32(uint32(ip4[0])<<24 | uint32(ip4[1])<<16 | uint32(ip4[2])<<8 | uint32(ip4[3]))
+				}
+			}
+			continue
+		}
+		// a datagram may carry several newline separated messages
+		for _, line := range bytes.Split(buf[:n], []byte{'\n'}) {
+			if len(line) > 0 {
+				sink.Add(firstIPv4(syslogMessage(line)))
+			}
+		}
+	}
+}
+
+// syslogMessage Strips the RFC 5424 or RFC 3164 header and returns the MSG part.
+func syslogMessage(line []byte) []byte {
+	line = bytes.TrimRight(line, "\r\x00")
+	// <PRI>
+	if len(line) > 0 && line[0] == '<' {
+		if i := bytes.IndexByte(line, '>'); i > 0 && i <= 4 {
+			line = line[i+1:]
+		}
+	}
+
+	// RFC 5424: VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP SD [SP MSG]
+	if len(line) > 1 && line[0] >= '1' && line[0] <= '9' && line[1] == ' ' {
+		rest := line
+		for i := 0; i < 6; i++ {
+			j := bytes.IndexByte(rest, ' ')
+			if j < 0 {
+				return nil
+			}
+			rest = rest[j+1:]
+		}
+		return skipStructuredData(rest)
+	}
+
+	// RFC 3164: "Mmm dd hh:mm:ss" SP HOSTNAME SP MSG
+	if len(line) >= 16 && line[3] == ' ' && line[15] == ' ' {
+		rest := line[16:]
+		if j := bytes.IndexByte(rest, ' '); j >= 0 {
+			return rest[j+1:]
+		}
+		return nil
+	}
+
+	return line
+}
+
+// skipStructuredData "-" or a sequence of "[...]" elements(with \] escapes).
+func skipStructuredData(b []byte) []byte {
+	if len(b) > 0 && b[0] == '-' {
+		return bytes.TrimPrefix(b[1:], []byte{' '})
+	}
+	for len(b) > 0 && b[0] == '[' {
+		i := 1
+		for ; i < len(b); i++ {
+			if b[i] == '\\' {
+				i++
+				continue
+			}
+			if b[i] == ']' {
+				break
+			}
+		}
+		if i >= len(b) {
+			return nil
+		}
+		b = b[i+1:]
+	}
+
+	return bytes.TrimPrefix(b, []byte{' '})
+}
+
+// firstIPv4 First token made of digits and dots that parses as an IPv4 address.
+func firstIPv4(b []byte) []byte {
+	var parser ipv4_bitset.Bitset
+	for i := 0; i < len(b); {
+		if !isIPv4Byte(b[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(b) && isIPv4Byte(b[j]) {
+			j++
+		}
+		tok := bytes.Trim(b[i:j], ".")
+		if _, ok := parser.IPv4ByteToUint32(tok); ok {
+			return tok
+		}
+		i = j
+	}
+
+	return nil
+}
+
+func isIPv4Byte(c byte) bool { return c == '.' || (c >= '0' && c <= '9') }
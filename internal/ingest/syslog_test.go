@@ -0,0 +1,88 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+func Test_syslogMessage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"rfc3164", "<34>Oct 11 22:14:15 10.0.0.1 sshd[42]: Failed password from 1.2.3.4", "sshd[42]: Failed password from 1.2.3.4"},
+		{"rfc5424 no sd", "<165>1 2003-10-11T22:14:15.003Z 10.0.0.1 nginx 1 - - GET / 5.6.7.8", "GET / 5.6.7.8"},
+		{"rfc5424 sd", `<165>1 2003-10-11T22:14:15Z host app - ID47 [ex@1 a="x\]y"][b@1 c="1"] client=9.9.9.9`, "client=9.9.9.9"},
+		{"no header", "1.1.1.1", "1.1.1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := string(syslogMessage([]byte(tt.line))); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_firstIPv4(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"client=1.2.3.4:5555 user=x", "1.2.3.4"},
+		{"version 1.2 from 300.1.1.1 and 10.0.0.7.", "10.0.0.7"},
+		{"no address here", ""},
+	}
+	for _, tt := range tests {
+		if got := string(firstIPv4([]byte(tt.msg))); got != tt.want {
+			t.Fatalf("firstIPv4(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func Test_ServeSyslog(t *testing.T) {
+	t.Parallel()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := pc.LocalAddr().String()
+	_ = pc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := NewSink(ipv4_bitset.New())
+	done := make(chan error, 1)
+	go func() { done <- ServeSyslog(ctx, zap.NewNop(), SyslogConfig{Addr: addr}, sink) }()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.Unique() < 2 && time.Now().Before(deadline) {
+		_, _ = conn.Write([]byte("<34>Oct 11 22:14:15 gw sshd: from 1.2.3.4\n<34>Oct 11 22:14:16 gw sshd: from 5.6.7.8"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatalf("ServeSyslog: %v", err)
+	}
+	if got := sink.Unique(); got != 2 {
+		t.Fatalf("unique = %d, want 2", got)
+	}
+}
@@ -0,0 +1,107 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// TCPConfig of the "serve tcp" mode.
+type TCPConfig struct {
+	Addr string
+}
+
+// ServeTCP Accepts connections until ctx is done, every connection is read by its own goroutine
+// as newline delimited addresses into the shared sink.
+func ServeTCP(ctx context.Context, logger *zap.Logger, cfg TCPConfig, sink *Sink) error {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("tcp listener started", zap.String("addr", ln.Addr().String()))
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns = map[net.Conn]struct{}{}
+	)
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+		// unblock readers of idle connections
+		mu.Lock()
+		for c := range conns {
+			_ = c.Close()
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+				_ = conn.Close()
+			}()
+			if _, err := readLines(conn, sink); err != nil && ctx.Err() == nil {
+				logger.Warn("tcp connection failed", zap.String("remote", conn.RemoteAddr().String()), zap.Error(err))
+			}
+		}()
+	}
+}
+
+// readLines Feeds every '\n' terminated line of r into sink and returns how many of them were new,
+// an unterminated tail is counted too since a client closing the connection ends its last record.
+// Over-long lines are skipped as invalid.
+func readLines(r io.Reader, sink *Sink) (uint64, error) {
+	var (
+		br       = bufio.NewReaderSize(r, 64<<10)
+		skipping bool
+		added    uint64
+	)
+	for {
+		line, err := br.ReadSlice('\n')
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			if !skipping {
+				sink.records.Add(1)
+				sink.invalid.Add(1)
+			}
+			skipping = true
+			continue
+		case skipping:
+			// tail of an over-long line
+			skipping = false
+		case len(line) > 0:
+			if sink.Add(line) {
+				added++
+			}
+		}
+		if err == io.EOF {
+			return added, nil
+		}
+		if err != nil {
+			return added, err
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+func Test_readLines(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("9", 100<<10)
+	sink := NewSink(ipv4_bitset.New())
+	in := "1.1.1.1\n2.2.2.2\r\n" + long + "\ngarbage\n1.1.1.1\n3.3.3.3"
+	added, err := readLines(strings.NewReader(in), sink)
+	if err != nil {
+		t.Fatalf("readLines: %v", err)
+	}
+	if added != 3 {
+		t.Fatalf("added = %d, want 3", added)
+	}
+	if got := sink.Unique(); got != 3 {
+		t.Fatalf("unique = %d, want 3", got)
+	}
+	if got := sink.Invalid(); got != 2 {
+		t.Fatalf("invalid = %d, want 2", got)
+	}
+}
+
+func Test_ServeTCP(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := NewSink(ipv4_bitset.New())
+	done := make(chan error, 1)
+	go func() { done <- ServeTCP(ctx, zap.NewNop(), TCPConfig{Addr: addr}, sink) }()
+
+	var conn net.Conn
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	// concurrent clients sharing the bitset, half of the addresses overlap
+	var wg sync.WaitGroup
+	for c := 0; c < 4; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			defer conn.Close()
+			var buf bytes.Buffer
+			for i := 0; i < 500; i++ {
+				fmt.Fprintf(&buf, "10.%d.0.%d\n", c%2, i%256)
+			}
+			_, _ = conn.Write(buf.Bytes())
+		}()
+	}
+	wg.Wait()
+
+	for deadline := time.Now().Add(5 * time.Second); sink.Records() < 2000 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatalf("ServeTCP: %v", err)
+	}
+	if got := sink.Unique(); got != 512 {
+		t.Fatalf("unique = %d, want 512", got)
+	}
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"runtime"
+
+	"go.uber.org/zap"
+)
+
+// errNoGops returned by startGops when the binary is built without the "gops" tag.
+var errNoGops = errors.New("gops agent is not compiled in, rebuild with -tags gops")
+
+// startIntrospection Publishes expvar counters on -debug-addr and starts the gops agent on -gops,
+// so running instances can be inspected with standard tooling(curl /debug/vars, gops).
+func (a *App) startIntrospection() error {
+	if a.cfg.gops {
+		if err := startGops(); err != nil {
+			return err
+		}
+	}
+	if a.cfg.debugAddr == "" {
+		return nil
+	}
+
+	expvar.Publish("unique_ips", expvar.Func(func() any {
+		if fp := a.fp.Load(); fp != nil {
+			return fp.UniqueCount()
+		}
+		return 0
+	}))
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("config", expvar.Func(func() any {
+		return map[string]any{"paths": a.cfg.paths, "th": a.cfg.th}
+	}))
+
+	ln, err := net.Listen("tcp", a.cfg.debugAddr)
+	if err != nil {
+		return err
+	}
+	a.logger.Info("serving expvar", zap.String("addr", ln.Addr().String()))
+	go func() {
+		// expvar registers /debug/vars on the default mux
+		if err := http.Serve(ln, http.DefaultServeMux); err != nil {
+			a.logger.Error("expvar server stopped", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
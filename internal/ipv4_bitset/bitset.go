@@ -1,7 +1,14 @@
 package ipv4_bitset
 
 import (
+	"iter"
+	"math/bits"
+	"net/netip"
+	"sync"
 	"sync/atomic"
+
+	"unique-ip-counter/internal/cpu_dispatch"
+	"unique-ip-counter/internal/hll"
 )
 
 type (
@@ -10,32 +17,101 @@ type (
 		// atomic.Pointer - thread safe
 		shards [1 << 16]atomic.Pointer[shard16]
 		unique atomic.Uint64
+		notify func(total uint64)
+		// bytes of the shard containers
+		memory atomic.Int64
+		// set by NewRoaring: shards start as arrays
+		roaring bool
+		// set by NewFlat: the bits of every shard
+		flat []uint64
+		// set by Degrade: addresses of shards not allocated yet are only estimated
+		overflow atomic.Pointer[hll.Sketch]
 	}
 	shard16 struct {
-		bits []uint64 // 65536 bit => 1024 uint64 (8 KB)
+		bits []uint64 // 65536 bit => 1024 uint64 (8 KB), nil for an array shard(NewRoaring)
+
+		// array shards only
+		mu   sync.Mutex
+		arr  []uint16 // sorted low 16 bits
+		next *shard16 // the bitmap shard that replaced it
 	}
 )
 
+// per-arch implementations of the hot routines, see cpu_dispatch
+var (
+	parsers     = cpu_dispatch.NewRegistry[func(sb []byte) (uint32, bool)]("ipv4-parser")
+	lineParsers = cpu_dispatch.NewRegistry[func(sb []byte) (uint32, bool)]("ipv4-line-parser")
+	popcount    = cpu_dispatch.NewRegistry[func(words []uint64) uint64]("popcount")
+
+	// the selected implementations, resolved once
+	parseIPv4     func(sb []byte) (uint32, bool)
+	parseIPv4Line func(sb []byte) (uint32, bool)
+	popcountWords func(words []uint64) uint64
+)
+
+func init() {
+	parsers.Register("generic", cpu_dispatch.Generic, 0, parseIPv4Generic)
+	lineParsers.Register("generic", cpu_dispatch.Generic, 0, parseIPv4LineGeneric)
+	popcount.Register("generic", cpu_dispatch.Generic, 0, popcountGeneric)
+	parseIPv4, parseIPv4Line, popcountWords = parsers.Get(), lineParsers.Get(), popcount.Get()
+}
+
 func New() *Bitset { return &Bitset{} }
 
+// NewSketch A set degraded from the start: every address is counted by a HyperLogLog sketch of 2^p registers
+// (see hll.New), so the memory stays fixed whatever the count and ApproxCount is the whole count.
+func NewSketch(p uint8) *Bitset {
+	b := &Bitset{}
+	b.overflow.Store(hll.New(p))
+
+	return b
+}
+
+// getOrCreate The bitmap shard of hi, an array shard is promoted.
 func (b *Bitset) getOrCreate(hi uint16) *shard16 {
-	if p := b.shards[hi].Load(); p != nil {
-		return p
+	p := b.shards[hi].Load()
+	if p == nil && b.flat != nil {
+		return b.flatShard(hi)
 	}
-	n := &shard16{bits: make([]uint64, 1024)}
-	if b.shards[hi].CompareAndSwap(nil, n) {
-		return n
+	if p == nil {
+		n := &shard16{bits: make([]uint64, 1024)}
+		if b.shards[hi].CompareAndSwap(nil, n) {
+			b.memory.Add(1024 * 8)
+			return n
+		}
+		p = b.shards[hi].Load()
+	}
+	if p.bits == nil {
+		return b.promote(hi, p)
 	}
 
-	return b.shards[hi].Load()
+	return p
 }
 
-// SetIfNew set bit; true — new addr
+// SetIfNew set bit; true — new addr.
+// Once degraded an address of a shard that is not allocated goes to the sketch and is never new.
 func (b *Bitset) SetIfNew(u32 uint32) bool {
+	if b.flat != nil {
+		return b.setFlat(u32)
+	}
 	hi := uint16(u32 >> 16)
 	lo := u32 & 0xFFFF
-	sh := b.getOrCreate(hi)
+	sh := b.shards[hi].Load()
+	if sh == nil {
+		if o := b.overflow.Load(); o != nil {
+			o.Add(u32)
+			return false
+		}
+		sh = b.newShard(hi)
+	}
+	if sh.bits == nil {
+		return b.setArray(hi, sh, uint16(lo))
+	}
 
+	return setBit(sh, uint16(lo))
+}
+
+func setBit(sh *shard16, lo uint16) bool {
 	idx := lo >> 6
 	mask := uint64(1) << (lo & 63)
 	for {
@@ -49,18 +125,266 @@ func (b *Bitset) SetIfNew(u32 uint32) bool {
 	}
 }
 
+// Contains Whether u32 is set, false for addresses only counted by the sketch of a degraded set.
+func (b *Bitset) Contains(u32 uint32) bool {
+	if b.flat != nil {
+		return atomic.LoadUint64(&b.flat[u32>>6])&(uint64(1)<<(u32&63)) != 0
+	}
+	sh := b.shards[u32>>16].Load()
+	if sh == nil {
+		return false
+	}
+	lo := uint16(u32)
+	if sh.bits == nil {
+		return sh.containsArray(lo)
+	}
+
+	return atomic.LoadUint64(&sh.bits[lo>>6])&(uint64(1)<<(lo&63)) != 0
+}
+
+// SetAddrIfNew SetIfNew of an IPv4 or IPv4-mapped IPv6(::ffff:1.2.3.4) address, false for any other address.
+// As with SetIfNew the caller counts a new address with AddUnique.
+func (b *Bitset) SetAddrIfNew(a netip.Addr) bool {
+	u32, ok := addrToUint32(a)
+	return ok && b.SetIfNew(u32)
+}
+
+// ContainsAddr Contains of an IPv4 or IPv4-mapped IPv6 address, false for any other address.
+func (b *Bitset) ContainsAddr(a netip.Addr) bool {
+	u32, ok := addrToUint32(a)
+	return ok && b.Contains(u32)
+}
+
+func addrToUint32(a netip.Addr) (uint32, bool) {
+	if a = a.Unmap(); !a.Is4() {
+		return 0, false
+	}
+	b := a.As4()
+
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]), true
+}
+
+// AddRange Sets every address of [first, last], returns how many were new. A word of 64 addresses is set
+// with a single CAS, a /8 costs 2^18 of them.
+func (b *Bitset) AddRange(first, last uint32) uint64 {
+	var added uint64
+	for u := uint64(first); u <= uint64(last); {
+		sh := b.getOrCreate(uint16(u >> 16))
+		idx := (u & 0xFFFF) >> 6
+		base := u &^ 63
+		// bits [u, min(last, word end)] of the word
+		mask := ^uint64(0) >> (63 - min(uint64(last)-base, 63)) &^ (uint64(1)<<(u-base) - 1)
+		for {
+			old := atomic.LoadUint64(&sh.bits[idx])
+			if old&mask == mask {
+				break
+			}
+			if atomic.CompareAndSwapUint64(&sh.bits[idx], old, old|mask) {
+				added += uint64(bits.OnesCount64(mask &^ old))
+				break
+			}
+		}
+		u = base + 64
+	}
+
+	return added
+}
+
 func (b *Bitset) AddUnique(n uint64) {
 	if n != 0 {
-		b.unique.Add(n)
+		total := b.unique.Add(n)
+		if b.notify != nil {
+			b.notify(total)
+		}
 	}
-
 }
 
+// SetNotify fn is called(concurrently) with the new total after every AddUnique,
+// must be set before processing starts.
+func (b *Bitset) SetNotify(fn func(total uint64)) { b.notify = fn }
+
 func (b *Bitset) GetUniqueCount() uint64 { return b.unique.Load() }
 
+// Degrade Stops allocating shards for SetIfNew: the addresses of the shards allocated so far stay exact,
+// the others are counted by a fixed size HyperLogLog sketch. False if already degraded.
+// AddRange and snapshot loads still allocate, ReadFrom/WriteTo do not carry the sketch.
+func (b *Bitset) Degrade() bool {
+	return b.overflow.CompareAndSwap(nil, hll.New(hll.DefaultPrecision))
+}
+
+// Degraded Reports whether Degrade was called.
+func (b *Bitset) Degraded() bool { return b.overflow.Load() != nil }
+
+// ApproxCount Estimated distinct addresses counted by the sketch since Degrade, disjoint from GetUniqueCount.
+// stdErr is the relative standard error of the estimate, both are 0 when nothing was sketched.
+func (b *Bitset) ApproxCount() (n uint64, stdErr float64) {
+	o := b.overflow.Load()
+	if o == nil || o.Empty() {
+		return 0, 0
+	}
+
+	return o.Estimate(), o.StdError()
+}
+
+// MemoryBytes Bytes allocated by the shards so far: 8KB a bitmap shard, the capacity of an array shard.
+func (b *Bitset) MemoryBytes() int64 { return b.memory.Load() }
+
+// All Iterates the set addresses in ascending order, concurrent inserts may or may not be seen.
+func (b *Bitset) All() iter.Seq[uint32] {
+	return func(yield func(uint32) bool) {
+		for hi := range b.shards {
+			sh := b.shards[hi].Load()
+			if sh == nil {
+				continue
+			}
+			if lows, ok := sh.lows(); ok {
+				for _, lo := range lows {
+					if !yield(uint32(hi)<<16 | uint32(lo)) {
+						return
+					}
+				}
+				continue
+			}
+			ws := sh.words()
+			for i := range ws {
+				for w := atomic.LoadUint64(&ws[i]); w != 0; w &= w - 1 {
+					if !yield(uint32(hi)<<16 | uint32(i)<<6 | uint32(bits.TrailingZeros64(w))) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// DistinctOctets Distinct values of every octet(A, B, C, D of A.B.C.D) over the set addresses.
+// The four 256-bit maps fall out of the layout: A.B is the shard, C is a group of 4 words
+// and D the word of the group and the bit, so every word is only ORed once.
+func (b *Bitset) DistinctOctets() [4]int {
+	var maps [4][4]uint64
+	for hi := range b.shards {
+		sh := b.shards[hi].Load()
+		if sh == nil {
+			continue
+		}
+		var used uint64
+		ws := sh.words()
+		for i := range ws {
+			w := atomic.LoadUint64(&ws[i])
+			if w == 0 {
+				continue
+			}
+			used |= w
+			maps[2][i>>8] |= 1 << (i >> 2 & 63)
+			maps[3][i&3] |= w
+		}
+		if used != 0 {
+			maps[0][hi>>14] |= 1 << (hi >> 8 & 63)
+			maps[1][hi>>6&3] |= 1 << (hi & 63)
+		}
+	}
+
+	var out [4]int
+	for o := range maps {
+		for _, w := range maps[o] {
+			out[o] += bits.OnesCount64(w)
+		}
+	}
+
+	return out
+}
+
+// IntersectionCount Addresses set in both a and b, counted word by word without allocating.
+func IntersectionCount(a, b *Bitset) uint64 {
+	var n int
+	for hi := range a.shards {
+		sa, sb := a.shards[hi].Load(), b.shards[hi].Load()
+		if sa == nil || sb == nil {
+			continue
+		}
+		wa, wb := sa.words(), sb.words()
+		for i := range wa {
+			n += bits.OnesCount64(atomic.LoadUint64(&wa[i]) & atomic.LoadUint64(&wb[i]))
+		}
+	}
+
+	return uint64(n)
+}
+
+// UnionCount Addresses set in any of sets, counted word by word without merging them.
+func UnionCount(sets ...*Bitset) uint64 {
+	var (
+		n      int
+		shards = make([][]uint64, 0, len(sets))
+	)
+	for hi := range 1 << 16 {
+		shards = shards[:0]
+		for _, s := range sets {
+			if sh := s.shards[hi].Load(); sh != nil {
+				shards = append(shards, sh.words())
+			}
+		}
+		if len(shards) == 0 {
+			continue
+		}
+		for i := range shards[0] {
+			var w uint64
+			for _, ws := range shards {
+				w |= atomic.LoadUint64(&ws[i])
+			}
+			n += bits.OnesCount64(w)
+		}
+	}
+
+	return uint64(n)
+}
+
 // IPv4ByteToUint32 Parse IPV4 to uint32 with no allocations.
 // input format: A.B.C.D (0-255 each)
-func (b *Bitset) IPv4ByteToUint32(sb []byte) (uint32, bool) {
+func (b *Bitset) IPv4ByteToUint32(sb []byte) (uint32, bool) { return parseIPv4(sb) }
+
+// IPv4LineToUint32 IPv4ByteToUint32 of a line without its '\n': a single trailing '\r'(CRLF files)
+// is accepted, so plain lines are parsed in one pass without trimming them first.
+func (b *Bitset) IPv4LineToUint32(sb []byte) (uint32, bool) { return parseIPv4Line(sb) }
+
+// parseIPv4LineGeneric portable implementation of IPv4LineToUint32
+func parseIPv4LineGeneric(sb []byte) (uint32, bool) {
+	// min="1.1.1.1"), max="255.255.255.255\r"
+	n := len(sb)
+	if n < 7 || n > 16 {
+		return 0, false
+	}
+	if sb[n-1] == '\r' {
+		n--
+	}
+	if n < 7 || n > 15 {
+		return 0, false
+	}
+	var acc, part, dots uint32
+	for _, c := range sb[:n] {
+		if d := c - '0'; d <= 9 {
+			part = part*10 + uint32(d)
+			if part > 255 {
+				return 0, false
+			}
+			continue
+		}
+		if c != '.' || dots == 3 {
+			return 0, false
+		}
+		acc = (acc << 8) | part
+		part = 0
+		dots++
+	}
+	if dots != 3 {
+		return 0, false
+	}
+
+	return (acc << 8) | part, true
+}
+
+// parseIPv4Generic portable implementation of IPv4ByteToUint32
+func parseIPv4Generic(sb []byte) (uint32, bool) {
 	// min="1.1.1.1"), max="255.255.255.255"
 	if n := len(sb); n < 7 || n > 15 {
 		return 0, false
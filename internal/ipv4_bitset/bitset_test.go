@@ -3,13 +3,19 @@ package ipv4_bitset
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
 	"math/rand"
+	"net/netip"
 	"runtime"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"unique-ip-counter/internal/hll"
 )
 
 func u32(a, b, c, d uint32) uint32 { return (a<<24 | b<<16 | c<<8 | d) }
@@ -185,6 +191,9 @@ func TestGetOrCreate_IsCASdedAndReusable(t *testing.T) {
 	if got := bs.shards[hi].Load(); got != base {
 		t.Fatalf("atomic pointer mismatch with created shard")
 	}
+	if got := bs.MemoryBytes(); got != 8<<10 {
+		t.Fatalf("MemoryBytes=%d; want one shard(8KB)", got)
+	}
 }
 
 func TestUniqueCounter_SerialAndConcurrent(t *testing.T) {
@@ -337,3 +346,489 @@ func ExampleBitset_IPv4ByteToUint32() {
 		fmt.Printf("%08x\n", u)
 	}
 }
+
+func TestAll_AscendingAndComplete(t *testing.T) {
+	t.Parallel()
+	b := New()
+	want := []uint32{0, 1, 63, 64, u32(10, 0, 0, 1), u32(10, 0, 255, 255), u32(255, 255, 255, 255)}
+	for i := len(want) - 1; i >= 0; i-- {
+		b.SetIfNew(want[i])
+	}
+
+	var got []uint32
+	for u := range b.All() {
+		got = append(got, u)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("All() = %v; want %v", got, want)
+	}
+
+	n := 0
+	for range b.All() {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Fatalf("early break: n=%d", n)
+	}
+}
+
+func TestIntegerToUint32(t *testing.T) {
+	t.Parallel()
+	valid := []struct {
+		in   string
+		want uint32
+	}{
+		{"3232235521", u32(192, 168, 0, 1)},
+		{"0", 0},
+		{"4294967295", u32(255, 255, 255, 255)},
+		{"0xC0A80001", u32(192, 168, 0, 1)},
+		{"0Xc0a80001", u32(192, 168, 0, 1)},
+		{"0x1", u32(0, 0, 0, 1)},
+		{"0xFFFFFFFF", u32(255, 255, 255, 255)},
+	}
+	for _, tt := range valid {
+		if got, ok := IntegerToUint32([]byte(tt.in)); !ok || got != tt.want {
+			t.Fatalf("IntegerToUint32(%q) => %d, %v; want %d", tt.in, got, ok, tt.want)
+		}
+	}
+	for _, in := range []string{"", "4294967296", "99999999999", "-1", "0x", "0x100000000", "0xG1", "1.1.1.1", " 1", "C0A80001"} {
+		if _, ok := IntegerToUint32([]byte(in)); ok {
+			t.Fatalf("IntegerToUint32(%q) => ok=true; want false", in)
+		}
+	}
+}
+
+func TestStrictToUint32(t *testing.T) {
+	t.Parallel()
+	for _, in := range []string{"1.2.3.4", "0.0.0.0", "255.255.255.255", "10.0.0.100", "100.10.0.1"} {
+		want, _ := New().IPv4ByteToUint32([]byte(in))
+		if got, ok := StrictToUint32([]byte(in)); !ok || got != want {
+			t.Fatalf("StrictToUint32(%q) => %d, %v; want %d", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"001.002.003.004", "01.2.3.4", "1.2.3.04", "1.2.3.00", "0001.2.3.4", "1..2.3", "1.2.3.", ".1.2.3",
+		"1.2.3.256", "1.2.3.4.5", "1.2.3", "a.b.c.d", " 1.2.3.4"} {
+		if _, ok := StrictToUint32([]byte(in)); ok {
+			t.Fatalf("StrictToUint32(%q) => ok=true; want false", in)
+		}
+	}
+
+	// parity with net/netip on random dotted strings
+	rnd := rand.New(rand.NewSource(1))
+	const alphabet = "0123456789."
+	for range 100000 {
+		b := make([]byte, 7+rnd.Intn(9))
+		for i := range b {
+			b[i] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		got, ok := StrictToUint32(b)
+		a, err := netip.ParseAddr(string(b))
+		if want := err == nil && a.Is4(); ok != want || (ok && got != binary.BigEndian.Uint32(a.AsSlice())) {
+			t.Fatalf("StrictToUint32(%q) => %d, %v; netip %v, %v", b, got, ok, a, err)
+		}
+	}
+}
+
+func TestAddRange(t *testing.T) {
+	t.Parallel()
+	b := New()
+	b.SetIfNew(u32(10, 0, 0, 5))
+
+	cases := []struct {
+		first, last uint32
+		want        uint64
+	}{
+		{u32(10, 0, 0, 0), u32(10, 0, 0, 255), 255},         // /24, one address already set
+		{u32(10, 0, 0, 128), u32(10, 0, 1, 127), 128},       // overlaps the /24
+		{u32(10, 0, 0, 3), u32(10, 0, 0, 3), 0},             // single address, set
+		{u32(10, 0, 255, 250), u32(10, 1, 0, 5), 12},        // across shards
+		{u32(1, 2, 3, 4), u32(1, 2, 3, 4), 1},               // single address
+		{u32(20, 0, 0, 0), u32(20, 255, 255, 255), 1 << 24}, // /8
+		{u32(255, 255, 255, 200), u32(255, 255, 255, 255), 56},
+	}
+	for _, tt := range cases {
+		if got := b.AddRange(tt.first, tt.last); got != tt.want {
+			t.Fatalf("AddRange(%x, %x)=%d; want %d", tt.first, tt.last, got, tt.want)
+		}
+	}
+	for _, a := range []uint32{u32(10, 0, 0, 0), u32(10, 0, 1, 127), u32(10, 1, 0, 5), u32(255, 255, 255, 255)} {
+		if b.SetIfNew(a) {
+			t.Fatalf("address %x of a range is not set", a)
+		}
+	}
+	for _, a := range []uint32{u32(10, 0, 1, 128), u32(10, 1, 0, 6), u32(255, 255, 255, 199)} {
+		if !b.SetIfNew(a) {
+			t.Fatalf("address %x outside the ranges is set", a)
+		}
+	}
+}
+
+func TestIntersectionAndUnionCount(t *testing.T) {
+	t.Parallel()
+	a, b, c := New(), New(), New()
+	for _, u := range []uint32{u32(1, 1, 1, 1), u32(2, 2, 2, 2), u32(10, 0, 0, 63), u32(10, 0, 0, 64)} {
+		a.SetIfNew(u)
+	}
+	for _, u := range []uint32{u32(2, 2, 2, 2), u32(10, 0, 0, 64), u32(200, 0, 0, 1)} {
+		b.SetIfNew(u)
+	}
+
+	if got := IntersectionCount(a, b); got != 2 {
+		t.Fatalf("IntersectionCount(a, b)=%d; want 2", got)
+	}
+	if got := IntersectionCount(a, c); got != 0 {
+		t.Fatalf("IntersectionCount(a, empty)=%d; want 0", got)
+	}
+	if got := UnionCount(a, b, c); got != 5 {
+		t.Fatalf("UnionCount(a, b, empty)=%d; want 5", got)
+	}
+	if got := UnionCount(); got != 0 {
+		t.Fatalf("UnionCount()=%d; want 0", got)
+	}
+}
+
+func TestContains(t *testing.T) {
+	t.Parallel()
+	b := New()
+	b.SetIfNew(u32(10, 0, 0, 63))
+
+	for _, tc := range []struct {
+		u    uint32
+		want bool
+	}{
+		{u32(10, 0, 0, 63), true},
+		{u32(10, 0, 0, 64), false},
+		{u32(10, 0, 1, 63), false},
+		{u32(11, 0, 0, 63), false},
+	} {
+		if got := b.Contains(tc.u); got != tc.want {
+			t.Errorf("Contains(%#08x)=%v; want %v", tc.u, got, tc.want)
+		}
+	}
+}
+
+func TestOrAndAndNot(t *testing.T) {
+	t.Parallel()
+	a, b := New(), New()
+	for _, u := range []uint32{u32(1, 1, 1, 1), u32(2, 2, 2, 2), u32(10, 0, 0, 63)} {
+		a.SetIfNew(u)
+	}
+	for _, u := range []uint32{u32(2, 2, 2, 2), u32(10, 0, 0, 64)} {
+		b.SetIfNew(u)
+	}
+
+	for _, tc := range []struct {
+		name string
+		fn   func(dst *Bitset)
+		want []uint32
+	}{
+		{"or", func(dst *Bitset) { Or(dst, a); Or(dst, b) }, []uint32{u32(1, 1, 1, 1), u32(2, 2, 2, 2), u32(10, 0, 0, 63), u32(10, 0, 0, 64)}},
+		{"and", func(dst *Bitset) { And(dst, a, b) }, []uint32{u32(2, 2, 2, 2)}},
+		{"and not", func(dst *Bitset) { AndNot(dst, a, b) }, []uint32{u32(1, 1, 1, 1), u32(10, 0, 0, 63)}},
+	} {
+		dst := New()
+		tc.fn(dst)
+		got := slices.Collect(dst.All())
+		if !slices.Equal(got, tc.want) || dst.GetUniqueCount() != uint64(len(tc.want)) {
+			t.Errorf("%s=%x(unique %d); want %x", tc.name, got, dst.GetUniqueCount(), tc.want)
+		}
+	}
+	// only shards with addresses are allocated
+	dst := New()
+	And(dst, a, b)
+	if dst.MemoryBytes() != 8<<10 {
+		t.Errorf("and allocated %d bytes; want one shard", dst.MemoryBytes())
+	}
+}
+
+func TestSetAddrIfNew(t *testing.T) {
+	t.Parallel()
+	b := New()
+	for _, tc := range []struct {
+		addr string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.1", false},
+		{"::ffff:10.0.0.1", false}, // the same address
+		{"::ffff:10.0.0.2", true},
+		{"2001:db8::1", false},
+	} {
+		if got := b.SetAddrIfNew(netip.MustParseAddr(tc.addr)); got != tc.want {
+			t.Errorf("SetAddrIfNew(%s)=%v; want %v", tc.addr, got, tc.want)
+		}
+	}
+	if b.SetAddrIfNew(netip.Addr{}) || b.ContainsAddr(netip.Addr{}) {
+		t.Errorf("the zero Addr must be neither set nor contained")
+	}
+	for addr, want := range map[string]bool{"10.0.0.1": true, "::ffff:10.0.0.2": true, "10.0.0.2": true, "10.0.0.3": false, "2001:db8::1": false} {
+		if got := b.ContainsAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("ContainsAddr(%s)=%v; want %v", addr, got, want)
+		}
+	}
+}
+
+func TestDistinctOctets(t *testing.T) {
+	t.Parallel()
+	b := New()
+	if got := b.DistinctOctets(); got != [4]int{} {
+		t.Fatalf("empty DistinctOctets=%v; want zeros", got)
+	}
+
+	for _, u := range []uint32{u32(10, 0, 0, 1), u32(10, 0, 0, 2), u32(10, 0, 1, 255), u32(192, 168, 255, 1), u32(255, 255, 255, 255)} {
+		b.SetIfNew(u)
+	}
+	// an allocated shard without addresses does not count
+	b.getOrCreate(uint16(u32(7, 7, 0, 0) >> 16))
+	if got, want := b.DistinctOctets(), [4]int{3, 3, 3, 3}; got != want {
+		t.Fatalf("DistinctOctets=%v; want %v", got, want)
+	}
+
+	// ports parsed as integers all land in 0.0.x.y
+	p := New()
+	for port := uint32(1024); port < 3048; port++ {
+		p.SetIfNew(port)
+	}
+	if got := p.DistinctOctets(); got[0] != 1 || got[1] != 1 || got[2] != 8 || got[3] != 256 {
+		t.Fatalf("ports DistinctOctets=%v; want [1 1 8 256]", got)
+	}
+}
+
+func TestDegrade(t *testing.T) {
+	t.Parallel()
+	b := New()
+	b.SetIfNew(u32(10, 0, 0, 1))
+	if n, _ := b.ApproxCount(); n != 0 || b.Degraded() {
+		t.Fatalf("not degraded yet: approx %d", n)
+	}
+	if !b.Degrade() || b.Degrade() {
+		t.Fatal("Degrade: want true once")
+	}
+
+	// the allocated shard stays exact
+	if !b.SetIfNew(u32(10, 0, 0, 2)) || b.SetIfNew(u32(10, 0, 0, 1)) {
+		t.Fatal("allocated shard is not exact after Degrade")
+	}
+	const sketched = 100_000
+	for i := range uint32(sketched) {
+		if b.SetIfNew(u32(20, 0, 0, 0) + i) {
+			t.Fatal("address of an unallocated shard is reported new")
+		}
+	}
+	if got := b.MemoryBytes(); got != 8<<10 {
+		t.Fatalf("MemoryBytes=%d; want one shard", got)
+	}
+	n, stdErr := b.ApproxCount()
+	if e := math.Abs(float64(n)-sketched) / sketched; stdErr == 0 || e > 4*stdErr {
+		t.Fatalf("ApproxCount=%d(±%.4f); want ~%d", n, stdErr, sketched)
+	}
+}
+
+func TestNewSketch(t *testing.T) {
+	t.Parallel()
+	b := NewSketch(hll.DefaultPrecision)
+	for i := uint32(0); i < 200000; i++ {
+		if b.SetIfNew(i * 2654435761) {
+			t.Fatalf("SetIfNew(%d)=true; want every address sketched", i)
+		}
+		b.SetIfNew(i * 2654435761) // duplicates are not counted
+	}
+	n, stdErr := b.ApproxCount()
+	if b.GetUniqueCount() != 0 || b.MemoryBytes() != 0 {
+		t.Fatalf("exact count %d, %d shard bytes; want none", b.GetUniqueCount(), b.MemoryBytes())
+	}
+	if d := math.Abs(float64(n)-200000) / 200000; d > 4*stdErr {
+		t.Fatalf("ApproxCount=%d(error %.4f); want 200000 within %.4f", n, d, 4*stdErr)
+	}
+	if b.Degrade() {
+		t.Fatalf("Degrade of a sketch=true; want already degraded")
+	}
+}
+
+func TestNewRoaring(t *testing.T) {
+	t.Parallel()
+	var (
+		b    = NewRoaring()
+		want []uint32
+	)
+	// a sparse shard per /16 and a dense one promoted past arrayMax
+	for hi := range uint32(100) {
+		for lo := range uint32(10) {
+			want = append(want, hi<<16|lo*977)
+		}
+	}
+	for lo := range uint32(arrayMax + 100) {
+		want = append(want, 200<<16|lo*7)
+	}
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n uint64
+			for i := w; i < len(want); i += 4 {
+				// racing on the addresses of the next worker too
+				for _, u := range []uint32{want[i], want[(i+1)%len(want)]} {
+					if b.SetIfNew(u) {
+						n++
+					}
+				}
+			}
+			b.AddUnique(n)
+		}()
+	}
+	wg.Wait()
+
+	if got := b.GetUniqueCount(); got != uint64(len(want)) {
+		t.Fatalf("unique=%d; want %d", got, len(want))
+	}
+	for _, u := range want {
+		if !b.Contains(u) {
+			t.Fatalf("Contains(%d)=false", u)
+		}
+	}
+	if b.Contains(300 << 16) {
+		t.Fatal("Contains of an unset address")
+	}
+	slices.Sort(want)
+	if got := slices.Collect(b.All()); !slices.Equal(got, want) {
+		t.Fatalf("All: %d addresses, want %d", len(got), len(want))
+	}
+	if got, dense := b.MemoryBytes(), int64(101*8<<10); got <= 8<<10 || got >= dense/4 {
+		t.Fatalf("MemoryBytes=%d; want well under %d of dense shards", got, dense)
+	}
+
+	d := New()
+	d.AddUnique(d.AddRange(0, 100<<16-1))
+	if got := IntersectionCount(b, d); got != 100*10 {
+		t.Fatalf("IntersectionCount=%d; want %d", got, 100*10)
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c := NewRoaring()
+	if _, err := c.ReadFrom(&buf); err != nil || c.GetUniqueCount() != uint64(len(want)) {
+		t.Fatalf("ReadFrom: %v, unique %d", err, c.GetUniqueCount())
+	}
+}
+
+func TestNewFlat(t *testing.T) {
+	t.Parallel()
+	b, ref := NewFlat(), New()
+	r := rand.New(rand.NewSource(1))
+	for range 100_000 {
+		u := r.Uint32()
+		if got, want := b.SetIfNew(u), ref.SetIfNew(u); got != want {
+			t.Fatalf("SetIfNew(%d)=%v; want %v", u, got, want)
+		}
+	}
+	if b.Contains(^uint32(0)) != ref.Contains(^uint32(0)) || b.SetIfNew(0) != ref.SetIfNew(0) {
+		t.Fatal("edges differ from New")
+	}
+	if !slices.Equal(slices.Collect(b.All()), slices.Collect(ref.All())) {
+		t.Fatal("All differs from New")
+	}
+	if b.DistinctOctets() != ref.DistinctOctets() || UnionCount(b, ref) != IntersectionCount(b, ref) {
+		t.Fatal("shard views differ from New")
+	}
+	if got := b.MemoryBytes(); got != 512<<20 {
+		t.Fatalf("MemoryBytes=%d; want 512 MB", got)
+	}
+
+	// only the touched /16s are shards: 10 addresses snapshot as 2 shards, not 65536
+	b = b.Recycle()
+	ref = New()
+	for i := range uint32(10) {
+		b.SetIfNew(u32(10, i/5, 0, i))
+		ref.SetIfNew(u32(10, i/5, 0, i))
+	}
+	b.AddRange(u32(10, 9, 0, 0), u32(10, 9, 0, 255))
+	ref.AddRange(u32(10, 9, 0, 0), u32(10, 9, 0, 255))
+	var got, want bytes.Buffer
+	if _, err := b.WriteTo(&got); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if _, err := ref.WriteTo(&want); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("snapshot of %d bytes; want the %d of New", got.Len(), want.Len())
+	}
+	if !bytes.Equal(b.MarshalProto(ProtoMeta{}), ref.MarshalProto(ProtoMeta{})) {
+		t.Fatal("MarshalProto differs from New")
+	}
+	// Recycle cleared the addresses of the first round
+	if n := UnionCount(b); n != 10+256 {
+		t.Fatalf("UnionCount=%d; want %d", n, 10+256)
+	}
+	if !slices.Equal(slices.Collect(b.All()), slices.Collect(ref.All())) {
+		t.Fatal("recycled set keeps addresses of the previous one")
+	}
+}
+
+// BenchmarkSetIfNew SetIfNew of the sets at a sparse fill(2^16 random addresses over the whole space)
+// and a dense one(2^22 random addresses of 10.0.0.0/8).
+func BenchmarkSetIfNew(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	sparse := make([]uint32, 1<<16)
+	for i := range sparse {
+		sparse[i] = r.Uint32()
+	}
+	dense := make([]uint32, 1<<22)
+	for i := range dense {
+		dense[i] = 10<<24 | uint32(r.Intn(1<<24))
+	}
+	sets := []struct {
+		name string
+		new  func() *Bitset
+	}{
+		{"sharded", New},
+		{"roaring", NewRoaring},
+		{"flat", NewFlat},
+	}
+	for _, fill := range []struct {
+		name  string
+		addrs []uint32
+	}{{"sparse", sparse}, {"dense", dense}} {
+		for _, set := range sets {
+			b.Run(fill.name+"/"+set.name, func(b *testing.B) {
+				bs := set.new()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					bs.SetIfNew(fill.addrs[i&(len(fill.addrs)-1)])
+				}
+			})
+		}
+	}
+}
+
+func TestIPv4LineToUint32(t *testing.T) {
+	t.Parallel()
+	b := New()
+
+	cases := []struct {
+		in   string
+		want uint32
+		ok   bool
+	}{
+		{"1.2.3.4", u32(1, 2, 3, 4), true},
+		{"1.2.3.4\r", u32(1, 2, 3, 4), true},
+		{"255.255.255.255\r", u32(255, 255, 255, 255), true},
+		{"1.2.3.4\r\r", 0, false}, // only a single '\r' is accepted
+		{"1.1.1.\r", 0, false},    // as short as IPv4ByteToUint32 rejects without the '\r'
+		{"1.2.3.256\r", 0, false},
+		{"1.2.3.4 ", 0, false},
+		{"\r", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range cases {
+		got, ok := b.IPv4LineToUint32([]byte(tt.in))
+		if ok != tt.ok || got != tt.want {
+			t.Fatalf("IPv4LineToUint32(%q) => %d, %v; want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package ipv4_bitset
+
+import "sync/atomic"
+
+// NewFlat A set of one flat 2^32-bit array(512 MB) allocated up front: SetIfNew and Contains index it
+// directly, without the shard pointer load and the CAS of a new shard. The shard of a /16 is a view of the
+// array stored once its first bit is set, so the methods walking shards skip the untouched /16s as usual.
+// The pages of the array are only backed by memory once touched.
+func NewFlat() *Bitset {
+	b := &Bitset{flat: make([]uint64, 1<<26)}
+	b.memory.Store(int64(len(b.flat)) * 8)
+
+	return b
+}
+
+// Recycle A flat set taking over the array of b, cleared of the /16s b touched, so the inputs of a run
+// share one 512 MB reservation. b must not be used afterwards.
+func (b *Bitset) Recycle() *Bitset {
+	for hi := range b.shards {
+		if sh := b.shards[hi].Load(); sh != nil {
+			clear(sh.bits)
+		}
+	}
+	n := &Bitset{flat: b.flat}
+	n.memory.Store(b.memory.Load())
+
+	return n
+}
+
+func (b *Bitset) setFlat(u32 uint32) bool {
+	idx := u32 >> 6
+	mask := uint64(1) << (u32 & 63)
+	for {
+		old := atomic.LoadUint64(&b.flat[idx])
+		if old&mask != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&b.flat[idx], old, old|mask) {
+			// the first bit of a word may be the first of its /16
+			if old == 0 {
+				b.flatShard(uint16(u32 >> 16))
+			}
+			return true
+		}
+	}
+}
+
+// flatShard The shard of hi as a view of the flat array, stored on first use.
+func (b *Bitset) flatShard(hi uint16) *shard16 {
+	if sh := b.shards[hi].Load(); sh != nil {
+		return sh
+	}
+	lo := int(hi) << 10
+	n := &shard16{bits: b.flat[lo : lo+1024 : lo+1024]}
+	if b.shards[hi].CompareAndSwap(nil, n) {
+		return n
+	}
+
+	return b.shards[hi].Load()
+}
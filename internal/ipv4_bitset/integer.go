@@ -0,0 +1,44 @@
+package ipv4_bitset
+
+// IntegerToUint32 Parses an address written as a single integer with no allocations: decimal(3232235521)
+// or hex with a 0x prefix(0xC0A80001), as used by netflow exports and database dumps.
+func IntegerToUint32(sb []byte) (uint32, bool) {
+	if len(sb) > 2 && sb[0] == '0' && (sb[1] == 'x' || sb[1] == 'X') {
+		hex := sb[2:]
+		if len(hex) > 8 {
+			return 0, false
+		}
+		var acc uint32
+		for _, c := range hex {
+			var d byte
+			switch {
+			case c >= '0' && c <= '9':
+				d = c - '0'
+			case c|0x20 >= 'a' && c|0x20 <= 'f':
+				d = c | 0x20 - 'a' + 10
+			default:
+				return 0, false
+			}
+			acc = acc<<4 | uint32(d)
+		}
+		return acc, true
+	}
+
+	// max="4294967295"
+	if n := len(sb); n == 0 || n > 10 {
+		return 0, false
+	}
+	var acc uint64
+	for _, c := range sb {
+		d := c - '0'
+		if d > 9 {
+			return 0, false
+		}
+		acc = acc*10 + uint64(d)
+	}
+	if acc > 1<<32-1 {
+		return 0, false
+	}
+
+	return uint32(acc), true
+}
@@ -0,0 +1,240 @@
+package ipv4_bitset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+)
+
+// protoSchemaVersion schema_version of proto/uipcounter/v1/counter.proto
+const protoSchemaVersion = 1
+
+// proto wire types
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+	wireI32    = 5
+)
+
+// ProtoMeta Fields of the uipcounter.v1.Counter message besides the set itself.
+type ProtoMeta struct {
+	Source          string
+	CreatedUnixNano int64
+	Unique          uint64 // as written by the sender
+}
+
+// MarshalProto Encodes b as a uipcounter.v1.Counter message, every shard picks
+// the smaller of the dense(bits) and sparse(lo) representations.
+func (b *Bitset) MarshalProto(meta ProtoMeta) []byte {
+	var (
+		shards []byte
+		unique uint64
+		words  = make([]uint64, 1024)
+	)
+	for hi := range b.shards {
+		sh := b.shards[hi].Load()
+		if sh == nil {
+			continue
+		}
+		var n int
+		ws := sh.words()
+		for i := range ws {
+			words[i] = atomic.LoadUint64(&ws[i])
+			n += bits.OnesCount64(words[i])
+		}
+		unique += uint64(n)
+
+		msg := appendVarintField(nil, 1, uint64(hi))
+		// packed lo values take at most 3 bytes each
+		if n*3 < len(words)*8 {
+			var lo []byte
+			for i, w := range words {
+				for ; w != 0; w &= w - 1 {
+					lo = binary.AppendUvarint(lo, uint64(i<<6|bits.TrailingZeros64(w)))
+				}
+			}
+			msg = appendBytesField(msg, 3, lo)
+		} else {
+			dense := make([]byte, 0, len(words)*8)
+			for _, w := range words {
+				dense = binary.LittleEndian.AppendUint64(dense, w)
+			}
+			msg = appendBytesField(msg, 2, dense)
+		}
+		shards = appendBytesField(shards, 3, msg)
+	}
+	if meta.Unique == 0 {
+		meta.Unique = unique
+	}
+
+	out := appendVarintField(nil, 1, protoSchemaVersion)
+	out = appendVarintField(out, 2, meta.Unique)
+	out = append(out, shards...)
+	if meta.Source != "" {
+		out = appendBytesField(out, 4, []byte(meta.Source))
+	}
+	if meta.CreatedUnixNano != 0 {
+		out = appendVarintField(out, 5, uint64(meta.CreatedUnixNano))
+	}
+
+	return out
+}
+
+// UnmarshalProto Merges(union) a uipcounter.v1.Counter message into b,
+// unknown fields are skipped for forward compatibility.
+func (b *Bitset) UnmarshalProto(data []byte) (ProtoMeta, error) {
+	var (
+		meta    ProtoMeta
+		version uint64
+		shards  [][]byte
+	)
+	err := protoFields(data, func(num int, typ int, v uint64, payload []byte) error {
+		switch {
+		case num == 1 && typ == wireVarint:
+			version = v
+		case num == 2 && typ == wireVarint:
+			meta.Unique = v
+		case num == 3 && typ == wireBytes:
+			shards = append(shards, payload)
+		case num == 4 && typ == wireBytes:
+			meta.Source = string(payload)
+		case num == 5 && typ == wireVarint:
+			meta.CreatedUnixNano = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return meta, err
+	}
+	if version != protoSchemaVersion {
+		return meta, fmt.Errorf("%w: unsupported schema version %d", ErrBadSnapshot, version)
+	}
+
+	// validate everything before touching b
+	type decoded struct {
+		hi    uint16
+		dense []byte
+		lo    []uint16
+	}
+	parsed := make([]decoded, 0, len(shards))
+	for _, sh := range shards {
+		var (
+			d     decoded
+			hi    uint64
+			dense bool
+		)
+		err = protoFields(sh, func(num int, typ int, v uint64, payload []byte) error {
+			switch {
+			case num == 1 && typ == wireVarint:
+				hi = v
+			case num == 2 && typ == wireBytes:
+				if len(payload) != 1024*8 {
+					return fmt.Errorf("%w: dense shard of %d bytes", ErrBadSnapshot, len(payload))
+				}
+				d.dense, dense = payload, true
+			case num == 3 && typ == wireBytes:
+				for len(payload) > 0 {
+					lo, n := binary.Uvarint(payload)
+					if n <= 0 || lo > 0xFFFF {
+						return fmt.Errorf("%w: bad sparse value", ErrBadSnapshot)
+					}
+					d.lo = append(d.lo, uint16(lo))
+					payload = payload[n:]
+				}
+			case num == 3 && typ == wireVarint:
+				// unpacked encoding of a repeated field is valid protobuf too
+				if v > 0xFFFF {
+					return fmt.Errorf("%w: bad sparse value", ErrBadSnapshot)
+				}
+				d.lo = append(d.lo, uint16(v))
+			}
+			return nil
+		})
+		if err != nil {
+			return meta, err
+		}
+		if hi > 0xFFFF {
+			return meta, fmt.Errorf("%w: shard %d", ErrBadSnapshot, hi)
+		}
+		if !dense && d.lo == nil {
+			continue
+		}
+		d.hi = uint16(hi)
+		parsed = append(parsed, d)
+	}
+
+	var added uint64
+	for _, d := range parsed {
+		sh := b.getOrCreate(d.hi)
+		for i := 0; i < len(d.dense); i += 8 {
+			added += orUint64(&sh.bits[i/8], binary.LittleEndian.Uint64(d.dense[i:]))
+		}
+		for _, lo := range d.lo {
+			added += orUint64(&sh.bits[lo>>6], uint64(1)<<(lo&63))
+		}
+	}
+	b.AddUnique(added)
+
+	return meta, nil
+}
+
+// protoFields Calls fn for every field of a message, v is the value of varint/fixed fields,
+// payload of length delimited ones.
+func protoFields(b []byte, fn func(num int, typ int, v uint64, payload []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("%w: bad field key", ErrBadSnapshot)
+		}
+		b = b[n:]
+		num, typ := int(key>>3), int(key&7)
+
+		var (
+			v       uint64
+			payload []byte
+		)
+		switch typ {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return fmt.Errorf("%w: bad varint", ErrBadSnapshot)
+			}
+			b = b[n:]
+		case wireI64:
+			if len(b) < 8 {
+				return fmt.Errorf("%w: truncated fixed64", ErrBadSnapshot)
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireI32:
+			if len(b) < 4 {
+				return fmt.Errorf("%w: truncated fixed32", ErrBadSnapshot)
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return fmt.Errorf("%w: truncated field %d", ErrBadSnapshot, num)
+			}
+			payload, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d", ErrBadSnapshot, typ)
+		}
+		if err := fn(num, typ, v, payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytesField(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
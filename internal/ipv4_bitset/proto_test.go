@@ -0,0 +1,102 @@
+package ipv4_bitset
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestProto_RoundTrip(t *testing.T) {
+	t.Parallel()
+	src := New()
+	add := func(a uint32) {
+		if src.SetIfNew(a) {
+			src.AddUnique(1)
+		}
+	}
+	// sparse shards
+	add(u32(1, 1, 1, 1))
+	add(u32(1, 1, 200, 7))
+	add(u32(255, 255, 255, 255))
+	// dense shard: every 4th address of 10.0/16
+	for i := uint32(0); i < 1<<16; i += 4 {
+		add(u32(10, 0, 0, 0) | i)
+	}
+
+	data := src.MarshalProto(ProtoMeta{Source: "w1", CreatedUnixNano: 42})
+	// the dense shard is stored as 8KB of bits, not 16384 packed values
+	if len(data) > 3*(1024*8) {
+		t.Fatalf("message size=%d; want the dense representation", len(data))
+	}
+
+	dst := New()
+	meta, err := dst.UnmarshalProto(data)
+	if err != nil {
+		t.Fatalf("UnmarshalProto: %v", err)
+	}
+	if meta.Source != "w1" || meta.CreatedUnixNano != 42 || meta.Unique != src.GetUniqueCount() {
+		t.Fatalf("meta=%+v; want source w1, created 42, unique %d", meta, src.GetUniqueCount())
+	}
+	if got := dst.GetUniqueCount(); got != src.GetUniqueCount() {
+		t.Fatalf("GetUniqueCount=%d; want %d", got, src.GetUniqueCount())
+	}
+	for a := range src.All() {
+		if dst.SetIfNew(a) {
+			t.Fatalf("address %08x missing after decode", a)
+		}
+	}
+
+	// merging the same message again adds nothing
+	if _, err = dst.UnmarshalProto(data); err != nil || dst.GetUniqueCount() != src.GetUniqueCount() {
+		t.Fatalf("re-merge err=%v unique=%d; want %d", err, dst.GetUniqueCount(), src.GetUniqueCount())
+	}
+}
+
+func TestProto_UnknownFieldsSkipped(t *testing.T) {
+	t.Parallel()
+	src := New()
+	src.SetIfNew(u32(8, 8, 8, 8))
+	data := src.MarshalProto(ProtoMeta{})
+	// fields a newer writer may add: varint 99, fixed64 100, bytes 101
+	data = appendVarintField(data, 99, 7)
+	data = binary.AppendUvarint(data, 100<<3|wireI64)
+	data = append(data, 1, 2, 3, 4, 5, 6, 7, 8)
+	data = appendBytesField(data, 101, []byte("x"))
+
+	dst := New()
+	if _, err := dst.UnmarshalProto(data); err != nil {
+		t.Fatalf("UnmarshalProto: %v", err)
+	}
+	if dst.SetIfNew(u32(8, 8, 8, 8)) {
+		t.Fatalf("8.8.8.8 missing after decode")
+	}
+}
+
+func TestProto_Bad(t *testing.T) {
+	t.Parallel()
+	shard := func(f []byte) []byte { return appendBytesField(appendVarintField(nil, 1, 1), 3, f) }
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty(no version)", nil},
+		{"future version", appendVarintField(nil, 1, 2)},
+		{"truncated", []byte{1<<3 | wireBytes, 10, 1}},
+		{"bad wire type", []byte{1<<3 | 3}},
+		{"shard hi", shard(appendVarintField(nil, 1, 1<<16))},
+		{"dense size", shard(appendBytesField(nil, 2, make([]byte, 8)))},
+		{"sparse value", shard(appendVarintField(nil, 3, 1<<16))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			b := New()
+			if _, err := b.UnmarshalProto(tt.in); !errors.Is(err, ErrBadSnapshot) {
+				t.Fatalf("UnmarshalProto err=%v; want ErrBadSnapshot", err)
+			}
+			if b.GetUniqueCount() != 0 {
+				t.Fatalf("bad message must not change the set")
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package ipv4_bitset
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// arrayMax Addresses of an array shard at most, 4096 low halves take the 8KB of a bitmap.
+const arrayMax = 4096
+
+// NewRoaring A set with roaring bitmap containers: a shard starts as a sorted array of the low 16 bits
+// of its addresses(2 bytes an address) and turns into the 8KB bitmap past arrayMax of them, so sparse
+// inputs(few addresses over many /16s) take a fraction of the memory of New.
+// AddRange, snapshot loads and set operations write bitmap shards.
+func NewRoaring() *Bitset { return &Bitset{roaring: true} }
+
+// newShard The shard SetIfNew allocates for hi, an array one for NewRoaring.
+func (b *Bitset) newShard(hi uint16) *shard16 {
+	if !b.roaring {
+		return b.getOrCreate(hi)
+	}
+	n := &shard16{}
+	if b.shards[hi].CompareAndSwap(nil, n) {
+		return n
+	}
+
+	return b.shards[hi].Load()
+}
+
+// setArray SetIfNew of an array shard, the arrayMax+1st address promotes it.
+func (b *Bitset) setArray(hi uint16, sh *shard16, lo uint16) bool {
+	sh.mu.Lock()
+	if n := sh.next; n != nil {
+		sh.mu.Unlock()
+		return setBit(n, lo)
+	}
+	i, found := slices.BinarySearch(sh.arr, lo)
+	if found {
+		sh.mu.Unlock()
+		return false
+	}
+	if len(sh.arr) == arrayMax {
+		n := b.promoteLocked(hi, sh)
+		sh.mu.Unlock()
+		return setBit(n, lo)
+	}
+	c := cap(sh.arr)
+	sh.arr = slices.Insert(sh.arr, i, lo)
+	b.memory.Add(int64(cap(sh.arr)-c) * 2)
+	sh.mu.Unlock()
+
+	return true
+}
+
+// promote Replaces the array shard sh of hi by a bitmap one, writers and readers still holding sh
+// follow next.
+func (b *Bitset) promote(hi uint16, sh *shard16) *shard16 {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.next != nil {
+		return sh.next
+	}
+
+	return b.promoteLocked(hi, sh)
+}
+
+func (b *Bitset) promoteLocked(hi uint16, sh *shard16) *shard16 {
+	n := &shard16{bits: make([]uint64, 1024)}
+	for _, lo := range sh.arr {
+		n.bits[lo>>6] |= 1 << (lo & 63)
+	}
+	b.memory.Add(1024*8 - int64(cap(sh.arr))*2)
+	sh.arr, sh.next = nil, n
+	b.shards[hi].Store(n)
+
+	return n
+}
+
+func (sh *shard16) containsArray(lo uint16) bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if n := sh.next; n != nil {
+		return atomic.LoadUint64(&n.bits[lo>>6])&(uint64(1)<<(lo&63)) != 0
+	}
+	_, found := slices.BinarySearch(sh.arr, lo)
+
+	return found
+}
+
+// lows A copy of the low halves of an array shard, false for a bitmap one.
+func (sh *shard16) lows() ([]uint16, bool) {
+	if sh.bits != nil {
+		return nil, false
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.next != nil {
+		return nil, false
+	}
+
+	return slices.Clone(sh.arr), true
+}
+
+// words The bitmap of sh, built for an array shard, read with atomic loads as it may be shared.
+func (sh *shard16) words() []uint64 {
+	if sh.bits != nil {
+		return sh.bits
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.next != nil {
+		return sh.next.bits
+	}
+	w := make([]uint64, 1024)
+	for _, lo := range sh.arr {
+		w[lo>>6] |= 1 << (lo & 63)
+	}
+
+	return w
+}
@@ -0,0 +1,53 @@
+package ipv4_bitset
+
+import "sync/atomic"
+
+// Or Merges src into dst word by word, the unique count of dst grows by the new addresses.
+func Or(dst, src *Bitset) {
+	combine(dst, src, src, func(x, _ uint64) uint64 { return x })
+}
+
+// And Merges the addresses set in both a and b into dst.
+func And(dst, a, b *Bitset) {
+	combine(dst, a, b, func(x, y uint64) uint64 { return x & y })
+}
+
+// AndNot Merges the addresses of a not set in b into dst.
+func AndNot(dst, a, b *Bitset) {
+	combine(dst, a, b, func(x, y uint64) uint64 { return x &^ y })
+}
+
+// combine ORs op of the words of a and b into dst, shards of a that are not allocated give nothing,
+// an unallocated shard of b reads as zeros.
+func combine(dst, a, b *Bitset, op func(x, y uint64) uint64) {
+	var added uint64
+	for hi := range a.shards {
+		sa := a.shards[hi].Load()
+		if sa == nil {
+			continue
+		}
+		var (
+			wa = sa.words()
+			wb []uint64
+			sd *shard16
+		)
+		if sb := b.shards[hi].Load(); sb != nil {
+			wb = sb.words()
+		}
+		for i := range wa {
+			var y uint64
+			if wb != nil {
+				y = atomic.LoadUint64(&wb[i])
+			}
+			w := op(atomic.LoadUint64(&wa[i]), y)
+			if w == 0 {
+				continue
+			}
+			if sd == nil {
+				sd = dst.getOrCreate(uint16(hi))
+			}
+			added += orUint64(&sd.bits[i], w)
+		}
+	}
+	dst.AddUnique(added)
+}
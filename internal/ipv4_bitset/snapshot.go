@@ -0,0 +1,147 @@
+package ipv4_bitset
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Snapshot format(little endian):
+//
+//	"UIPB" | version uint16 | unique uint64 | shards uint32 | shards * (hi uint16 | 1024 * uint64)
+//
+// only allocated shards are written, so sparse sets stay small.
+const (
+	snapshotMagic   = "UIPB"
+	snapshotVersion = uint16(1)
+)
+
+var ErrBadSnapshot = errors.New("bad bitset snapshot")
+
+// WriteTo Implements io.WriterTo. Safe to call while other goroutines insert,
+// the header count is informational, ReadFrom always recounts the bits.
+func (b *Bitset) WriteTo(w io.Writer) (int64, error) {
+	var (
+		count  uint32
+		unique uint64
+	)
+	words := make([]uint64, 1024)
+	for i := range b.shards {
+		if sh := b.shards[i].Load(); sh != nil {
+			count++
+			ws := sh.words()
+			for j := range ws {
+				words[j] = atomic.LoadUint64(&ws[j])
+			}
+			unique += popcountWords(words)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	hdr := make([]byte, 0, 18)
+	hdr = append(hdr, snapshotMagic...)
+	hdr = binary.LittleEndian.AppendUint16(hdr, snapshotVersion)
+	hdr = binary.LittleEndian.AppendUint64(hdr, unique)
+	hdr = binary.LittleEndian.AppendUint32(hdr, count)
+	if _, err := cw.Write(hdr); err != nil {
+		return cw.n, err
+	}
+
+	buf := make([]byte, 2+1024*8)
+	for i := range b.shards {
+		sh := b.shards[i].Load()
+		if sh == nil {
+			continue
+		}
+		binary.LittleEndian.PutUint16(buf, uint16(i))
+		ws := sh.words()
+		for j := range ws {
+			binary.LittleEndian.PutUint64(buf[2+j*8:], atomic.LoadUint64(&ws[j]))
+		}
+		if _, err := cw.Write(buf); err != nil {
+			return cw.n, err
+		}
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// ReadFrom Implements io.ReaderFrom. The snapshot is merged(union) into b
+// and the unique counter grows by the number of newly set bits.
+func (b *Bitset) ReadFrom(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	var n int64
+
+	hdr := make([]byte, 18)
+	m, err := io.ReadFull(br, hdr)
+	n += int64(m)
+	if err != nil {
+		return n, fmt.Errorf("%w: header: %v", ErrBadSnapshot, err)
+	}
+	if string(hdr[:4]) != snapshotMagic {
+		return n, fmt.Errorf("%w: magic %q", ErrBadSnapshot, hdr[:4])
+	}
+	if v := binary.LittleEndian.Uint16(hdr[4:]); v != snapshotVersion {
+		return n, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, v)
+	}
+	count := binary.LittleEndian.Uint32(hdr[14:])
+	if count > 1<<16 {
+		return n, fmt.Errorf("%w: %d shards", ErrBadSnapshot, count)
+	}
+
+	buf := make([]byte, 2+1024*8)
+	var added uint64
+	for ; count > 0; count-- {
+		m, err = io.ReadFull(br, buf)
+		n += int64(m)
+		if err != nil {
+			return n, fmt.Errorf("%w: shard: %v", ErrBadSnapshot, err)
+		}
+		sh := b.getOrCreate(binary.LittleEndian.Uint16(buf))
+		for j := range sh.bits {
+			added += orUint64(&sh.bits[j], binary.LittleEndian.Uint64(buf[2+j*8:]))
+		}
+	}
+	b.AddUnique(added)
+
+	return n, nil
+}
+
+// orUint64 Atomically ORs v into *addr and returns the number of newly set bits.
+func orUint64(addr *uint64, v uint64) uint64 {
+	for {
+		old := atomic.LoadUint64(addr)
+		if old|v == old {
+			return 0
+		}
+		if atomic.CompareAndSwapUint64(addr, old, old|v) {
+			return uint64(bits.OnesCount64(v &^ old))
+		}
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+func popcountGeneric(words []uint64) uint64 {
+	var n int
+	for _, w := range words {
+		n += bits.OnesCount64(w)
+	}
+
+	return uint64(n)
+}
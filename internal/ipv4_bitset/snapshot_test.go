@@ -0,0 +1,70 @@
+package ipv4_bitset
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	t.Parallel()
+	src := New()
+	addrs := []uint32{u32(1, 1, 1, 1), u32(1, 1, 200, 7), u32(10, 0, 0, 1), u32(255, 255, 255, 255)}
+	for _, a := range addrs {
+		if src.SetIfNew(a) {
+			src.AddUnique(1)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := src.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	// 3 shards: 1.1, 10.0, 255.255
+	if want := 18 + 3*(2+1024*8); buf.Len() != want {
+		t.Fatalf("snapshot size=%d; want %d", buf.Len(), want)
+	}
+
+	dst := New()
+	if _, err := dst.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := dst.GetUniqueCount(); got != uint64(len(addrs)) {
+		t.Fatalf("GetUniqueCount=%d; want %d", got, len(addrs))
+	}
+	for _, a := range addrs {
+		if dst.SetIfNew(a) {
+			t.Fatalf("address %08x missing after restore", a)
+		}
+	}
+}
+
+func TestSnapshot_ReadFromMerges(t *testing.T) {
+	t.Parallel()
+	a := New()
+	a.SetIfNew(u32(1, 1, 1, 1))
+	a.SetIfNew(u32(2, 2, 2, 2))
+	var buf bytes.Buffer
+	if _, err := a.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	b := New()
+	b.SetIfNew(u32(2, 2, 2, 2))
+	b.AddUnique(1)
+	if _, err := b.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := b.GetUniqueCount(); got != 2 {
+		t.Fatalf("union GetUniqueCount=%d; want 2", got)
+	}
+}
+
+func TestSnapshot_Bad(t *testing.T) {
+	t.Parallel()
+	for _, in := range [][]byte{nil, []byte("XXXX\x01\x00"), append([]byte("UIPB\x09\x00"), make([]byte, 12)...)} {
+		if _, err := New().ReadFrom(bytes.NewReader(in)); !errors.Is(err, ErrBadSnapshot) {
+			t.Fatalf("ReadFrom(%q) err=%v; want ErrBadSnapshot", in, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package ipv4_bitset
+
+// StrictToUint32 IPv4ByteToUint32 with the canonical form only, as net/netip parses it: every octet has
+// 1-3 digits and no leading zero, "001.002.003.004" and "1.2.3.04" are rejected.
+func StrictToUint32(sb []byte) (uint32, bool) {
+	if n := len(sb); n < 7 || n > 15 {
+		return 0, false
+	}
+	var acc, part, dots, digits uint32
+	for _, c := range sb {
+		if d := c - '0'; d <= 9 {
+			if digits == 1 && part == 0 {
+				return 0, false
+			}
+			part = part*10 + uint32(d)
+			digits++
+			if part > 255 {
+				return 0, false
+			}
+			continue
+		}
+		if c != '.' || digits == 0 || dots >= 3 {
+			return 0, false
+		}
+		acc = acc<<8 | part
+		part, digits = 0, 0
+		dots++
+	}
+	if dots != 3 || digits == 0 {
+		return 0, false
+	}
+
+	return acc<<8 | part, true
+}
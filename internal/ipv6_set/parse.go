@@ -0,0 +1,149 @@
+package ipv6_set
+
+import "bytes"
+
+// Parse Parses the text forms of RFC 4291 without allocations: full(2001:db8:0:0:0:0:0:1),
+// compressed(2001:db8::1, ::), with the last 32 bits as a dotted IPv4(::ffff:1.2.3.4) and
+// with a zone(fe80::1%eth0, the zone is ignored). Hex digits are case insensitive.
+func Parse(b []byte) (Addr, bool) {
+	if i := bytes.IndexByte(b, '%'); i >= 0 {
+		if i == len(b)-1 {
+			return Addr{}, false
+		}
+		b = b[:i]
+	}
+	// "::" .. "ffff:ffff:ffff:ffff:ffff:ffff:255.255.255.255"
+	if len(b) < 2 || len(b) > 45 {
+		return Addr{}, false
+	}
+
+	var (
+		g        [8]uint16
+		n        int // groups parsed
+		ellipsis = -1
+		i        int
+	)
+	if b[0] == ':' {
+		if b[1] != ':' {
+			return Addr{}, false
+		}
+		if len(b) == 2 {
+			return Addr{}, true
+		}
+		ellipsis, i = 0, 2
+	}
+	for i < len(b) {
+		if n == 8 {
+			return Addr{}, false
+		}
+		start := i
+		var v uint16
+		for i < len(b) && i-start < 4 {
+			d, ok := hexDigit(b[i])
+			if !ok {
+				break
+			}
+			v = v<<4 | d
+			i++
+		}
+		if i == start {
+			return Addr{}, false
+		}
+		if i < len(b) && b[i] == '.' {
+			// the dotted IPv4 takes the last two groups
+			if n > 6 {
+				return Addr{}, false
+			}
+			v4, ok := parseDotted(b[start:])
+			if !ok {
+				return Addr{}, false
+			}
+			g[n], g[n+1] = uint16(v4>>16), uint16(v4)
+			n += 2
+			break
+		}
+		g[n] = v
+		n++
+		if i == len(b) {
+			break
+		}
+		// also rejects a fifth hex digit
+		if b[i] != ':' {
+			return Addr{}, false
+		}
+		i++
+		switch {
+		case i == len(b):
+			// a trailing single ':'
+			return Addr{}, false
+		case b[i] == ':':
+			if ellipsis >= 0 {
+				return Addr{}, false
+			}
+			ellipsis = n
+			i++
+		}
+	}
+
+	switch {
+	case ellipsis < 0 && n != 8, ellipsis >= 0 && n == 8:
+		return Addr{}, false
+	case ellipsis >= 0:
+		// the groups after "::" move to the end, the ones in between are zero
+		tail := n - ellipsis
+		copy(g[8-tail:], g[ellipsis:n])
+		clear(g[ellipsis : 8-tail])
+	}
+
+	var a Addr
+	for k := 0; k < 4; k++ {
+		a.Hi = a.Hi<<16 | uint64(g[k])
+		a.Lo = a.Lo<<16 | uint64(g[k+4])
+	}
+
+	return a, true
+}
+
+func hexDigit(c byte) (uint16, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return uint16(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return uint16(c - 'a' + 10), true
+	case c >= 'A' && c <= 'F':
+		return uint16(c - 'A' + 10), true
+	}
+
+	return 0, false
+}
+
+// parseDotted A.B.C.D(0-255 each) of an embedded IPv4.
+func parseDotted(b []byte) (uint32, bool) {
+	var acc, part, dots uint32
+	digits := 0
+	for _, c := range b {
+		switch {
+		case c >= '0' && c <= '9':
+			// no leading zeros in the dotted tail(RFC 4291 text form)
+			if digits == 1 && part == 0 {
+				return 0, false
+			}
+			part = part*10 + uint32(c-'0')
+			digits++
+			if part > 255 {
+				return 0, false
+			}
+		case c == '.' && digits > 0 && dots < 3:
+			acc = acc<<8 | part
+			part, digits = 0, 0
+			dots++
+		default:
+			return 0, false
+		}
+	}
+	if dots != 3 || digits == 0 {
+		return 0, false
+	}
+
+	return acc<<8 | part, true
+}
@@ -0,0 +1,58 @@
+package ipv6_set
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// PrefixSet Distinct /n prefixes(n <= 64) of IPv6 addresses: the prefix fits the high half of an
+	// address, so it is kept as a 64-bit key in shards as independently locked as those of Set.
+	PrefixSet struct {
+		bits   int
+		mask   uint64
+		shards [256]prefixShard
+		unique atomic.Uint64
+	}
+	prefixShard struct {
+		mu sync.Mutex
+		m  map[uint64]struct{}
+	}
+)
+
+// prefixBytes Approximate memory of a prefix in a shard map.
+const prefixBytes = 24
+
+// NewPrefixSet Counts the /bits prefixes, bits is 1..64.
+func NewPrefixSet(bits int) *PrefixSet {
+	return &PrefixSet{bits: bits, mask: ^uint64(0) << (64 - bits)}
+}
+
+// Add Reports whether the prefix of a is a new one.
+func (s *PrefixSet) Add(a Addr) bool {
+	p := a.Hi & s.mask
+	sh := &s.shards[p*0x9E3779B97F4A7C15>>56]
+	sh.mu.Lock()
+	if sh.m == nil {
+		sh.m = make(map[uint64]struct{})
+	}
+	_, ok := sh.m[p]
+	if !ok {
+		sh.m[p] = struct{}{}
+	}
+	sh.mu.Unlock()
+	if ok {
+		return false
+	}
+	s.unique.Add(1)
+
+	return true
+}
+
+func (s *PrefixSet) Len() uint64 { return s.unique.Load() }
+
+// Bits The prefix length.
+func (s *PrefixSet) Bits() int { return s.bits }
+
+// MemoryBytes Approximate memory of the set so far.
+func (s *PrefixSet) MemoryBytes() int64 { return int64(s.unique.Load()) * prefixBytes }
@@ -0,0 +1,99 @@
+// Package ipv6_set counts distinct IPv6 addresses. The 2^128 space rules out a bitset,
+// addresses are kept in a hash set split into independently locked shards instead.
+package ipv6_set
+
+import (
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Addr IPv6 address as two big endian halves: 2001:db8::1 is {0x20010db800000000, 1}.
+	Addr struct {
+		Hi, Lo uint64
+	}
+	Set struct {
+		// 256 shards picked by a hash of the address, concurrent file shards rarely share a lock
+		shards [256]shard
+		unique atomic.Uint64
+	}
+	shard struct {
+		mu sync.Mutex
+		m  map[Addr]struct{}
+	}
+)
+
+// addrBytes Approximate memory of an address in a shard map(key, hash bits, load factor).
+const addrBytes = 40
+
+// New The shard maps are allocated on their first address, an IPv4-only input pays for none.
+func New() *Set { return &Set{} }
+
+// Add Reports whether a is a new address.
+func (s *Set) Add(a Addr) bool {
+	sh := s.shard(a)
+	sh.mu.Lock()
+	if sh.m == nil {
+		sh.m = make(map[Addr]struct{})
+	}
+	_, ok := sh.m[a]
+	if !ok {
+		sh.m[a] = struct{}{}
+	}
+	sh.mu.Unlock()
+	if ok {
+		return false
+	}
+	s.unique.Add(1)
+
+	return true
+}
+
+// Contains Reports whether a was added.
+func (s *Set) Contains(a Addr) bool {
+	sh := s.shard(a)
+	sh.mu.Lock()
+	_, ok := sh.m[a]
+	sh.mu.Unlock()
+
+	return ok
+}
+
+// All Iterates the addresses in no particular order, a shard is copied before its addresses are yielded
+// so the loop may add to s; concurrent adds may or may not be seen.
+func (s *Set) All() iter.Seq[Addr] {
+	return func(yield func(Addr) bool) {
+		var keys []Addr
+		for i := range s.shards {
+			sh := &s.shards[i]
+			sh.mu.Lock()
+			keys = keys[:0]
+			for a := range sh.m {
+				keys = append(keys, a)
+			}
+			sh.mu.Unlock()
+			for _, a := range keys {
+				if !yield(a) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Set) Len() uint64 { return s.unique.Load() }
+
+func (s *Set) shard(a Addr) *shard { return &s.shards[(a.Hi^a.Lo)*0x9E3779B97F4A7C15>>56] }
+
+// MemoryBytes Approximate memory of the set so far.
+func (s *Set) MemoryBytes() int64 { return int64(s.unique.Load()) * addrBytes }
+
+// IPv4 The address of an IPv4-mapped address(::ffff:1.2.3.4), ok=false for any other.
+func (a Addr) IPv4() (uint32, bool) {
+	if a.Hi != 0 || a.Lo>>32 != 0xFFFF {
+		return 0, false
+	}
+
+	return uint32(a.Lo), true
+}
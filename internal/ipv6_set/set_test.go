@@ -0,0 +1,204 @@
+package ipv6_set
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// reference The address as parsed by net/netip, ok=false for anything but an IPv6 address.
+func reference(s string) (Addr, bool) {
+	ip, err := netip.ParseAddr(s)
+	if err != nil || !ip.Is6() {
+		return Addr{}, false
+	}
+	b := ip.As16()
+
+	return Addr{binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])}, true
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	cases := []string{
+		"::", "::1", "1::", "2001:db8::1", "2001:DB8::1", "2001:0db8:0000:0000:0000:0000:0000:0001",
+		"fe80::1%eth0", "fe80::1%", "::ffff:1.2.3.4", "::1.2.3.4", "64:ff9b::192.0.2.33",
+		"1:2:3:4:5:6:7:8", "1:2:3:4:5:6:7::", "::2:3:4:5:6:7:8", "1:2:3:4:5:6:1.2.3.4",
+		"1:2:3:4:5:6:7:8:9", "1:2:3:4:5:6:7", "1::2::3", ":::", ":1::", "1:", "1:::2", "12345::",
+		"1:2:3:4:5:6:7:1.2.3.4", "::ffff:1.2.3", "::ffff:1.2.3.256", "::ffff:1.2.3.4.5", "::1.2.3.4:5",
+		"1.2.3.4", "", ":", "g::1", "::ffff:0001.2.3.4", "::ffff:01.2.3.4", "1:2:3:4:5::6:7:8",
+	}
+	for _, s := range cases {
+		want, wok := reference(s)
+		if strings.HasSuffix(s, "%") {
+			// netip accepts an empty zone, a zone must have a name here
+			wok = false
+		}
+		got, ok := Parse([]byte(s))
+		if ok != wok || got != want {
+			t.Fatalf("Parse(%q)=%x, %v; want %x, %v", s, got, ok, want, wok)
+		}
+	}
+}
+
+func TestParse_Random(t *testing.T) {
+	t.Parallel()
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		var b [16]byte
+		for j := range b {
+			// zero runs make compressed forms likely
+			if rnd.Intn(3) > 0 {
+				b[j] = byte(rnd.Intn(256))
+			}
+		}
+		ip := netip.AddrFrom16(b)
+		for _, s := range []string{ip.String(), ip.StringExpanded(), strings.ToUpper(ip.StringExpanded())} {
+			want, _ := reference(s)
+			if got, ok := Parse([]byte(s)); !ok || got != want {
+				t.Fatalf("Parse(%q)=%x, %v; want %x", s, got, ok, want)
+			}
+		}
+	}
+}
+
+func TestAddr_IPv4(t *testing.T) {
+	t.Parallel()
+	a, _ := Parse([]byte("::ffff:1.2.3.4"))
+	if v4, ok := a.IPv4(); !ok || v4 != 0x01020304 {
+		t.Fatalf("IPv4()=%x, %v; want 1.2.3.4", v4, ok)
+	}
+	a, _ = Parse([]byte("::1.2.3.4"))
+	if _, ok := a.IPv4(); ok {
+		t.Fatalf("::1.2.3.4 is not IPv4-mapped")
+	}
+}
+
+func TestSet_Add(t *testing.T) {
+	t.Parallel()
+	s := New()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// every worker adds the same addresses
+			for i := uint64(0); i < 5000; i++ {
+				s.Add(Addr{Hi: 0x20010db8 << 32, Lo: i})
+			}
+		}()
+	}
+	wg.Wait()
+	if s.Len() != 5000 {
+		t.Fatalf("Len=%d; want 5000", s.Len())
+	}
+	if s.Add(Addr{Hi: 0x20010db8 << 32, Lo: 1}) || !s.Add(Addr{Lo: 1}) {
+		t.Fatalf("Add of a seen address must be false, of a new one true")
+	}
+}
+
+func TestSet_Snapshot(t *testing.T) {
+	t.Parallel()
+	a, b := New(), New()
+	for i := uint64(0); i < 1000; i++ {
+		a.Add(Addr{Hi: i << 48, Lo: i})
+	}
+	b.Add(Addr{Lo: 1})
+	b.Add(Addr{Hi: 1 << 48, Lo: 1}) // also in a
+
+	var buf, again strings.Builder
+	if _, err := a.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if _, err := b.ReadFrom(strings.NewReader(buf.String())); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if b.Len() != 1001 || !b.Contains(Addr{Hi: 999 << 48, Lo: 999}) || !b.Contains(Addr{Lo: 1}) || b.Contains(Addr{Lo: 2}) {
+		t.Fatalf("merged Len=%d; want 1001 with the addresses of both", b.Len())
+	}
+	var n int
+	for range b.All() {
+		n++
+	}
+	if n != 1001 {
+		t.Fatalf("All yielded %d; want 1001", n)
+	}
+
+	// sorted: equal sets, equal snapshots
+	c := New()
+	_, _ = c.ReadFrom(strings.NewReader(buf.String()))
+	_, _ = c.WriteTo(&again)
+	if again.String() != buf.String() {
+		t.Fatalf("snapshot of a restored set differs")
+	}
+	for _, bad := range []string{"", "UIPB\x00\x01", "UIP6\x00\x02" + strings.Repeat("\x00", 8), "UIP6\x00\x01\x00\x00\x00\x00\x00\x00\x00\x02" + strings.Repeat("\x00", 20)} {
+		if _, err := New().ReadFrom(strings.NewReader(bad)); !errors.Is(err, ErrBadSnapshot) {
+			t.Errorf("ReadFrom(%q) err=%v; want ErrBadSnapshot", bad, err)
+		}
+	}
+}
+
+func TestPrefixSet(t *testing.T) {
+	t.Parallel()
+	s64, s48 := NewPrefixSet(64), NewPrefixSet(48)
+	for _, addr := range []string{
+		"2001:db8:1:1::1", "2001:db8:1:1::2", "2001:db8:1:1:aaaa::", // one /64
+		"2001:db8:1:2::1", // same /48
+		"2001:db8:2::1",
+	} {
+		a, ok := reference(addr)
+		if !ok {
+			t.Fatalf("reference(%q)", addr)
+		}
+		s64.Add(a)
+		s48.Add(a)
+	}
+	if s64.Len() != 3 || s48.Len() != 2 || s48.Bits() != 48 {
+		t.Fatalf("/64s=%d /48s=%d; want 3 and 2", s64.Len(), s48.Len())
+	}
+	if a, _ := reference("2001:db8:2::ffff"); s48.Add(a) {
+		t.Fatal("Add of a known /48 is new")
+	}
+}
+
+func TestSpill(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	s := NewSpill(dir, 1024)
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 5000 {
+				// 3000 distinct addresses, every worker adds all of them
+				if err := s.Add(Addr{0x20010db800000000, uint64((i*7 + w*1000) % 3000)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if runs, _ := os.ReadDir(dir); len(runs) == 0 {
+		t.Fatal("no run was written")
+	}
+
+	n, err := s.Count()
+	if err != nil || n != 3000 || s.Added() != 20000 {
+		t.Fatalf("Count=%d, %v(added %d); want 3000", n, err, s.Added())
+	}
+	if runs, _ := os.ReadDir(dir); len(runs) != 0 {
+		t.Fatalf("%d runs left after Count", len(runs))
+	}
+
+	// nothing spilled
+	if n, err = NewSpill(dir, 1<<20).Count(); err != nil || n != 0 {
+		t.Fatalf("Count of an empty spill=%d, %v", n, err)
+	}
+}
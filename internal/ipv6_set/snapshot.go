@@ -0,0 +1,85 @@
+package ipv6_set
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Snapshot format(big endian, the byte order of the addresses):
+//
+//	"UIP6" | version uint16 | count uint64 | count * (hi uint64 | lo uint64)
+//
+// addresses are sorted, so snapshots of equal sets are equal files.
+const (
+	snapshotMagic   = "UIP6"
+	snapshotVersion = uint16(1)
+)
+
+var ErrBadSnapshot = errors.New("bad ipv6 set snapshot")
+
+// WriteTo Implements io.WriterTo. Addresses added while writing may or may not be included.
+func (s *Set) WriteTo(w io.Writer) (int64, error) {
+	addrs := make([]Addr, 0, s.Len())
+	for a := range s.All() {
+		addrs = append(addrs, a)
+	}
+	slices.SortFunc(addrs, func(a, b Addr) int {
+		return cmp.Or(cmp.Compare(a.Hi, b.Hi), cmp.Compare(a.Lo, b.Lo))
+	})
+
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 0, 16)
+	buf = append(buf, snapshotMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, snapshotVersion)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(addrs)))
+	n, err := bw.Write(buf)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	for _, a := range addrs {
+		buf = binary.BigEndian.AppendUint64(buf[:0], a.Hi)
+		buf = binary.BigEndian.AppendUint64(buf, a.Lo)
+		n, err = bw.Write(buf)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, bw.Flush()
+}
+
+// ReadFrom Implements io.ReaderFrom. The snapshot is merged(union) into s.
+func (s *Set) ReadFrom(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, 14)
+	m, err := io.ReadFull(br, hdr)
+	n := int64(m)
+	if err != nil {
+		return n, fmt.Errorf("%w: header: %v", ErrBadSnapshot, err)
+	}
+	if string(hdr[:4]) != snapshotMagic {
+		return n, fmt.Errorf("%w: magic %q", ErrBadSnapshot, hdr[:4])
+	}
+	if v := binary.BigEndian.Uint16(hdr[4:]); v != snapshotVersion {
+		return n, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, v)
+	}
+
+	buf := make([]byte, 16)
+	for count := binary.BigEndian.Uint64(hdr[6:]); count > 0; count-- {
+		m, err = io.ReadFull(br, buf)
+		n += int64(m)
+		if err != nil {
+			return n, fmt.Errorf("%w: address: %v", ErrBadSnapshot, err)
+		}
+		s.Add(Addr{binary.BigEndian.Uint64(buf), binary.BigEndian.Uint64(buf[8:])})
+	}
+
+	return n, nil
+}
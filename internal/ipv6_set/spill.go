@@ -0,0 +1,222 @@
+package ipv6_set
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// spillBuffers Buffers of a Spill picked by a hash of the address, as the shards of a Set.
+const spillBuffers = 16
+
+type (
+	// Spill Exact distinct count of IPv6 addresses kept on disk instead of in memory: added addresses are
+	// buffered, a full buffer is sorted, deduplicated and written as a run of 16-byte big endian keys(the
+	// snapshot order) to a temp file by the goroutine that filled it, Count merges the runs.
+	Spill struct {
+		dir     string
+		runKeys int // of a buffer
+
+		buffers [spillBuffers]spillBuffer
+		added   atomic.Uint64
+
+		mu   sync.Mutex
+		runs []string
+	}
+	spillBuffer struct {
+		mu   sync.Mutex
+		keys []Addr
+	}
+)
+
+// NewSpill Runs of at most runKeys addresses are written to dir("" - the system temp directory),
+// up to 16 bytes * runKeys of addresses are buffered.
+func NewSpill(dir string, runKeys int) *Spill {
+	return &Spill{dir: dir, runKeys: max(runKeys/spillBuffers, 1)}
+}
+
+// Add Buffers a, writing a run once the buffer is full.
+func (s *Spill) Add(a Addr) error {
+	s.added.Add(1)
+	b := &s.buffers[(a.Hi^a.Lo)*0x9E3779B97F4A7C15>>60]
+	b.mu.Lock()
+	b.keys = append(b.keys, a)
+	if len(b.keys) < s.runKeys {
+		b.mu.Unlock()
+		return nil
+	}
+	full := b.keys
+	b.keys = nil
+	b.mu.Unlock()
+
+	return s.writeRun(full)
+}
+
+// Added Addresses added so far, duplicates included.
+func (s *Spill) Added() uint64 { return s.added.Load() }
+
+func (s *Spill) writeRun(keys []Addr) error {
+	keys = sortedKeys(keys)
+	f, err := os.CreateTemp(s.dir, "uip6-run-*")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.runs = append(s.runs, f.Name())
+	s.mu.Unlock()
+
+	bw := bufio.NewWriterSize(f, 1<<20)
+	var buf [16]byte
+	for _, a := range keys {
+		binary.BigEndian.PutUint64(buf[:8], a.Hi)
+		binary.BigEndian.PutUint64(buf[8:], a.Lo)
+		if _, err = bw.Write(buf[:]); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Count Merges the runs and the buffered addresses into the distinct count, the runs are removed.
+// Call once every Add returned.
+func (s *Spill) Count() (uint64, error) {
+	defer s.Close()
+
+	var rest []Addr
+	for i := range s.buffers {
+		rest = append(rest, s.buffers[i].keys...)
+		s.buffers[i].keys = nil
+	}
+	h := mergeHeap{&sliceCursor{keys: sortedKeys(rest)}}
+	for _, name := range s.runs {
+		f, err := os.Open(name)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		h = append(h, &runCursor{r: bufio.NewReaderSize(f, 1<<16)})
+	}
+
+	// advance every cursor to its first key
+	live := h[:0]
+	for _, c := range h {
+		ok, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			live = append(live, c)
+		}
+	}
+	h = live
+	heap.Init(&h)
+
+	var (
+		n    uint64
+		last Addr
+	)
+	for len(h) > 0 {
+		c := h[0]
+		if a := c.head(); n == 0 || a != last {
+			n++
+			last = a
+		}
+		ok, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	return n, nil
+}
+
+// Close Removes the runs written so far.
+func (s *Spill) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, name := range s.runs {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	s.runs = nil
+
+	return errors.Join(errs...)
+}
+
+func sortedKeys(keys []Addr) []Addr {
+	slices.SortFunc(keys, compareAddr)
+	return slices.Compact(keys)
+}
+
+func compareAddr(a, b Addr) int { return cmp.Or(cmp.Compare(a.Hi, b.Hi), cmp.Compare(a.Lo, b.Lo)) }
+
+type (
+	// cursor The sorted keys of a run or of the buffers, head is valid after next returned true.
+	cursor interface {
+		next() (bool, error)
+		head() Addr
+	}
+	runCursor struct {
+		r   *bufio.Reader
+		cur Addr
+		buf [16]byte
+	}
+	sliceCursor struct {
+		keys []Addr
+		i    int
+	}
+	mergeHeap []cursor
+)
+
+func (c *runCursor) next() (bool, error) {
+	if _, err := io.ReadFull(c.r, c.buf[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	c.cur = Addr{binary.BigEndian.Uint64(c.buf[:8]), binary.BigEndian.Uint64(c.buf[8:])}
+
+	return true, nil
+}
+
+func (c *runCursor) head() Addr { return c.cur }
+
+func (c *sliceCursor) next() (bool, error) {
+	c.i++
+	return c.i <= len(c.keys), nil
+}
+
+func (c *sliceCursor) head() Addr { return c.keys[c.i-1] }
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Less(i, j int) bool { return compareAddr(h[i].head(), h[j].head()) < 0 }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)        { *h = append(*h, x.(cursor)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return c
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"unique-ip-counter/internal/file_processor"
+	"unique-ip-counter/internal/ipv4_bitset"
+	"unique-ip-counter/internal/remote_source"
+)
+
+// runKube "k8s -selector app=nginx -since=1h -format combined" - counts the unique addresses
+// of the logs of matching pods streamed through the Kubernetes API, no log shipping needed.
+func runKube(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		kubeconfig, kubeContext, namespace string
+		selector, container, format        string
+		sinceTime                          string
+		since                              time.Duration
+		allNamespaces, follow              bool
+		streams                            int
+	)
+	fs := newFlagSet("k8s")
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig file(default $KUBECONFIG, ~/.kube/config or the in-cluster service account)")
+	fs.StringVar(&kubeContext, "context", "", "kubeconfig context(default current-context)")
+	fs.StringVar(&namespace, "namespace", "", "namespace of the pods(default the namespace of the context)")
+	fs.BoolVar(&allNamespaces, "all-namespaces", false, "pods of all namespaces")
+	fs.StringVar(&selector, "selector", "", "label selector of the pods, e.g. app=nginx,tier!=db")
+	fs.StringVar(&container, "container", "", "only containers of this name(default all containers of the pods)")
+	fs.StringVar(&sinceTime, "since-time", "", "only log lines since this RFC3339 time")
+	fs.DurationVar(&since, "since", 0, "only log lines newer than this duration(alternative to -since-time)")
+	fs.BoolVar(&follow, "follow", false, "keep streaming new lines until interrupted")
+	fs.IntVar(&streams, "streams", 8, "log streams read at the same time")
+	fs.StringVar(&format, "format", "", "extract the address from log lines of this format: "+strings.Join(file_processor.Formats(), ", "))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !file_processor.IsFormat(format) || format == "w3c" || format == "iis" {
+		return fmt.Errorf("bad -format %q", format)
+	}
+	var opts remote_source.KubeLogOptions
+	switch {
+	case sinceTime != "" && since > 0:
+		return fmt.Errorf("-since-time and -since are exclusive")
+	case sinceTime != "":
+		var err error
+		if opts.Since, err = time.Parse(time.RFC3339, sinceTime); err != nil {
+			return fmt.Errorf("bad -since-time: %w", err)
+		}
+	case since > 0:
+		opts.Since = time.Now().Add(-since)
+	}
+	opts.Follow = follow
+
+	kube, err := remote_source.OpenKube(kubeconfig, kubeContext)
+	if err != nil {
+		return err
+	}
+	if namespace == "" && !allNamespaces {
+		namespace = kube.Namespace
+	}
+	containers, err := kube.Containers(ctx, namespace, selector, container)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers match selector %q in namespace %q", selector, namespace)
+	}
+	logger.Info("streaming pod logs", zap.Int("containers", len(containers)), zap.String("namespace", namespace), zap.String("selector", selector))
+
+	var (
+		bs      = ipv4_bitset.New()
+		invalid atomic.Uint64
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(streams, 1))
+	for _, c := range containers {
+		g.Go(func() error {
+			body, err := kube.Logs(gctx, c, opts)
+			if err != nil {
+				// a pod may be gone or not started yet, the others are still counted
+				logger.Warn("cannot stream log", zap.String("pod", c.Namespace+"/"+c.Pod), zap.String("container", c.Container), zap.Error(err))
+				return nil
+			}
+			defer body.Close()
+
+			fp := file_processor.New(logger, nil, bs, 1, file_processor.WithFormat(format))
+			err = fp.ProcessReader(gctx, body)
+			invalid.Add(fp.InvalidCount())
+			if err != nil && !errors.Is(err, context.Canceled) {
+				logger.Warn("log stream interrupted", zap.String("pod", c.Namespace+"/"+c.Pod), zap.Error(err))
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	logger.Info("pod logs counted", zap.Uint64("unique", bs.GetUniqueCount()), zap.Uint64("invalid", invalid.Load()))
+	fmt.Printf("unique ip's: %v\n", bs.GetUniqueCount())
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"unique-ip-counter/internal/cpu_dispatch"
+)
+
+type (
+	// runManifest Everything a run depends on(build, effective options, input identities, environment)
+	// next to its results, so a number can be re-derived and audited long after the run.
+	runManifest struct {
+		mu   sync.Mutex
+		path string
+
+		Tool     manifestTool      `json:"tool"`
+		Options  map[string]string `json:"options"`
+		Env      manifestEnv       `json:"env"`
+		Started  time.Time         `json:"started"`
+		Finished time.Time         `json:"finished"`
+		Inputs   []manifestInput   `json:"inputs"`
+		Error    string            `json:"error,omitempty"`
+	}
+	manifestTool struct {
+		Module    string `json:"module"`
+		Version   string `json:"version"`
+		Go        string `json:"go"`
+		Commit    string `json:"commit,omitempty"`
+		CommitAt  string `json:"commit_time,omitempty"`
+		Modified  bool   `json:"modified,omitempty"` // built from a dirty tree
+		BuildTags string `json:"build_tags,omitempty"`
+	}
+	manifestEnv struct {
+		OS          string            `json:"os"`
+		Arch        string            `json:"arch"`
+		CPUs        int               `json:"cpus"`
+		GOMAXPROCS  int               `json:"gomaxprocs"`
+		Host        string            `json:"host,omitempty"`
+		Vars        map[string]string `json:"vars,omitempty"` // runtime tuning variables that are set
+		CPUDispatch map[string]string `json:"cpu_dispatch"`
+	}
+	// manifestInput Local files are identified by size, modification time and content hash,
+	// remote sources by their path only.
+	manifestInput struct {
+		Path    string     `json:"path"`
+		Size    int64      `json:"size,omitempty"`
+		ModTime *time.Time `json:"mod_time,omitempty"`
+		SHA256  string     `json:"sha256,omitempty"`
+		Result  result     `json:"result"`
+	}
+)
+
+// manifestEnvVars Variables changing how the runtime behaves, recorded when set.
+var manifestEnvVars = []string{"GOGC", "GOMEMLIMIT", "GODEBUG", "GOMAXPROCS", "GOAMD64", "GOARM64"}
+
+// newRunManifest Captures the build, the effective value of every flag(defaults included) and the environment.
+func newRunManifest(path string, fs *flag.FlagSet) *runManifest {
+	m := &runManifest{
+		path:    path,
+		Options: make(map[string]string),
+		Started: time.Now().UTC(),
+		Env: manifestEnv{
+			OS:          runtime.GOOS,
+			Arch:        runtime.GOARCH,
+			CPUs:        runtime.NumCPU(),
+			GOMAXPROCS:  runtime.GOMAXPROCS(0),
+			CPUDispatch: cpu_dispatch.Selected(),
+		},
+		Tool: manifestTool{Go: runtime.Version(), Version: buildVersion()},
+	}
+	fs.VisitAll(func(f *flag.Flag) { m.Options[f.Name] = f.Value.String() })
+	m.Env.Host, _ = os.Hostname()
+	for _, name := range manifestEnvVars {
+		if v, ok := os.LookupEnv(name); ok {
+			if m.Env.Vars == nil {
+				m.Env.Vars = make(map[string]string)
+			}
+			m.Env.Vars[name] = v
+		}
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		m.Tool.Module = bi.Main.Path
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				m.Tool.Commit = s.Value
+			case "vcs.time":
+				m.Tool.CommitAt = s.Value
+			case "vcs.modified":
+				m.Tool.Modified = s.Value == "true"
+			case "-tags":
+				m.Tool.BuildTags = s.Value
+			}
+		}
+	}
+
+	return m
+}
+
+// add Records a completed input, a local file is hashed after counting(a second sequential read).
+func (m *runManifest) add(path string, s summary, err error) error {
+	in := manifestInput{Path: path, Result: newResult(s, err)}
+	if isLocal(path) {
+		f, ferr := os.Open(path)
+		if ferr != nil {
+			return ferr
+		}
+		defer f.Close()
+		fi, ferr := f.Stat()
+		if ferr != nil {
+			return ferr
+		}
+		h := sha256.New()
+		if _, ferr = io.Copy(h, f); ferr != nil {
+			return ferr
+		}
+		mt := fi.ModTime().UTC()
+		in.Size, in.ModTime, in.SHA256 = fi.Size(), &mt, hex.EncodeToString(h.Sum(nil))
+	}
+
+	m.mu.Lock()
+	m.Inputs = append(m.Inputs, in)
+	m.mu.Unlock()
+
+	return nil
+}
+
+// write Writes the manifest as indented JSON, runErr is the error the run ended with.
+func (m *runManifest) write(runErr error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Finished = time.Now().UTC()
+	if runErr != nil {
+		m.Error = runErr.Error()
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(m.path, append(b, '\n'), 0o644)
+}
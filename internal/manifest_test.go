@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunManifest(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	input := filepath.Join(dir, "ips.txt")
+	if err := os.WriteFile(input, []byte("1.1.1.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("th", 4, "")
+	fs.String("format", "", "")
+	if err := fs.Parse([]string{"-format=combined"}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "manifest.json")
+	m := newRunManifest(path, fs)
+	if err := m.add(input, summary{Path: input, Unique: 1}, nil); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := m.add("s3://bucket/ips.txt", summary{Path: "s3://bucket/ips.txt"}, errors.New("boom")); err != nil {
+		t.Fatalf("add remote: %v", err)
+	}
+	if err := m.write(nil); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got runManifest
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	// defaults are effective options too
+	if got.Options["th"] != "4" || got.Options["format"] != "combined" {
+		t.Fatalf("options %v", got.Options)
+	}
+	if got.Tool.Go == "" || got.Env.CPUs == 0 || got.Finished.Before(got.Started) {
+		t.Fatalf("tool %+v env %+v", got.Tool, got.Env)
+	}
+	if len(got.Inputs) != 2 {
+		t.Fatalf("inputs %d; want 2", len(got.Inputs))
+	}
+	// sha256("1.1.1.1\n")
+	local, remote := got.Inputs[0], got.Inputs[1]
+	if local.Size != 8 || local.ModTime == nil || local.SHA256 != "454debca5afcd37addd0dcca05094625dcde6402510f8fc664cd567893ec7096" || local.Result.Unique != 1 {
+		t.Fatalf("local input %+v", local)
+	}
+	if remote.SHA256 != "" || remote.Size != 0 || remote.Result.Error != "boom" {
+		t.Fatalf("remote input %+v", remote)
+	}
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"unique-ip-counter/internal/file_processor"
+)
+
+type (
+	// matrixWriter Streams the dimension matrix of every completed input for pivoting downstream:
+	// "path,row,col,unique" CSV rows(unions as "dimension=*" against itself) for a .csv path,
+	// one JSON record per input otherwise.
+	matrixWriter struct {
+		mu  sync.Mutex
+		f   *os.File
+		csv *csv.Writer
+		enc *json.Encoder
+	}
+	matrixRecord struct {
+		Path    string            `json:"path"`
+		Partial bool              `json:"partial,omitempty"`
+		Cells   []matrixCell      `json:"cells"`
+		Unions  map[string]uint64 `json:"unions,omitempty"`
+	}
+	matrixCell struct {
+		Row    string `json:"row"`
+		Col    string `json:"col"`
+		Unique uint64 `json:"unique"`
+	}
+)
+
+func newMatrixWriter(path string) (*matrixWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		return &matrixWriter{f: f, enc: json.NewEncoder(f)}, nil
+	}
+	w := &matrixWriter{f: f, csv: csv.NewWriter(f)}
+	if err = w.csv.Write([]string{"path", "row", "col", "unique"}); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// write The matrix of an input, partial(stopped early, saturated) inputs get their bucket counts only.
+func (w *matrixWriter) write(path string, m file_processor.DimensionMatrix, partial bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.enc != nil {
+		r := matrixRecord{Path: path, Partial: partial, Cells: make([]matrixCell, len(m.Cells)), Unions: m.Unions}
+		for i, c := range m.Cells {
+			r.Cells[i] = matrixCell{c.Row, c.Col, c.Unique}
+		}
+		return w.enc.Encode(r)
+	}
+
+	for _, c := range m.Cells {
+		_ = w.csv.Write([]string{path, c.Row, c.Col, strconv.FormatUint(c.Unique, 10)})
+	}
+	for _, name := range slices.Sorted(maps.Keys(m.Unions)) {
+		all := name + "=*"
+		_ = w.csv.Write([]string{path, all, all, strconv.FormatUint(m.Unions[name], 10)})
+	}
+	w.csv.Flush()
+
+	return w.csv.Error()
+}
+
+func (w *matrixWriter) Close() error { return w.f.Close() }
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"unique-ip-counter/internal/file_processor"
+)
+
+func TestMatrixWriter(t *testing.T) {
+	t.Parallel()
+	m := file_processor.DimensionMatrix{
+		Cells: []file_processor.MatrixCell{
+			{Row: "status=2xx", Col: "status=2xx", Unique: 3},
+			{Row: "status=2xx", Col: "status=5xx", Unique: 1},
+			{Row: "status=5xx", Col: "status=2xx", Unique: 1},
+			{Row: "status=5xx", Col: "status=5xx", Unique: 2},
+		},
+		Unions: map[string]uint64{"status": 4},
+	}
+	partial := file_processor.DimensionMatrix{Cells: []file_processor.MatrixCell{{Row: "status=2xx", Col: "status=2xx", Unique: 1}}}
+
+	cases := []struct {
+		name, want string
+	}{
+		{"matrix.csv", "path,row,col,unique\n" +
+			"a.log,status=2xx,status=2xx,3\na.log,status=2xx,status=5xx,1\na.log,status=5xx,status=2xx,1\na.log,status=5xx,status=5xx,2\n" +
+			"a.log,status=*,status=*,4\n" +
+			"b.log,status=2xx,status=2xx,1\n"},
+		{"matrix.json", `{"path":"a.log","cells":[{"row":"status=2xx","col":"status=2xx","unique":3},{"row":"status=2xx","col":"status=5xx","unique":1},` +
+			`{"row":"status=5xx","col":"status=2xx","unique":1},{"row":"status=5xx","col":"status=5xx","unique":2}],"unions":{"status":4}}` + "\n" +
+			`{"path":"b.log","partial":true,"cells":[{"row":"status=2xx","col":"status=2xx","unique":1}]}` + "\n"},
+	}
+	for _, tt := range cases {
+		path := filepath.Join(t.TempDir(), tt.name)
+		w, err := newMatrixWriter(path)
+		if err != nil {
+			t.Fatalf("newMatrixWriter: %v", err)
+		}
+		if err = w.write("a.log", m, false); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err = w.write("b.log", partial, true); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = w.Close()
+
+		if got, _ := os.ReadFile(path); string(got) != tt.want {
+			t.Fatalf("%s: %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
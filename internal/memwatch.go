@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// memoryPoll How often -memory-watermark samples the RSS, a shard allocation is 8 KB
+// so even a fast input grows by a few MB between samples.
+const memoryPoll = 100 * time.Millisecond
+
+// watchMemory Degrades bs to exact-so-far plus an approximate sketch once the RSS crosses the watermark,
+// until ctx is done.
+func (a *App) watchMemory(ctx context.Context, path string, bs *ipv4_bitset.Bitset) {
+	t := time.NewTicker(memoryPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			rss := residentBytes()
+			if rss < a.cfg.memoryWatermark || !bs.Degrade() {
+				continue
+			}
+			a.logger.Warn("memory watermark crossed, counting new /16 blocks approximately",
+				zap.String("path", path), zap.Uint64("rss", rss), zap.Uint64("watermark", a.cfg.memoryWatermark),
+				zap.Uint64("exact", bs.GetUniqueCount()), zap.Int64("bitset_bytes", bs.MemoryBytes()))
+			return
+		}
+	}
+}
+
+// residentBytes RSS of the process from /proc, the memory obtained by the Go runtime elsewhere.
+func residentBytes() uint64 {
+	if b, err := os.ReadFile("/proc/self/statm"); err == nil {
+		// size resident shared text lib data dt, in pages
+		if f := bytes.Fields(b); len(f) > 1 {
+			if pages, err := strconv.ParseUint(string(f[1]), 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	return ms.Sys - ms.HeapReleased
+}
+
+// byteSize Flag of a byte count: 1073741824, 512MB, 4GiB(K/M/G/T, decimal or binary).
+type byteSize uint64
+
+func (s *byteSize) String() string { return strconv.FormatUint(uint64(*s), 10) }
+
+func (s *byteSize) Set(v string) error {
+	n, err := parseByteSize(v)
+	if err != nil {
+		return err
+	}
+	*s = byteSize(n)
+
+	return nil
+}
+
+func parseByteSize(v string) (uint64, error) {
+	num := strings.TrimRight(v, "KMGTiBkmgtib")
+	unit := strings.ToUpper(v[len(num):])
+	mult := map[string]uint64{
+		"": 1, "B": 1,
+		"K": 1e3, "KB": 1e3, "KIB": 1 << 10,
+		"M": 1e6, "MB": 1e6, "MIB": 1 << 20,
+		"G": 1e9, "GB": 1e9, "GIB": 1 << 30,
+		"T": 1e12, "TB": 1e12, "TIB": 1 << 40,
+	}[unit]
+	if mult == 0 {
+		return 0, fmt.Errorf("bad unit %q: want K, M, G, T with an optional i and B", unit)
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(num), 10, 64)
+	if err != nil {
+		return 0, errors.New("want a whole number of bytes with an optional unit, e.g. 512MB or 4GiB")
+	}
+	if n > ^uint64(0)/mult {
+		return 0, errors.New("too large")
+	}
+
+	return n * mult, nil
+}
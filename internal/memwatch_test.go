@@ -0,0 +1,38 @@
+package internal
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		in   string
+		want uint64
+		ok   bool
+	}{
+		{"1024", 1024, true},
+		{"512MB", 512e6, true},
+		{"4GiB", 4 << 30, true},
+		{"4gib", 4 << 30, true},
+		{"2k", 2000, true},
+		{"1T", 1e12, true},
+		{"", 0, false},
+		{"GiB", 0, false},
+		{"1.5G", 0, false},
+		{"10XB", 0, false},
+		{"-1", 0, false},
+		{"20000000TiB", 0, false},
+	}
+	for _, tt := range cases {
+		got, err := parseByteSize(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Fatalf("parseByteSize(%q)=%d, %v; want %d, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestResidentBytes(t *testing.T) {
+	t.Parallel()
+	if n := residentBytes(); n < 1<<20 {
+		t.Fatalf("residentBytes=%d; want a running process size", n)
+	}
+}
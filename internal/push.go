@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/file_processor"
+	"unique-ip-counter/internal/ingest"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// runPush "push -f access.log -remote host:9090" - client of "serve grpc": the file is sharded and
+// parsed locally, its distinct addresses are streamed to the remote counter as parsed uint32s,
+// so many hosts feed one central set without shipping their logs.
+func runPush(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		path, remote, format, csvCol string
+		th, batch                    int
+	)
+	fs := newFlagSet("push")
+	fs.StringVar(&path, "f", "", "path to file")
+	fs.StringVar(&remote, "remote", "", "host:port of a \"serve grpc\" instance")
+	fs.IntVar(&th, "th", runtime.NumCPU(), "count of goroutines + shards")
+	fs.IntVar(&batch, "batch", 64<<10, "addresses per streamed message(4 bytes each, at most 1M)")
+	fs.StringVar(&format, "format", "", "extract the address from log lines of this format: "+strings.Join(file_processor.Formats(), ", "))
+	fs.StringVar(&csvCol, "csv-col", "", "push a field of comma separated rows: 1-based index or header name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if path == "" || remote == "" {
+		return fmt.Errorf("please provide -f and -remote")
+	}
+	if batch <= 0 || batch > 1<<20 {
+		return fmt.Errorf("bad -batch %d: want 1..%d", batch, 1<<20)
+	}
+	if !file_processor.IsFormat(format) {
+		return fmt.Errorf("bad -format %q: want one of %s", format, strings.Join(file_processor.Formats(), ", "))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// duplicates are dropped locally, only the distinct addresses cross the network
+	bs := ipv4_bitset.New()
+	fp := file_processor.New(logger, f, bs, th,
+		file_processor.WithFormat(format),
+		file_processor.WithCSVColumn(csvCol),
+	)
+	if fi.Mode().IsRegular() {
+		err = fp.ProcessFile(ctx, fi)
+	} else {
+		err = fp.ProcessReader(ctx, f)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("counted locally, pushing", zap.String("path", path), zap.Uint64("unique", bs.GetUniqueCount()),
+		zap.Uint64("invalid", fp.InvalidCount()), zap.String("remote", remote))
+
+	sum, err := ingest.PushGRPC(ctx, remote, bs.All(), batch)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("pushed %d unique ip's, %d new to the remote, remote unique ip's: %d\n", sum.Records, sum.Added, sum.Unique)
+
+	return nil
+}
@@ -0,0 +1,230 @@
+// Package query Read-only queries over the named snapshots of a daemon, for exploring historical sets:
+// nothing is ever written, ingested or pruned.
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"unique-ip-counter/internal/daemon"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// maxRefs Snapshots of one union.
+const maxRefs = 64
+
+var ErrBadQuery = errors.New("bad query")
+
+type (
+	// Server Loads the snapshots of a directory lazily, on their first query, and keeps the last
+	// maxLoaded of them in memory.
+	Server struct {
+		snaps     *daemon.Snapshots
+		maxLoaded int
+
+		mu     sync.Mutex
+		loaded map[string]*loaded // by name/id
+		clock  uint64
+	}
+	loaded struct {
+		ready chan struct{} // closed once bs or err is set
+		bs    *ipv4_bitset.Bitset
+		err   error
+		used  uint64 // Server.clock of the last use
+	}
+	// Count Unique addresses of one snapshot or of the union of several.
+	Count struct {
+		Snapshots []daemon.Snapshot `json:"snapshots"`
+		Unique    uint64            `json:"unique"`
+	}
+	// Contains Whether each address is in the snapshot.
+	Contains struct {
+		Snapshot daemon.Snapshot `json:"snapshot"`
+		Contains map[string]bool `json:"contains"`
+	}
+)
+
+func NewServer(snaps *daemon.Snapshots, maxLoaded int) *Server {
+	return &Server{snaps: snaps, maxLoaded: max(maxLoaded, 1), loaded: make(map[string]*loaded)}
+}
+
+// Handler HTTP API, GET only:
+//
+//	GET /snapshots?name=..                -> []Snapshot(every name without a name)
+//	GET /count?snapshot=name[/id]         -> Count(the newest snapshot of name without an id)
+//	GET /contains?snapshot=..&ip=..[&ip=..] -> Contains
+//	GET /union?snapshot=..&snapshot=..    -> Count of the union
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /snapshots", func(w http.ResponseWriter, r *http.Request) {
+		list, err := s.snaps.List(r.URL.Query().Get("name"))
+		if err != nil {
+			writeError(w, status(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	mux.HandleFunc("GET /count", func(w http.ResponseWriter, r *http.Request) {
+		refs := r.URL.Query()["snapshot"]
+		if len(refs) != 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: want one snapshot", ErrBadQuery))
+			return
+		}
+		s.writeCount(w, refs)
+	})
+	mux.HandleFunc("GET /union", func(w http.ResponseWriter, r *http.Request) {
+		refs := r.URL.Query()["snapshot"]
+		if len(refs) == 0 || len(refs) > maxRefs {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: want 1..%d snapshots", ErrBadQuery, maxRefs))
+			return
+		}
+		s.writeCount(w, refs)
+	})
+	mux.HandleFunc("GET /contains", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		ips := q["ip"]
+		if len(ips) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: want an ip", ErrBadQuery))
+			return
+		}
+		addrs := make([]netip.Addr, len(ips))
+		for i, ip := range ips {
+			a, err := netip.ParseAddr(ip)
+			if err != nil || !a.Unmap().Is4() {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("%w: ip %q is not an IPv4 address", ErrBadQuery, ip))
+				return
+			}
+			addrs[i] = a
+		}
+		sn, bs, err := s.get(q.Get("snapshot"))
+		if err != nil {
+			writeError(w, status(err), err)
+			return
+		}
+		out := Contains{Snapshot: sn, Contains: make(map[string]bool, len(ips))}
+		for i, ip := range ips {
+			out.Contains[ip] = bs.ContainsAddr(addrs[i])
+		}
+		writeJSON(w, http.StatusOK, out)
+	})
+
+	return mux
+}
+
+func (s *Server) writeCount(w http.ResponseWriter, refs []string) {
+	var (
+		out  = Count{Snapshots: make([]daemon.Snapshot, 0, len(refs))}
+		sets = make([]*ipv4_bitset.Bitset, 0, len(refs))
+	)
+	for _, ref := range refs {
+		sn, bs, err := s.get(ref)
+		if err != nil {
+			writeError(w, status(err), err)
+			return
+		}
+		out.Snapshots = append(out.Snapshots, sn)
+		sets = append(sets, bs)
+	}
+	out.Unique = ipv4_bitset.UnionCount(sets...)
+	writeJSON(w, http.StatusOK, out)
+}
+
+// get The snapshot of ref(name or name/id) and its set, loaded on the first use.
+func (s *Server) get(ref string) (daemon.Snapshot, *ipv4_bitset.Bitset, error) {
+	if ref == "" {
+		return daemon.Snapshot{}, nil, fmt.Errorf("%w: want a snapshot", ErrBadQuery)
+	}
+	name, id, _ := strings.Cut(ref, "/")
+	sn, err := s.snaps.Find(name, id)
+	if err != nil {
+		return sn, nil, err
+	}
+	key := sn.Name + "/" + sn.ID
+
+	s.mu.Lock()
+	s.clock++
+	l, ok := s.loaded[key]
+	if !ok {
+		l = &loaded{ready: make(chan struct{})}
+		s.loaded[key] = l
+		s.evict()
+	}
+	l.used = s.clock
+	s.mu.Unlock()
+
+	if !ok {
+		l.bs, l.err = s.snaps.Load(sn)
+		close(l.ready)
+		if l.err != nil {
+			// the next query tries again
+			s.mu.Lock()
+			if s.loaded[key] == l {
+				delete(s.loaded, key)
+			}
+			s.mu.Unlock()
+		}
+	}
+	<-l.ready
+
+	return sn, l.bs, l.err
+}
+
+// evict Forgets the least recently used sets above maxLoaded, sets still loading stay, s.mu held.
+func (s *Server) evict() {
+	for len(s.loaded) > s.maxLoaded {
+		var (
+			oldest string
+			used   uint64
+		)
+		for key, l := range s.loaded {
+			select {
+			case <-l.ready:
+			default:
+				continue
+			}
+			if oldest == "" || l.used < used {
+				oldest, used = key, l.used
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(s.loaded, oldest)
+	}
+}
+
+// Loaded Snapshots(name/id) in memory.
+func (s *Server) Loaded() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.loaded)
+}
+
+func status(err error) int {
+	switch {
+	case errors.Is(err, ErrBadQuery), errors.Is(err, daemon.ErrBadSnapshot):
+		return http.StatusBadRequest
+	case errors.Is(err, daemon.ErrNoSnapshot):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,121 @@
+package query
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"unique-ip-counter/internal/daemon"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writer, err := daemon.NewSnapshots(dir, daemon.Retention{})
+	if err != nil {
+		t.Fatalf("NewSnapshots: %v", err)
+	}
+	take := func(name string, ips ...uint32) daemon.Snapshot {
+		bs := ipv4_bitset.New()
+		for _, u := range ips {
+			if bs.SetIfNew(u) {
+				bs.AddUnique(1)
+			}
+		}
+		sn, err := writer.Take(name, bs)
+		if err != nil {
+			t.Fatalf("Take(%s): %v", name, err)
+		}
+		return sn
+	}
+	old := take("march", 0x0a000001, 0x0a000002)
+	take("march", 0x0a000001, 0x0a000002, 0x0a000003)
+	take("april", 0x0a000003, 0xc0a80001)
+
+	snaps, err := daemon.OpenSnapshots(dir)
+	if err != nil {
+		t.Fatalf("OpenSnapshots: %v", err)
+	}
+	s := NewServer(snaps, 2)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	get := func(path string, want int, out any) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("GET %s status=%d; want %d", path, resp.StatusCode, want)
+		}
+		if out != nil {
+			if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatalf("GET %s: %v", path, err)
+			}
+		}
+	}
+
+	if s.Loaded() != 0 {
+		t.Fatalf("loaded %d sets before any query; want lazy loading", s.Loaded())
+	}
+	var list []daemon.Snapshot
+	get("/snapshots", http.StatusOK, &list)
+	if len(list) != 3 {
+		t.Fatalf("GET /snapshots=%+v; want 3 snapshots", list)
+	}
+
+	for _, tc := range []struct {
+		path string
+		want uint64
+	}{
+		{"/count?snapshot=march", 3}, // the newest
+		{"/count?snapshot=march/" + old.ID, 2},
+		{"/union?snapshot=march&snapshot=april", 4},
+		{"/union?snapshot=march/" + old.ID + "&snapshot=april", 4},
+	} {
+		var c Count
+		get(tc.path, http.StatusOK, &c)
+		if c.Unique != tc.want {
+			t.Errorf("GET %s unique=%d; want %d", tc.path, c.Unique, tc.want)
+		}
+	}
+	if s.Loaded() != 2 {
+		t.Errorf("loaded %d sets; want at most 2", s.Loaded())
+	}
+
+	var c Contains
+	get("/contains?snapshot=april&ip=192.168.0.1&ip=10.0.0.1&ip=::ffff:10.0.0.3", http.StatusOK, &c)
+	if !c.Contains["192.168.0.1"] || c.Contains["10.0.0.1"] || !c.Contains["::ffff:10.0.0.3"] {
+		t.Errorf("GET /contains=%v; want 192.168.0.1 and ::ffff:10.0.0.3 only", c.Contains)
+	}
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/count?snapshot=may", http.StatusNotFound},
+		{"/count?snapshot=march/20200101T000000.000Z", http.StatusNotFound},
+		{"/count?snapshot=../march", http.StatusBadRequest},
+		{"/count", http.StatusBadRequest},
+		{"/count?snapshot=march&snapshot=april", http.StatusBadRequest},
+		{"/contains?snapshot=march", http.StatusBadRequest},
+		{"/contains?snapshot=march&ip=2001:db8::1", http.StatusBadRequest},
+		{"/union", http.StatusBadRequest},
+	} {
+		get(tc.path, tc.want, nil)
+	}
+
+	// nothing but queries
+	resp, err := http.Post(srv.URL+"/snapshots", "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST /snapshots: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /snapshots status=%d; want 405", resp.StatusCode)
+	}
+}
@@ -0,0 +1,90 @@
+package remote_source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// HTTPObject Remote http(s) file. Servers advertising "Accept-Ranges: bytes"
+// are read with ranged requests(ReadAt) to keep parallel sharding,
+// others only support a single sequential Stream.
+type HTTPObject struct {
+	ctx    context.Context // of OpenHTTP, cancels ReadAt requests and retries
+	client *http.Client
+	url    string
+	size   int64
+	ranged bool
+}
+
+// IsHTTP Reports whether path is an http(s) url.
+func IsHTTP(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// OpenHTTP Probes the url with a HEAD request for size and range support.
+// ctx also bounds the ReadAt requests of the object.
+func OpenHTTP(ctx context.Context, url string) (*HTTPObject, error) {
+	o := &HTTPObject{ctx: ctx, client: &http.Client{}, url: url}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	// some servers reject HEAD, a plain GET stream still works for them
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		return o, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HEAD %s: %s", resp.Request.URL.Redacted(), resp.Status)
+	}
+
+	o.size = resp.ContentLength
+	o.ranged = o.size > 0 && strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes")
+
+	return o, nil
+}
+
+func (o *HTTPObject) Size() int64 { return o.size }
+
+// Ranged Reports whether ReadAt can be used.
+func (o *HTTPObject) Ranged() bool { return o.ranged }
+
+// ReadAt Implements io.ReaderAt with a "Range: bytes=off-end" request, retried on transient failures.
+func (o *HTTPObject) ReadAt(p []byte, off int64) (int, error) {
+	return readRangeAt(o.ctx, p, off, o.size, func(rng string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(o.ctx, http.MethodGet, o.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", rng)
+
+		return o.client.Do(req)
+	})
+}
+
+// Stream Opens the whole body for sequential reading, the caller must close it.
+// Not retried: a failure in the middle of the body can not be resumed without ranges.
+func (o *HTTPObject) Stream(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", resp.Request.URL.Redacted(), resp.Status)
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,97 @@
+package remote_source
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPObject_Ranged(t *testing.T) {
+	t.Parallel()
+	data := []byte("1.1.1.1\n2.2.2.2\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "ips.txt", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	o, err := OpenHTTP(context.Background(), srv.URL+"/ips.txt")
+	if err != nil {
+		t.Fatalf("OpenHTTP error: %v", err)
+	}
+	if !o.Ranged() || o.Size() != int64(len(data)) {
+		t.Fatalf("Ranged=%v Size=%d; want true, %d", o.Ranged(), o.Size(), len(data))
+	}
+
+	got, err := io.ReadAll(io.NewSectionReader(o, 8, 8))
+	if err != nil {
+		t.Fatalf("read section: %v", err)
+	}
+	if !bytes.Equal(got, data[8:]) {
+		t.Fatalf("read %q; want %q", got, data[8:])
+	}
+}
+
+func TestHTTPObject_StreamFallback(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("1.1.1.1\n"))
+	}))
+	defer srv.Close()
+
+	o, err := OpenHTTP(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("OpenHTTP error: %v", err)
+	}
+	if o.Ranged() {
+		t.Fatalf("server without Accept-Ranges must not be ranged")
+	}
+
+	body, err := o.Stream(context.Background())
+	if err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	defer body.Close()
+	got, _ := io.ReadAll(body)
+	if string(got) != "1.1.1.1\n" {
+		t.Fatalf("stream body %q", got)
+	}
+}
+
+func TestHTTPObject_ReadAtRetries(t *testing.T) {
+	t.Parallel()
+	data := []byte("1.1.1.1\n2.2.2.2\n")
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && gets.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		http.ServeContent(w, r, "ips.txt", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o, err := OpenHTTP(ctx, srv.URL)
+	if err != nil {
+		t.Fatalf("OpenHTTP error: %v", err)
+	}
+	p := make([]byte, 8)
+	if n, err := o.ReadAt(p, 8); n != 8 || err != nil || !bytes.Equal(p, data[8:]) {
+		t.Fatalf("ReadAt => n=%d err=%v %q; want 8, nil", n, err, p)
+	}
+	if gets.Load() != 2 {
+		t.Fatalf("GETs=%d; want 2", gets.Load())
+	}
+
+	cancel()
+	if _, err = o.ReadAt(p, 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadAt after cancel err=%v; want context.Canceled", err)
+	}
+}
@@ -0,0 +1,316 @@
+package remote_source
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// in-cluster service account files
+const kubeServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+type (
+	// Kube Minimal Kubernetes API client: pod listing and log streaming over REST.
+	Kube struct {
+		client    *http.Client
+		server    string
+		token     string
+		Namespace string // of the kubeconfig context or the service account
+	}
+	// KubeContainer Container of a pod, the unit of a log stream.
+	KubeContainer struct {
+		Namespace, Pod, Container string
+	}
+	// KubeLogOptions Query of a log stream.
+	KubeLogOptions struct {
+		Since  time.Time // zero - the whole retained log
+		Follow bool      // keep streaming new lines until the context is canceled
+	}
+
+	kubeconfig struct {
+		CurrentContext string `yaml:"current-context"`
+		Contexts       []struct {
+			Name    string `yaml:"name"`
+			Context struct {
+				Cluster   string `yaml:"cluster"`
+				User      string `yaml:"user"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"context"`
+		} `yaml:"contexts"`
+		Clusters []struct {
+			Name    string `yaml:"name"`
+			Cluster struct {
+				Server                   string `yaml:"server"`
+				CertificateAuthority     string `yaml:"certificate-authority"`
+				CertificateAuthorityData string `yaml:"certificate-authority-data"`
+				InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			} `yaml:"cluster"`
+		} `yaml:"clusters"`
+		Users []struct {
+			Name string `yaml:"name"`
+			User struct {
+				Token                 string `yaml:"token"`
+				TokenFile             string `yaml:"tokenFile"`
+				ClientCertificate     string `yaml:"client-certificate"`
+				ClientCertificateData string `yaml:"client-certificate-data"`
+				ClientKey             string `yaml:"client-key"`
+				ClientKeyData         string `yaml:"client-key-data"`
+			} `yaml:"user"`
+		} `yaml:"users"`
+	}
+)
+
+// OpenKube Connects with the kubeconfig at path(empty - $KUBECONFIG, then ~/.kube/config)
+// and its context(empty - current-context), inside a pod without a kubeconfig the service account is used.
+// Exec/auth-provider plugins are not supported, use a token or a client certificate.
+func OpenKube(path, kubeContext string) (*Kube, error) {
+	if path == "" {
+		path = os.Getenv("KUBECONFIG")
+	}
+	if path == "" {
+		if host := os.Getenv("KUBERNETES_SERVICE_HOST"); host != "" {
+			return inClusterKube(host, os.Getenv("KUBERNETES_SERVICE_PORT"))
+		}
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, ".kube", "config")
+	}
+	// KUBECONFIG may be a list, the first file is used
+	path = filepath.SplitList(path)[0]
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg kubeconfig
+	if err = yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("kubeconfig %s: %w", path, err)
+	}
+
+	return cfg.client(filepath.Dir(path), kubeContext)
+}
+
+func (cfg *kubeconfig) client(dir, name string) (*Kube, error) {
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	k := &Kube{Namespace: "default"}
+	var cluster, user string
+	found := false
+	for _, c := range cfg.Contexts {
+		if c.Name == name {
+			cluster, user, found = c.Context.Cluster, c.Context.User, true
+			if c.Context.Namespace != "" {
+				k.Namespace = c.Context.Namespace
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kubeconfig: no context %q", name)
+	}
+
+	// relative file references are relative to the kubeconfig
+	file := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	found = false
+	for _, c := range cfg.Clusters {
+		if c.Name != cluster {
+			continue
+		}
+		found = true
+		k.server = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsCfg.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := dataOrFile(c.Cluster.CertificateAuthorityData, file(c.Cluster.CertificateAuthority))
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig cluster %s: %w", cluster, err)
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("kubeconfig cluster %s: bad certificate authority", cluster)
+			}
+			tlsCfg.RootCAs = pool
+		}
+	}
+	if !found || k.server == "" {
+		return nil, fmt.Errorf("kubeconfig: no server of cluster %q", cluster)
+	}
+
+	for _, u := range cfg.Users {
+		if u.Name != user {
+			continue
+		}
+		k.token = u.User.Token
+		if u.User.TokenFile != "" {
+			token, err := os.ReadFile(file(u.User.TokenFile))
+			if err != nil {
+				return nil, err
+			}
+			k.token = strings.TrimSpace(string(token))
+		}
+		cert, err := dataOrFile(u.User.ClientCertificateData, file(u.User.ClientCertificate))
+		if err != nil {
+			return nil, err
+		}
+		key, err := dataOrFile(u.User.ClientKeyData, file(u.User.ClientKey))
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("kubeconfig user %s: %w", user, err)
+			}
+			tlsCfg.Certificates = []tls.Certificate{pair}
+		}
+	}
+	k.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg, Proxy: http.ProxyFromEnvironment}}
+
+	return k, nil
+}
+
+func inClusterKube(host, port string) (*Kube, error) {
+	token, err := os.ReadFile(filepath.Join(kubeServiceAccount, "token"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(kubeServiceAccount, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("bad service account ca.crt")
+	}
+	k := &Kube{
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}},
+		server:    "https://" + net.JoinHostPort(host, cmp.Or(port, "443")),
+		token:     strings.TrimSpace(string(token)),
+		Namespace: "default",
+	}
+	if ns, err := os.ReadFile(filepath.Join(kubeServiceAccount, "namespace")); err == nil {
+		k.Namespace = strings.TrimSpace(string(ns))
+	}
+
+	return k, nil
+}
+
+// Containers Containers of the pods matching the label selector in namespace(empty - all namespaces),
+// container limits them to the containers of that name.
+func (k *Kube) Containers(ctx context.Context, namespace, selector, container string) ([]KubeContainer, error) {
+	path := "/api/v1/pods"
+	if namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods"
+	}
+	q := url.Values{}
+	if selector != "" {
+		q.Set("labelSelector", selector)
+	}
+	resp, err := k.get(ctx, path, q)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec struct {
+				Containers []struct {
+					Name string `json:"name"`
+				} `json:"containers"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("pod list: %w", err)
+	}
+
+	var out []KubeContainer
+	for _, p := range list.Items {
+		for _, c := range p.Spec.Containers {
+			if container == "" || c.Name == container {
+				out = append(out, KubeContainer{Namespace: p.Metadata.Namespace, Pod: p.Metadata.Name, Container: c.Name})
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// Logs Streams the log of a container, the caller must close it.
+func (k *Kube) Logs(ctx context.Context, c KubeContainer, opts KubeLogOptions) (io.ReadCloser, error) {
+	q := url.Values{"container": {c.Container}}
+	if !opts.Since.IsZero() {
+		q.Set("sinceTime", opts.Since.UTC().Format(time.RFC3339))
+	}
+	if opts.Follow {
+		q.Set("follow", "true")
+	}
+	resp, err := k.get(ctx, "/api/v1/namespaces/"+url.PathEscape(c.Namespace)+"/pods/"+url.PathEscape(c.Pod)+"/log", q)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (k *Kube) get(ctx context.Context, path string, q url.Values) (*http.Response, error) {
+	u := k.server + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
+
+// dataOrFile base64 kubeconfig "-data" value, otherwise the content of the file, nil when both are empty.
+func dataOrFile(data, path string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	return os.ReadFile(path)
+}
@@ -0,0 +1,105 @@
+package remote_source
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKube_ContainersAndLogs(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/web/pods":
+			if r.URL.Query().Get("labelSelector") != "app=nginx" {
+				http.Error(w, "selector", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"items":[
+				{"metadata":{"name":"nginx-1","namespace":"web"},"spec":{"containers":[{"name":"nginx"},{"name":"sidecar"}]}},
+				{"metadata":{"name":"nginx-2","namespace":"web"},"spec":{"containers":[{"name":"nginx"}]}}]}`)
+		case "/api/v1/namespaces/web/pods/nginx-1/log":
+			q := r.URL.Query()
+			if q.Get("container") != "nginx" || q.Get("sinceTime") != "2024-01-01T00:00:00Z" || q.Get("follow") != "" {
+				http.Error(w, "query "+q.Encode(), http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, "10.0.0.1 - - [...]\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// kubeconfig trusting the test server
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	path := filepath.Join(t.TempDir(), "config")
+	cfg := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+contexts:
+- name: test
+  context: {cluster: c1, user: u1, namespace: web}
+- name: other
+  context: {cluster: c1, user: u1}
+clusters:
+- name: c1
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+users:
+- name: u1
+  user: {token: secret}
+`, srv.URL, base64.StdEncoding.EncodeToString(ca))
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatalf("write kubeconfig: %v", err)
+	}
+
+	k, err := OpenKube(path, "")
+	if err != nil {
+		t.Fatalf("OpenKube: %v", err)
+	}
+	if k.Namespace != "web" {
+		t.Fatalf("Namespace=%q; want web", k.Namespace)
+	}
+	if other, err := OpenKube(path, "other"); err != nil || other.Namespace != "default" {
+		t.Fatalf("OpenKube(other) namespace=%v, %v; want default", other, err)
+	}
+	if _, err = OpenKube(path, "missing"); err == nil {
+		t.Fatalf("OpenKube(missing context): want error")
+	}
+
+	ctx := context.Background()
+	cs, err := k.Containers(ctx, "web", "app=nginx", "nginx")
+	if err != nil {
+		t.Fatalf("Containers: %v", err)
+	}
+	if len(cs) != 2 || cs[0] != (KubeContainer{Namespace: "web", Pod: "nginx-1", Container: "nginx"}) {
+		t.Fatalf("Containers=%+v; want the 2 nginx containers", cs)
+	}
+
+	body, err := k.Logs(ctx, cs[0], KubeLogOptions{Since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("Logs: %v", err)
+	}
+	defer body.Close()
+	if data, _ := io.ReadAll(body); string(data) != "10.0.0.1 - - [...]\n" {
+		t.Fatalf("log=%q", data)
+	}
+
+	if _, err = k.Logs(ctx, cs[1], KubeLogOptions{}); err == nil {
+		t.Fatalf("Logs of a missing pod: want error")
+	}
+}
@@ -0,0 +1,65 @@
+package remote_source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// rangeAttempts Tries of a ranged GET: transport errors, 5xx responses and short bodies are retried
+// with a backoff doubling from rangeBackoff.
+const rangeAttempts = 4
+
+var rangeBackoff = 200 * time.Millisecond
+
+// readRangeAt Shared io.ReaderAt logic of ranged sources:
+// requests "bytes=off-end" through get and fills p from the response body.
+func readRangeAt(ctx context.Context, p []byte, off, size int64, get func(rng string) (*http.Response, error)) (int, error) {
+	if off >= size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p)) - 1
+	if end >= size {
+		end = size - 1
+	}
+	rng := fmt.Sprintf("bytes=%d-%d", off, end)
+
+	var (
+		n     int
+		err   error
+		retry bool
+	)
+	for attempt := 0; ; attempt++ {
+		if n, retry, err = readRange(p[:end-off+1], rng, get); !retry || attempt == rangeAttempts-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(rangeBackoff << attempt):
+		}
+	}
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// readRange One ranged GET filling p, retry reports a transient failure.
+func readRange(p []byte, rng string, get func(rng string) (*http.Response, error)) (n int, retry bool, err error) {
+	resp, err := get(rng)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, resp.StatusCode >= 500, fmt.Errorf("ranged GET %s: %s", resp.Request.URL.Redacted(), resp.Status)
+	}
+
+	n, err = io.ReadFull(resp.Body, p)
+
+	return n, err != nil, err
+}
@@ -0,0 +1,302 @@
+package remote_source
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptySHA256 hex(sha256("")) - payload hash of bodyless GET/HEAD requests
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type (
+	// S3Object io.ReaderAt backed by ranged GET requests,
+	// so every shard of FileProcessor downloads only its own byte range.
+	S3Object struct {
+		ctx      context.Context // of OpenS3, cancels ReadAt requests and retries
+		client   *http.Client
+		endpoint *url.URL
+		creds    s3Credentials
+		region   string
+		size     int64
+	}
+	s3Credentials struct {
+		AccessKey, SecretKey, SessionToken string
+	}
+)
+
+// IsS3 Reports whether path is an "s3://bucket/key" url.
+func IsS3(path string) bool { return strings.HasPrefix(path, "s3://") }
+
+// OpenS3 Resolves credentials and region from the standard AWS env/profile chain
+// and fetches the object size with a HEAD request.
+// The key is taken verbatim(as the aws cli does), "?", "#" and "+" are part of it.
+// ctx also bounds the ReadAt requests of the object.
+func OpenS3(ctx context.Context, rawURL string) (*S3Object, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(rawURL, "s3://"), "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("bad s3 url %q: want s3://bucket/key", rawURL)
+	}
+
+	profile := envOr("AWS_PROFILE", "default")
+	creds, err := loadS3Credentials(profile)
+	if err != nil {
+		return nil, err
+	}
+	region := envOr("AWS_REGION", envOr("AWS_DEFAULT_REGION", awsConfigValue(profile, "region")))
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	o := &S3Object{
+		ctx:    ctx,
+		client: &http.Client{},
+		creds:  creds,
+		region: region,
+	}
+	// custom endpoint(minio, localstack) => path-style, otherwise virtual-hosted style
+	if ep := envOr("AWS_ENDPOINT_URL_S3", os.Getenv("AWS_ENDPOINT_URL")); ep != "" {
+		if o.endpoint, err = url.Parse(strings.TrimSuffix(ep, "/")); err != nil {
+			return nil, err
+		}
+		o.endpoint.RawPath = o.endpoint.EscapedPath() + "/" + s3EscapePath(bucket) + "/" + s3EscapePath(key)
+		o.endpoint.Path += "/" + bucket + "/" + key
+	} else {
+		o.endpoint = &url.URL{
+			Scheme:  "https",
+			Host:    bucket + ".s3." + region + ".amazonaws.com",
+			Path:    "/" + key,
+			RawPath: "/" + s3EscapePath(key),
+		}
+	}
+
+	if o.size, err = o.head(ctx); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (o *S3Object) Size() int64 { return o.size }
+
+func (o *S3Object) head(ctx context.Context) (int64, error) {
+	resp, err := o.do(ctx, http.MethodHead, "")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("s3 HEAD %s: %s", o.endpoint.Redacted(), resp.Status)
+	}
+
+	return resp.ContentLength, nil
+}
+
+// ReadAt Implements io.ReaderAt with a "Range: bytes=off-end" request, retried on transient failures.
+func (o *S3Object) ReadAt(p []byte, off int64) (int, error) {
+	return readRangeAt(o.ctx, p, off, o.size, func(rng string) (*http.Response, error) {
+		return o.do(o.ctx, http.MethodGet, rng)
+	})
+}
+
+func (o *S3Object) do(ctx context.Context, method, rng string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	if o.creds.AccessKey != "" {
+		signV4(req, o.creds, o.region, time.Now().UTC())
+	}
+
+	return o.client.Do(req)
+}
+
+// signV4 Signs req with AWS Signature Version 4 for the "s3" service.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signV4(req *http.Request, creds s3Credentials, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// canonical headers: host, range and every x-amz-*
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonReq := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonHeaders.String(),
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonReq))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, toSign)),
+	))
+}
+
+// s3EscapePath SigV4 URI encoding of an object key: every byte but the unreserved
+// A-Z a-z 0-9 - . _ ~ and the "/" separators becomes %XX.
+func s3EscapePath(key string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&15])
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// loadS3Credentials env variables first, then the shared credentials file,
+// no credentials at all means anonymous(unsigned) requests.
+func loadS3Credentials(profile string) (s3Credentials, error) {
+	if ak := os.Getenv("AWS_ACCESS_KEY_ID"); ak != "" {
+		return s3Credentials{
+			AccessKey:    ak,
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return s3Credentials{}, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	sec, err := readINISection(path, profile)
+	if errors.Is(err, os.ErrNotExist) {
+		return s3Credentials{}, nil
+	}
+	if err != nil {
+		return s3Credentials{}, err
+	}
+
+	return s3Credentials{
+		AccessKey:    sec["aws_access_key_id"],
+		SecretKey:    sec["aws_secret_access_key"],
+		SessionToken: sec["aws_session_token"],
+	}, nil
+}
+
+// awsConfigValue Reads a key of the profile from ~/.aws/config(AWS_CONFIG_FILE).
+func awsConfigValue(profile, key string) string {
+	path := os.Getenv("AWS_CONFIG_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		path = filepath.Join(home, ".aws", "config")
+	}
+	section := "profile " + profile
+	if profile == "default" {
+		section = "default"
+	}
+	sec, err := readINISection(path, section)
+	if err != nil {
+		return ""
+	}
+
+	return sec[key]
+}
+
+func readINISection(path, name string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		res = map[string]string{}
+		in  bool
+	)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			in = strings.TrimSpace(strings.Trim(line, "[]")) == name
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok && in {
+			res[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+
+	return res, sc.Err()
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return def
+}
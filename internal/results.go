@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+type (
+	// resultsWriter Streams one NDJSON record per completed input,
+	// every record is written as soon as its input finishes.
+	resultsWriter struct {
+		mu  sync.Mutex
+		f   *os.File
+		enc *json.Encoder
+	}
+	result struct {
+		Path      string  `json:"path"`
+		Unique    uint64  `json:"unique"`
+		Seconds   float64 `json:"seconds"`
+		Stopped   bool    `json:"stopped,omitempty"`
+		Saturated bool    `json:"saturated,omitempty"`
+		HoleBytes int64   `json:"hole_bytes,omitempty"`
+
+		Invalid        uint64   `json:"invalid,omitempty"`
+		InvalidSamples []string `json:"invalid_samples,omitempty"`
+		Skipped        uint64   `json:"skipped,omitempty"`
+		IPv4           uint64   `json:"ipv4,omitempty"`
+		IPv6           uint64   `json:"ipv6,omitempty"`
+		IPv6Prefixes   uint64   `json:"ipv6_prefixes,omitempty"`
+		IPv6PrefixLen  int      `json:"ipv6_prefix_len,omitempty"`
+		Octets         []int    `json:"distinct_octets,omitempty"`
+
+		Exactness    string     `json:"exactness,omitempty"` // "mixed" once part of the count is estimated, "approximate" once all of it
+		Approx       uint64     `json:"approx,omitempty"`
+		ApproxStdErr float64    `json:"approx_std_err,omitempty"`
+		StdErr       float64    `json:"std_err,omitempty"` // relative, of unique
+		CI95         *[2]uint64 `json:"ci95,omitempty"`    // 95% confidence interval of unique
+
+		Dimensions map[string]map[string]uint64 `json:"dimensions,omitempty"`
+
+		Enrich   map[string]any `json:"enrich,omitempty"`
+		Previous *historyDelta  `json:"previous,omitempty"`
+		Error    string         `json:"error,omitempty"`
+	}
+)
+
+func newResultsWriter(path string) (*resultsWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resultsWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// newResult The record of an input, err is the error it failed with.
+func newResult(s summary, err error) result {
+	r := result{Path: s.Path, Unique: s.Unique, Seconds: s.Seconds, Stopped: s.Stopped, Saturated: s.Saturated, HoleBytes: s.HoleBytes, Enrich: s.Enrich, Previous: s.Previous,
+		Invalid: s.Invalid, InvalidSamples: s.InvalidSamples, Skipped: s.Skipped, IPv4: s.IPv4, IPv6: s.IPv6,
+		IPv6Prefixes: s.IPv6Prefixes, IPv6PrefixLen: s.IPv6PrefixLen, Dimensions: s.Dimensions}
+	if s.Octets != ([4]int{}) {
+		r.Octets = s.Octets[:]
+	}
+	if s.Approx > 0 {
+		r.Exactness, r.Approx, r.ApproxStdErr = "mixed", s.Approx, s.ApproxStdErr
+		if s.Approx == s.Unique {
+			r.Exactness = "approximate"
+		}
+		r.StdErr, r.CI95 = s.StdErr, &[2]uint64{s.CILow, s.CIHigh}
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+func (w *resultsWriter) write(s summary, err error) error {
+	r := newResult(s, err)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.enc.Encode(r)
+}
+
+func (w *resultsWriter) Close() error { return w.f.Close() }
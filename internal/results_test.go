@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResultsWriter_NDJSON(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "results.ndjson")
+	w, err := newResultsWriter(path)
+	if err != nil {
+		t.Fatalf("newResultsWriter: %v", err)
+	}
+
+	if err = w.write(summary{Path: "a.txt", Unique: 2, Seconds: 0.5}, nil); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// the first record must be visible before the batch completes
+	got, _ := os.ReadFile(path)
+	if want := `{"path":"a.txt","unique":2,"seconds":0.5}` + "\n"; string(got) != want {
+		t.Fatalf("after first write %q; want %q", got, want)
+	}
+
+	if err = w.write(summary{Path: "b.txt"}, errors.New("boom")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = w.Close()
+
+	got, _ = os.ReadFile(path)
+	want := `{"path":"a.txt","unique":2,"seconds":0.5}` + "\n" + `{"path":"b.txt","unique":0,"seconds":0,"error":"boom"}` + "\n"
+	if string(got) != want {
+		t.Fatalf("results %q; want %q", got, want)
+	}
+}
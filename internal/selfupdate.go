@@ -0,0 +1,374 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/remote_source"
+)
+
+// Set at release build time:
+//
+//	go build -ldflags "-X unique-ip-counter/internal.version=v1.5.0 -X unique-ip-counter/internal.releaseURL=https://... -X unique-ip-counter/internal.releaseKey=<base64>"
+var (
+	version    string // overrides the module version of the build info
+	releaseURL string // default -release of self-update
+	releaseKey string // default -pubkey of self-update
+)
+
+// maxReleaseBinary Largest binary self-update downloads.
+const maxReleaseBinary = 512 << 20
+
+type (
+	// release Document of the release endpoint, assets are keyed by "GOOS/GOARCH",
+	// an asset url may be relative to the document.
+	release struct {
+		Version string                  `json:"version"`
+		Assets  map[string]releaseAsset `json:"assets"`
+	}
+	releaseAsset struct {
+		URL       string `json:"url"`
+		SHA256    string `json:"sha256"`
+		Signature string `json:"signature"` // base64 ed25519 signature of signedMessage
+	}
+)
+
+// runSelfUpdate "self-update -release url|path -pubkey key" - replaces the running binary with the release
+// of its platform once the signature of the release checks out. The release document may be a local file,
+// so air-gapped hosts update from a copied release directory.
+func runSelfUpdate(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		rel, key, target string
+		check, force     bool
+	)
+	fs := newFlagSet("self-update")
+	fs.StringVar(&rel, "release", releaseURL, "url or path of the release document(JSON)")
+	fs.StringVar(&key, "pubkey", releaseKey, "ed25519 public key of the releases: PEM file or base64 of the raw key")
+	fs.StringVar(&target, "target", "", "binary to replace(default - the running one)")
+	fs.BoolVar(&check, "check", false, "only report whether an update is available")
+	fs.BoolVar(&force, "force", false, "install the release even if it is not newer(downgrades, development builds)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if rel == "" || key == "" {
+		return fmt.Errorf("please provide -release and -pubkey")
+	}
+	pub, err := parsePublicKey(key)
+	if err != nil {
+		return fmt.Errorf("bad -pubkey: %w", err)
+	}
+	if target == "" {
+		if target, err = os.Executable(); err != nil {
+			return err
+		}
+	}
+	if target, err = filepath.EvalSymlinks(target); err != nil {
+		return err
+	}
+
+	r, err := fetchRelease(ctx, rel)
+	if err != nil {
+		return err
+	}
+	current := buildVersion()
+	newer, known := versionNewer(r.Version, current)
+	if !newer && !force {
+		if !known {
+			return fmt.Errorf("cannot compare release %s with the running version %q, use -force", r.Version, current)
+		}
+		fmt.Printf("up to date: %s(release %s)\n", current, r.Version)
+		return nil
+	}
+	if check {
+		fmt.Printf("update available: %s -> %s\n", current, r.Version)
+		return nil
+	}
+
+	bin, err := fetchAsset(ctx, rel, r, pub)
+	if err != nil {
+		return err
+	}
+	if err = replaceBinary(target, bin); err != nil {
+		return err
+	}
+	logger.Info("binary replaced", zap.String("path", target), zap.String("from", current), zap.String("to", r.Version))
+	fmt.Printf("updated %s: %s -> %s\n", target, current, r.Version)
+
+	return nil
+}
+
+// buildVersion Version of the running binary: the release one or the module version of the build info.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		return bi.Main.Version
+	}
+
+	return ""
+}
+
+// signedMessage What a release signature covers: the version and platform bind the digest,
+// so a signed binary cannot be replayed as another version or platform.
+func signedMessage(version, platform string, digest []byte) []byte {
+	return fmt.Appendf(nil, "uip_counter %s %s %x", version, platform, digest)
+}
+
+// fetchRelease Reads the release document of loc.
+func fetchRelease(ctx context.Context, loc string) (release, error) {
+	var r release
+	b, err := readLocation(ctx, loc, 1<<20)
+	if err != nil {
+		return r, fmt.Errorf("release: %w", err)
+	}
+	if err = json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("release %s: %w", loc, err)
+	}
+	if _, _, ok := parseVersion(r.Version); !ok {
+		return r, fmt.Errorf("release %s: bad version %q", loc, r.Version)
+	}
+
+	return r, nil
+}
+
+// fetchAsset Downloads the binary of the running platform and verifies its digest and signature.
+func fetchAsset(ctx context.Context, loc string, r release, pub ed25519.PublicKey) ([]byte, error) {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	a, ok := r.Assets[platform]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s binary", r.Version, platform)
+	}
+	sig, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("release %s: bad signature of %s", r.Version, platform)
+	}
+
+	bin, err := readLocation(ctx, resolveLocation(loc, a.URL), maxReleaseBinary)
+	if err != nil {
+		return nil, fmt.Errorf("binary: %w", err)
+	}
+	digest := sha256.Sum256(bin)
+	if a.SHA256 != "" && !strings.EqualFold(a.SHA256, hex.EncodeToString(digest[:])) {
+		return nil, fmt.Errorf("binary %s: sha256 mismatch", a.URL)
+	}
+	if !ed25519.Verify(pub, signedMessage(r.Version, platform, digest[:]), sig) {
+		return nil, fmt.Errorf("binary %s: signature verification failed", a.URL)
+	}
+
+	return bin, nil
+}
+
+// replaceBinary Writes bin next to path and renames it over path, the binary is either the old or the new one.
+func replaceBinary(path string, bin []byte) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+
+	if _, err = tmp.Write(bin); err == nil {
+		err = tmp.Chmod(fi.Mode().Perm())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// readLocation Reads an http(s) url or a local path, at most limit bytes.
+func readLocation(ctx context.Context, loc string, limit int64) ([]byte, error) {
+	var rd io.Reader
+	if remote_source.IsHTTP(loc) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", resp.Request.URL.Redacted(), resp.Status)
+		}
+		rd = resp.Body
+	} else {
+		f, err := os.Open(loc)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		rd = f
+	}
+
+	b, err := io.ReadAll(io.LimitReader(rd, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", loc, limit)
+	}
+
+	return b, nil
+}
+
+// resolveLocation ref relative to the release document base.
+func resolveLocation(base, ref string) string {
+	if remote_source.IsHTTP(ref) || filepath.IsAbs(ref) {
+		return ref
+	}
+	if remote_source.IsHTTP(base) {
+		if u, err := url.Parse(base); err == nil {
+			if r, err := url.Parse(ref); err == nil {
+				return u.ResolveReference(r).String()
+			}
+		}
+		return ref
+	}
+
+	return filepath.Join(filepath.Dir(base), ref)
+}
+
+// parsePublicKey A PEM file("PUBLIC KEY", as written by openssl) or the base64 of the raw 32 byte key.
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	if b, err := os.ReadFile(s); err == nil {
+		blk, _ := pem.Decode(b)
+		if blk == nil {
+			return nil, errors.New("no PEM block")
+		}
+		k, err := x509.ParsePKIXPublicKey(blk.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := k.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%T is not an ed25519 key", k)
+		}
+		return pub, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, errors.New("want a PEM file or the base64 of a 32 byte key")
+	}
+
+	return b, nil
+}
+
+// versionNewer Reports whether release is newer than current, known=false when current is not
+// a release version("(devel)", empty).
+func versionNewer(release, current string) (newer, known bool) {
+	rn, rpre, _ := parseVersion(release)
+	cn, cpre, ok := parseVersion(current)
+	if !ok {
+		return false, false
+	}
+	for i := range rn {
+		if rn[i] != cn[i] {
+			return rn[i] > cn[i], true
+		}
+	}
+	// v1.2.0-rc.1 < v1.2.0
+	switch {
+	case rpre == cpre:
+		return false, true
+	case rpre == "":
+		return true, true
+	case cpre == "":
+		return false, true
+	}
+
+	return comparePrerelease(rpre, cpre) > 0, true
+}
+
+// comparePrerelease Orders two pre-releases by semver §11: identifier by identifier, numeric ones
+// numerically and below alphanumeric ones, alphanumeric ones in ASCII order, a prefix first.
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range min(len(as), len(bs)) {
+		x, y := as[i], bs[i]
+		xNum, yNum := isNumeric(x), isNumeric(y)
+		switch {
+		case xNum && yNum:
+			// no leading zeros: the longer number is the larger one
+			if c := cmp.Or(cmp.Compare(len(x), len(y)), strings.Compare(x, y)); c != 0 {
+				return c
+			}
+		case xNum != yNum:
+			if xNum {
+				return -1
+			}
+			return 1
+		default:
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return cmp.Compare(len(as), len(bs))
+}
+
+func isNumeric(id string) bool {
+	for i := 0; i < len(id); i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+	}
+
+	return id != ""
+}
+
+// parseVersion "v1.2.3[-pre][+build]" into its numbers and pre-release.
+func parseVersion(v string) (nums [3]int, pre string, ok bool) {
+	v, found := strings.CutPrefix(v, "v")
+	if !found {
+		return nums, "", false
+	}
+	v, _, _ = strings.Cut(v, "+")
+	v, pre, _ = strings.Cut(v, "-")
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return nums, "", false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nums, "", false
+		}
+		nums[i] = n
+	}
+
+	return nums, pre, true
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestSelfUpdate(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	bin := []byte("#!/bin/sh\necho v9.0.0\n")
+	digest := sha256.Sum256(bin)
+	sign := func(v string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, signedMessage(v, platform, digest[:])))
+	}
+
+	var doc release
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest.json", func(w http.ResponseWriter, _ *http.Request) { _ = json.NewEncoder(w).Encode(doc) })
+	mux.HandleFunc("/bin/uip_counter", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write(bin) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	target := filepath.Join(t.TempDir(), "uip_counter")
+	if err = os.WriteFile(target, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) error {
+		args = append([]string{"-release", srv.URL + "/latest.json", "-pubkey", base64.StdEncoding.EncodeToString(pub), "-target", target, "-force"}, args...)
+		return runSelfUpdate(context.Background(), zap.NewNop(), args)
+	}
+
+	// signed for another version: a replayed binary
+	doc = release{Version: "v9.0.0", Assets: map[string]releaseAsset{platform: {URL: "bin/uip_counter", Signature: sign("v8.0.0")}}}
+	if err = run(); err == nil {
+		t.Fatalf("expected signature error")
+	}
+	if b, _ := os.ReadFile(target); string(b) != "old" {
+		t.Fatalf("target replaced by an unverified binary")
+	}
+
+	doc.Assets[platform] = releaseAsset{URL: "bin/uip_counter", Signature: sign("v9.0.0")}
+	if err = run(); err != nil {
+		t.Fatalf("self-update: %v", err)
+	}
+	b, _ := os.ReadFile(target)
+	fi, _ := os.Stat(target)
+	if string(b) != string(bin) || fi.Mode().Perm() != 0o755 {
+		t.Fatalf("target %q %v; want the release binary, 0755", b, fi.Mode().Perm())
+	}
+	if left, _ := filepath.Glob(filepath.Join(filepath.Dir(target), ".*update*")); len(left) > 0 {
+		t.Fatalf("temporary files left: %v", left)
+	}
+}
+
+func TestVersionNewer(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		release, current string
+		newer, known     bool
+	}{
+		{"v1.2.3", "v1.2.2", true, true},
+		{"v1.10.0", "v1.9.9", true, true},
+		{"v1.2.3", "v1.2.3", false, true},
+		{"v1.2.2", "v1.2.3", false, true},
+		{"v1.2.3", "v1.2.3-rc.1", true, true},
+		{"v1.2.3-rc.2", "v1.2.3-rc.1", true, true},
+		{"v1.2.3-rc.1", "v1.2.3", false, true},
+		// pre-releases by semver §11
+		{"v1.2.3-rc.10", "v1.2.3-rc.9", true, true},
+		{"v1.2.3-rc.9", "v1.2.3-rc.10", false, true},
+		{"v1.2.3-beta", "v1.2.3-alpha", true, true},
+		{"v1.2.3-alpha", "v1.2.3-beta", false, true},
+		{"v1.2.3-alpha.1", "v1.2.3-alpha", true, true},
+		{"v1.2.3-alpha.beta", "v1.2.3-alpha.1", true, true},
+		{"v1.2.3-beta.11", "v1.2.3-beta.2", true, true},
+		{"v1.2.3-rc.1", "v1.2.3-beta.11", true, true},
+		{"v1.2.3-1", "v1.2.3-alpha", false, true},
+		{"v1.2.3-rc.1+build.5", "v1.2.3-rc.1", false, true},
+		{"v1.2.3", "(devel)", false, false},
+		{"v1.2.3", "", false, false},
+	}
+	for _, tt := range cases {
+		newer, known := versionNewer(tt.release, tt.current)
+		if newer != tt.newer || known != tt.known {
+			t.Fatalf("versionNewer(%q, %q) = %v, %v; want %v, %v", tt.release, tt.current, newer, known, tt.newer, tt.known)
+		}
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	t.Parallel()
+	cases := []struct{ base, ref, want string }{
+		{"https://r.example/uip/latest.json", "bin/uip", "https://r.example/uip/bin/uip"},
+		{"https://r.example/uip/latest.json", "https://cdn.example/uip", "https://cdn.example/uip"},
+		{"/media/usb/release/latest.json", "uip_counter-linux-amd64", "/media/usb/release/uip_counter-linux-amd64"},
+	}
+	for _, tt := range cases {
+		if got := resolveLocation(tt.base, tt.ref); got != tt.want {
+			t.Fatalf("resolveLocation(%q, %q) = %q; want %q", tt.base, tt.ref, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/daemon"
+	"unique-ip-counter/internal/ingest"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// runServe "serve <source>" - long-running ingestion modes.
+func runServe(ctx context.Context, logger *zap.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: serve <kafka|nats|syslog|tcp|grpc|daemon> [flags]")
+	}
+
+	switch args[0] {
+	case "kafka":
+		return serveKafka(ctx, logger, args[1:])
+	case "nats":
+		return serveNATS(ctx, logger, args[1:])
+	case "syslog":
+		return serveSyslog(ctx, logger, args[1:])
+	case "tcp":
+		return serveTCP(ctx, logger, args[1:])
+	case "grpc":
+		return serveGRPC(ctx, logger, args[1:])
+	case "daemon":
+		return serveDaemon(ctx, logger, args[1:])
+	default:
+		return fmt.Errorf("unknown serve source %q", args[0])
+	}
+}
+
+func serveKafka(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		cfg     ingest.KafkaConfig
+		brokers string
+	)
+	fs := newFlagSet("serve kafka")
+	fs.StringVar(&brokers, "brokers", "localhost:9092", "comma separated kafka brokers")
+	fs.StringVar(&cfg.Topic, "topic", "", "topic to consume")
+	fs.StringVar(&cfg.GroupID, "group", "uip-counter", "consumer group id")
+	fs.StringVar(&cfg.Field, "field", "", "JSON field with the address(empty - the whole message is the address)")
+	fs.StringVar(&cfg.StatePath, "state", "", "bitset snapshot file, loaded on start and saved before every offset commit")
+	fs.DurationVar(&cfg.ReportInterval, "report-interval", 10*time.Second, "how often the unique count is reported, persisted and offsets committed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.Topic == "" {
+		return fmt.Errorf("please provide -topic")
+	}
+	if cfg.ReportInterval <= 0 {
+		return fmt.Errorf("bad -report-interval %v: want a positive duration", cfg.ReportInterval)
+	}
+	cfg.Brokers = strings.Split(brokers, ",")
+
+	bs := ipv4_bitset.New()
+	if cfg.StatePath != "" {
+		if err := ingest.LoadState(cfg.StatePath, bs); err != nil {
+			return fmt.Errorf("load state: %w", err)
+		}
+		logger.Info("state loaded", zap.String("path", cfg.StatePath), zap.Uint64("unique", bs.GetUniqueCount()))
+	}
+
+	logger.Info("consuming kafka", zap.Strings("brokers", cfg.Brokers), zap.String("topic", cfg.Topic))
+	sink := ingest.NewSink(bs)
+	if err := ingest.ConsumeKafka(ctx, logger, cfg, sink); err != nil {
+		return err
+	}
+	fmt.Printf("unique ip's: %v\n", sink.Unique())
+
+	return nil
+}
+
+func serveNATS(ctx context.Context, logger *zap.Logger, args []string) error {
+	var cfg ingest.NATSConfig
+	fs := newFlagSet("serve nats")
+	fs.StringVar(&cfg.URL, "url", "nats://127.0.0.1:4222", "NATS server url")
+	fs.StringVar(&cfg.Subject, "subject", "", "subject to subscribe(filter subject with -stream)")
+	fs.StringVar(&cfg.Stream, "stream", "", "JetStream stream to consume with a durable consumer")
+	fs.StringVar(&cfg.Durable, "durable", "uip-counter", "durable consumer name")
+	fs.StringVar(&cfg.Field, "field", "", "JSON field with the address(empty - the whole message is the address)")
+	fs.StringVar(&cfg.StatePath, "state", "", "bitset snapshot file, loaded on start and saved every report")
+	fs.DurationVar(&cfg.ReportInterval, "report-interval", 10*time.Second, "how often the unique count is reported, persisted and acked")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.Subject == "" && cfg.Stream == "" {
+		return fmt.Errorf("please provide -subject or -stream")
+	}
+	if cfg.ReportInterval <= 0 {
+		return fmt.Errorf("bad -report-interval %v: want a positive duration", cfg.ReportInterval)
+	}
+
+	bs := ipv4_bitset.New()
+	if cfg.StatePath != "" {
+		if err := ingest.LoadState(cfg.StatePath, bs); err != nil {
+			return fmt.Errorf("load state: %w", err)
+		}
+		logger.Info("state loaded", zap.String("path", cfg.StatePath), zap.Uint64("unique", bs.GetUniqueCount()))
+	}
+
+	logger.Info("consuming nats", zap.String("url", cfg.URL), zap.String("subject", cfg.Subject), zap.String("stream", cfg.Stream))
+	sink := ingest.NewSink(bs)
+	if err := ingest.ConsumeNATS(ctx, logger, cfg, sink); err != nil {
+		return err
+	}
+	fmt.Printf("unique ip's: %v\n", sink.Unique())
+
+	return nil
+}
+
+func serveSyslog(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		cfg      ingest.SyslogConfig
+		httpAddr string
+	)
+	fs := newFlagSet("serve syslog")
+	fs.StringVar(&cfg.Addr, "addr", ":514", "UDP address to listen on")
+	fs.BoolVar(&cfg.FromSender, "from-sender", false, "count the datagram source address instead of the first IPv4 of the message")
+	fs.StringVar(&httpAddr, "http", "", "address of the live count endpoint(GET / -> JSON, POST /ingest), empty - disabled")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	sink := ingest.NewSink(ipv4_bitset.New())
+	if err := serveLiveCount(ctx, logger, httpAddr, sink); err != nil {
+		return err
+	}
+	if err := ingest.ServeSyslog(ctx, logger, cfg, sink); err != nil {
+		return err
+	}
+	fmt.Printf("unique ip's: %v\n", sink.Unique())
+
+	return nil
+}
+
+func serveTCP(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		cfg      ingest.TCPConfig
+		httpAddr string
+	)
+	fs := newFlagSet("serve tcp")
+	fs.StringVar(&cfg.Addr, "addr", ":9514", "TCP address to listen on")
+	fs.StringVar(&httpAddr, "http", "", "address of the live count endpoint(GET / -> JSON, POST /ingest), empty - disabled")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	sink := ingest.NewSink(ipv4_bitset.New())
+	if err := serveLiveCount(ctx, logger, httpAddr, sink); err != nil {
+		return err
+	}
+	if err := ingest.ServeTCP(ctx, logger, cfg, sink); err != nil {
+		return err
+	}
+	fmt.Printf("unique ip's: %v\n", sink.Unique())
+
+	return nil
+}
+
+func serveGRPC(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		cfg      ingest.GRPCConfig
+		httpAddr string
+	)
+	fs := newFlagSet("serve grpc")
+	fs.StringVar(&cfg.Addr, "addr", ":9090", "cleartext HTTP/2 address of the uipcounter.v1.Ingest service")
+	fs.StringVar(&httpAddr, "http", "", "address of the live count endpoint(GET / -> JSON, POST /ingest), empty - disabled")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	sink := ingest.NewSink(ipv4_bitset.New())
+	if err := serveLiveCount(ctx, logger, httpAddr, sink); err != nil {
+		return err
+	}
+	if err := ingest.ServeGRPC(ctx, logger, cfg, sink); err != nil {
+		return err
+	}
+	fmt.Printf("unique ip's: %v\n", sink.Unique())
+
+	return nil
+}
+
+// serveDaemon Shared counting service: jobs are submitted over HTTP and their sets
+// are kept per tag and day for trend reporting(see package daemon).
+func serveDaemon(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		addr, dir, snapDir string
+		root, tokenFile    string
+		th, workers, queue int
+		limits             daemon.Limits
+		retention          daemon.Retention
+	)
+	fs := newFlagSet("serve daemon")
+	fs.StringVar(&addr, "addr", "127.0.0.1:8080", "HTTP address of the API")
+	fs.StringVar(&root, "root", "", "directory the files of every job must resolve under, relative job paths are relative to it(required)")
+	fs.StringVar(&tokenFile, "admin-token-file", "", "file holding the bearer token of /admin/*(default - /admin/* is disabled)")
+	fs.StringVar(&dir, "dir", "uip-data", "directory of the per tag daily sets")
+	fs.StringVar(&snapDir, "snapshot-dir", "uip-snapshots", "directory of the named snapshots(POST /admin/snapshots)")
+	fs.IntVar(&retention.KeepLast, "snapshot-keep-last", 10, "keep the newest N snapshots of every name")
+	fs.IntVar(&retention.KeepDaily, "snapshot-keep-daily", 30, "keep the newest snapshot of each of the last N days of every name(both 0 - keep every snapshot)")
+	fs.IntVar(&workers, "workers", 2, "jobs counted at the same time")
+	fs.IntVar(&th, "th", runtime.NumCPU(), "count of goroutines + shards per job")
+	fs.IntVar(&queue, "queue", 64, "jobs waiting for a worker before new ones are rejected")
+	fs.IntVar(&limits.MaxFDs, "job-max-fds", 4, "input files a job keeps open(and counts) at the same time, 0 - unlimited")
+	fs.Int64Var(&limits.MaxMemory, "job-max-memory", 0, "fail a job once its unique set exceeds this many bytes(at most 512MB), 0 - unlimited")
+	fs.DurationVar(&limits.MaxDuration, "job-max-duration", time.Hour, "fail a job running longer than this, 0 - unlimited")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if root == "" {
+		return fmt.Errorf("-root is required: the directory job paths must resolve under")
+	}
+	// symlinks resolved, so are the files of a job before they are compared with it
+	root, err := filepath.Abs(root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return fmt.Errorf("-root: %w", err)
+	}
+	limits.Root = root
+	var token string
+	if tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return fmt.Errorf("-admin-token-file: %w", err)
+		}
+		if token = strings.TrimSpace(string(b)); token == "" {
+			return fmt.Errorf("-admin-token-file: %s is empty", tokenFile)
+		}
+	}
+
+	store, err := daemon.NewStore(dir)
+	if err != nil {
+		return err
+	}
+	if retention.KeepLast < 0 || retention.KeepDaily < 0 {
+		return fmt.Errorf("-snapshot-keep-last and -snapshot-keep-daily must not be negative")
+	}
+	snaps, err := daemon.NewSnapshots(snapDir, retention)
+	if err != nil {
+		return err
+	}
+	// snapshots left over by a run with a longer retention, then the days aging out
+	prune := func() error {
+		n, err := snaps.Prune()
+		if n > 0 {
+			logger.Info("snapshots pruned", zap.Int("removed", n), zap.Any("retention", retention))
+		}
+		return err
+	}
+	if err = prune(); err != nil {
+		return err
+	}
+	go func() {
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := prune(); err != nil {
+					logger.Error("cannot prune snapshots", zap.Error(err))
+				}
+			}
+		}
+	}()
+	runner := daemon.NewRunner(logger, store, th, queue, limits)
+	go runner.Run(ctx, workers)
+
+	srv := &http.Server{Addr: addr, Handler: daemon.Handler(runner, store, snaps, token)}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	logger.Info("daemon started", zap.String("addr", addr), zap.String("dir", dir), zap.String("root", root), zap.Bool("admin", token != ""), zap.Int("workers", workers), zap.Any("job_limits", limits))
+	if err = srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// serveLiveCount Makes the count of a listener queryable while it runs:
+// SIGUSR1 logs it and, when addr is set, an HTTP endpoint returns it as JSON
+// and merges newline delimited bodies POSTed to /ingest into the same sink.
+func serveLiveCount(ctx context.Context, logger *zap.Logger, addr string, sink *ingest.Sink) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sig:
+				logger.Info("live count", zap.Uint64("unique", sink.Unique()), zap.Uint64("records", sink.Records()), zap.Uint64("invalid", sink.Invalid()))
+			}
+		}
+	}()
+
+	if addr == "" {
+		return nil
+	}
+	srv := &http.Server{Addr: addr, Handler: ingest.LiveHandler(sink)}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("live count endpoint failed", zap.Error(err))
+		}
+	}()
+	logger.Info("live count endpoint started", zap.String("addr", addr))
+
+	return nil
+}
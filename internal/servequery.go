@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/daemon"
+	"unique-ip-counter/internal/query"
+)
+
+// runServeQuery "serve-query" - read-only HTTP queries over a snapshot directory of serve daemon,
+// without any ingestion paths.
+func runServeQuery(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		addr, dir string
+		maxLoaded int
+	)
+	fs := newFlagSet("serve-query")
+	fs.StringVar(&addr, "addr", ":8081", "HTTP address of the API")
+	fs.StringVar(&dir, "data", "uip-snapshots", "snapshot directory(-snapshot-dir of serve daemon)")
+	fs.IntVar(&maxLoaded, "max-loaded", 8, "snapshots kept in memory(up to 512MB each), the least recently queried are dropped")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if maxLoaded < 1 {
+		return fmt.Errorf("-max-loaded must be at least 1")
+	}
+	snaps, err := daemon.OpenSnapshots(dir)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Addr: addr, Handler: query.NewServer(snaps, maxLoaded).Handler()}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	logger.Info("query server started", zap.String("addr", addr), zap.String("data", dir), zap.Int("max_loaded", maxLoaded))
+	if err = srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/setops"
+)
+
+// runSet "set <union|intersect|diff> [-out=path] <snapshot>..." - combines snapshots of one key type
+// (UIPB/.pb IPv4 sets or UIP6 sets of 128-bit keys): union - keys of any, intersect - keys of every one,
+// diff - keys of the first that are in none of the others.
+func runSet(ctx context.Context, logger *zap.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: set <union|intersect|diff> [-out=path] <snapshot>...")
+	}
+	op := args[0]
+	if op != "union" && op != "intersect" && op != "diff" {
+		return fmt.Errorf("unknown set operation %q", op)
+	}
+	var out string
+	fs := newFlagSet("set " + op)
+	fs.StringVar(&out, "out", "", "write the resulting set to this snapshot(UIPB, .pb or UIP6 as the inputs)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) < 2 {
+		return fmt.Errorf("set %s needs at least two snapshots", op)
+	}
+
+	kind, err := setops.Sniff(paths[0])
+	if err != nil {
+		return err
+	}
+	for _, p := range paths[1:] {
+		k, err := setops.Sniff(p)
+		if err != nil {
+			return err
+		}
+		if k != kind {
+			return fmt.Errorf("%s is a %s snapshot, %s a %s one: sets of different keys cannot be combined", paths[0], kind, p, k)
+		}
+	}
+
+	var n uint64
+	switch kind {
+	case setops.KindIPv4:
+		n, err = combineSets(ctx, op, paths, out, func() setops.Counter[uint32] { return setops.NewIPv4() })
+	default:
+		n, err = combineSets(ctx, op, paths, out, func() setops.Counter[setops.Key128] { return setops.New128() })
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("sets combined", zap.String("op", op), zap.Strings("snapshots", paths), zap.String("kind", string(kind)))
+	fmt.Printf("unique keys: %v\n", n)
+
+	return nil
+}
+
+// combineSets Folds op over the snapshots at paths, returns the unique count of the result.
+func combineSets[K comparable](ctx context.Context, op string, paths []string, out string, newSet func() setops.Counter[K]) (uint64, error) {
+	acc := newSet()
+	if err := setops.Load(paths[0], acc); err != nil {
+		return 0, err
+	}
+	for _, p := range paths[1:] {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		next := newSet()
+		if err := setops.Load(p, next); err != nil {
+			return 0, err
+		}
+		if op == "union" {
+			setops.Union(acc, next)
+			continue
+		}
+		res := newSet()
+		if op == "intersect" {
+			setops.Intersect(res, acc, next)
+		} else {
+			setops.Diff(res, acc, next)
+		}
+		acc = res
+	}
+	if out != "" {
+		if err := setops.Save(out, acc); err != nil {
+			return 0, err
+		}
+	}
+
+	return acc.Len(), nil
+}
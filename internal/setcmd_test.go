@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/setops"
+)
+
+func Test_runSet(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	save := func(name string, keys ...string) string {
+		s := setops.New128()
+		for _, k := range keys {
+			s.Add(setops.FNV128a([]byte(k)))
+		}
+		path := filepath.Join(dir, name)
+		if err := setops.Save(path, setops.Counter[setops.Key128](s)); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		return path
+	}
+	a, b, c := save("a.uip6", "u1", "u2", "u3"), save("b.uip6", "u2", "u3", "u4"), save("c.uip6", "u3")
+	v4 := filepath.Join(dir, "v4.uipb")
+	if err := setops.Save(v4, setops.Counter[uint32](setops.NewIPv4())); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	for _, tc := range []struct {
+		op   string
+		in   []string
+		want uint64
+	}{
+		{"union", []string{a, b, c}, 4},
+		{"intersect", []string{a, b, c}, 1},
+		{"diff", []string{a, b}, 1},
+		{"diff", []string{b, a, c}, 1},
+	} {
+		out := filepath.Join(dir, "out.uip6")
+		args := append([]string{tc.op, "-out=" + out}, tc.in...)
+		if err := runSet(context.Background(), zap.NewNop(), args); err != nil {
+			t.Fatalf("set %s: %v", tc.op, err)
+		}
+		got := setops.New128()
+		if err := setops.Load(out, setops.Counter[setops.Key128](got)); err != nil || got.Len() != tc.want {
+			t.Errorf("set %s %v wrote %d keys(%v); want %d", tc.op, tc.in, got.Len(), err, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"union", a, v4}, "different keys"},
+		{[]string{"xor", a, b}, "unknown set operation"},
+		{[]string{"union", a}, "at least two"},
+	} {
+		if err := runSet(context.Background(), zap.NewNop(), tc.args); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("set %v err=%v; want %q", tc.args, err, tc.want)
+		}
+	}
+}
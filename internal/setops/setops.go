@@ -0,0 +1,121 @@
+// Package setops Union, intersection and difference of unique sets and their snapshots, defined over
+// the Counter abstraction so every key type gets the same tooling: IPv4 addresses in the bitset and
+// 128-bit keys(IPv6 addresses, hashed tokens) in the hash set.
+package setops
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"iter"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+	"unique-ip-counter/internal/ipv6_set"
+)
+
+type (
+	// Counter A unique set of keys K, its snapshot format is the one of WriteTo, ReadFrom merges one.
+	Counter[K comparable] interface {
+		// Add Reports whether k is new.
+		Add(k K) bool
+		Contains(k K) bool
+		Len() uint64
+		All() iter.Seq[K]
+		io.WriterTo
+		io.ReaderFrom
+	}
+	// IPv4 Counter of IPv4 addresses over the bitset.
+	IPv4 struct {
+		*ipv4_bitset.Bitset
+	}
+	// Key128 128-bit key: an IPv6 address or the Hash of a token.
+	Key128 = ipv6_set.Addr
+	// Hash Maps a token(a user id, a cookie, any key that is not an address) to a Key128. Sets of tokens
+	// can only be combined when they were built with the same Hash.
+	Hash func(tok []byte) Key128
+)
+
+var (
+	_ Counter[uint32] = (*IPv4)(nil)
+	_ Counter[Key128] = (*ipv6_set.Set)(nil)
+)
+
+func NewIPv4() *IPv4 { return &IPv4{ipv4_bitset.New()} }
+
+// New128 Counter of 128-bit keys.
+func New128() *ipv6_set.Set { return ipv6_set.New() }
+
+func (c *IPv4) Add(u uint32) bool {
+	if !c.SetIfNew(u) {
+		return false
+	}
+	c.AddUnique(1)
+
+	return true
+}
+
+func (c *IPv4) Len() uint64 { return c.GetUniqueCount() }
+
+// FNV128a Default Hash, stable across runs and machines so snapshots of tokens stay comparable.
+func FNV128a(tok []byte) Key128 {
+	h := fnv.New128a()
+	_, _ = h.Write(tok)
+	var sum [16]byte
+	h.Sum(sum[:0])
+
+	return Key128{Hi: binary.BigEndian.Uint64(sum[:8]), Lo: binary.BigEndian.Uint64(sum[8:])}
+}
+
+// Union Adds the keys of every set to dst.
+func Union[K comparable](dst Counter[K], sets ...Counter[K]) {
+	for _, s := range sets {
+		if d, x, _, ok := bitsets(dst, s, s); ok {
+			ipv4_bitset.Or(d, x)
+			continue
+		}
+		for k := range s.All() {
+			dst.Add(k)
+		}
+	}
+}
+
+// Intersect Adds the keys set in both a and b to dst.
+func Intersect[K comparable](dst, a, b Counter[K]) {
+	if d, x, y, ok := bitsets(dst, a, b); ok {
+		ipv4_bitset.And(d, x, y)
+		return
+	}
+	if b.Len() < a.Len() {
+		a, b = b, a
+	}
+	for k := range a.All() {
+		if b.Contains(k) {
+			dst.Add(k)
+		}
+	}
+}
+
+// Diff Adds the keys of a that are not set in b to dst.
+func Diff[K comparable](dst, a, b Counter[K]) {
+	if d, x, y, ok := bitsets(dst, a, b); ok {
+		ipv4_bitset.AndNot(d, x, y)
+		return
+	}
+	for k := range a.All() {
+		if !b.Contains(k) {
+			dst.Add(k)
+		}
+	}
+}
+
+// bitsets The bitsets of IPv4 counters, combined word by word instead of key by key.
+func bitsets[K comparable](dst, a, b Counter[K]) (d, x, y *ipv4_bitset.Bitset, ok bool) {
+	cd, ok1 := any(dst).(*IPv4)
+	ca, ok2 := any(a).(*IPv4)
+	cb, ok3 := any(b).(*IPv4)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, nil, nil, false
+	}
+
+	return cd.Bitset, ca.Bitset, cb.Bitset, true
+}
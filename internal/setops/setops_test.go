@@ -0,0 +1,116 @@
+package setops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testOps The same union/intersect/diff checks for every key type, key(i) must be distinct for distinct i.
+func testOps[K comparable](t *testing.T, newSet func() Counter[K], key func(i int) K) {
+	t.Helper()
+	a, b := newSet(), newSet()
+	for i := range 10 {
+		a.Add(key(i))
+	}
+	for i := 5; i < 20; i++ {
+		b.Add(key(i))
+	}
+
+	for _, tc := range []struct {
+		name  string
+		fn    func(dst Counter[K])
+		want  uint64
+		in    int
+		notIn int
+	}{
+		{"union", func(dst Counter[K]) { Union(dst, a, b) }, 20, 19, 20},
+		{"intersect", func(dst Counter[K]) { Intersect(dst, a, b) }, 5, 5, 4},
+		{"diff", func(dst Counter[K]) { Diff(dst, a, b) }, 5, 4, 5},
+		{"diff reversed", func(dst Counter[K]) { Diff(dst, b, a) }, 10, 19, 9},
+	} {
+		dst := newSet()
+		tc.fn(dst)
+		if dst.Len() != tc.want || !dst.Contains(key(tc.in)) || dst.Contains(key(tc.notIn)) {
+			t.Errorf("%s Len=%d contains(%d)=%v contains(%d)=%v; want %d, true, false", tc.name, dst.Len(),
+				tc.in, dst.Contains(key(tc.in)), tc.notIn, dst.Contains(key(tc.notIn)), tc.want)
+		}
+	}
+}
+
+func TestOps(t *testing.T) {
+	t.Parallel()
+	t.Run("ipv4", func(t *testing.T) {
+		testOps(t, func() Counter[uint32] { return NewIPv4() }, func(i int) uint32 { return uint32(i) << 12 })
+	})
+	t.Run("128", func(t *testing.T) {
+		testOps(t, func() Counter[Key128] { return New128() }, func(i int) Key128 { return Key128{Hi: uint64(i), Lo: 1} })
+	})
+	t.Run("tokens", func(t *testing.T) {
+		testOps(t, func() Counter[Key128] { return New128() }, func(i int) Key128 { return FNV128a([]byte{'u', byte(i)}) })
+	})
+}
+
+func TestSnapshots(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	v4 := NewIPv4()
+	v4.Add(0x01020304)
+	v6 := New128()
+	v6.Add(FNV128a([]byte("user-1")))
+	v6.Add(FNV128a([]byte("user-2")))
+
+	for _, tc := range []struct {
+		file string
+		save func(path string) error
+		kind Kind
+		load func(path string) (uint64, error)
+	}{
+		{"a.uipb", func(p string) error { return Save(p, Counter[uint32](v4)) }, KindIPv4, func(p string) (uint64, error) {
+			c := NewIPv4()
+			err := Load(p, Counter[uint32](c))
+			return c.Len(), err
+		}},
+		{"a.pb", func(p string) error { return Save(p, Counter[uint32](v4)) }, KindIPv4, func(p string) (uint64, error) {
+			c := NewIPv4()
+			err := Load(p, Counter[uint32](c))
+			return c.Len(), err
+		}},
+		{"b.uip6", func(p string) error { return Save(p, Counter[Key128](v6)) }, Kind128, func(p string) (uint64, error) {
+			c := New128()
+			err := Load(p, Counter[Key128](c))
+			return c.Len(), err
+		}},
+	} {
+		path := filepath.Join(dir, tc.file)
+		if err := tc.save(path); err != nil {
+			t.Fatalf("Save(%s): %v", tc.file, err)
+		}
+		if kind, err := Sniff(path); err != nil || kind != tc.kind {
+			t.Errorf("Sniff(%s)=%q,%v; want %q", tc.file, kind, err, tc.kind)
+		}
+		if n, err := tc.load(path); err != nil || n == 0 {
+			t.Errorf("Load(%s)=%d,%v; want the saved keys", tc.file, n, err)
+		}
+	}
+
+	if err := Load(filepath.Join(dir, "missing.uipb"), Counter[uint32](NewIPv4())); !os.IsNotExist(err) {
+		t.Errorf("Load(missing) err=%v; want not exist", err)
+	}
+	junk := filepath.Join(dir, "junk.txt")
+	_ = os.WriteFile(junk, []byte("1.2.3.4\n"), 0o600)
+	if _, err := Sniff(junk); err == nil {
+		t.Errorf("Sniff(text file) err=nil; want an error")
+	}
+}
+
+func TestFNV128a(t *testing.T) {
+	t.Parallel()
+	// stable across runs: the FNV-1a 128 of the empty input is its offset basis
+	if got := FNV128a(nil); got != (Key128{Hi: 0x6c62272e07bb0142, Lo: 0x62b821756295c58d}) {
+		t.Fatalf("FNV128a(nil)=%x; want the offset basis", got)
+	}
+	if FNV128a([]byte("a")) == FNV128a([]byte("b")) {
+		t.Fatalf("FNV128a collides on a and b")
+	}
+}
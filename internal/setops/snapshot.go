@@ -0,0 +1,80 @@
+package setops
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"unique-ip-counter/internal/ingest"
+)
+
+// Kind Key type of a snapshot.
+type Kind string
+
+const (
+	KindIPv4 Kind = "ipv4" // UIPB bitset snapshots and uipcounter.v1.Counter messages(.pb)
+	Kind128  Kind = "128"  // UIP6 sets of IPv6 addresses or hashed tokens
+)
+
+// Sniff The kind of the snapshot at path by its magic, any other ".pb" file is an IPv4 Counter message.
+func Sniff(path string) (Kind, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic, _ := bufio.NewReader(f).Peek(4)
+	switch {
+	case string(magic) == "UIPB":
+		return KindIPv4, nil
+	case string(magic) == "UIP6":
+		return Kind128, nil
+	case strings.EqualFold(filepath.Ext(path), ".pb"):
+		return KindIPv4, nil
+	}
+
+	return "", fmt.Errorf("%s: not a UIPB, UIP6 or .pb snapshot", path)
+}
+
+// Load Merges the snapshot at path into c, a missing file is an error.
+func Load[K comparable](path string, c Counter[K]) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	var err error
+	if v4, ok := any(c).(*IPv4); ok {
+		// UIPB or a Counter message
+		err = ingest.LoadState(path, v4.Bitset)
+	} else {
+		err = readSnapshot(path, c)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+func readSnapshot(path string, r io.ReaderFrom) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = r.ReadFrom(f)
+
+	return err
+}
+
+// Save Writes the snapshot of c to path atomically, IPv4 counters as a Counter message for a ".pb" path.
+func Save[K comparable](path string, c Counter[K]) error {
+	if v4, ok := any(c).(*IPv4); ok {
+		return ingest.SaveState(path, v4.Bitset)
+	}
+
+	return ingest.SaveSnapshot(path, c)
+}
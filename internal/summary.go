@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/template"
+
+	"unique-ip-counter/internal/file_processor"
+)
+
+// defaultSummaryTemplate Dual-stack inputs get the total split by family, inputs with invalid or skipped lines
+// their counts, degraded ones the estimated part and -sketch ones their error, -history ones the change against the previous run,
+// clean exact IPv4-only ones keep the plain line.
+const defaultSummaryTemplate = "unique ip's: {{.Unique}}{{if .IPv6}}(ipv4: {{.IPv4}}, ipv6: {{.IPv6}}" +
+	"{{if .IPv6Prefixes}}, ipv6 /{{.IPv6PrefixLen}}s: {{.IPv6Prefixes}}{{end}}){{end}}" +
+	"{{if .Approx}}{{if eq .Approx .Unique}}, estimated{{else}}, mixed exactness: ~{{.Approx}} estimated{{end}}" +
+	"(±{{printf \"%.2f\" .StdErrPercent}}%, 95% CI {{.CILow}}..{{.CIHigh}}){{end}}" +
+	"{{if .Invalid}}, invalid lines: {{.Invalid}}{{end}}{{if .Skipped}}, skipped lines: {{.Skipped}}{{end}}" +
+	"{{with .Previous}}, vs previous run: {{printf \"%+d\" .Delta}}({{printf \"%+.2f\" .DeltaPct}}%){{end}}, total time: {{.Seconds}} sec"
+
+// summary Fields available in -summary-template.
+type summary struct {
+	Path      string
+	Threads   int
+	Unique    uint64
+	Seconds   float64
+	Stopped   bool           // stopped early by -stop-after-uniques
+	Saturated bool           // stopped early, the saturation ceiling is reached
+	HoleBytes int64          // sparse file holes skipped without reading
+	Enrich    map[string]any // enricher name -> report
+	Previous  *historyDelta  // the count against the previous run of the series(-history)
+
+	Dimensions map[string]map[string]uint64 // dimension -> bucket -> unique(-status-col, -ua-col)
+
+	Invalid        uint64   // lines that are not an address
+	InvalidSamples []string // distinct examples of invalid lines(-invalid-samples)
+	Skipped        uint64   // lines skipped by -skip-prefix, lines without an address under -extract
+	IPv4           uint64   // distinct IPv4 addresses, included in Unique
+	IPv6           uint64   // distinct IPv6 addresses, included in Unique
+	IPv6Prefixes   uint64   // distinct IPv6 /IPv6PrefixLen prefixes(-ipv6-prefix), not included in Unique
+	IPv6PrefixLen  int
+	Octets         [4]int // distinct values of every IPv4 octet, a data quality check
+
+	Approx       uint64  // estimated part of Unique after -memory-watermark(all of it under -sketch), 0 - the count is exact
+	ApproxStdErr float64 // relative standard error of Approx
+	StdErr       float64 // relative standard error of Unique, set with Approx
+	CILow        uint64  // 95% confidence interval of Unique, set with Approx
+	CIHigh       uint64
+}
+
+// z95 Standard normal quantile of a two-sided 95% interval.
+const z95 = 1.96
+
+// setApprox Sets the estimated part of Unique and derives the error of the whole count from it:
+// the exact part has none, the interval never goes below it.
+func (s *summary) setApprox(approx uint64, stdErr float64) {
+	if approx == 0 {
+		return
+	}
+	sigma := float64(approx) * stdErr
+	s.Approx, s.ApproxStdErr = approx, stdErr
+	s.StdErr = sigma / float64(s.Unique)
+	s.CILow = s.Unique - approx + uint64(max(float64(approx)-z95*sigma, 0))
+	s.CIHigh = s.Unique + uint64(math.Ceil(z95*sigma))
+}
+
+// StdErrPercent StdErr in percent, for templates.
+func (s summary) StdErrPercent() float64 { return s.StdErr * 100 }
+
+// dimensionsMap Unique counts by dimension and bucket.
+func dimensionsMap(dims []file_processor.DimensionCount) map[string]map[string]uint64 {
+	m := make(map[string]map[string]uint64, len(dims))
+	for _, d := range dims {
+		m[d.Name] = make(map[string]uint64, len(d.Buckets))
+		for _, b := range d.Buckets {
+			m[d.Name][b.Value] = b.Unique
+		}
+	}
+
+	return m
+}
+
+func parseSummaryTemplate(text string) (*template.Template, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	return template.New("summary").Parse(text)
+}
+
+// write Executes tmpl, an estimated count is never printed bare: templates not printing its error
+// get it appended to their output.
+func (s summary) write(w io.Writer, tmpl *template.Template) error {
+	if s.Approx == 0 || printsError(tmpl) {
+		return tmpl.Execute(w, s)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, s); err != nil {
+		return err
+	}
+	out := strings.TrimSuffix(buf.String(), "\n")
+	_, err := fmt.Fprintf(w, "%s (estimate: ±%.2f%%, 95%% CI %d..%d)\n", out, s.StdErrPercent(), s.CILow, s.CIHigh)
+
+	return err
+}
+
+// printsError Reports whether tmpl refers to the error of an estimated count.
+func printsError(tmpl *template.Template) bool {
+	if tmpl.Tree == nil {
+		return false
+	}
+	text := tmpl.Tree.Root.String()
+
+	return strings.Contains(text, ".StdErr") || strings.Contains(text, ".CILow") || strings.Contains(text, ".CIHigh")
+}
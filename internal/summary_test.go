@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSummary_Templates(t *testing.T) {
+	t.Parallel()
+	s := summary{Path: "ips.txt", Threads: 4, Unique: 42, Seconds: 1.23456}
+
+	cases := []struct {
+		tmpl, want string
+	}{
+		{defaultSummaryTemplate, "unique ip's: 42, total time: 1.23456 sec\n"},
+		{"{{.Unique}}", "42\n"},
+		{`{{.Path}},{{.Unique}},{{printf "%.2f" .Seconds}}` + "\n", "ips.txt,42,1.23\n"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			t.Parallel()
+			tmpl, err := parseSummaryTemplate(tt.tmpl)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.tmpl, err)
+			}
+			var buf bytes.Buffer
+			if err = s.write(&buf, tmpl); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Fatalf("got %q; want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSummary_DualStackInvalid(t *testing.T) {
+	t.Parallel()
+	s := summary{Unique: 42, IPv4: 40, IPv6: 2, Invalid: 7, Seconds: 1.5}
+	tmpl, err := parseSummaryTemplate(defaultSummaryTemplate)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err = s.write(&buf, tmpl); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if want := "unique ip's: 42(ipv4: 40, ipv6: 2), invalid lines: 7, total time: 1.5 sec\n"; buf.String() != want {
+		t.Fatalf("got %q; want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	s.IPv6Prefixes, s.IPv6PrefixLen = 1, 64
+	if err = s.write(&buf, tmpl); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if want := "unique ip's: 42(ipv4: 40, ipv6: 2, ipv6 /64s: 1), invalid lines: 7, total time: 1.5 sec\n"; buf.String() != want {
+		t.Fatalf("got %q; want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	s.IPv6, s.IPv6Prefixes, s.Skipped = 0, 0, 3
+	if err = s.write(&buf, tmpl); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if want := "unique ip's: 42, invalid lines: 7, skipped lines: 3, total time: 1.5 sec\n"; buf.String() != want {
+		t.Fatalf("got %q; want %q", buf.String(), want)
+	}
+}
+
+func TestSummary_MixedExactness(t *testing.T) {
+	t.Parallel()
+	s := summary{Unique: 1000, Seconds: 2}
+	s.setApprox(600, 0.008)
+	if s.CILow != 990 || s.CIHigh != 1010 || s.StdErr != 0.0048 {
+		t.Fatalf("StdErr=%v CI=%d..%d; want 0.0048, 990..1010", s.StdErr, s.CILow, s.CIHigh)
+	}
+
+	cases := []struct {
+		tmpl, want string
+	}{
+		{defaultSummaryTemplate, "unique ip's: 1000, mixed exactness: ~600 estimated(±0.48%, 95% CI 990..1010), total time: 2 sec\n"},
+		// an estimate is never printed bare
+		{"{{.Unique}}", "1000 (estimate: ±0.48%, 95% CI 990..1010)\n"},
+		{"{{.Unique}} [{{.CILow}}, {{.CIHigh}}]", "1000 [990, 1010]\n"},
+	}
+	for _, tt := range cases {
+		tmpl, err := parseSummaryTemplate(tt.tmpl)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.tmpl, err)
+		}
+		var buf bytes.Buffer
+		if err = s.write(&buf, tmpl); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if buf.String() != tt.want {
+			t.Fatalf("got %q; want %q", buf.String(), tt.want)
+		}
+	}
+
+	if r := newResult(s, nil); r.Exactness != "mixed" || r.Approx != 600 || r.StdErr != 0.0048 || *r.CI95 != [2]uint64{990, 1010} {
+		t.Fatalf("result %+v; want mixed, 600, 0.0048, [990 1010]", r)
+	}
+	// -sketch hll, nothing is exact
+	s = summary{Unique: 1000, Seconds: 2}
+	s.setApprox(1000, 0.008)
+	tmpl, err := parseSummaryTemplate(defaultSummaryTemplate)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err = s.write(&buf, tmpl); err != nil || buf.String() != "unique ip's: 1000, estimated(±0.80%, 95% CI 984..1016), total time: 2 sec\n" {
+		t.Fatalf("sketch summary %q, err=%v", buf.String(), err)
+	}
+	if r := newResult(s, nil); r.Exactness != "approximate" {
+		t.Fatalf("sketch result marked %q; want approximate", r.Exactness)
+	}
+	if r := newResult(summary{Unique: 1}, nil); r.Exactness != "" || r.CI95 != nil {
+		t.Fatalf("exact result marked %q", r.Exactness)
+	}
+}
+
+func TestSummary_BadTemplate(t *testing.T) {
+	t.Parallel()
+	if _, err := parseSummaryTemplate("{{.Unique"); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
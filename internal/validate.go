@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/pkg/uipcounter"
+)
+
+// runValidate "validate -f file" - acceptance gate for algorithm changes:
+// counts the input(or a sample of it) with the counter and with an independent
+// parser + external "sort -u" and fails on any discrepancy.
+func runValidate(ctx context.Context, logger *zap.Logger, args []string) error {
+	var (
+		path        string
+		th          int
+		sampleBytes int64
+		chunks      int
+		seed        int64
+	)
+	fs := newFlagSet("validate")
+	fs.StringVar(&path, "f", "", "path to file")
+	fs.IntVar(&th, "th", runtime.NumCPU(), "count of goroutines + shards")
+	fs.Int64Var(&sampleBytes, "sample-bytes", 256<<20, "files larger than this are validated on a random sample of this size")
+	fs.IntVar(&chunks, "sample-chunks", 64, "number of random byte ranges the sample is made of")
+	fs.Int64Var(&seed, "seed", 1, "sampling seed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if path == "" {
+		return fmt.Errorf("please provide path to file")
+	}
+
+	tmp, err := os.MkdirTemp("", "uip-validate")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	input, err := validationInput(path, tmp, sampleBytes, chunks, seed)
+	if err != nil {
+		return err
+	}
+	if input != path {
+		logger.Info("file is large, validating on a sample", zap.String("sample", input), zap.Int64("bytes", sampleBytes))
+	}
+
+	c := uipcounter.New(logger, th)
+	if err = c.CountFile(ctx, input); err != nil {
+		return fmt.Errorf("counter: %w", err)
+	}
+	ref, err := referenceCount(ctx, input, tmp)
+	if err != nil {
+		return fmt.Errorf("reference: %w", err)
+	}
+
+	fmt.Printf("counter: %d, reference(sort -u): %d\n", c.UniqueCount(), ref)
+	if c.UniqueCount() != ref {
+		return fmt.Errorf("MISMATCH: counter=%d reference=%d", c.UniqueCount(), ref)
+	}
+	fmt.Println("OK")
+
+	return nil
+}
+
+// validationInput Returns path itself for small files, otherwise a sample file made of
+// chunks random byte ranges cut to whole lines.
+func validationInput(path, dir string, sampleBytes int64, chunks int, seed int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.Size() <= sampleBytes {
+		return path, nil
+	}
+
+	out, err := os.Create(filepath.Join(dir, "sample.txt"))
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if err = writeSample(out, f, fi.Size(), sampleBytes, chunks, seed); err != nil {
+		return "", err
+	}
+
+	return out.Name(), nil
+}
+
+func writeSample(w io.Writer, r io.ReaderAt, size, sampleBytes int64, chunks int, seed int64) error {
+	if chunks <= 0 {
+		chunks = 1
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	chunkSize := sampleBytes / int64(chunks)
+
+	offsets := make([]int64, chunks)
+	for i := range offsets {
+		offsets[i] = rnd.Int63n(size - chunkSize + 1)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	buf := make([]byte, chunkSize)
+	for _, off := range offsets {
+		n, err := r.ReadAt(buf, off)
+		if n == 0 && err != nil {
+			return err
+		}
+		b := buf[:n]
+		// whole lines only: drop the partial head(unless at file start) and tail
+		if off > 0 {
+			i := bytes.IndexByte(b, '\n')
+			if i < 0 {
+				continue
+			}
+			b = b[i+1:]
+		}
+		i := bytes.LastIndexByte(b, '\n')
+		if i < 0 {
+			continue
+		}
+		if _, err = w.Write(b[:i+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// referenceCount Exact distinct count by an independent parser and the external "sort -u".
+func referenceCount(ctx context.Context, path, dir string) (uint64, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	canonPath := filepath.Join(dir, "canonical.txt")
+	canon, err := os.Create(canonPath)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(canon)
+
+	// only '\n' terminated lines are records, like in the counter
+	r := bufio.NewReader(in)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = canon.Close()
+			return 0, err
+		}
+		if ip, ok := referenceIPv4(strings.TrimRight(line, "\r\n")); ok {
+			w.WriteString(ip)
+			w.WriteByte('\n')
+		}
+	}
+	if err = w.Flush(); err != nil {
+		_ = canon.Close()
+		return 0, err
+	}
+	if err = canon.Close(); err != nil {
+		return 0, err
+	}
+
+	sorted := filepath.Join(dir, "sorted.txt")
+	cmd := exec.CommandContext(ctx, "sort", "-u", "-T", dir, "-o", sorted, canonPath)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("sort -u: %v: %s", err, out)
+	}
+
+	return countLines(sorted)
+}
+
+// referenceIPv4 Deliberately naive reimplementation of the accepted format:
+// "A.B.C.D", 7-15 bytes, decimal octets 0-255(leading zeros allowed), canonicalized.
+func referenceIPv4(s string) (string, bool) {
+	if len(s) < 7 || len(s) > 15 {
+		return "", false
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return "", false
+	}
+	octets := make([]string, 4)
+	for i, p := range parts {
+		if p == "" || strings.TrimLeft(p, "0123456789") != "" {
+			return "", false
+		}
+		v, err := strconv.Atoi(p)
+		if err != nil || v > 255 {
+			return "", false
+		}
+		octets[i] = strconv.Itoa(v)
+	}
+
+	return strings.Join(octets, "."), true
+}
+
+func countLines(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n uint64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		n++
+	}
+
+	return n, sc.Err()
+}
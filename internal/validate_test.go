@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_referenceIPv4(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"1.2.3.4", "1.2.3.4", true},
+		{"001.002.003.004", "1.2.3.4", true},
+		{"255.255.255.255", "255.255.255.255", true},
+		{"256.1.1.1", "", false},
+		{"1.1.1", "", false},
+		{"1..1.1", "", false},
+		{"1.1.1.1 ", "", false},
+		{"a.b.c.d", "", false},
+	}
+	for _, tt := range cases {
+		got, ok := referenceIPv4(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Fatalf("referenceIPv4(%q)=%q,%v; want %q,%v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func Test_writeSample_WholeLines(t *testing.T) {
+	t.Parallel()
+	var src bytes.Buffer
+	for i := 0; i < 5000; i++ {
+		src.WriteString(fmt.Sprintf("10.0.%d.%d\n", i/256, i%256))
+	}
+
+	var out bytes.Buffer
+	if err := writeSample(&out, bytes.NewReader(src.Bytes()), int64(src.Len()), 4096, 8, 42); err != nil {
+		t.Fatalf("writeSample: %v", err)
+	}
+	if out.Len() == 0 || out.Len() > 4096 {
+		t.Fatalf("sample size=%d; want (0, 4096]", out.Len())
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if _, ok := referenceIPv4(line); !ok {
+			t.Fatalf("sample contains a cut line %q", line)
+		}
+	}
+}
+
+func Test_referenceCount_MatchesCounter(t *testing.T) {
+	if _, err := exec.LookPath("sort"); err != nil {
+		t.Skip("sort is not available")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ips.txt")
+	data := "1.1.1.1\n01.1.1.1\n2.2.2.2\r\nbad\n2.2.2.2\n3.3.3.3"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	got, err := referenceCount(context.Background(), path, dir)
+	if err != nil {
+		t.Fatalf("referenceCount: %v", err)
+	}
+	// the unterminated last line is not a record
+	if got != 2 {
+		t.Fatalf("referenceCount=%d; want 2", got)
+	}
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/file_processor"
+	"unique-ip-counter/internal/ipv4_bitset"
+	"unique-ip-counter/internal/watcher"
+)
+
+type (
+	// tailed Read position of a file of the watched directory.
+	tailed struct {
+		f        *os.File
+		fp       *file_processor.FileProcessor
+		off      int64     // everything before is counted, always a line start
+		backfill *backfill // -tail-first: the head still being counted
+	}
+	// backfill Counts the head of a file in the background while its tail is followed.
+	backfill struct {
+		fp     *file_processor.FileProcessor
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+)
+
+// close Stops the backfill of t and closes the file.
+func (t *tailed) close() {
+	t.stopBackfill()
+	_ = t.f.Close()
+}
+
+func (t *tailed) stopBackfill() {
+	if t.backfill != nil {
+		t.backfill.cancel()
+		<-t.backfill.done
+	}
+}
+
+// runWatch -watch: counts the complete lines of the files of a directory as they are created
+// or appended into one running set, until the context is canceled.
+func (a *App) runWatch(ctx context.Context) error {
+	start := time.Now()
+	w, err := watcher.New(a.cfg.watchDir, a.cfg.watchPoll)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	a.logger.Info("watching directory", zap.String("dir", a.cfg.watchDir), zap.String("backend", w.Backend()))
+
+	var (
+		bs    = a.newBitset()
+		files = make(map[string]*tailed)
+	)
+	defer func() {
+		for _, t := range files {
+			t.close()
+		}
+	}()
+
+	report := time.NewTicker(a.cfg.watchReport)
+	defer report.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s := summary{Path: a.cfg.watchDir, Threads: a.cfg.th, Unique: bs.GetUniqueCount(), Invalid: watchedInvalid(files),
+				Seconds: time.Since(start).Seconds()}
+			return s.write(os.Stdout, a.cfg.summary)
+		case <-report.C:
+			a.logger.Info("running count", zap.Uint64("unique", bs.GetUniqueCount()), zap.Uint64("invalid", watchedInvalid(files)),
+				zap.Int("files", len(files)))
+		case ev, ok := <-w.Events():
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			if err = a.watchEvent(ctx, bs, files, ev); err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
+				a.logger.Error("cannot process watched file", zap.String("path", ev.Path), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (a *App) watchEvent(ctx context.Context, bs *ipv4_bitset.Bitset, files map[string]*tailed, ev watcher.Event) error {
+	// shippers write into dot files and rename them when complete
+	if strings.HasPrefix(filepath.Base(ev.Path), ".") || !isPlainText(ev.Path) {
+		return nil
+	}
+	t := files[ev.Path]
+	if ev.Op == watcher.Remove {
+		if t != nil {
+			t.close()
+			delete(files, ev.Path)
+		}
+		return nil
+	}
+
+	if t != nil && ev.Op == watcher.Create && replaced(t, ev.Path) {
+		// rotated by a rename over the path: the new file is read from its start
+		a.logger.Info("watched file replaced, reading the new one from the start", zap.String("path", ev.Path))
+		t.close()
+		delete(files, ev.Path)
+		t = nil
+	}
+	if t == nil {
+		f, err := os.Open(ev.Path)
+		if err != nil {
+			return err
+		}
+		t = &tailed{f: f, fp: a.watchProcessor(f, bs)}
+		files[ev.Path] = t
+		if err = a.startBackfill(ctx, t, bs); err != nil {
+			return err
+		}
+	}
+	fi, err := t.f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	if fi.Size() < t.off {
+		// truncated(copytruncate rotation): the new content starts over
+		a.logger.Info("watched file truncated, reading from the start", zap.String("path", ev.Path))
+		t.stopBackfill()
+		t.off, t.fp, t.backfill = 0, a.watchProcessor(t.f, bs), nil
+	}
+
+	end, err := t.fp.LastRecordEnd(ctx, t.off, fi.Size())
+	if err != nil || end <= t.off {
+		return err
+	}
+	if err = t.fp.ProcessAppended(ctx, t.off, end); err != nil {
+		return err
+	}
+	t.off = end
+
+	return nil
+}
+
+// replaced Reports whether path names another file than the one t reads.
+func replaced(t *tailed, path string) bool {
+	pfi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	fi, err := t.f.Stat()
+
+	return err == nil && !os.SameFile(pfi, fi)
+}
+
+// startBackfill -tail-first: a file found larger than the tail size is followed from the record start of its
+// last tail bytes at once, its head is counted by another processor in the background.
+func (a *App) startBackfill(ctx context.Context, t *tailed, bs *ipv4_bitset.Bitset) error {
+	if a.cfg.tailFirst == 0 {
+		return nil
+	}
+	fi, err := t.f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || uint64(fi.Size()) <= a.cfg.tailFirst {
+		return err
+	}
+	head, err := t.fp.LastRecordEnd(ctx, 0, fi.Size()-int64(a.cfg.tailFirst))
+	if err != nil || head == 0 {
+		return err
+	}
+	if err = t.fp.ResolveHeader(head); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	b := &backfill{fp: a.watchProcessor(t.f, bs), cancel: cancel, done: make(chan struct{})}
+	t.off, t.backfill = head, b
+	a.logger.Info("following the tail first, backfilling the head", zap.String("path", t.f.Name()),
+		zap.Int64("head_bytes", head), zap.Int64("tail_bytes", fi.Size()-head))
+	go func() {
+		defer close(b.done)
+		start := time.Now()
+		if err := b.fp.ProcessAppended(ctx, 0, head); err != nil {
+			if ctx.Err() == nil {
+				a.logger.Error("cannot backfill watched file", zap.String("path", t.f.Name()), zap.Error(err))
+			}
+			return
+		}
+		a.logger.Info("backfill done", zap.String("path", t.f.Name()), zap.Duration("took", time.Since(start)))
+	}()
+
+	return nil
+}
+
+// watchedInvalid Invalid lines of the watched files since they were(re)opened.
+func watchedInvalid(files map[string]*tailed) uint64 {
+	var n uint64
+	for _, t := range files {
+		if t.fp != nil {
+			n += t.fp.InvalidCount()
+		}
+		if t.backfill != nil {
+			n += t.backfill.fp.InvalidCount()
+		}
+	}
+
+	return n
+}
+
+func (a *App) watchProcessor(f *os.File, bs *ipv4_bitset.Bitset) *file_processor.FileProcessor {
+	opts := []file_processor.Option{
+		file_processor.WithCSVColumn(a.cfg.csvCol),
+		file_processor.WithHeader(a.cfg.header),
+		file_processor.WithFormat(a.cfg.format),
+		file_processor.WithGrok(a.cfg.grok),
+		file_processor.WithInvalidSamples(a.cfg.invalidSamples),
+		file_processor.WithDelimiter(a.cfg.delim),
+		file_processor.WithSkipPrefixes(a.cfg.skipPrefixes),
+		file_processor.WithInlineComments(a.cfg.comments),
+		file_processor.WithExtractAll(a.cfg.extractAll),
+		file_processor.WithStripPort(a.cfg.stripPort),
+		file_processor.WithTrimSpace(a.cfg.trimSpace),
+		file_processor.WithIntegerAddresses(a.cfg.intAddr),
+		file_processor.WithStrictIPv4(a.cfg.strictIPv4),
+		file_processor.WithMaxLineBytes(int(a.cfg.maxLineBytes)),
+		file_processor.WithCIDR(a.cfg.cidr),
+		file_processor.WithIPv6Prefix(a.cfg.ipv6Prefix),
+		file_processor.WithStatusDimension(a.cfg.statusCol),
+		file_processor.WithUserAgentDimension(a.cfg.uaCol),
+	}
+	if a.invalid != nil {
+		opts = append(opts, file_processor.WithInvalidOut(a.invalid, f.Name()))
+	}
+
+	return file_processor.New(a.logger, f, bs, a.cfg.th, opts...)
+}
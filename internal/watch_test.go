@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/ipv4_bitset"
+	"unique-ip-counter/internal/watcher"
+)
+
+func TestApp_watchEvent(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	a := &App{logger: zap.NewNop(), cfg: config{th: 2, delim: "\n"}}
+	bs := ipv4_bitset.New()
+	files := make(map[string]*tailed)
+	defer func() {
+		for _, f := range files {
+			_ = f.f.Close()
+		}
+	}()
+
+	path := filepath.Join(dir, "drop.log")
+	step := func(data string, op watcher.Op, flag int, want uint64) {
+		t.Helper()
+		if data != "" {
+			f, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0o600)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			_, _ = f.WriteString(data)
+			_ = f.Close()
+		}
+		if err := a.watchEvent(context.Background(), bs, files, watcher.Event{Path: path, Op: op}); err != nil {
+			t.Fatalf("watchEvent: %v", err)
+		}
+		if got := bs.GetUniqueCount(); got != want {
+			t.Fatalf("after %q unique=%d; want %d", data, got, want)
+		}
+	}
+
+	step("1.1.1.1\n2.2.2.2\n3.3.", watcher.Create, os.O_TRUNC, 2) // the partial line waits
+	step("3.3\n1.1.1.1\n", watcher.Write, os.O_APPEND, 3)         // completed, 1.1.1.1 is not new
+	step("4.4.4.4\n", watcher.Write, os.O_TRUNC, 4)               // truncated: read from the start
+	step("", watcher.Create, 0, 4)                                // the same file again(a rescan)
+	// rotated by a rename over the path: the new file of the same size is read from its start
+	if err := os.WriteFile(path+".new", []byte("5.5.5.5\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.Rename(path+".new", path); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	step("", watcher.Create, 0, 5)
+	step("", watcher.Remove, 0, 5)
+	if len(files) != 0 {
+		t.Fatalf("removed file is still tailed")
+	}
+
+	// temp files of shippers are ignored
+	tmp := filepath.Join(dir, ".drop.log.part")
+	_ = os.WriteFile(tmp, []byte("9.9.9.9\n"), 0o600)
+	if err := a.watchEvent(context.Background(), bs, files, watcher.Event{Path: tmp, Op: watcher.Create}); err != nil || bs.GetUniqueCount() != 5 {
+		t.Fatalf("dot file: err=%v unique=%d; want ignored", err, bs.GetUniqueCount())
+	}
+}
+
+func TestApp_watchTailFirst(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	a := &App{logger: zap.NewNop(), cfg: config{th: 2, delim: "\n", tailFirst: 1000, csvCol: "ip", header: true}}
+	bs := ipv4_bitset.New()
+	files := make(map[string]*tailed)
+	defer func() {
+		for _, f := range files {
+			f.close()
+		}
+	}()
+
+	var sb strings.Builder
+	sb.WriteString("ip,n\n")
+	for i := range 1000 {
+		fmt.Fprintf(&sb, "10.0.%d.%d,%d\n", i/256, i%256, i)
+	}
+	path := filepath.Join(dir, "big.csv")
+	_ = os.WriteFile(path, []byte(sb.String()), 0o600)
+
+	if err := a.watchEvent(context.Background(), bs, files, watcher.Event{Path: path, Op: watcher.Create}); err != nil {
+		t.Fatalf("watchEvent: %v", err)
+	}
+	tf := files[path]
+	if tf.backfill == nil || tf.off != int64(sb.Len()) {
+		t.Fatalf("off=%d backfill=%v; want the whole tail followed and a backfill", tf.off, tf.backfill != nil)
+	}
+	<-tf.backfill.done
+	if got, inv := bs.GetUniqueCount(), watchedInvalid(files); got != 1000 || inv != 0 {
+		t.Fatalf("unique=%d invalid=%d; want 1000, 0(the header is not a line of the tail)", got, inv)
+	}
+}
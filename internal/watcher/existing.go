@@ -0,0 +1,23 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// existing Create events of the regular files already in dir.
+func existing(dir string) ([]Event, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Event
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			res = append(res, Event{Path: filepath.Join(dir, e.Name()), Op: Create})
+		}
+	}
+
+	return res, nil
+}
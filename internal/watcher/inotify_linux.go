@@ -0,0 +1,136 @@
+package watcher
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotify Linux backend.
+type inotify struct {
+	dir    string
+	fd     int
+	wd     int
+	events chan Event
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func newNative(dir string) (Watcher, error) {
+	initial, err := existing(dir)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	wd, err := unix.InotifyAddWatch(fd, dir, unix.IN_CREATE|unix.IN_MODIFY|
+		unix.IN_MOVED_TO|unix.IN_MOVED_FROM|unix.IN_DELETE|unix.IN_ONLYDIR)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	w := &inotify{dir: dir, fd: fd, wd: wd, events: make(chan Event, 64), stop: make(chan struct{})}
+	go w.run(initial)
+
+	return w, nil
+}
+
+func (w *inotify) Events() <-chan Event { return w.events }
+func (w *inotify) Backend() string      { return "inotify" }
+
+// Close Removing the watch queues IN_IGNORED, which wakes up the blocked read.
+func (w *inotify) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.stop)
+		_, err = unix.InotifyRmWatch(w.fd, uint32(w.wd))
+	})
+
+	return err
+}
+
+func (w *inotify) run(initial []Event) {
+	defer close(w.events)
+	defer unix.Close(w.fd)
+
+	for _, ev := range initial {
+		if !w.emit(ev) {
+			return
+		}
+	}
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := unix.Read(w.fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			return
+		}
+
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(raw.Len)]
+			off += unix.SizeofInotifyEvent + int(raw.Len)
+
+			if raw.Mask&unix.IN_IGNORED != 0 {
+				return
+			}
+			if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+				// events were dropped: report every file again, as at the start
+				if !w.rescan() {
+					return
+				}
+				continue
+			}
+			if raw.Mask&unix.IN_ISDIR != 0 {
+				continue
+			}
+			ev := Event{Path: filepath.Join(w.dir, string(bytes.TrimRight(name, "\x00")))}
+			switch {
+			case raw.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+				ev.Op = Create
+			case raw.Mask&unix.IN_MODIFY != 0:
+				ev.Op = Write
+			case raw.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+				ev.Op = Remove
+			default:
+				continue
+			}
+			if !w.emit(ev) {
+				return
+			}
+		}
+	}
+}
+
+// rescan Create events of the files in the directory, false once closed.
+func (w *inotify) rescan() bool {
+	evs, err := existing(w.dir)
+	if err != nil {
+		return true
+	}
+	for _, ev := range evs {
+		if !w.emit(ev) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *inotify) emit(ev Event) bool {
+	select {
+	case w.events <- ev:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
@@ -0,0 +1,182 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// kqueue BSD/macOS backend. kqueue has no per-entry directory events, so a write of the directory
+// triggers a rescan for new files and every file gets its own vnode watch for appends and removal.
+type kqueue struct {
+	dir    string
+	kq     int
+	dirFD  int
+	files  map[int]string // fd -> path
+	paths  map[string]int
+	events chan Event
+	stop   chan struct{}
+	once   sync.Once
+}
+
+const (
+	fileNotes = unix.NOTE_WRITE | unix.NOTE_EXTEND | unix.NOTE_DELETE | unix.NOTE_RENAME
+	// pollTimeout how often the event loop checks for Close
+	pollTimeout = int64(200e6)
+)
+
+func newNative(dir string) (Watcher, error) {
+	kq, err := unix.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	dirFD, err := unix.Open(dir, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		_ = unix.Close(kq)
+		return nil, err
+	}
+
+	w := &kqueue{
+		dir:    dir,
+		kq:     kq,
+		dirFD:  dirFD,
+		files:  map[int]string{},
+		paths:  map[string]int{},
+		events: make(chan Event, 64),
+		stop:   make(chan struct{}),
+	}
+	if err = w.register(dirFD, unix.NOTE_WRITE); err != nil {
+		w.closeFDs()
+		return nil, err
+	}
+	go w.run()
+
+	return w, nil
+}
+
+func (w *kqueue) Events() <-chan Event { return w.events }
+func (w *kqueue) Backend() string      { return "kqueue" }
+
+func (w *kqueue) Close() error {
+	w.once.Do(func() { close(w.stop) })
+	return nil
+}
+
+func (w *kqueue) register(fd int, notes uint32) error {
+	var ev unix.Kevent_t
+	unix.SetKevent(&ev, fd, unix.EVFILT_VNODE, unix.EV_ADD|unix.EV_CLEAR)
+	ev.Fflags = notes
+	_, err := unix.Kevent(w.kq, []unix.Kevent_t{ev}, nil, nil)
+
+	return err
+}
+
+func (w *kqueue) run() {
+	defer close(w.events)
+	defer w.closeFDs()
+
+	// the initial scan reports the existing files as created
+	if !w.rescan() {
+		return
+	}
+
+	buf := make([]unix.Kevent_t, 64)
+	timeout := unix.NsecToTimespec(pollTimeout)
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		n, err := unix.Kevent(w.kq, nil, buf, &timeout)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		for _, kev := range buf[:n] {
+			fd := int(kev.Ident)
+			if fd == w.dirFD {
+				if !w.rescan() {
+					return
+				}
+				continue
+			}
+			path, ok := w.files[fd]
+			if !ok {
+				continue
+			}
+			if kev.Fflags&(unix.NOTE_DELETE|unix.NOTE_RENAME) != 0 {
+				w.forget(fd)
+				if !w.emit(Event{Path: path, Op: Remove}) {
+					return
+				}
+				continue
+			}
+			if !w.emit(Event{Path: path, Op: Write}) {
+				return
+			}
+		}
+	}
+}
+
+// rescan Starts watching the files not seen yet, false once the watcher is closed.
+func (w *kqueue) rescan() bool {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return true
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(w.dir, e.Name())
+		if _, ok := w.paths[path]; ok {
+			continue
+		}
+		fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			continue
+		}
+		if err = w.register(fd, fileNotes); err != nil {
+			_ = unix.Close(fd)
+			continue
+		}
+		w.files[fd], w.paths[path] = path, fd
+		if !w.emit(Event{Path: path, Op: Create}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// forget Closing the fd removes its kevents as well.
+func (w *kqueue) forget(fd int) {
+	delete(w.paths, w.files[fd])
+	delete(w.files, fd)
+	_ = unix.Close(fd)
+}
+
+func (w *kqueue) closeFDs() {
+	for fd := range w.files {
+		w.forget(fd)
+	}
+	_ = unix.Close(w.dirFD)
+	_ = unix.Close(w.kq)
+}
+
+func (w *kqueue) emit(ev Event) bool {
+	select {
+	case w.events <- ev:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package watcher
+
+func newNative(string) (Watcher, error) { return nil, errUnsupported }
@@ -0,0 +1,123 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type (
+	// polling Portable backend, compares size and mtime of the directory entries every interval.
+	polling struct {
+		dir      string
+		interval time.Duration
+		events   chan Event
+		stop     chan struct{}
+		once     sync.Once
+		seen     map[string]fileState
+	}
+	fileState struct {
+		size  int64
+		mtime time.Time
+	}
+)
+
+// NewPolling Portable backend for filesystems without notifications(NFS, FUSE) or platforms without a native one.
+func NewPolling(dir string, interval time.Duration) (Watcher, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	w := &polling{
+		dir:      dir,
+		interval: interval,
+		events:   make(chan Event, 64),
+		stop:     make(chan struct{}),
+		seen:     map[string]fileState{},
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		return nil, err
+	}
+	go w.run()
+
+	return w, nil
+}
+
+func (w *polling) Events() <-chan Event { return w.events }
+func (w *polling) Backend() string      { return "polling" }
+
+func (w *polling) Close() error {
+	w.once.Do(func() { close(w.stop) })
+	return nil
+}
+
+func (w *polling) run() {
+	defer close(w.events)
+
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		if !w.scan() {
+			return
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// scan Emits the difference to the previous scan, false once the watcher is closed.
+func (w *polling) scan() bool {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		// the directory may be recreated by rotation, retry on the next tick
+		return true
+	}
+
+	cur := make(map[string]fileState, len(entries))
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(w.dir, e.Name())
+		st := fileState{size: fi.Size(), mtime: fi.ModTime()}
+		cur[path] = st
+
+		prev, ok := w.seen[path]
+		switch {
+		case !ok:
+			if !w.emit(Event{Path: path, Op: Create}) {
+				return false
+			}
+		case prev != st:
+			if !w.emit(Event{Path: path, Op: Write}) {
+				return false
+			}
+		}
+	}
+	for path := range w.seen {
+		if _, ok := cur[path]; !ok {
+			if !w.emit(Event{Path: path, Op: Remove}) {
+				return false
+			}
+		}
+	}
+	w.seen = cur
+
+	return true
+}
+
+func (w *polling) emit(ev Event) bool {
+	select {
+	case w.events <- ev:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
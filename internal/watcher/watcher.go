@@ -0,0 +1,60 @@
+// Package watcher reports file changes of a directory through the native notification API
+// of the platform(inotify on linux, kqueue on BSD/macOS) with a portable polling fallback.
+package watcher
+
+import (
+	"errors"
+	"time"
+)
+
+type (
+	// Op Kind of a file change.
+	Op uint8
+
+	// Event Change of a regular file of the watched directory(not recursive).
+	Event struct {
+		Path string
+		Op   Op
+	}
+
+	// Watcher Source of events, files existing when the watch starts are reported as Create.
+	// Events is closed after Close.
+	Watcher interface {
+		Events() <-chan Event
+		// Backend "inotify", "kqueue" or "polling"
+		Backend() string
+		Close() error
+	}
+)
+
+const (
+	Create Op = iota + 1
+	Write
+	Remove
+)
+
+func (op Op) String() string {
+	switch op {
+	case Create:
+		return "create"
+	case Write:
+		return "write"
+	case Remove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// errUnsupported no native backend on this platform
+var errUnsupported = errors.New("native file notifications are not supported")
+
+// New Watches dir with the native backend of the platform, falls back to polling
+// every interval when there is none or it cannot be initialized(e.g. inotify limits reached).
+func New(dir string, interval time.Duration) (Watcher, error) {
+	if w, err := newNative(dir); err == nil {
+		return w, nil
+	}
+
+	return NewPolling(dir, interval)
+}
@@ -0,0 +1,80 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher_Backends(t *testing.T) {
+	t.Parallel()
+
+	backends := map[string]func(dir string) (Watcher, error){
+		"native":  func(dir string) (Watcher, error) { return New(dir, 10*time.Millisecond) },
+		"polling": func(dir string) (Watcher, error) { return NewPolling(dir, 10*time.Millisecond) },
+	}
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			old := filepath.Join(dir, "old.log")
+			if err := os.WriteFile(old, []byte("1.1.1.1\n"), 0o600); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			w, err := open(dir)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer w.Close()
+
+			expect(t, w, Event{Path: old, Op: Create})
+
+			// polling compares mtime, make the change visible on coarse clocks
+			time.Sleep(20 * time.Millisecond)
+			added := filepath.Join(dir, "new.log")
+			if err = os.WriteFile(added, []byte("2.2.2.2\n"), 0o600); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			expect(t, w, Event{Path: added, Op: Create})
+
+			f, err := os.OpenFile(old, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			_, _ = f.WriteString("3.3.3.3\n")
+			_ = f.Close()
+			expect(t, w, Event{Path: old, Op: Write})
+
+			if err = os.Remove(added); err != nil {
+				t.Fatalf("remove: %v", err)
+			}
+			expect(t, w, Event{Path: added, Op: Remove})
+
+			_ = w.Close()
+			for range w.Events() {
+			}
+		})
+	}
+}
+
+// expect Waits for want, other events(e.g. a Write right after a Create) are skipped.
+func expect(t *testing.T, w Watcher, want Event) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-w.Events():
+			if !ok {
+				t.Fatalf("%s: events closed, want %v %s", w.Backend(), want.Op, want.Path)
+			}
+			if ev == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s: timeout waiting for %v %s", w.Backend(), want.Op, want.Path)
+		}
+	}
+}
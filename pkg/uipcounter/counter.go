@@ -0,0 +1,87 @@
+// Package uipcounter is the embeddable counting core used by the CLI,
+// so other programs can count unique IPv4 addresses in-process.
+package uipcounter
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+
+	"go.uber.org/zap"
+
+	"unique-ip-counter/internal/file_processor"
+	"unique-ip-counter/internal/ipv4_bitset"
+)
+
+// Counter Accumulates unique IPv4 addresses over any number of inputs.
+type Counter struct {
+	logger     *zap.Logger
+	bitset     *ipv4_bitset.Bitset
+	th         int
+	milestones milestones
+}
+
+// New th <= 0 means runtime.NumCPU(), nil logger disables logs.
+func New(logger *zap.Logger, th int) *Counter {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	if th <= 0 {
+		th = runtime.NumCPU()
+	}
+
+	c := &Counter{
+		logger: logger,
+		bitset: ipv4_bitset.New(),
+		th:     th,
+	}
+	c.bitset.SetNotify(c.milestones.observe)
+
+	return c
+}
+
+// CountFile Processes the file at path(see countFile of the target platform).
+func (c *Counter) CountFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.countFile(ctx, f)
+}
+
+// CountReader Processes a stream sequentially.
+func (c *Counter) CountReader(ctx context.Context, r io.Reader) error {
+	return file_processor.New(c.logger, nil, c.bitset, c.th).ProcessReader(ctx, r)
+}
+
+func (c *Counter) UniqueCount() uint64 { return c.bitset.GetUniqueCount() }
+
+// MemoryBytes Memory of the unique set so far, it grows by 8KB per allocated /16.
+func (c *Counter) MemoryBytes() int64 { return c.bitset.MemoryBytes() }
+
+// WriteTo Writes a snapshot of the unique set(the UIPB bitset snapshot format),
+// implements io.WriterTo.
+func (c *Counter) WriteTo(w io.Writer) (int64, error) { return c.bitset.WriteTo(w) }
+
+// CountFile One-shot helper around Counter.CountFile.
+func CountFile(ctx context.Context, path string, th int) (uint64, error) {
+	c := New(nil, th)
+	if err := c.CountFile(ctx, path); err != nil {
+		return 0, err
+	}
+
+	return c.UniqueCount(), nil
+}
+
+// CountReader One-shot helper around Counter.CountReader.
+func CountReader(ctx context.Context, r io.Reader) (uint64, error) {
+	c := New(nil, 1)
+	if err := c.CountReader(ctx, r); err != nil {
+		return 0, err
+	}
+
+	return c.UniqueCount(), nil
+}
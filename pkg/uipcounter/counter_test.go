@@ -0,0 +1,46 @@
+package uipcounter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCountReader(t *testing.T) {
+	t.Parallel()
+	got, err := CountReader(context.Background(), strings.NewReader("1.1.1.1\n2.2.2.2\r\n1.1.1.1\nbad\n"))
+	if err != nil {
+		t.Fatalf("CountReader error: %v", err)
+	}
+	if got != 2 {
+		t.Fatalf("CountReader=%d; want 2", got)
+	}
+}
+
+func TestCounter_AccumulatesAcrossInputs(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "ips.txt")
+	if err := os.WriteFile(path, []byte("10.0.0.1\n10.0.0.2\n"), 0o600); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	c := New(nil, 2)
+	if err := c.CountFile(context.Background(), path); err != nil {
+		t.Fatalf("CountFile error: %v", err)
+	}
+	if err := c.CountReader(context.Background(), strings.NewReader("10.0.0.2\n10.0.0.3\n")); err != nil {
+		t.Fatalf("CountReader error: %v", err)
+	}
+	if got := c.UniqueCount(); got != 3 {
+		t.Fatalf("UniqueCount=%d; want 3", got)
+	}
+}
+
+func TestCountFile_Missing(t *testing.T) {
+	t.Parallel()
+	if _, err := CountFile(context.Background(), filepath.Join(t.TempDir(), "nope"), 1); err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package uipcounter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCountFile_FIFO(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "ips.fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	go func() {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+		_, _ = w.WriteString("1.1.1.1\n2.2.2.2\n1.1.1.1\n3.3.3.3\n")
+	}()
+
+	got, err := CountFile(context.Background(), path, 4)
+	if err != nil {
+		t.Fatalf("CountFile error: %v", err)
+	}
+	if got != 3 {
+		t.Fatalf("CountFile=%d; want 3", got)
+	}
+}
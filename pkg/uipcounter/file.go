@@ -0,0 +1,40 @@
+//go:build !wasip1
+
+package uipcounter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"unique-ip-counter/internal/file_processor"
+)
+
+// countFile Processes f in parallel shards(zip archives entry by entry,
+// pcap/pcapng captures by source address, .bin as binary-be32, seekable zstd/BGZF by blocks), FIFOs and devices are streamed.
+func (c *Counter) countFile(ctx context.Context, f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	fp := file_processor.New(c.logger, f, c.bitset, c.th)
+	if !fi.Mode().IsRegular() {
+		return fp.ProcessReader(ctx, f)
+	}
+	switch strings.ToLower(filepath.Ext(f.Name())) {
+	case ".zip":
+		return fp.ProcessZip(ctx, fi)
+	case ".pcap", ".pcapng":
+		return fp.ProcessPcap(ctx, fi)
+	case ".bin":
+		return fp.ProcessBinary(ctx, fi)
+	case ".zst", ".zstd":
+		return fp.ProcessZstd(ctx, fi)
+	case ".gz", ".bgz":
+		return fp.ProcessGzip(ctx, fi)
+	}
+
+	return fp.ProcessFile(ctx, fi)
+}
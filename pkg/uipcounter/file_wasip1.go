@@ -0,0 +1,15 @@
+//go:build wasip1
+
+package uipcounter
+
+import (
+	"context"
+	"os"
+
+	"unique-ip-counter/internal/file_processor"
+)
+
+// countFile WASI has no threads to shard on, so the file is always streamed.
+func (c *Counter) countFile(ctx context.Context, f *os.File) error {
+	return file_processor.New(c.logger, f, c.bitset, 1).ProcessReader(ctx, f)
+}
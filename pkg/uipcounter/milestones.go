@@ -0,0 +1,79 @@
+package uipcounter
+
+import "sync"
+
+type (
+	// milestones Turns concurrent unique totals into an ordered stream of every-Nth counts.
+	milestones struct {
+		mu   sync.Mutex
+		subs []*subscriber
+	}
+	subscriber struct {
+		every uint64
+		last  uint64
+		fn    func(count uint64)
+	}
+)
+
+func (m *milestones) add(s *subscriber) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs = append(m.subs, s)
+}
+
+func (m *milestones) remove(s *subscriber) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, cur := range m.subs {
+		if cur == s {
+			m.subs = append(m.subs[:i], m.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// observe totals may arrive out of order from different shards,
+// the lock + "last" keep every stream monotonic and without duplicates.
+func (m *milestones) observe(total uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.subs {
+		for next := s.last + s.every; next <= total; next += s.every {
+			s.fn(next)
+			s.last = next
+		}
+	}
+}
+
+// OnMilestone Registers fn called with every multiple of every the unique count reaches,
+// in increasing order. fn runs on the processing goroutines, so it must be fast.
+// The returned func unregisters fn.
+func (c *Counter) OnMilestone(every uint64, fn func(count uint64)) (cancel func()) {
+	if every == 0 {
+		every = 1
+	}
+	s := &subscriber{every: every, last: c.UniqueCount() / every * every, fn: fn}
+	c.milestones.add(s)
+
+	return func() { c.milestones.remove(s) }
+}
+
+// Subscribe Channel flavour of OnMilestone. Milestones are dropped(never reordered)
+// when the consumer falls behind the buffer, cancel closes the channel.
+func (c *Counter) Subscribe(every uint64, buffer int) (ch <-chan uint64, cancel func()) {
+	out := make(chan uint64, buffer)
+	unregister := c.OnMilestone(every, func(count uint64) {
+		select {
+		case out <- count:
+		default:
+		}
+	})
+
+	var once sync.Once
+	return out, func() {
+		once.Do(func() {
+			unregister()
+			close(out)
+		})
+	}
+}
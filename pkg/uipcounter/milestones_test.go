@@ -0,0 +1,48 @@
+package uipcounter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCounter_Subscribe(t *testing.T) {
+	t.Parallel()
+	var sb strings.Builder
+	for i := 0; i < 2500; i++ {
+		sb.WriteString(fmt.Sprintf("10.0.%d.%d\n", i/256, i%256))
+	}
+
+	c := New(nil, 1)
+	ch, cancel := c.Subscribe(1000, 16)
+	if err := c.CountReader(context.Background(), strings.NewReader(sb.String())); err != nil {
+		t.Fatalf("CountReader error: %v", err)
+	}
+	cancel()
+
+	var got []uint64
+	for v := range ch {
+		got = append(got, v)
+	}
+	if fmt.Sprint(got) != "[1000 2000]" {
+		t.Fatalf("milestones=%v; want [1000 2000]", got)
+	}
+}
+
+func TestCounter_OnMilestone_MonotonicAcrossTotals(t *testing.T) {
+	t.Parallel()
+	c := New(nil, 1)
+
+	var got []uint64
+	cancel := c.OnMilestone(10, func(n uint64) { got = append(got, n) })
+	defer cancel()
+
+	// out of order totals from concurrent shards
+	for _, total := range []uint64{25, 12, 31, 30, 40} {
+		c.milestones.observe(total)
+	}
+	if fmt.Sprint(got) != "[10 20 30 40]" {
+		t.Fatalf("milestones=%v; want [10 20 30 40]", got)
+	}
+}